@@ -2,16 +2,24 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
-	"io"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/nazar256/combine-mcp/pkg/aggregator"
+	"github.com/nazar256/combine-mcp/pkg/capture"
 	"github.com/nazar256/combine-mcp/pkg/config"
 	"github.com/nazar256/combine-mcp/pkg/logger"
+	"github.com/nazar256/combine-mcp/pkg/metrics"
 	"github.com/nazar256/combine-mcp/pkg/stdio"
+	"github.com/nazar256/combine-mcp/pkg/tracing"
 )
 
 const (
@@ -19,53 +27,29 @@ const (
 	Version = "1.0.0"
 	// Name is the name of the MCP aggregator
 	Name = "mcp-aggregator"
+	// CursorName is the name reported in Cursor compatibility mode, unless a name is configured
+	CursorName = "cursor-mcp-server"
 )
 
 func main() {
-	// SET UP STDOUT REDIRECTION FIRST - before anything else!
-	// We need to capture ALL stdout output and redirect it
-
-	// Create a pipe for capturing stdout
-	stdoutReader, stdoutWriter, err := os.Pipe()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating stdout pipe: %v\n", err)
-		os.Exit(1)
+	validateOnly := flag.Bool("validate", config.GetValidateOnly(), "validate the config and exit without starting the servers")
+	listTools := flag.Bool("list-tools", config.GetListTools(), "connect to the servers, print the aggregated tools as JSON and exit")
+	flag.Parse()
+	if *validateOnly {
+		os.Exit(validate())
 	}
 
-	// Save the original stdout file descriptor
-	oldStdoutFd := int(os.Stdout.Fd())
-	// Get a duplicate file descriptor for the original stdout
-	realStdoutFd, err := syscall.Dup(oldStdoutFd)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error duplicating stdout fd: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Create a new file from the duplicated fd that we'll use later
-	realStdout := os.NewFile(uintptr(realStdoutFd), "stdout")
-
-	// Now replace stdout with our pipe writer
-	os.Stdout = stdoutWriter
-
-	// Start a goroutine to read from the pipe and redirect to stderr
-	// This ensures ANY fmt.Printf or println from any library gets redirected
-	go func() {
-		// We want to read stdout continuously
-		buffer := make([]byte, 4096)
-		for {
-			n, err := stdoutReader.Read(buffer)
-			if err != nil {
-				if err != io.EOF {
-					fmt.Fprintf(os.Stderr, "Error reading from stdout pipe: %v\n", err)
-				}
-				break
-			}
-			if n > 0 {
-				// Write the captured output to stderr instead
-				os.Stderr.Write(buffer[:n])
-			}
+	// SET UP STDOUT REDIRECTION FIRST - before anything else!
+	// Any fmt.Printf or println from any library goes to stderr, so it can't corrupt the JSON on stdout
+	restoreStdout := func() {}
+	if stdoutCapture := config.GetStdoutCapture(); !stdoutCapture.Disabled {
+		var err error
+		restoreStdout, err = capture.Stdout(stdoutCapture.BufferSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error capturing stdout: %v\n", err)
+			os.Exit(1)
 		}
-	}()
+	}
 
 	// Create a context that can be cancelled
 	ctx, cancel := context.WithCancel(context.Background())
@@ -85,6 +69,12 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
 		os.Exit(1)
 	}
+	// Stdin was consumed by reading the config, so the client has to connect another way
+	if cfg.FromStdin && cfg.ServeMode != config.ServeModeHTTP && !*listTools {
+		fmt.Fprintf(os.Stderr, "Error: the config was read from stdin (%s=%s), which only works with %s=%s\n",
+			config.DefaultEnvVar, config.StdinConfigPath, config.ServeModeEnvVar, config.ServeModeHTTP)
+		os.Exit(1)
+	}
 
 	// Initialize the logger
 	if err := logger.Init(cfg.LogLevel, cfg.LogFile); err != nil {
@@ -93,44 +83,200 @@ func main() {
 	}
 	defer logger.Close()
 
+	for _, warning := range cfg.Warnings {
+		logger.Warn("%s", warning)
+	}
+
 	// Log startup message to file only
 	logger.Info("Starting MCP Aggregator v%s", Version)
-	logger.Debug("Configuration loaded: %d servers configured", len(cfg.Servers))
+	logger.Debug("Configuration loaded: %s configured", countOf(len(cfg.Servers), "server"))
+	if len(cfg.ServerFormats) > 0 {
+		logger.Debug("Servers found in the %s format", strings.Join(cfg.ServerFormats, " and "))
+	}
 
 	// Only print startup messages to stderr, never stdout
 	fmt.Fprintf(os.Stderr, "Starting MCP Aggregator v%s\n", Version)
 
-	// Create and initialize the aggregator
-	agg := aggregator.NewMCPAggregator()
-	if err := agg.Initialize(ctx, cfg); err != nil {
+	// Export spans of tool calls if an OTLP endpoint is configured
+	shutdownTracing, err := tracing.Setup(ctx, Name, Version)
+	if err != nil {
+		logger.Fatal("Error setting up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// Create and initialize the aggregator, recording metrics only if asked to,
+	// so there is no listener or bookkeeping otherwise
+	opts := []aggregator.Option{aggregator.WithStdoutGuard(true)}
+	var registry *metrics.Registry
+	if cfg.MetricsAddr != "" {
+		registry = metrics.NewRegistry()
+		opts = append(opts, aggregator.WithMetrics(registry))
+	}
+	agg := aggregator.NewMCPAggregator(opts...)
+	err = agg.Initialize(ctx, cfg)
+	fmt.Fprintln(os.Stderr, agg.InitReport())
+	if err != nil {
 		logger.Fatal("Error initializing aggregator: %v", err)
 	}
 	defer agg.Close()
 
-	// Create the MCP server
-	server := stdio.NewAggregatorServer(Name, Version, agg)
+	if registry != nil {
+		go serveMetrics(cfg.MetricsAddr, registry)
+	}
+
+	// Create the MCP server, presenting the configured identity to the client if any
+	serverName, serverVersion := serverIdentity(cfg, os.Getenv("MCP_CURSOR_MODE") != "")
+	server := stdio.NewAggregatorServer(serverName, serverVersion, agg,
+		stdio.WithDownstreamLogging(cfg.DownstreamLogging), stdio.WithPassthrough(cfg.Passthrough))
+	server.SetMaintenance(cfg.Maintenance, cfg.MaintenanceMessage)
+	server.SetDeadLetterFile(cfg.DeadLetterFile)
+	server.SetSoftErrors(cfg.SoftErrors)
+	server.SetSlowCallThreshold(time.Duration(cfg.SlowCallThresholdMs) * time.Millisecond)
+	instructions := cfg.Instructions
+	if instructions == "" {
+		instructions = stdio.DefaultInstructions(cfg)
+	}
+	server.SetInstructions(instructions)
+	server.SetAllowedOrigins(cfg.AllowedOrigins)
+
+	// On SIGINT/SIGTERM stop serving, so the deferred cleanup lets calls in flight finish before the servers are stopped.
+	// Serving over stdio stops with ctx, the HTTP listener is closed here.
+	go func() {
+		<-ctx.Done()
+		if err := server.Shutdown(context.Background()); err != nil {
+			logger.Error("Error shutting down HTTP server: %v", err)
+		}
+	}()
 
 	// Register tools from the aggregator
 	if err := server.RegisterTools(); err != nil {
 		logger.Fatal("Error registering tools: %v", err)
 	}
 
-	// Start the server - logging to file only
-	logger.Debug("Starting stdio server")
-	fmt.Fprintf(os.Stderr, "Server started, listening on stdin/stdout\n")
+	// Register resources from the aggregator
+	if err := server.RegisterResources(); err != nil {
+		logger.Fatal("Error registering resources: %v", err)
+	}
+
+	// Register prompts from the aggregator
+	if err := server.RegisterPrompts(); err != nil {
+		logger.Fatal("Error registering prompts: %v", err)
+	}
 
-	// Close the writer to stop the redirection goroutine
-	// This ensures we've processed all previous stdout writes before we restore
-	stdoutWriter.Close()
+	// Print the tools instead of serving them, once everything written to stdout so far went to stderr
+	if *listTools {
+		output, err := toolListJSON(agg.GetTools())
+		if err != nil {
+			logger.Fatal("Error listing tools: %v", err)
+		}
+		restoreStdout()
+		os.Stdout.Write(output)
+		return
+	}
 
-	// Restore stdout to the original file we captured earlier
-	os.Stdout = realStdout
+	// Restore stdout once everything written to it so far reached stderr
+	restoreStdout()
 
-	// Clean up when done
-	defer realStdout.Close()
+	if cfg.ServeMode == config.ServeModeHTTP {
+		addr := cfg.ServeAddr
+		if addr == "" {
+			addr = config.DefaultServeAddr
+		}
+		fmt.Fprintln(os.Stderr, startupBanner("http://"+addr+stdio.HTTPEndpoint, agg.Stats()))
+		err = server.ServeStreamableHTTP(addr)
+	} else {
+		// Start the server - logging to file only
+		logger.Debug("Starting stdio server")
+		fmt.Fprintln(os.Stderr, startupBanner("stdin/stdout", agg.Stats()))
 
-	// Now serve using our clean stdout
-	if err := server.ServeStdio(); err != nil {
+		// Now serve using our clean stdout
+		err = server.ServeStdio(ctx)
+	}
+	if err != nil {
 		logger.Fatal("Error serving MCP: %v", err)
 	}
+	if ctx.Err() != nil {
+		logger.Info("Shutting down, waiting for tool calls in flight")
+	}
+}
+
+// serveMetrics serves the metrics of the registry at /metrics until the process exits
+func serveMetrics(addr string, registry *metrics.Registry) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+	logger.Info("Serving metrics on %s/metrics", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logger.Error("Metrics endpoint failed: %v", err)
+	}
+}
+
+// validate loads and validates the config, prints a summary to stderr and returns the exit code
+func validate() int {
+	cfg, err := config.LoadConfig("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration is invalid:\n%v\n", err)
+		return 1
+	}
+	fmt.Fprint(os.Stderr, validationSummary(cfg))
+	return 0
+}
+
+// serverIdentity returns the name and version reported to the client: the configured ones, or the built-in ones.
+// Cursor compatibility mode replaces the built-in name, but not a configured one.
+func serverIdentity(cfg *config.Config, cursorMode bool) (string, string) {
+	name, version := Name, Version
+	if cursorMode {
+		name = CursorName
+	}
+	if cfg.ServerName != "" {
+		name = cfg.ServerName
+	}
+	if cfg.ServerVersion != "" {
+		version = cfg.ServerVersion
+	}
+	return name, version
+}
+
+// validationSummary describes a valid config, one line per server
+func validationSummary(cfg *config.Config) string {
+	var summary strings.Builder
+	fmt.Fprintf(&summary, "Configuration is valid: %s\n", countOf(len(cfg.Servers), "server"))
+	for _, server := range cfg.Servers {
+		switch server.Transport {
+		case config.TransportSSE, config.TransportHTTP:
+			fmt.Fprintf(&summary, "  %s: %s %s\n", server.Name, server.Transport, server.RedactedURL())
+		default:
+			fmt.Fprintf(&summary, "  %s: %s\n", server.Name, strings.Join(append([]string{server.Command}, server.RedactedArgs()...), " "))
+		}
+	}
+	for _, warning := range cfg.Warnings {
+		fmt.Fprintf(&summary, "Warning: %s\n", warning)
+	}
+	return summary.String()
+}
+
+// toolListJSON formats the aggregated tools as printed by --list-tools
+func toolListJSON(tools []mcp.Tool) ([]byte, error) {
+	output, err := json.MarshalIndent(tools, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal tools: %w", err)
+	}
+	return append(output, '\n'), nil
+}
+
+// startupBanner builds the stderr message printed once tools are registered
+func startupBanner(listening string, stats aggregator.Stats) string {
+	banner := fmt.Sprintf("Server started, listening on %s: %s connected, %s exposed", listening, countOf(stats.Connected, "server"), countOf(stats.Tools, "tool"))
+	if stats.Failed > 0 {
+		banner += fmt.Sprintf(", %s failed", countOf(stats.Failed, "server"))
+	}
+	return banner
+}
+
+// countOf formats a count with the noun in singular or plural, e.g. "1 server" or "2 servers"
+func countOf(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/aggregator"
+	"github.com/nazar256/combine-mcp/pkg/config"
+)
+
+func TestStartupBanner(t *testing.T) {
+	tests := []struct {
+		name      string
+		listening string
+		stats     aggregator.Stats
+		want      string
+	}{
+		{
+			name:      "Servers and tools",
+			listening: "stdin/stdout",
+			stats:     aggregator.Stats{Connected: 3, Tools: 27},
+			want:      "Server started, listening on stdin/stdout: 3 servers connected, 27 tools exposed",
+		},
+		{
+			name:      "No tools",
+			listening: "stdin/stdout",
+			stats:     aggregator.Stats{Connected: 1},
+			want:      "Server started, listening on stdin/stdout: 1 server connected, 0 tools exposed",
+		},
+		{
+			name:      "HTTP",
+			listening: "http://localhost:8080/mcp",
+			stats:     aggregator.Stats{Connected: 2, Tools: 5},
+			want:      "Server started, listening on http://localhost:8080/mcp: 2 servers connected, 5 tools exposed",
+		},
+		{
+			name:      "Failed servers",
+			listening: "stdin/stdout",
+			stats:     aggregator.Stats{Connected: 2, Tools: 5, Failed: 1},
+			want:      "Server started, listening on stdin/stdout: 2 servers connected, 5 tools exposed, 1 server failed",
+		},
+		{
+			name:      "One of each",
+			listening: "stdin/stdout",
+			stats:     aggregator.Stats{Connected: 1, Tools: 1, Failed: 2},
+			want:      "Server started, listening on stdin/stdout: 1 server connected, 1 tool exposed, 2 servers failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := startupBanner(tt.listening, tt.stats); got != tt.want {
+				t.Errorf("startupBanner(%q, %+v) = %q, want %q", tt.listening, tt.stats, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidationSummary(t *testing.T) {
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{Name: "github", Command: "npx", Args: []string{"-y", "@modelcontextprotocol/server-github"}},
+			{Name: "docs", Transport: config.TransportSSE, URL: "http://localhost:8080/sse"},
+		},
+		Warnings: []string{"server github env GITHUB_TOKEN references unset environment variable GITHUB_TOKEN"},
+	}
+
+	want := "Configuration is valid: 2 servers\n" +
+		"  github: npx -y @modelcontextprotocol/server-github\n" +
+		"  docs: sse http://localhost:8080/sse\n" +
+		"Warning: server github env GITHUB_TOKEN references unset environment variable GITHUB_TOKEN\n"
+	if got := validationSummary(cfg); got != want {
+		t.Errorf("validationSummary() = %q, want %q", got, want)
+	}
+
+	single := &config.Config{Servers: cfg.Servers[:1]}
+	want = "Configuration is valid: 1 server\n" +
+		"  github: npx -y @modelcontextprotocol/server-github\n"
+	if got := validationSummary(single); got != want {
+		t.Errorf("validationSummary() of one server = %q, want %q", got, want)
+	}
+}
+
+func TestServerIdentity(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         config.Config
+		cursorMode  bool
+		wantName    string
+		wantVersion string
+	}{
+		{name: "Built-in", wantName: Name, wantVersion: Version},
+		{name: "Configured", cfg: config.Config{ServerName: "team-tools", ServerVersion: "2.0.0"}, wantName: "team-tools", wantVersion: "2.0.0"},
+		{name: "Cursor mode", cursorMode: true, wantName: CursorName, wantVersion: Version},
+		{name: "Cursor mode keeps the configured name", cfg: config.Config{ServerName: "team-tools"}, cursorMode: true, wantName: "team-tools", wantVersion: Version},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, version := serverIdentity(&tt.cfg, tt.cursorMode)
+			if name != tt.wantName || version != tt.wantVersion {
+				t.Errorf("serverIdentity() = %s %s, want %s %s", name, version, tt.wantName, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestToolListJSON(t *testing.T) {
+	tools := []mcp.Tool{
+		mcp.NewTool("github_search_repos",
+			mcp.WithDescription("[github] Search repositories"),
+			mcp.WithString("query", mcp.Required()),
+		),
+		mcp.NewTool("shortcut_get_story"),
+	}
+
+	output, err := toolListJSON(tools)
+	if err != nil {
+		t.Fatalf("toolListJSON() error = %v", err)
+	}
+
+	// The output is a single JSON document with names, descriptions and input schemas
+	var listed []struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		InputSchema struct {
+			Properties map[string]interface{} `json:"properties"`
+			Required   []string               `json:"required"`
+		} `json:"inputSchema"`
+	}
+	if err := json.Unmarshal(output, &listed); err != nil {
+		t.Fatalf("toolListJSON() output isn't valid JSON: %v\n%s", err, output)
+	}
+	if len(listed) != 2 {
+		t.Fatalf("Listed %d tools, want 2", len(listed))
+	}
+	if listed[0].Name != "github_search_repos" || listed[0].Description != "[github] Search repositories" {
+		t.Errorf("First tool = %+v, want github_search_repos with its description", listed[0])
+	}
+	if _, ok := listed[0].InputSchema.Properties["query"]; !ok || len(listed[0].InputSchema.Required) != 1 {
+		t.Errorf("First tool schema = %+v, want the required query parameter", listed[0].InputSchema)
+	}
+}
@@ -1,21 +1,64 @@
 package aggregator
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	"github.com/mark3labs/mcp-go/client"
 	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/capture"
 	"github.com/nazar256/combine-mcp/pkg/config"
 	"github.com/nazar256/combine-mcp/pkg/logger"
+	"github.com/nazar256/combine-mcp/pkg/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 )
 
-// MCPClient is an interface that matches the methods we use from StdioMCPClient
+// defaultDrainTimeout bounds how long Close waits for tool calls in flight
+const defaultDrainTimeout = 10 * time.Second
+
+// errServerBusy is returned when a server already handles as many calls as it is allowed to
+var errServerBusy = errors.New("server is busy")
+
+// errRateLimited is returned when a call would exceed the rate limit of a server that rejects such calls
+var errRateLimited = errors.New("rate limit exceeded")
+
+// tracerName identifies the spans of the aggregator
+const tracerName = "github.com/nazar256/combine-mcp/pkg/aggregator"
+
+// defaultDiscoveryTimeout bounds how long a server may take to answer tools/list
+const defaultDiscoveryTimeout = 30 * time.Second
+
+// defaultReadinessInterval is the delay between calls of a server's readiness tool
+const defaultReadinessInterval = 500 * time.Millisecond
+
+// unknownToolLabel is the metrics label of calls of names that don't resolve to a tool,
+// so that clients calling arbitrary names can't grow the registry without bound
+const unknownToolLabel = "unknown"
+
+// serverTimeout returns the timeout a server configures in seconds, or the fallback if it configures none
+func serverTimeout(seconds int, fallback time.Duration) time.Duration {
+	if seconds <= 0 {
+		return fallback
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// MCPClient is an interface that matches the methods we use from an MCP client
 type MCPClient interface {
 	Initialize(ctx context.Context, request mcp.InitializeRequest) (*mcp.InitializeResult, error)
 	ListTools(ctx context.Context, request mcp.ListToolsRequest) (*mcp.ListToolsResult, error)
@@ -23,23 +66,111 @@ type MCPClient interface {
 	Close() error
 }
 
+// Every transport's client must satisfy MCPClient
+var (
+	_ MCPClient = (*stdioClient)(nil)
+	_ MCPClient = (*sseClient)(nil)
+	_ MCPClient = (*httpClient)(nil)
+)
+
 // MCPAggregator is responsible for aggregating multiple MCP servers
 type MCPAggregator struct {
-	clients map[string]MCPClient
-	tools   map[string]toolMapping
-	configs map[string]*config.ServerConfig
-	mu      sync.RWMutex
+	clients   map[string]MCPClient
+	tools     map[string]toolMapping
+	resources map[string]resourceMapping // Keyed by prefixed URI
+	prompts   map[string]promptMapping
+	configs   map[string]*config.ServerConfig
+	infos     map[string]mcp.Implementation     // Name and version servers reported when initialized
+	caps      map[string]mcp.ServerCapabilities // Capabilities servers reported when initialized
+	filtered  map[string][]string               // Original names of the tools each server's filters removed
+	mu        sync.RWMutex
+
+	dualNames       bool
+	readOnlyMode    bool // Only tools that don't modify anything according to their annotations are exposed
+	disablePrefix   bool
+	sanitizeMode    string
+	delimiter       string
+	nameTemplate    *template.Template // Builds exposed tool names instead of joining prefix and name, if set
+	initReport      InitReport         // Servers the last Initialize connected and skipped
+	aliases         map[string]string  // Unprefixed tool name -> prefixed name, if dual names are enabled
+	aliasCollisions map[string]bool    // Unprefixed names that are only exposed prefixed
+
+	clientFactory       ClientFactory
+	discoveryTimeout    time.Duration
+	listChangedDebounce time.Duration
+	callTimeout         time.Duration            // Time allowed for tool calls of servers that don't configure one
+	initTimeout         time.Duration            // Time allowed for the handshake of servers that don't configure one
+	readinessTimeout    time.Duration            // Time allowed to become ready for servers that don't configure one
+	healthCheckInterval time.Duration            // Overrides the configured health check intervals if set
+	readinessInterval   time.Duration            // Delay between calls of readiness tools
+	rediscoveries       map[string]*time.Timer   // Pending rediscoveries after list_changed notifications
+	callSlots           map[string]chan struct{} // Semaphores of servers with limited concurrent calls
+	rateLimiters        map[string]*rate.Limiter // Token buckets of servers with a rate limit
+	resultCache         *resultCache             // Results of cacheable tools
+	progressCalls       map[string]progressCall  // Calls in flight that asked for progress, by progress token
+	onToolsChanged      func()
+	metrics             *metrics.Registry // Records tool calls if set
+	audit               *auditLog         // Writes every tool call to the audit file if set
+	samplingHandler     SamplingHandler
+	rootsHandler        RootsHandler
+	progressHandler     ProgressHandler
+	samplingFallback    string         // Server that answers sampling requests the client can't, if set
+	clientSampling      *bool          // Whether the downstream client supports sampling, nil until it has initialized
+	clientRoots         *bool          // Whether the downstream client supports roots, nil until it has initialized
+	reconnectMu         sync.Mutex     // Serializes respawning servers after failed calls
+	calls               sync.WaitGroup // Tool calls in flight
+	drainTimeout        time.Duration  // Grace period for calls in flight when closing
+	stdoutGuard         bool           // Redirects os.Stdout to stderr during Initialize
+	done                chan struct{}  // Closed when the aggregator is closed
+	closeOnce           sync.Once
 }
 
 type toolMapping struct {
 	serverName    string
 	originalName  string
 	sanitizedName string
+	exposedPrefix string                 // Prefix and delimiter the exposed name starts with, empty if unprefixed
+	tool          mcp.Tool               // Upstream tool as discovered, so listing doesn't query the server again
+	presetArgs    map[string]interface{} // Fixed arguments of a preset-backed virtual tool
+	description   string                 // Replaces the upstream description if set
 }
 
-// sanitizeToolName replaces dashes with underscores in a tool name to make it compatible with Cursor
-func sanitizeToolName(name string) string {
-	return strings.ReplaceAll(name, "-", "_")
+// toolPrefix returns the prefix of a server's exposed tool names: the configured prefix or the server name,
+// or an empty string if prefixing is disabled
+func toolPrefix(serverName string, serverConfig *config.ServerConfig, disablePrefix bool) string {
+	if disablePrefix || (serverConfig != nil && serverConfig.NoPrefix) {
+		return ""
+	}
+	if serverConfig != nil && serverConfig.Prefix != "" {
+		return serverConfig.Prefix
+	}
+	return serverName
+}
+
+// uniqueToolName appends the lowest numeric suffix, starting at 2, that makes the name unused
+func uniqueToolName(name string, taken func(name string) bool) string {
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s_%d", name, i)
+		if !taken(candidate) {
+			return candidate
+		}
+	}
+}
+
+// exposedPrefix returns the start of the exposed names of a sanitized prefix, empty if unprefixed
+func exposedPrefix(prefix, delimiter string) string {
+	if prefix == "" {
+		return ""
+	}
+	if delimiter == "" {
+		delimiter = config.DefaultDelimiter
+	}
+	return prefix + delimiter
+}
+
+// exposedToolName joins the sanitized prefix and tool name with the delimiter
+func exposedToolName(prefix, delimiter, name string) string {
+	return exposedPrefix(prefix, delimiter) + name
 }
 
 // normalizeToolName normalizes a tool name by replacing both dashes and underscores with underscores
@@ -48,12 +179,245 @@ func normalizeToolName(name string) string {
 	return name
 }
 
-// NewMCPAggregator creates a new MCPAggregator
-func NewMCPAggregator() *MCPAggregator {
-	return &MCPAggregator{
-		clients: make(map[string]MCPClient),
-		tools:   make(map[string]toolMapping),
-		configs: make(map[string]*config.ServerConfig),
+// compileToolPatterns compiles the tool name patterns of a server, skipping invalid ones.
+// Patterns are validated when the config is loaded, so an invalid one only ends up here if the config was built in code.
+func compileToolPatterns(serverName string, patterns []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			logger.Error("Ignoring invalid tool pattern %q of server %s: %v", pattern, serverName, err)
+			continue
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled
+}
+
+// matchesAnyPattern reports whether the original name of a tool matches any of the patterns
+func matchesAnyPattern(patterns []*regexp.Regexp, toolName string) bool {
+	for _, re := range patterns {
+		if re.MatchString(toolName) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewMCPAggregator creates a new MCPAggregator. Without options it uses the defaults of the combine-mcp binary,
+// except for the stdout guard, which is off.
+func NewMCPAggregator(opts ...Option) *MCPAggregator {
+	a := &MCPAggregator{
+		clients:             make(map[string]MCPClient),
+		tools:               make(map[string]toolMapping),
+		resources:           make(map[string]resourceMapping),
+		prompts:             make(map[string]promptMapping),
+		configs:             make(map[string]*config.ServerConfig),
+		infos:               make(map[string]mcp.Implementation),
+		caps:                make(map[string]mcp.ServerCapabilities),
+		filtered:            make(map[string][]string),
+		progressCalls:       make(map[string]progressCall),
+		aliases:             make(map[string]string),
+		clientFactory:       newMCPClient,
+		discoveryTimeout:    defaultDiscoveryTimeout,
+		callTimeout:         config.DefaultCallTimeoutSeconds * time.Second,
+		initTimeout:         config.DefaultInitTimeoutSeconds * time.Second,
+		readinessTimeout:    config.DefaultReadinessTimeoutSeconds * time.Second,
+		listChangedDebounce: defaultListChangedDebounce,
+		readinessInterval:   defaultReadinessInterval,
+		rediscoveries:       make(map[string]*time.Timer),
+		callSlots:           make(map[string]chan struct{}),
+		rateLimiters:        make(map[string]*rate.Limiter),
+		resultCache:         newResultCache(),
+		drainTimeout:        defaultDrainTimeout,
+		done:                make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// newMCPClient is the default client factory, connecting to the server over its configured transport
+func newMCPClient(serverCfg config.ServerConfig) (MCPClient, error) {
+	switch serverCfg.Transport {
+	case config.TransportSSE:
+		return newSSEMCPClient(serverCfg)
+	case config.TransportHTTP:
+		logger.Debug("Connecting to MCP server %s over HTTP at %s", serverCfg.Name, serverCfg.RedactedURL())
+		return newHTTPClient(serverCfg.URL, serverCfg.InitOptions), nil
+	default:
+		return newStdioMCPClient(serverCfg)
+	}
+}
+
+// sseClient is the mcp-go SSE client owning the context of its event stream.
+// The mcp-go client doesn't close the stream itself, so it's cancelled on Close.
+type sseClient struct {
+	*client.SSEMCPClient
+	cancel context.CancelFunc
+}
+
+// newSSEMCPClient connects to a remote server using the HTTP+SSE transport
+func newSSEMCPClient(serverCfg config.ServerConfig) (MCPClient, error) {
+	logger.Debug("Connecting to MCP server %s over SSE at %s", serverCfg.Name, serverCfg.RedactedURL())
+
+	mcpClient, err := client.NewSSEMCPClient(serverCfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSE client: %w", err)
+	}
+
+	// The event stream lives as long as the client, so it must not be tied to a request context
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := mcpClient.Start(ctx); err != nil {
+		cancel()
+		mcpClient.Close()
+		return nil, fmt.Errorf("failed to connect to %s: %w", serverCfg.RedactedURL(), err)
+	}
+	return &sseClient{SSEMCPClient: mcpClient, cancel: cancel}, nil
+}
+
+// Close fails pending requests and closes the event stream
+func (c *sseClient) Close() error {
+	err := c.SSEMCPClient.Close()
+	c.cancel()
+	return err
+}
+
+// newStdioMCPClient spawns the server as a subprocess talking over stdio
+func newStdioMCPClient(serverCfg config.ServerConfig) (MCPClient, error) {
+	// Args of the deployment are appended to those of the config
+	serverCfg = serverCfg.WithArgsEnv()
+
+	// Convert environment variables to string array format
+	var envVars []string
+	for key, value := range serverCfg.Env {
+		envVars = append(envVars, key+"="+value)
+	}
+
+	// Debug output to file only, without the secrets the server is given
+	logger.Debug("Initializing MCP server %s with command: %s %v", serverCfg.Name, serverCfg.Command, serverCfg.RedactedArgs())
+	logger.Debug("Environment variables: %v", serverCfg.RedactedEnv())
+
+	// Create an exec.Cmd manually to control stderr redirection
+	cmd := exec.Command(serverCfg.Command, serverCfg.Args...)
+	cmd.Env = append(parentEnv(serverCfg), envVars...)
+	cmd.Dir = serverCfg.WorkingDir
+
+	// A dedicated log file keeps the output of a noisy server out of our log
+	var stderr *os.File
+	if serverCfg.LogFile != "" {
+		var err error
+		stderr, err = os.OpenFile(serverCfg.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			logger.Warn("Failed to open log file for server %s, logging its stderr: %v", serverCfg.Name, err)
+			stderr = nil
+		}
+	}
+
+	// Otherwise its stderr is logged line by line, tagged with the server name
+	if stderr == nil {
+		var err error
+		stderr, err = forwardStderr(serverCfg.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	cmd.Stderr = stderr
+
+	shutdownGrace := time.Duration(serverCfg.ShutdownGraceMs) * time.Millisecond
+	if shutdownGrace <= 0 {
+		shutdownGrace = config.DefaultShutdownGraceMs * time.Millisecond
+	}
+	mcpClient, err := newStdioClient(serverCfg.Name, cmd, stderr, shutdownGrace)
+	if err != nil {
+		return nil, err
+	}
+	// Servers that need settings at startup get them in the initializationOptions
+	mcpClient.initOptions = serverCfg.InitOptions
+	return mcpClient, nil
+}
+
+// parentEnv returns the variables of our environment a server process gets: all of them,
+// or only those it inherits by name if it asks for a clean environment
+func parentEnv(serverCfg config.ServerConfig) []string {
+	if !serverCfg.CleanEnv && serverCfg.InheritEnv == nil {
+		return os.Environ()
+	}
+	// Not nil, a nil env would make the process inherit everything
+	env := []string{}
+	for _, name := range serverCfg.InheritEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// initializeClient performs the initialize handshake with a freshly created client
+func (a *MCPAggregator) initializeClient(ctx context.Context, serverCfg config.ServerConfig, mcpClient MCPClient) (*mcp.InitializeResult, error) {
+	// Sampling requests from the server are relayed to the downstream client
+	a.connectSampling(serverCfg.Name, mcpClient)
+
+	// Roots requests from the server are relayed to the downstream client too
+	a.connectRoots(serverCfg.Name, mcpClient)
+
+	// Servers announce changes to their tools, which are then rediscovered
+	a.connectNotifications(serverCfg.Name, mcpClient)
+
+	// Progress of long calls is relayed to the downstream client while they run
+	a.connectProgress(serverCfg.Name, mcpClient)
+
+	// NPM packages may need a long time for a cold install, so the timeout is configurable per server
+	timeout := serverTimeout(serverCfg.InitTimeoutSeconds, a.initTimeout)
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Legacy servers that reject the latest protocol version can be pinned to one they understand
+	initRequest := mcp.InitializeRequest{}
+	initRequest.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	if serverCfg.ProtocolVersion != "" {
+		initRequest.Params.ProtocolVersion = serverCfg.ProtocolVersion
+	}
+	initRequest.Params.ClientInfo = mcp.Implementation{
+		Name:    "mcp-aggregator",
+		Version: "1.0.0",
+	}
+	if a.samplingAdvertised() {
+		initRequest.Params.Capabilities.Sampling = &struct{}{}
+	}
+	if a.rootsAdvertised(mcpClient) {
+		initRequest.Params.Capabilities.Roots = &struct {
+			ListChanged bool `json:"listChanged,omitempty"`
+		}{ListChanged: true}
+	}
+
+	logger.Debug("Sending initialize request to %s...", serverCfg.Name)
+	return mcpClient.Initialize(ctxWithTimeout, initRequest)
+}
+
+// SetMetrics makes the aggregator record every tool call in the given registry
+func (a *MCPAggregator) SetMetrics(registry *metrics.Registry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.metrics = registry
+}
+
+// OnToolsChanged registers a callback invoked whenever the set of exposed tools changes at runtime
+func (a *MCPAggregator) OnToolsChanged(callback func()) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onToolsChanged = callback
+}
+
+// notifyToolsChanged invokes the tools-changed callback, if any
+func (a *MCPAggregator) notifyToolsChanged() {
+	a.mu.RLock()
+	callback := a.onToolsChanged
+	a.mu.RUnlock()
+
+	if callback != nil {
+		callback()
 	}
 }
 
@@ -64,130 +428,255 @@ func (a *MCPAggregator) Initialize(ctx context.Context, cfg *config.Config) erro
 		return fmt.Errorf("failed to initialize logger: %w", err)
 	}
 
-	// Override the os.Stdout during initialization to redirect it to stderr
-	// This prevents any subprocess output from corrupting our JSON stdout
-	oldStdout := os.Stdout
-
-	// Create a pipe to capture any potential stdout output from subprocesses
-	r, w, err := os.Pipe()
-	if err != nil {
-		return fmt.Errorf("failed to create output pipe: %w", err)
+	// Name templates are validated when the config is loaded, configs built in code are checked here
+	var nameTemplate *template.Template
+	if cfg.NameTemplate != "" {
+		var err error
+		nameTemplate, err = config.ParseNameTemplate(cfg.NameTemplate)
+		if err != nil {
+			return fmt.Errorf("invalid name template: %w", err)
+		}
 	}
 
-	// Replace stdout with our pipe temporarily
-	os.Stdout = w
+	a.mu.Lock()
+	a.dualNames = cfg.DualNames
+	a.readOnlyMode = cfg.ReadOnlyMode
+	// A passthrough proxy of a single server exposes its tools like the server itself does
+	a.disablePrefix = cfg.DisablePrefix || cfg.Passthrough
+	a.sanitizeMode = cfg.SanitizeMode
+	a.delimiter = cfg.Delimiter
+	a.nameTemplate = nameTemplate
+	a.samplingFallback = cfg.SamplingFallback
+	a.initReport = InitReport{}
+	a.mu.Unlock()
 
-	// Start a goroutine to read from the pipe and write to stderr
-	go func() {
-		defer r.Close()
-		buffer := make([]byte, 1024)
-		for {
-			n, err := r.Read(buffer)
-			if err != nil {
-				if err != os.ErrClosed {
-					logger.Error("Error reading subprocess output: %v", err)
-				}
-				break
-			}
-			if n > 0 {
-				// Write subprocess output to stderr instead of stdout
-				os.Stderr.Write(buffer[:n])
-			}
+	// Tool calls are audited independently of the log level
+	if cfg.AuditFile != "" {
+		audit, err := openAuditLog(cfg.AuditFile)
+		if err != nil {
+			return err
 		}
-	}()
+		a.mu.Lock()
+		if a.audit != nil {
+			a.audit.close()
+		}
+		a.audit = audit
+		a.mu.Unlock()
+	}
 
-	// Make sure we restore stdout when we're done
-	defer func() {
-		w.Close()
-		os.Stdout = oldStdout
-	}()
+	// Redirect stdout to stderr during initialization
+	// This prevents any subprocess output from corrupting our JSON stdout
+	if a.stdoutGuard && !cfg.StdoutCapture.Disabled {
+		restoreStdout, err := capture.Stdout(cfg.StdoutCapture.BufferSize)
+		if err != nil {
+			return fmt.Errorf("failed to capture stdout: %w", err)
+		}
+		defer restoreStdout()
+	}
 
+	launched := 0
 	for _, serverCfg := range cfg.Servers {
+		// Variables of the env file are passed to the server unless its env sets them
+		serverCfg, err := serverCfg.WithEnvFile()
+		if err != nil {
+			logger.Warn("Skipping server %s: %v", serverCfg.Name, err)
+			a.reportSkipped(serverCfg.Name, err)
+			continue
+		}
+
 		// Store server config for filtering
 		a.mu.Lock()
 		a.configs[serverCfg.Name] = &serverCfg
 		a.mu.Unlock()
 
-		// Convert environment variables to string array format
-		var envVars []string
-		for key, value := range serverCfg.Env {
-			envVars = append(envVars, key+"="+value)
+		// Locked-down deployments only run the commands they allow
+		if err := checkCommandAllowed(serverCfg, cfg.AllowedCommands); err != nil {
+			logger.Warn("Skipping server %s: %v", serverCfg.Name, err)
+			a.reportSkipped(serverCfg.Name, err)
+			continue
 		}
 
-		// Debug output to file only
-		logger.Debug("Initializing MCP server %s with command: %s %v", serverCfg.Name, serverCfg.Command, serverCfg.Args)
-		logger.Debug("Environment variables: %v", envVars)
+		// Servers launched in quick succession contend for resources like a shared npm cache
+		if launched > 0 && !waitStartupStagger(ctx, cfg.StartupStaggerMs) {
+			logger.Warn("Skipping server %s: %v", serverCfg.Name, ctx.Err())
+			a.reportSkipped(serverCfg.Name, ctx.Err())
+			continue
+		}
+		launched++
 
-		// Create an exec.Cmd manually to control stderr redirection
-		cmd := exec.Command(serverCfg.Command, serverCfg.Args...)
-		cmd.Stderr = os.Stderr // Redirect stderr to stderr
-		cmd.Env = append(os.Environ(), envVars...)
+		// Servers that are slow to become ready get the configured number of further attempts
+		var mcpClient MCPClient
+		var initResult *mcp.InitializeResult
+		for attempt := 0; ; attempt++ {
+			mcpClient, err = a.clientFactory(serverCfg)
+			if err != nil {
+				logger.Error("Failed to create client for server %s: %v", serverCfg.Name, err)
+				return fmt.Errorf("failed to create client for server %s: %w", serverCfg.Name, err)
+			}
 
-		// Create client
-		mcpClient, err := client.NewStdioMCPClient(
-			serverCfg.Command,
-			envVars,
-			serverCfg.Args...,
-		)
+			initResult, err = a.connectClient(ctx, serverCfg, mcpClient)
+			if err == nil || attempt >= serverCfg.InitRetries || !waitInitRetry(ctx, serverCfg, attempt) {
+				break
+			}
+		}
 		if err != nil {
-			logger.Error("Failed to create client for server %s: %v", serverCfg.Name, err)
-			return fmt.Errorf("failed to create client for server %s: %w", serverCfg.Name, err)
+			// Skip this server but continue with others
+			logger.Warn("Skipping server %s: %v", serverCfg.Name, err)
+			a.reportSkipped(serverCfg.Name, err)
+			if cfg.AllowEmptyStart {
+				a.startInBackground(serverCfg)
+			}
+			continue
 		}
+		a.reportInitialized(serverCfg.Name)
 
-		// Initialize the client with longer timeout for NPM packages
-		ctxWithTimeout, cancel := context.WithTimeout(ctx, 60*time.Second)
-		defer cancel()
+		a.discoverServerResources(ctx, serverCfg.Name, initResult)
+		a.discoverServerPrompts(ctx, serverCfg.Name, initResult)
 
-		// Initialize the client
-		initRequest := mcp.InitializeRequest{}
-		initRequest.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
-		initRequest.Params.ClientInfo = mcp.Implementation{
-			Name:    "mcp-aggregator",
-			Version: "1.0.0",
-		}
+		// Watch the server process so it can be restarted according to its policy
+		a.superviseServer(serverCfg, mcpClient)
 
-		logger.Debug("Sending initialize request to %s...", serverCfg.Name)
-		initResult, err := mcpClient.Initialize(ctxWithTimeout, initRequest)
-		if err != nil {
-			mcpClient.Close()
-			logger.Error("Failed to initialize server %s: %v", serverCfg.Name, err)
-
-			// Check if this is a context cancellation or deadline exceeded error
-			// We want to handle these more gracefully
-			if ctxWithTimeout.Err() != nil || strings.Contains(err.Error(), "context") {
-				logger.Error("Context error for server %s: %v", serverCfg.Name, err)
-				logger.Error("Skipping server %s", serverCfg.Name)
-				continue // Skip this server but continue with others
-			}
+		// Ping the server so its tools are withdrawn while it doesn't answer
+		a.monitorHealth(serverCfg)
+	}
 
-			// For other errors, we'll continue with other servers but log the error
-			logger.Error("Error initializing server %s: %v", serverCfg.Name, err)
-			logger.Error("Continuing with other servers...")
-			continue
+	// Check if we have at least one server initialized.
+	// Servers that connected without exposing tools count as initialized, they just have nothing to call yet.
+	a.mu.RLock()
+	connected, tools := len(a.clients), len(a.tools)
+	a.mu.RUnlock()
+	if connected == 0 {
+		if !cfg.AllowEmptyStart {
+			return fmt.Errorf("no servers were successfully initialized")
 		}
-		logger.Info("Server %s initialized: %s %s", serverCfg.Name, initResult.ServerInfo.Name, initResult.ServerInfo.Version)
+		logger.Warn("No servers were successfully initialized, starting without tools and retrying them in the background")
+		return nil
+	}
+	if tools == 0 {
+		logger.Warn("%d servers connected but none of them exposes any tools", connected)
+	}
+
+	return nil
+}
+
+// connectClient initializes the client of a server, registers it and discovers its tools.
+// A client that fails to initialize or whose tool discovery times out is closed and not registered.
+func (a *MCPAggregator) connectClient(ctx context.Context, serverCfg config.ServerConfig, mcpClient MCPClient) (*mcp.InitializeResult, error) {
+	initResult, err := a.initializeReady(ctx, serverCfg, mcpClient)
+	if err != nil {
+		logger.Error("Failed to initialize server %s: %v", serverCfg.Name, err)
+		return nil, err
+	}
+
+	// Store the client
+	a.mu.Lock()
+	a.clients[serverCfg.Name] = mcpClient
+	a.infos[serverCfg.Name] = initResult.ServerInfo
+	a.caps[serverCfg.Name] = initResult.Capabilities
+	a.mu.Unlock()
 
-		// Store the client
+	// Discover tools and register them with prefix
+	err = a.discoverTools(ctx, serverCfg.Name)
+	if errors.Is(err, context.DeadlineExceeded) {
+		// A server that hangs on tools/list is dropped so it can't block startup
+		logger.Error("Tool discovery for server %s timed out after %s", serverCfg.Name, a.discoveryTimeout)
 		a.mu.Lock()
-		a.clients[serverCfg.Name] = mcpClient
+		delete(a.clients, serverCfg.Name)
 		a.mu.Unlock()
+		mcpClient.Close()
+		return nil, err
+	}
+	if err != nil {
+		// The server stays connected even if tool discovery fails
+		logger.Error("Failed to discover tools for server %s: %v", serverCfg.Name, err)
+	}
+	return initResult, nil
+}
 
-		// Discover tools and register them with prefix
-		err = a.discoverTools(ctx, serverCfg.Name)
-		if err != nil {
-			logger.Error("Failed to discover tools for server %s: %v", serverCfg.Name, err)
-			// Continue with other servers even if tool discovery fails
-			logger.Error("Continuing with other servers...")
-			continue
+// initializeReady initializes the client of a server and waits until its readiness tool works.
+// Every path that connects a server goes through it, the client is closed if either step fails.
+func (a *MCPAggregator) initializeReady(ctx context.Context, serverCfg config.ServerConfig, mcpClient MCPClient) (*mcp.InitializeResult, error) {
+	initResult, err := a.initializeClient(ctx, serverCfg, mcpClient)
+	if err != nil {
+		mcpClient.Close()
+		return nil, err
+	}
+	logger.Info("Server %s initialized: %s %s", serverCfg.Name, initResult.ServerInfo.Name, initResult.ServerInfo.Version)
+
+	// Some servers answer initialize before they can serve tools, they are only used once their readiness tool works
+	if serverCfg.ReadinessTool != "" {
+		if err := a.waitReady(ctx, serverCfg, mcpClient); err != nil {
+			mcpClient.Close()
+			return nil, err
 		}
 	}
+	return initResult, nil
+}
 
-	// Check if we have at least one server initialized
-	if len(a.clients) == 0 {
-		return fmt.Errorf("no servers were successfully initialized")
+// waitInitRetry waits before the next attempt to start a server, doubling the delay on each attempt.
+// It returns false if the context is done before the delay has passed.
+func waitInitRetry(ctx context.Context, serverCfg config.ServerConfig, attempt int) bool {
+	backoff := time.Duration(serverCfg.InitRetryBackoffMs) * time.Millisecond
+	if backoff <= 0 {
+		backoff = config.DefaultInitRetryBackoffMs * time.Millisecond
 	}
+	delay := backoff << attempt
 
-	return nil
+	logger.Info("Retrying server %s in %s (attempt %d/%d)", serverCfg.Name, delay, attempt+1, serverCfg.InitRetries)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// waitStartupStagger waits the configured delay before the next server is launched.
+// It returns false if the context is done before the delay has passed.
+func waitStartupStagger(ctx context.Context, staggerMs int) bool {
+	if staggerMs <= 0 {
+		return true
+	}
+	timer := time.NewTimer(time.Duration(staggerMs) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// waitReady calls the readiness tool of a server until it succeeds or the server's readiness timeout has passed
+func (a *MCPAggregator) waitReady(ctx context.Context, serverCfg config.ServerConfig, mcpClient MCPClient) error {
+	timeout := serverTimeout(serverCfg.ReadinessTimeoutSeconds, a.readinessTimeout)
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = serverCfg.ReadinessTool
+	request.Params.Arguments = map[string]interface{}{}
+	for attempt := 1; ; attempt++ {
+		result, err := mcpClient.CallTool(ctxWithTimeout, request)
+		if err == nil && result != nil && result.IsError {
+			err = fmt.Errorf("tool returned an error: %s", resultText(result))
+		}
+		if err == nil {
+			logger.Debug("Server %s is ready after %d calls of readiness tool %s", serverCfg.Name, attempt, serverCfg.ReadinessTool)
+			return nil
+		}
+		logger.Debug("Readiness tool %s of server %s failed (attempt %d): %v", serverCfg.ReadinessTool, serverCfg.Name, attempt, err)
+
+		timer := time.NewTimer(a.readinessInterval)
+		select {
+		case <-ctxWithTimeout.Done():
+			timer.Stop()
+			return fmt.Errorf("readiness tool %s didn't succeed within %v: %w", serverCfg.ReadinessTool, timeout, err)
+		case <-timer.C:
+		}
+	}
 }
 
 // discoverTools discovers all tools available on a server and registers them with a prefix
@@ -195,123 +684,611 @@ func (a *MCPAggregator) discoverTools(ctx context.Context, serverName string) er
 	a.mu.RLock()
 	mcpClient, exists := a.clients[serverName]
 	serverConfig := a.configs[serverName]
+	disablePrefix := a.disablePrefix
+	sanitizeMode := a.sanitizeMode
+	delimiter := a.delimiter
+	nameTemplate := a.nameTemplate
+	readOnlyMode := a.readOnlyMode
 	a.mu.RUnlock()
 
 	if !exists {
 		return fmt.Errorf("client for server %s not found", serverName)
 	}
 
-	// Get tools using list method
+	// Get tools using list method, bounded so a hanging server can't block the caller forever
 	logger.Debug("Discovering tools for server %s...", serverName)
-	toolsResp, err := mcpClient.ListTools(ctx, mcp.ListToolsRequest{})
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, a.discoveryTimeout)
+	defer cancel()
+	toolsResp, annotations, err := listTools(ctxWithTimeout, mcpClient)
 	if err != nil {
 		return fmt.Errorf("failed to list tools for server %s: %w", serverName, err)
 	}
 	logger.Debug("Found %d tools for server %s", len(toolsResp.Tools), serverName)
+	if len(toolsResp.Tools) == 0 {
+		// The server is connected and keeps being listed, but contributes nothing to call
+		logger.Warn("Server %s is connected but exposes no tools", serverName)
+	}
 
-	// Create a map of allowed tools for faster lookup
+	// Create a map of allowed tools for faster lookup, tools matching an allowed pattern are allowed too
+	// A nil allowed list means no filtering, while an explicit empty list exposes nothing
 	allowedTools := make(map[string]bool)
-	if serverConfig != nil && serverConfig.Tools != nil {
+	var allowedPatterns []*regexp.Regexp
+	filterAllowed := false
+	if serverConfig != nil && serverConfig.Tools != nil && (serverConfig.Tools.Allowed != nil || len(serverConfig.Tools.AllowedPatterns) > 0) {
 		logger.Debug("Tool filtering enabled for server %s", serverName)
+		filterAllowed = true
 		for _, tool := range serverConfig.Tools.Allowed {
 			normalizedName := normalizeToolName(tool)
 			logger.Debug("Adding allowed tool: %s (normalized: %s)", tool, normalizedName)
 			allowedTools[normalizedName] = true
 		}
-		// If Tools config exists but allowed list is empty, no tools should be exposed
-		if len(serverConfig.Tools.Allowed) == 0 {
+		allowedPatterns = compileToolPatterns(serverName, serverConfig.Tools.AllowedPatterns)
+		// If the allowed list is empty, no tools should be exposed
+		if len(allowedTools) == 0 && len(allowedPatterns) == 0 {
 			logger.Debug("Empty allowed tools list for server %s, no tools will be exposed", serverName)
+			filtered := make([]string, 0, len(toolsResp.Tools))
+			for _, tool := range toolsResp.Tools {
+				filtered = append(filtered, tool.Name)
+			}
+			a.setFilteredTools(serverName, filtered)
+			a.replaceServerTools(serverName, nil)
 			return nil
 		}
 	} else {
 		logger.Debug("No tool filtering configured for server %s", serverName)
 	}
 
-	// Register each tool with a prefix
-	a.mu.Lock()
-	defer a.mu.Unlock()
+	// Collect the tools that keep their original name instead of being sanitized,
+	// and the denied tools which are removed even if they are allowed
+	unsanitizedTools := make(map[string]bool)
+	deniedTools := make(map[string]bool)
+	var deniedPatterns []*regexp.Regexp
+	if serverConfig != nil && serverConfig.Tools != nil {
+		for _, tool := range serverConfig.Tools.Unsanitized {
+			unsanitizedTools[normalizeToolName(tool)] = true
+		}
+		for _, tool := range serverConfig.Tools.Denied {
+			deniedTools[normalizeToolName(tool)] = true
+		}
+		deniedPatterns = compileToolPatterns(serverName, serverConfig.Tools.DeniedPatterns)
+	}
+	isAllowed := func(toolName string) bool {
+		return !filterAllowed || allowedTools[normalizeToolName(toolName)] || matchesAnyPattern(allowedPatterns, toolName)
+	}
+	isDenied := func(toolName string) bool {
+		return deniedTools[normalizeToolName(toolName)] || matchesAnyPattern(deniedPatterns, toolName)
+	}
+
+	// In read-only mode tools that modify anything are removed, unannotated ones are kept but reported
+	modifying := make(map[string]bool)
+	if readOnlyMode {
+		for _, tool := range toolsResp.Tools {
+			var toolAnnotations *ToolAnnotations
+			if annotated, ok := annotations[tool.Name]; ok {
+				toolAnnotations = &annotated
+			}
+			allowed, known := toolAnnotations.allowedReadOnly()
+			if !known {
+				logger.Warn("Tool %s of server %s has no read-only or destructive annotation, exposing it in read-only mode", tool.Name, serverName)
+			}
+			modifying[tool.Name] = !allowed
+		}
+	}
 
-	sanitizedServerName := sanitizeToolName(serverName)
+	// Build the prefixed mappings off-lock and swap them in afterwards
+	mappings := make(map[string]toolMapping, len(toolsResp.Tools))
+	var filtered []string
+	prefix := toolPrefix(serverName, serverConfig, disablePrefix)
+	sanitizedPrefix := config.SanitizeToolName(prefix, sanitizeMode)
+	namePrefix := exposedPrefix(sanitizedPrefix, delimiter)
+	exposedName := func(originalName, sanitizedName string, unsanitized bool) string {
+		if nameTemplate == nil {
+			return exposedToolName(sanitizedPrefix, delimiter, sanitizedName)
+		}
+		name, err := config.ExecuteNameTemplate(nameTemplate, config.ToolNameData{Server: prefix, Tool: originalName, Sanitized: sanitizedName})
+		if err != nil {
+			logger.Error("Failed to apply name template to tool %s of server %s, exposing it under its prefixed name: %v", originalName, serverName, err)
+			return exposedToolName(sanitizedPrefix, delimiter, sanitizedName)
+		}
+		// Templates may produce characters the client doesn't accept, unless the tool is configured to keep its name
+		if unsanitized {
+			return name
+		}
+		return config.SanitizeToolName(name, sanitizeMode)
+	}
+	if nameTemplate != nil {
+		// Templated names don't necessarily start with a common prefix
+		namePrefix = ""
+	}
 	for _, tool := range toolsResp.Tools {
 		// Skip if tool filtering is enabled and tool is not in allowed list
-		if len(allowedTools) > 0 {
+		if filterAllowed {
 			normalizedName := normalizeToolName(tool.Name)
-			if !allowedTools[normalizedName] {
+			if !isAllowed(tool.Name) {
 				logger.Debug("Skipping tool %s (normalized: %s) as it's not in allowed list for server %s", tool.Name, normalizedName, serverName)
+				filtered = append(filtered, tool.Name)
 				continue
 			}
 			logger.Debug("Including allowed tool %s (normalized: %s) for server %s", tool.Name, normalizedName, serverName)
 		}
 
+		// Deny is applied after allow
+		if isDenied(tool.Name) {
+			logger.Debug("Skipping tool %s as it's denied for server %s", tool.Name, serverName)
+			filtered = append(filtered, tool.Name)
+			continue
+		}
+
+		if modifying[tool.Name] {
+			logger.Debug("Skipping tool %s of server %s as it isn't read-only", tool.Name, serverName)
+			filtered = append(filtered, tool.Name)
+			continue
+		}
+
 		originalName := tool.Name
-		sanitizedName := sanitizeToolName(originalName)
-		prefixedName := fmt.Sprintf("%s_%s", sanitizedServerName, sanitizedName)
+		sanitizedName := config.SanitizeToolName(originalName, sanitizeMode)
+		unsanitized := unsanitizedTools[normalizeToolName(originalName)]
+		if unsanitized {
+			logger.Debug("Keeping original name for tool %s on server %s", originalName, serverName)
+			sanitizedName = originalName
+		}
+		prefixedName := exposedName(originalName, sanitizedName, unsanitized)
+		if existing, duplicate := mappings[prefixedName]; duplicate {
+			// Tools of the same server can collide after sanitization, e.g. get-user and get_user
+			uniqueName := uniqueToolName(prefixedName, func(name string) bool {
+				_, exists := mappings[name]
+				return exists
+			})
+			logger.Warn("Tool %s of server %s collides with tool %s as %s, exposing it as %s",
+				originalName, serverName, existing.originalName, prefixedName, uniqueName)
+			prefixedName = uniqueName
+		}
 
 		logger.Debug("Registering tool: %s -> %s (sanitized from: %s)", originalName, prefixedName, tool.Name)
 
-		a.tools[prefixedName] = toolMapping{
+		mapping := toolMapping{
 			serverName:    serverName,
 			originalName:  originalName,
 			sanitizedName: sanitizedName,
+			exposedPrefix: namePrefix,
+			tool:          tool,
+		}
+		if serverConfig != nil {
+			if description, ok := serverConfig.DescriptionOverrides[originalName]; ok {
+				logger.Debug("Using description override for tool %s", prefixedName)
+				mapping.description = description
+			}
+		}
+		mappings[prefixedName] = mapping
+	}
+
+	// Register preset-backed virtual tools for upstream tools that exist
+	if serverConfig != nil && serverConfig.Tools != nil {
+		upstreamTools := make(map[string]mcp.Tool, len(toolsResp.Tools))
+		for _, tool := range toolsResp.Tools {
+			upstreamTools[tool.Name] = tool
+		}
+		for presetName, preset := range serverConfig.Tools.Presets {
+			upstreamTool, found := upstreamTools[preset.Tool]
+			if !found {
+				logger.Error("Skipping preset %s: tool %s not found on server %s", presetName, preset.Tool, serverName)
+				continue
+			}
+			// A preset can't expose a tool the filters hide
+			if !isAllowed(preset.Tool) {
+				logger.Debug("Skipping preset %s: tool %s is not in allowed list for server %s", presetName, preset.Tool, serverName)
+				continue
+			}
+			if isDenied(preset.Tool) {
+				logger.Debug("Skipping preset %s: tool %s is denied for server %s", presetName, preset.Tool, serverName)
+				continue
+			}
+			if modifying[preset.Tool] {
+				logger.Debug("Skipping preset %s: tool %s of server %s isn't read-only", presetName, preset.Tool, serverName)
+				continue
+			}
+
+			prefixedName := exposedName(presetName, config.SanitizeToolName(presetName, sanitizeMode), false)
+			logger.Debug("Registering preset tool: %s -> %s with args %v", prefixedName, preset.Tool, preset.Args)
+
+			mappings[prefixedName] = toolMapping{
+				serverName:    serverName,
+				originalName:  preset.Tool,
+				sanitizedName: config.SanitizeToolName(presetName, sanitizeMode),
+				exposedPrefix: namePrefix,
+				tool:          upstreamTool,
+				presetArgs:    preset.Args,
+				description:   preset.Description,
+			}
 		}
 	}
 
+	a.setFilteredTools(serverName, filtered)
+	a.replaceServerTools(serverName, mappings)
 	return nil
 }
 
-// GetTools returns a list of all tools from all servers with prefixed names
-func (a *MCPAggregator) GetTools() []mcp.Tool {
-	a.mu.RLock()
-	defer a.mu.RUnlock()
+// setFilteredTools records the tools of a server that its filters removed in the last discovery
+func (a *MCPAggregator) setFilteredTools(serverName string, filtered []string) {
+	sort.Strings(filtered)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.filtered[serverName] = filtered
+}
+
+// replaceServerTools swaps the registered tools of a server for the given mappings under a brief write lock
+func (a *MCPAggregator) replaceServerTools(serverName string, mappings map[string]toolMapping) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-	// Get tools from all servers
-	var allTools []mcp.Tool
 	for prefixedName, mapping := range a.tools {
-		mcpClient := a.clients[mapping.serverName]
+		if mapping.serverName == serverName {
+			delete(a.tools, prefixedName)
+		}
+	}
+	// Register in a stable order, so suffixes for colliding names are assigned deterministically
+	names := make([]string, 0, len(mappings))
+	for prefixedName := range mappings {
+		names = append(names, prefixedName)
+	}
+	sort.Strings(names)
 
-		// Get the original tool schema using ListTools
-		toolsResp, err := mcpClient.ListTools(context.Background(), mcp.ListToolsRequest{})
-		if err != nil {
-			// Skip tools that can't be retrieved
-			logger.Error("Error getting tools for %s: %v", mapping.serverName, err)
+	for _, prefixedName := range names {
+		mapping := mappings[prefixedName]
+		existing, taken := a.tools[prefixedName]
+		if taken && existing.serverName != serverName {
+			// Without prefixes, servers can expose the same name and the first one registered keeps it
+			if prefixedName == mapping.sanitizedName {
+				logger.Warn("Tool name %s of server %s collides with server %s, keeping the tool of server %s",
+					prefixedName, serverName, existing.serverName, existing.serverName)
+				continue
+			}
+
+			// Prefixed names only collide through sanitization, so both tools are kept reachable
+			uniqueName := uniqueToolName(prefixedName, func(name string) bool {
+				_, registered := a.tools[name]
+				_, pending := mappings[name]
+				return registered || pending
+			})
+			logger.Warn("Tool %s of server %s collides with tool %s of server %s as %s, exposing it as %s",
+				mapping.originalName, serverName, existing.originalName, existing.serverName, prefixedName, uniqueName)
+			prefixedName = uniqueName
+		}
+		a.tools[prefixedName] = mapping
+	}
+	a.rebuildAliases()
+}
+
+// rebuildAliases registers unprefixed names for tools whose unprefixed name is unique
+// across all servers and doesn't clash with a prefixed name. Must be called with the write lock held.
+func (a *MCPAggregator) rebuildAliases() {
+	a.aliases = make(map[string]string)
+	if !a.dualNames {
+		return
+	}
+
+	candidates := make(map[string][]string)
+	for prefixedName, mapping := range a.tools {
+		// Tools without a prefix already have their unprefixed name
+		if prefixedName == mapping.sanitizedName {
 			continue
 		}
+		candidates[mapping.sanitizedName] = append(candidates[mapping.sanitizedName], prefixedName)
+	}
 
-		// Find the specific tool
-		var tool mcp.Tool
-		found := false
-		for _, t := range toolsResp.Tools {
-			if t.Name == mapping.originalName {
-				tool = t
-				found = true
-				break
-			}
+	collisions := make(map[string]bool)
+	for name, prefixedNames := range candidates {
+		if _, taken := a.tools[name]; !taken && len(prefixedNames) == 1 {
+			a.aliases[name] = prefixedNames[0]
+			continue
 		}
 
-		if !found {
-			logger.Debug("Tool %s not found in server %s", mapping.originalName, mapping.serverName)
+		// Only warn about collisions once, not on every rediscovery
+		collisions[name] = true
+		if !a.aliasCollisions[name] {
+			sort.Strings(prefixedNames)
+			logger.Warn("Unprefixed tool name %s collides (%s), exposing it only under its prefixed name", name, strings.Join(prefixedNames, ", "))
+		}
+	}
+	a.aliasCollisions = collisions
+}
+
+// resolveToolName maps an exposed tool name, prefixed or unprefixed, to its prefixed name.
+// Must be called with the lock held.
+func (a *MCPAggregator) resolveToolName(name string) string {
+	if _, exists := a.tools[name]; exists {
+		return name
+	}
+	if prefixedName, exists := a.aliases[name]; exists {
+		return prefixedName
+	}
+	return name
+}
+
+// GetTools returns a list of all tools from all servers with prefixed names
+func (a *MCPAggregator) GetTools() []mcp.Tool {
+	// Snapshot the state so the tools are built without holding the lock
+	a.mu.RLock()
+	mappings := make(map[string]toolMapping, len(a.tools))
+	for prefixedName, mapping := range a.tools {
+		mappings[prefixedName] = mapping
+	}
+	clients := make(map[string]MCPClient, len(a.clients))
+	for name, mcpClient := range a.clients {
+		clients[name] = mcpClient
+	}
+	configs := make(map[string]*config.ServerConfig, len(a.configs))
+	for name, serverConfig := range a.configs {
+		configs[name] = serverConfig
+	}
+	aliases := make(map[string]string, len(a.aliases))
+	for name, prefixedName := range a.aliases {
+		aliases[name] = prefixedName
+	}
+	a.mu.RUnlock()
+
+	// Get tools from all servers
+	var allTools []mcp.Tool
+	for prefixedName, mapping := range mappings {
+		if _, exists := clients[mapping.serverName]; !exists {
+			logger.Debug("Client for server %s not found", mapping.serverName)
 			continue
 		}
 
+		// Work on a copy of the tool cached at discovery, so the cache itself is never modified
+		tool := mapping.tool
+
 		// Create a new tool with the prefixed name (with underscores instead of dashes)
 		tool.Name = prefixedName
+		if mapping.description != "" {
+			tool.Description = mapping.description
+		}
+
+		// Preset arguments are fixed, so they are hidden from the exposed schema
+		if len(mapping.presetArgs) > 0 {
+			tool.InputSchema = withoutParameters(tool.InputSchema, mapping.presetArgs)
+		}
+
+		// Parameters with configured defaults don't have to be passed anymore
+		if argDefaults := findArgDefaults(configs[mapping.serverName], mapping.originalName); len(argDefaults) > 0 {
+			tool.InputSchema = withOptionalParameters(tool.InputSchema, argDefaults)
+		}
 
 		// Update the description to indicate the source server
 		if tool.Description != "" {
 			tool.Description = fmt.Sprintf("[%s] %s", mapping.serverName, tool.Description)
 		}
 
+		// Replace the upstream schema if the config provides an override
+		if override, ok := findToolOverride(configs[mapping.serverName], mapping.originalName); ok && override.Schema != nil {
+			logger.Debug("Using schema override for tool %s", prefixedName)
+			tool.InputSchema = mcp.ToolInputSchema{}
+			tool.RawInputSchema = override.Schema
+		}
+
 		// Ensure the tool has a valid input schema for Cursor
 		ensureValidToolSchema(&tool)
 
 		allTools = append(allTools, tool)
 	}
 
+	// Expose the same tools under their unprefixed names
+	if len(aliases) > 0 {
+		toolsByName := make(map[string]mcp.Tool, len(allTools))
+		for _, tool := range allTools {
+			toolsByName[tool.Name] = tool
+		}
+		for name, prefixedName := range aliases {
+			if tool, ok := toolsByName[prefixedName]; ok {
+				tool.Name = name
+				allTools = append(allTools, tool)
+			}
+		}
+	}
+
 	return allTools
 }
 
+// withoutParameters returns a copy of the schema without the given parameters
+func withoutParameters(schema mcp.ToolInputSchema, params map[string]interface{}) mcp.ToolInputSchema {
+	result := mcp.ToolInputSchema{Type: schema.Type}
+	if schema.Properties != nil {
+		result.Properties = make(map[string]interface{}, len(schema.Properties))
+		for name, property := range schema.Properties {
+			if _, fixed := params[name]; !fixed {
+				result.Properties[name] = property
+			}
+		}
+	}
+	for _, name := range schema.Required {
+		if _, fixed := params[name]; !fixed {
+			result.Required = append(result.Required, name)
+		}
+	}
+	return result
+}
+
+// withOptionalParameters returns a copy of the schema in which the given parameters are not required
+func withOptionalParameters(schema mcp.ToolInputSchema, params map[string]interface{}) mcp.ToolInputSchema {
+	result := schema
+	result.Required = nil
+	for _, name := range schema.Required {
+		if _, hasDefault := params[name]; !hasDefault {
+			result.Required = append(result.Required, name)
+		}
+	}
+	return result
+}
+
+// findArgDefaults returns the configured default arguments of a tool, matching names the same way as the allowed list
+func findArgDefaults(serverConfig *config.ServerConfig, toolName string) map[string]interface{} {
+	if serverConfig == nil {
+		return nil
+	}
+
+	normalizedName := normalizeToolName(toolName)
+	for name, defaults := range serverConfig.ArgDefaults {
+		if normalizeToolName(name) == normalizedName {
+			return defaults
+		}
+	}
+	return nil
+}
+
+// callSchema returns the input schema calls of a tool are validated against: the schema override
+// if the config provides one, since clients only see the override, and the upstream schema otherwise
+func callSchema(serverConfig *config.ServerConfig, mapping toolMapping) mcp.ToolInputSchema {
+	override, ok := findToolOverride(serverConfig, mapping.originalName)
+	if !ok || override.Schema == nil {
+		return mapping.tool.InputSchema
+	}
+	var schema mcp.ToolInputSchema
+	if err := json.Unmarshal(override.Schema, &schema); err != nil {
+		// Overrides are validated when the config is loaded, so one that can't be decoded leaves nothing to check against
+		logger.Debug("Not validating calls of tool %s, its schema override can't be decoded: %v", mapping.originalName, err)
+		return mcp.ToolInputSchema{}
+	}
+	return schema
+}
+
+// findToolOverride returns the configured override for a tool, matching names the same way as the allowed list
+func findToolOverride(serverConfig *config.ServerConfig, toolName string) (config.ToolOverride, bool) {
+	if serverConfig == nil || serverConfig.Tools == nil {
+		return config.ToolOverride{}, false
+	}
+
+	normalizedName := normalizeToolName(toolName)
+	for name, override := range serverConfig.Tools.Overrides {
+		if normalizeToolName(name) == normalizedName {
+			return override, true
+		}
+	}
+	return config.ToolOverride{}, false
+}
+
+// ToolServer returns the name of the server that serves an exposed tool
+func (a *MCPAggregator) ToolServer(toolName string) (string, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	mapping, exists := a.tools[a.resolveToolName(toolName)]
+	return mapping.serverName, exists
+}
+
+// ToolOrigin returns where an exposed tool comes from: the server serving it, the tool's name on that server,
+// and the command and args the server was started with. Secrets in the args are redacted as they end up in logs.
+// Remote servers have their URL as command and no args.
+func (a *MCPAggregator) ToolOrigin(prefixedName string) (serverName, originalName, command string, args []string, ok bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	mapping, exists := a.tools[a.resolveToolName(prefixedName)]
+	if !exists {
+		return "", "", "", nil, false
+	}
+
+	serverName, originalName = mapping.serverName, mapping.originalName
+	if serverConfig := a.configs[serverName]; serverConfig != nil {
+		switch serverConfig.Transport {
+		case config.TransportSSE, config.TransportHTTP:
+			command = serverConfig.RedactedURL()
+		default:
+			command = serverConfig.Command
+			args = serverConfig.RedactedArgs()
+		}
+	}
+	return serverName, originalName, command, args, true
+}
+
+// ServerStatus describes a connected server
+type ServerStatus struct {
+	Name          string `json:"name"`          // Name of the server in the config
+	ServerName    string `json:"serverName"`    // Name the server reported when initialized
+	ServerVersion string `json:"serverVersion"` // Version the server reported when initialized
+	Tools         int    `json:"tools"`         // Number of tools the server contributes
+	ToolPrefix    string `json:"toolPrefix"`    // Start of the exposed names of the server's tools, empty if unprefixed
+}
+
+// ServerStatuses describes the connected servers, sorted by name
+func (a *MCPAggregator) ServerStatuses() []ServerStatus {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	toolCounts := make(map[string]int)
+	toolPrefixes := make(map[string]string)
+	for _, mapping := range a.tools {
+		toolCounts[mapping.serverName]++
+		toolPrefixes[mapping.serverName] = mapping.exposedPrefix
+	}
+
+	statuses := make([]ServerStatus, 0, len(a.clients))
+	for name := range a.clients {
+		statuses = append(statuses, ServerStatus{
+			Name:          name,
+			ServerName:    a.infos[name].Name,
+			ServerVersion: a.infos[name].Version,
+			Tools:         toolCounts[name],
+			ToolPrefix:    toolPrefixes[name],
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// ServerTools lists the tools of a connected server
+type ServerTools struct {
+	Name     string   // Name of the server in the config
+	Tools    []string // Exposed names of the registered tools, sorted
+	Filtered []string // Original names of the tools removed by the server's filters, sorted
+}
+
+// ToolsByServer lists the registered and filtered out tools of every connected server, sorted by server name
+func (a *MCPAggregator) ToolsByServer() []ServerTools {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	registered := make(map[string][]string)
+	for prefixedName, mapping := range a.tools {
+		registered[mapping.serverName] = append(registered[mapping.serverName], prefixedName)
+	}
+
+	servers := make([]ServerTools, 0, len(a.clients))
+	for name := range a.clients {
+		tools := registered[name]
+		sort.Strings(tools)
+		servers = append(servers, ServerTools{
+			Name:     name,
+			Tools:    tools,
+			Filtered: a.filtered[name],
+		})
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].Name < servers[j].Name
+	})
+	return servers
+}
+
+// ServerCount returns the number of connected servers
+func (a *MCPAggregator) ServerCount() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.clients)
+}
+
+// ToolCount returns the number of tools exposed across all servers
+func (a *MCPAggregator) ToolCount() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.tools) + len(a.aliases)
+}
+
 // ensureValidToolSchema ensures the tool's input schema is in a format Cursor expects
 func ensureValidToolSchema(tool *mcp.Tool) {
+	// A raw schema is passed through as-is and must not be mixed with the structured one
+	if tool.RawInputSchema != nil {
+		return
+	}
+
 	// Ensure the input schema has required fields
 	if tool.InputSchema.Type == "" {
 		tool.InputSchema.Type = "object"
@@ -323,12 +1300,83 @@ func ensureValidToolSchema(tool *mcp.Tool) {
 	}
 }
 
+// InvokeTool calls the tool exposed under the given name with the given arguments, like CallTool.
+// It lets programs embedding the aggregator call tools without building a JSON-RPC request.
+func (a *MCPAggregator) InvokeTool(ctx context.Context, name string, args map[string]any) (*mcp.CallToolResult, error) {
+	request := mcp.CallToolRequest{}
+	request.Method = string(mcp.MethodToolsCall)
+	request.Params.Name = name
+	request.Params.Arguments = args
+	return a.CallTool(ctx, request)
+}
+
 // CallTool calls a tool on the appropriate server
 func (a *MCPAggregator) CallTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	a.mu.RLock()
-	prefixedName := request.Params.Name
+	// Calls are counted while holding the lock, so none starts once Close waits for them to drain
+	select {
+	case <-a.done:
+		a.mu.RUnlock()
+		return nil, errAggregatorClosed
+	default:
+	}
+	a.calls.Add(1)
+	defer a.calls.Done()
+
+	registry := a.metrics
+	audit := a.audit
+	prefixedName := a.resolveToolName(request.Params.Name)
+	mapping, exists := a.tools[prefixedName]
+	a.mu.RUnlock()
+
+	// Calls under an alias are counted with those under the prefixed name
+	metricsTool := prefixedName
+	if !exists {
+		metricsTool = unknownToolLabel
+	}
+
+	// Calls made through the library rather than a client request get their own correlation ID
+	if CorrelationID(ctx) == "" {
+		ctx = WithCorrelationID(ctx, NewCorrelationID())
+	}
+
+	// Spans are no-ops unless tracing has been set up
+	ctx, span := otel.Tracer(tracerName).Start(ctx, prefixedName, trace.WithAttributes(
+		attribute.String("server.name", mapping.serverName),
+		attribute.String("tool.original_name", mapping.originalName),
+	))
+	defer span.End()
+
+	// Relay the progress the server reports while the call runs, if the caller asked for it
+	untrackProgress := a.trackProgress(ctx, mapping.serverName, request)
+	defer untrackProgress()
+
+	start := time.Now()
+	result, err := a.callTool(ctx, request)
+	failed := err != nil || (result != nil && result.IsError)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else if failed {
+		span.SetStatus(codes.Error, "tool returned an error")
+	}
+
+	if registry != nil {
+		registry.ObserveCall(mapping.serverName, metricsTool, time.Since(start), failed)
+	}
+	if audit != nil {
+		audit.record(newAuditEntry(start, prefixedName, mapping.serverName, request.Params.Arguments, result, err))
+	}
+	return result, err
+}
+
+// callTool routes a tool call to the server that owns the tool
+func (a *MCPAggregator) callTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	a.mu.RLock()
+	prefixedName := a.resolveToolName(request.Params.Name)
 	mapping, exists := a.tools[prefixedName]
 	mcpClient, clientExists := a.clients[mapping.serverName]
+	serverConfig := a.configs[mapping.serverName]
 	a.mu.RUnlock()
 
 	if !exists {
@@ -339,23 +1387,286 @@ func (a *MCPAggregator) CallTool(ctx context.Context, request mcp.CallToolReques
 		return nil, fmt.Errorf("client for server %s not found", mapping.serverName)
 	}
 
-	logger.Debug("Calling tool %s on server %s (mapped from %s)", mapping.originalName, mapping.serverName, prefixedName)
+	// Enforce configured argument constraints before anything reaches the upstream server
+	if override, ok := findToolOverride(serverConfig, mapping.originalName); ok {
+		if err := checkAllowedValues(override.AllowedValues, request.Params.Arguments); err != nil {
+			logger.Info("Rejected call to tool %s: %v", prefixedName, err)
+			return newToolErrorResult("Invalid arguments for tool %s: %v", prefixedName, err), nil
+		}
+	}
+
+	// Read-only tools marked cacheable are answered from the cache while their result is fresh
+	var cacheKey string
+	cacheTTL := cacheTTL(serverConfig, mapping.originalName)
+	if cacheTTL > 0 {
+		cacheKey = resultCacheKey(prefixedName, request.Params.Arguments)
+		if result, ok := a.resultCache.get(cacheKey); ok {
+			logger.Debug("Answering call to tool %s from the cache", prefixedName)
+			return result, nil
+		}
+	}
+
+	// Rate limited upstream APIs only get as many calls as they allow
+	if err := a.waitRateLimit(ctx, mapping.serverName, serverConfig); err != nil {
+		if errors.Is(err, errRateLimited) {
+			logger.Info("Rejected call to tool %s: %v", prefixedName, err)
+			return newToolErrorResult("Tool %s was not called: %v", prefixedName, err), nil
+		}
+		return nil, err
+	}
+
+	// Servers that don't handle parallel requests well only get a limited number of calls at a time
+	release, err := a.acquireCallSlot(ctx, mapping.serverName, serverConfig)
+	if errors.Is(err, errServerBusy) {
+		logger.Info("Rejected call to tool %s: %v", prefixedName, err)
+		return newToolErrorResult("Server %s is busy, try again later", mapping.serverName), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	logger.Debug("Calling tool %s on server %s (mapped from %s), correlation=%s", mapping.originalName, mapping.serverName, prefixedName, CorrelationID(ctx))
 
 	// Create a new request with the original tool name (without prefix and with original dashes)
 	newRequest := request
 	newRequest.Params.Name = mapping.originalName
 
+	// Merge default and preset arguments into a copy so the caller's arguments aren't modified.
+	// Arguments of the caller take precedence over defaults, preset arguments over both.
+	argDefaults := findArgDefaults(serverConfig, mapping.originalName)
+	if len(argDefaults) > 0 || len(mapping.presetArgs) > 0 {
+		arguments := make(map[string]interface{}, len(argDefaults)+len(request.Params.Arguments)+len(mapping.presetArgs))
+		for name, value := range argDefaults {
+			arguments[name] = value
+		}
+		for name, value := range request.Params.Arguments {
+			arguments[name] = value
+		}
+		for name, value := range mapping.presetArgs {
+			arguments[name] = value
+		}
+		newRequest.Params.Arguments = arguments
+	}
+
+	// Catch invalid arguments before the round trip to the server
+	if serverConfig != nil && serverConfig.ValidateArgs {
+		if err := validateArguments(callSchema(serverConfig, mapping), newRequest.Params.Arguments); err != nil {
+			logger.Info("Rejected call to tool %s: %v", prefixedName, err)
+			return newToolErrorResult("Invalid arguments for tool %s: %v", prefixedName, err), nil
+		}
+	}
+
 	// Call the tool on the appropriate server
-	return mcpClient.CallTool(ctx, newRequest)
+	result, err := a.forwardCall(ctx, mcpClient, serverConfig, mapping.serverName, prefixedName, newRequest)
+	if cacheKey != "" && err == nil && result != nil && !result.IsError {
+		a.resultCache.put(mapping.serverName, cacheKey, result, cacheTTL)
+	}
+	return result, err
+}
+
+// forwardCall calls a tool on a server, respawning the server and retrying if its process is gone
+func (a *MCPAggregator) forwardCall(ctx context.Context, mcpClient MCPClient, serverConfig *config.ServerConfig, serverName, prefixedName string, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	result, err := a.callWithTimeout(ctx, mcpClient, serverConfig, prefixedName, request)
+	if err == nil || !isBrokenClientError(err) || !a.reconnectsOnCall(serverName) {
+		return result, err
+	}
+
+	// The server process is gone, respawn it and retry the call, waiting longer after every failed attempt
+	backoff := config.DefaultRestartBackoffMs * time.Millisecond
+	if serverConfig != nil {
+		backoff = newRestartPolicy(*serverConfig).backoff
+	}
+	for attempt := 1; attempt <= maxReconnectAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return nil, err
+			case <-a.done:
+				return nil, err
+			case <-time.After(backoff):
+			}
+			backoff = nextRestartBackoff(backoff)
+		}
+
+		logger.Error("Server %s is unreachable (%v), reconnecting (attempt %d/%d)", serverName, err, attempt, maxReconnectAttempts)
+		if reconnectErr := a.reconnect(serverName); reconnectErr != nil {
+			logger.Error("Failed to reconnect server %s: %v", serverName, reconnectErr)
+			continue
+		}
+
+		a.mu.RLock()
+		mcpClient, clientExists := a.clients[serverName]
+		a.mu.RUnlock()
+		if !clientExists {
+			continue
+		}
+
+		result, err = a.callWithTimeout(ctx, mcpClient, serverConfig, prefixedName, request)
+		if err == nil || !isBrokenClientError(err) {
+			return result, err
+		}
+	}
+	return nil, err
+}
+
+// callWithTimeout calls a tool, bounded by the call timeout of its server.
+// A call that times out is answered with a tool error instead of blocking the client.
+func (a *MCPAggregator) callWithTimeout(ctx context.Context, mcpClient MCPClient, serverConfig *config.ServerConfig, prefixedName string, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	timeout := a.callTimeout
+	if serverConfig != nil {
+		timeout = serverTimeout(serverConfig.CallTimeoutSeconds, a.callTimeout)
+	}
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	result, err := mcpClient.CallTool(ctxWithTimeout, request)
+	if err != nil && errors.Is(ctxWithTimeout.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		logger.Error("Tool call %s timed out after %v", prefixedName, timeout)
+		return newToolErrorResult("Tool %s timed out after %v", prefixedName, timeout), nil
+	}
+	return result, err
+}
+
+// acquireCallSlot waits for a free call slot of a server with a concurrency limit, or fails with errServerBusy
+// if the server doesn't queue calls. The returned function releases the slot.
+func (a *MCPAggregator) acquireCallSlot(ctx context.Context, serverName string, serverConfig *config.ServerConfig) (func(), error) {
+	if serverConfig == nil || serverConfig.MaxConcurrentCalls <= 0 {
+		return func() {}, nil
+	}
+
+	a.mu.Lock()
+	slots, exists := a.callSlots[serverName]
+	if !exists {
+		slots = make(chan struct{}, serverConfig.MaxConcurrentCalls)
+		a.callSlots[serverName] = slots
+	}
+	a.mu.Unlock()
+
+	release := func() { <-slots }
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if !serverConfig.QueueCalls {
+		return nil, fmt.Errorf("%w: %d calls to server %s in progress", errServerBusy, serverConfig.MaxConcurrentCalls, serverName)
+	}
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// waitRateLimit waits until a call to a server with a rate limit may start, or fails with errRateLimited
+// if the server rejects calls over its limit
+func (a *MCPAggregator) waitRateLimit(ctx context.Context, serverName string, serverConfig *config.ServerConfig) error {
+	if serverConfig == nil || serverConfig.RateLimitPerMinute <= 0 {
+		return nil
+	}
+
+	a.mu.Lock()
+	limiter, exists := a.rateLimiters[serverName]
+	if !exists {
+		limiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(serverConfig.RateLimitPerMinute)), 1)
+		a.rateLimiters[serverName] = limiter
+	}
+	a.mu.Unlock()
+
+	if !serverConfig.RejectRateLimited {
+		return limiter.Wait(ctx)
+	}
+	reservation := limiter.Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		return fmt.Errorf("%w: server %s allows %d calls per minute, try again in %v",
+			errRateLimited, serverName, serverConfig.RateLimitPerMinute, delay.Round(time.Millisecond))
+	}
+	return nil
+}
+
+// checkAllowedValues verifies that every constrained argument present in the call uses one of its allowed values
+func checkAllowedValues(allowedValues map[string][]interface{}, arguments map[string]interface{}) error {
+	for param, allowed := range allowedValues {
+		value, exists := arguments[param]
+		if !exists {
+			continue
+		}
+		if !containsValue(allowed, value) {
+			return fmt.Errorf("value %v is not allowed for parameter %s (allowed: %v)", value, param, allowed)
+		}
+	}
+	return nil
+}
+
+// containsValue compares values by their JSON encoding so numbers match regardless of their Go type
+func containsValue(values []interface{}, value interface{}) bool {
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+	for _, candidate := range values {
+		encodedCandidate, err := json.Marshal(candidate)
+		if err == nil && bytes.Equal(encodedCandidate, encodedValue) {
+			return true
+		}
+	}
+	return false
+}
+
+// newToolErrorResult creates a tool result that reports an error to the client without failing the request
+func newToolErrorResult(format string, v ...interface{}) *mcp.CallToolResult {
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{mcp.NewTextContent(fmt.Sprintf(format, v...))},
+		IsError: true,
+	}
 }
 
-// Close closes all client connections
+// Close waits a bounded grace period for tool calls in flight and closes all client connections
 func (a *MCPAggregator) Close() {
+	// Stop supervisors and new calls first so closing clients doesn't trigger restarts
+	a.mu.Lock()
+	a.closeOnce.Do(func() {
+		close(a.done)
+	})
+	a.mu.Unlock()
+
+	// Calls in flight get a grace period to finish, so the client isn't left waiting for their responses
+	drained := make(chan struct{})
+	go func() {
+		a.calls.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(a.drainTimeout):
+		logger.Error("Tool calls still running after %v, closing servers anyway", a.drainTimeout)
+	}
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
+	for name, timer := range a.rediscoveries {
+		timer.Stop()
+		delete(a.rediscoveries, name)
+	}
+	// Servers are closed concurrently, so stubborn processes don't add up their shutdown grace periods
+	var closing sync.WaitGroup
 	for name, mcpClient := range a.clients {
-		mcpClient.Close()
+		closing.Add(1)
+		go func() {
+			defer closing.Done()
+			if err := mcpClient.Close(); err != nil {
+				logger.Error("Failed to close server %s: %v", name, err)
+			}
+		}()
 		delete(a.clients, name)
 	}
+	closing.Wait()
+	if a.audit != nil {
+		a.audit.close()
+	}
 }
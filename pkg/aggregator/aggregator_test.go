@@ -2,60 +2,345 @@ package aggregator
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/nazar256/combine-mcp/pkg/config"
+	"github.com/nazar256/combine-mcp/pkg/logger"
+	"github.com/nazar256/combine-mcp/pkg/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
-func TestSanitizeToolName(t *testing.T) {
+func TestSanitizeModeMapsCallsBack(t *testing.T) {
 	tests := []struct {
-		name     string
-		input    string
-		expected string
+		mode        string
+		wantExposed string
+	}{
+		{mode: config.SanitizeCursor, wantExposed: "my_server_get_user.v2"},
+		{mode: config.SanitizeNone, wantExposed: "my-server_get-user.v2"},
+		{mode: config.SanitizeStrict, wantExposed: "my_server_get_userv2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			mockClient := &MockClient{Tools: []mcp.Tool{{Name: "get-user.v2"}}}
+			agg := NewMCPAggregator()
+			agg.sanitizeMode = tt.mode
+			agg.clients["my-server"] = mockClient
+			agg.configs["my-server"] = &config.ServerConfig{Name: "my-server", Command: "test-command"}
+
+			if err := agg.discoverTools(context.Background(), "my-server"); err != nil {
+				t.Fatalf("discoverTools() error = %v", err)
+			}
+			tools := agg.GetTools()
+			if len(tools) != 1 || tools[0].Name != tt.wantExposed {
+				t.Fatalf("GetTools() = %v, want a single tool named %s", tools, tt.wantExposed)
+			}
+
+			request := mcp.CallToolRequest{}
+			request.Params.Name = tt.wantExposed
+			if _, err := agg.CallTool(context.Background(), request); err != nil {
+				t.Fatalf("CallTool() error = %v", err)
+			}
+			if len(mockClient.Calls) != 1 || mockClient.Calls[0].Params.Name != "get-user.v2" {
+				t.Errorf("Upstream calls = %v, want a call to get-user.v2", mockClient.Calls)
+			}
+		})
+	}
+}
+
+func TestNameTemplate(t *testing.T) {
+	tests := []struct {
+		name        string
+		template    string
+		mode        string
+		prefix      string
+		unsanitized []string
+		wantNames   []string
 	}{
 		{
-			name:     "No dashes",
-			input:    "getuser",
-			expected: "getuser",
+			name:      "Server first",
+			template:  "{{.Server}}::{{.Tool}}",
+			mode:      config.SanitizeNone,
+			wantNames: []string{"github::list-issues", "github::open_issues"},
 		},
 		{
-			name:     "Single dash",
-			input:    "get-user",
-			expected: "get_user",
+			name:      "Tool first, sanitized",
+			template:  "{{.Tool}}@{{.Server}}",
+			wantNames: []string{"list_issues@github", "open_issues@github"},
 		},
 		{
-			name:     "Multiple dashes",
-			input:    "get-user-details",
-			expected: "get_user_details",
+			name:      "Configured prefix and sanitized name",
+			template:  "{{.Sanitized}}_via_{{.Server}}",
+			prefix:    "gh",
+			wantNames: []string{"list_issues_via_gh", "open_issues_via_gh"},
 		},
 		{
-			name:     "Already has underscores",
-			input:    "get_user",
-			expected: "get_user",
+			// Tools configured to keep their name aren't sanitized after the template either
+			name:        "Unsanitized tool",
+			template:    "{{.Tool}}@{{.Server}}",
+			unsanitized: []string{"list-issues"},
+			wantNames:   []string{"list-issues@github", "open_issues@github"},
 		},
 		{
-			name:     "Mixed dashes and underscores",
-			input:    "get_user-details",
-			expected: "get_user_details",
+			// Strict mode removes the characters the template adds
+			name:      "Strict mode",
+			template:  "{{.Server}}::{{.Tool}}",
+			mode:      config.SanitizeStrict,
+			wantNames: []string{"githublist_issues", "githubopen_issues"},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := sanitizeToolName(tt.input)
-			if result != tt.expected {
-				t.Errorf("sanitizeToolName(%q) = %q, want %q", tt.input, result, tt.expected)
+			mockClient := &MockClient{Tools: []mcp.Tool{{Name: "list-issues"}}}
+			agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+				return mockClient, nil
+			}))
+			cfg := &config.Config{
+				Servers: []config.ServerConfig{{
+					Name:    "github",
+					Command: "test-command",
+					Prefix:  tt.prefix,
+					Tools: &config.ToolsConfig{
+						Presets: map[string]config.ToolPreset{
+							"open_issues": {Tool: "list-issues", Args: map[string]interface{}{"state": "open"}},
+						},
+						Unsanitized: tt.unsanitized,
+					},
+				}},
+				NameTemplate: tt.template,
+				SanitizeMode: tt.mode,
+				LogLevel:     config.LogLevelError,
+			}
+			if err := agg.Initialize(context.Background(), cfg); err != nil {
+				t.Fatalf("Initialize() error = %v", err)
+			}
+			defer agg.Close()
+
+			var names []string
+			for _, tool := range agg.GetTools() {
+				names = append(names, tool.Name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Fatalf("GetTools() names = %v, want %v", names, tt.wantNames)
+			}
+
+			// Templated names are routed to the original tool
+			for _, name := range tt.wantNames {
+				request := mcp.CallToolRequest{}
+				request.Params.Name = name
+				if _, err := agg.CallTool(context.Background(), request); err != nil {
+					t.Errorf("CallTool(%s) error = %v", name, err)
+				}
+			}
+			if len(mockClient.Calls) != 2 || mockClient.Calls[0].Params.Name != "list-issues" || mockClient.Calls[1].Params.Name != "list-issues" {
+				t.Errorf("Upstream calls = %v, want two calls to list-issues", mockClient.Calls)
 			}
 		})
 	}
+
+	// Configs built in code are checked on Initialize
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		return &MockClient{}, nil
+	}))
+	cfg := &config.Config{
+		Servers:      []config.ServerConfig{{Name: "github", Command: "test-command"}},
+		NameTemplate: "{{.Unknown}}",
+		LogLevel:     config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), "invalid name template") {
+		t.Errorf("Initialize() with an invalid name template error = %v, want an invalid name template error", err)
+	}
+}
+
+func TestPassthrough(t *testing.T) {
+	mockClient := &MockClient{Tools: []mcp.Tool{{Name: "list-issues"}, {Name: "get_repo"}}}
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		return mockClient, nil
+	}))
+	cfg := &config.Config{
+		Servers:     []config.ServerConfig{{Name: "github", Command: "test-command"}},
+		Passthrough: true,
+		LogLevel:    config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	defer agg.Close()
+
+	var names []string
+	for _, tool := range agg.GetTools() {
+		names = append(names, tool.Name)
+	}
+	sort.Strings(names)
+	if want := []string{"get_repo", "list_issues"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("GetTools() names = %v, want %v", names, want)
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "list_issues"
+	if _, err := agg.CallTool(context.Background(), request); err != nil {
+		t.Fatalf("CallTool() error = %v", err)
+	}
+	if len(mockClient.Calls) != 1 || mockClient.Calls[0].Params.Name != "list-issues" {
+		t.Errorf("Server received %+v, want a call of list-issues", mockClient.Calls)
+	}
+}
+
+func TestStartupStagger(t *testing.T) {
+	var launches []time.Time
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		launches = append(launches, time.Now())
+		return &MockClient{Tools: []mcp.Tool{{Name: "search"}}}, nil
+	}))
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{Name: "github", Command: "test-command"},
+			{Name: "shortcut", Command: "test-command"},
+			{Name: "docs", Command: "test-command"},
+		},
+		StartupStaggerMs: 50,
+		LogLevel:         config.LogLevelError,
+	}
+	start := time.Now()
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	defer agg.Close()
+
+	if len(launches) != 3 {
+		t.Fatalf("Launched %d servers, want 3", len(launches))
+	}
+	// The first server isn't delayed, every further one waits for the stagger
+	if delay := launches[0].Sub(start); delay >= 50*time.Millisecond {
+		t.Errorf("First server launched after %s, want no delay", delay)
+	}
+	for i := 1; i < len(launches); i++ {
+		if gap := launches[i].Sub(launches[i-1]); gap < 50*time.Millisecond {
+			t.Errorf("Server %d launched %s after the previous one, want at least 50ms", i, gap)
+		}
+	}
+}
+
+func TestInvokeTool(t *testing.T) {
+	mockClient := &MockClient{Tools: []mcp.Tool{{Name: "search-issues"}}}
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		return mockClient, nil
+	}))
+	cfg := &config.Config{
+		Servers:  []config.ServerConfig{{Name: "github", Command: "test-command"}},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	defer agg.Close()
+
+	args := map[string]any{"query": "is:open", "limit": 10}
+	if _, err := agg.InvokeTool(context.Background(), "github_search_issues", args); err != nil {
+		t.Fatalf("InvokeTool() error = %v", err)
+	}
+	if len(mockClient.Calls) != 1 {
+		t.Fatalf("Server received %d calls, want 1", len(mockClient.Calls))
+	}
+	call := mockClient.Calls[0]
+	if call.Params.Name != "search-issues" || !reflect.DeepEqual(call.Params.Arguments, args) {
+		t.Errorf("Server received %s(%v), want search-issues(%v)", call.Params.Name, call.Params.Arguments, args)
+	}
+
+	if _, err := agg.InvokeTool(context.Background(), "github_unknown", nil); err == nil {
+		t.Error("InvokeTool() of an unknown tool returned no error")
+	}
+}
+
+func TestInitOptions(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	withOptions := &streamableHandler{mcpServer: newEchoServer(), sessions: make(map[string]bool)}
+	withOptionsServer := httptest.NewServer(withOptions)
+	defer withOptionsServer.Close()
+	withoutOptions := &streamableHandler{mcpServer: newEchoServer(), sessions: make(map[string]bool)}
+	withoutOptionsServer := httptest.NewServer(withoutOptions)
+	defer withoutOptionsServer.Close()
+
+	// The stdio server only records the initialize request and exits, failing the handshake
+	requestFile := filepath.Join(t.TempDir(), "initialize.json")
+	options := map[string]interface{}{"workspace": "/srv/project", "features": map[string]interface{}{"index": true}}
+	agg := NewMCPAggregator()
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{Name: "github", Transport: config.TransportHTTP, URL: withOptionsServer.URL, InitOptions: options},
+			{Name: "shortcut", Transport: config.TransportHTTP, URL: withoutOptionsServer.URL},
+			{Name: "local", Command: "sh", Args: []string{"-c", `head -n 1 > "$0"`, requestFile}, InitOptions: options},
+		},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	defer agg.Close()
+
+	initOptions := func(params []byte) map[string]interface{} {
+		t.Helper()
+		var decoded struct {
+			InitializationOptions map[string]interface{} `json:"initializationOptions"`
+		}
+		if err := json.Unmarshal(params, &decoded); err != nil {
+			t.Fatalf("Failed to decode initialize params %s: %v", params, err)
+		}
+		return decoded.InitializationOptions
+	}
+
+	if len(withOptions.initializes) != 1 || !reflect.DeepEqual(initOptions(withOptions.initializes[0]), options) {
+		t.Errorf("Server github was initialized with %s, want options %v", withOptions.initializes, options)
+	}
+	if len(withoutOptions.initializes) != 1 || initOptions(withoutOptions.initializes[0]) != nil {
+		t.Errorf("Server shortcut was initialized with %s, want no options", withoutOptions.initializes)
+	}
+
+	data, err := os.ReadFile(requestFile)
+	if err != nil {
+		t.Fatalf("Failed to read the initialize request of the stdio server: %v", err)
+	}
+	var request rpcMessage
+	if err := json.Unmarshal(data, &request); err != nil {
+		t.Fatalf("Failed to decode the initialize request %s: %v", data, err)
+	}
+	if got := initOptions(request.Params); !reflect.DeepEqual(got, options) {
+		t.Errorf("Server local was initialized with options %v, want %v", got, options)
+	}
 }
 
 // MockClient implements a simple mock for testing without real StdioMCPClient
 type MockClient struct {
 	Tools []mcp.Tool
+	Calls []mcp.CallToolRequest
 }
 
+var _ MCPClient = (*MockClient)(nil)
+
 func (m *MockClient) Initialize(ctx context.Context, request mcp.InitializeRequest) (*mcp.InitializeResult, error) {
 	return &mcp.InitializeResult{
 		ServerInfo: mcp.Implementation{
@@ -72,7 +357,8 @@ func (m *MockClient) ListTools(ctx context.Context, request mcp.ListToolsRequest
 }
 
 func (m *MockClient) CallTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	// Just return a simple success result for testing
+	// Record the call and return a simple success result for testing
+	m.Calls = append(m.Calls, request)
 	return &mcp.CallToolResult{}, nil
 }
 
@@ -101,9 +387,9 @@ func TestToolNameSanitization(t *testing.T) {
 
 	// Verify sanitization
 	for _, tt := range testTools {
-		result := sanitizeToolName(tt.originalName)
+		result := config.SanitizeToolName(tt.originalName, config.SanitizeCursor)
 		if result != tt.sanitizedName {
-			t.Errorf("sanitizeToolName(%q) = %q, want %q", tt.originalName, result, tt.sanitizedName)
+			t.Errorf("config.SanitizeToolName(%q) = %q, want %q", tt.originalName, result, tt.sanitizedName)
 		}
 	}
 }
@@ -172,6 +458,170 @@ func TestToolFiltering(t *testing.T) {
 			},
 			wantToolNames: []string{"test_server_tool1"},
 		},
+		{
+			name: "Denied tools are removed from all tools",
+			serverConfig: config.ServerConfig{
+				Name:    "test-server",
+				Command: "test-command",
+				Tools: &config.ToolsConfig{
+					Denied: []string{"tool2"},
+				},
+			},
+			serverTools: []mcp.Tool{
+				{Name: "tool1", Description: "Tool 1"},
+				{Name: "tool2", Description: "Tool 2"},
+				{Name: "tool3", Description: "Tool 3"},
+			},
+			wantToolNames: []string{"test_server_tool1", "test_server_tool3"},
+		},
+		{
+			name: "Deny is applied after allow",
+			serverConfig: config.ServerConfig{
+				Name:    "test-server",
+				Command: "test-command",
+				Tools: &config.ToolsConfig{
+					Allowed: []string{"tool1", "tool2"},
+					Denied:  []string{"tool2"},
+				},
+			},
+			serverTools: []mcp.Tool{
+				{Name: "tool1", Description: "Tool 1"},
+				{Name: "tool2", Description: "Tool 2"},
+				{Name: "tool3", Description: "Tool 3"},
+			},
+			wantToolNames: []string{"test_server_tool1"},
+		},
+		{
+			name: "Denied tools match the original name",
+			serverConfig: config.ServerConfig{
+				Name:    "test-server",
+				Command: "test-command",
+				Tools: &config.ToolsConfig{
+					Denied: []string{"delete-repo"},
+				},
+			},
+			serverTools: []mcp.Tool{
+				{Name: "delete-repo", Description: "Delete a repository"},
+				{Name: "get-repo", Description: "Get a repository"},
+			},
+			wantToolNames: []string{"test_server_get_repo"},
+		},
+		{
+			name: "Anchored allowed patterns match the start of the original name",
+			serverConfig: config.ServerConfig{
+				Name:    "test-server",
+				Command: "test-command",
+				Tools: &config.ToolsConfig{
+					AllowedPatterns: []string{"^list-"},
+				},
+			},
+			serverTools: []mcp.Tool{
+				{Name: "list-issues", Description: "List issues"},
+				{Name: "list-repos", Description: "List repositories"},
+				{Name: "get-list-item", Description: "Get a list item"},
+			},
+			wantToolNames: []string{"test_server_list_issues", "test_server_list_repos"},
+		},
+		{
+			name: "Unanchored patterns match anywhere in the original name",
+			serverConfig: config.ServerConfig{
+				Name:    "test-server",
+				Command: "test-command",
+				Tools: &config.ToolsConfig{
+					AllowedPatterns: []string{"issue"},
+				},
+			},
+			serverTools: []mcp.Tool{
+				{Name: "list-issues", Description: "List issues"},
+				{Name: "create_issue", Description: "Create an issue"},
+				{Name: "list-repos", Description: "List repositories"},
+			},
+			wantToolNames: []string{"test_server_list_issues", "test_server_create_issue"},
+		},
+		{
+			name: "Allowed patterns are combined with the allowed list",
+			serverConfig: config.ServerConfig{
+				Name:    "test-server",
+				Command: "test-command",
+				Tools: &config.ToolsConfig{
+					Allowed:         []string{"get_repo"},
+					AllowedPatterns: []string{"^list-"},
+				},
+			},
+			serverTools: []mcp.Tool{
+				{Name: "list-issues", Description: "List issues"},
+				{Name: "get-repo", Description: "Get a repository"},
+				{Name: "delete-repo", Description: "Delete a repository"},
+			},
+			wantToolNames: []string{"test_server_list_issues", "test_server_get_repo"},
+		},
+		{
+			name: "Allowed patterns expose tools despite an empty allowed list",
+			serverConfig: config.ServerConfig{
+				Name:    "test-server",
+				Command: "test-command",
+				Tools: &config.ToolsConfig{
+					Allowed:         []string{},
+					AllowedPatterns: []string{"^get-"},
+				},
+			},
+			serverTools: []mcp.Tool{
+				{Name: "get-repo", Description: "Get a repository"},
+				{Name: "delete-repo", Description: "Delete a repository"},
+			},
+			wantToolNames: []string{"test_server_get_repo"},
+		},
+		{
+			name: "Denied patterns are combined with the denied list",
+			serverConfig: config.ServerConfig{
+				Name:    "test-server",
+				Command: "test-command",
+				Tools: &config.ToolsConfig{
+					Denied:         []string{"push-files"},
+					DeniedPatterns: []string{"^delete-", "admin"},
+				},
+			},
+			serverTools: []mcp.Tool{
+				{Name: "delete-repo", Description: "Delete a repository"},
+				{Name: "get-repo", Description: "Get a repository"},
+				{Name: "push-files", Description: "Push files"},
+				{Name: "repo-admin-settings", Description: "Change admin settings"},
+				{Name: "undelete-repo", Description: "Restore a repository"},
+			},
+			wantToolNames: []string{"test_server_get_repo", "test_server_undelete_repo"},
+		},
+		{
+			name: "Denied patterns are applied after allowed patterns",
+			serverConfig: config.ServerConfig{
+				Name:    "test-server",
+				Command: "test-command",
+				Tools: &config.ToolsConfig{
+					AllowedPatterns: []string{"repo"},
+					DeniedPatterns:  []string{"^delete-"},
+				},
+			},
+			serverTools: []mcp.Tool{
+				{Name: "delete-repo", Description: "Delete a repository"},
+				{Name: "get-repo", Description: "Get a repository"},
+				{Name: "list-issues", Description: "List issues"},
+			},
+			wantToolNames: []string{"test_server_get_repo"},
+		},
+		{
+			name: "Non-existent denied tools are ignored",
+			serverConfig: config.ServerConfig{
+				Name:    "test-server",
+				Command: "test-command",
+				Tools: &config.ToolsConfig{
+					Denied: []string{"non-existent"},
+				},
+			},
+			serverTools: []mcp.Tool{
+				{Name: "tool1", Description: "Tool 1"},
+				{Name: "tool2", Description: "Tool 2"},
+			},
+			wantToolNames: []string{"test_server_tool1", "test_server_tool2"},
+		},
 	}
 
 	for _, tt := range tests {
@@ -231,3 +681,2428 @@ func TestToolFiltering(t *testing.T) {
 		})
 	}
 }
+
+func TestSchemaOverride(t *testing.T) {
+	overrideSchema := json.RawMessage(`{"type":"object","properties":{"branch":{"type":"string","enum":["main","dev"]}},"required":["branch"]}`)
+
+	serverConfig := config.ServerConfig{
+		Name:    "git",
+		Command: "test-command",
+		Tools: &config.ToolsConfig{
+			Overrides: map[string]config.ToolOverride{
+				"checkout-branch": {Schema: overrideSchema},
+			},
+		},
+	}
+	mockClient := &MockClient{
+		Tools: []mcp.Tool{
+			{
+				Name:        "checkout-branch",
+				Description: "Checkout a branch",
+				InputSchema: mcp.ToolInputSchema{Type: "object", Properties: map[string]interface{}{}},
+			},
+			{
+				Name:        "status",
+				Description: "Show status",
+				InputSchema: mcp.ToolInputSchema{Type: "object", Properties: map[string]interface{}{}},
+			},
+		},
+	}
+
+	agg := NewMCPAggregator()
+	agg.clients[serverConfig.Name] = mockClient
+	agg.configs[serverConfig.Name] = &serverConfig
+	if err := agg.discoverTools(context.Background(), serverConfig.Name); err != nil {
+		t.Fatalf("discoverTools() error = %v", err)
+	}
+
+	tools := make(map[string]mcp.Tool)
+	for _, tool := range agg.GetTools() {
+		tools[tool.Name] = tool
+	}
+
+	overridden, ok := tools["git_checkout_branch"]
+	if !ok {
+		t.Fatalf("Missing tool git_checkout_branch")
+	}
+	if string(overridden.RawInputSchema) != string(overrideSchema) {
+		t.Errorf("RawInputSchema = %s, want %s", overridden.RawInputSchema, overrideSchema)
+	}
+	exposed, err := json.Marshal(overridden)
+	if err != nil {
+		t.Fatalf("Failed to marshal overridden tool: %v", err)
+	}
+	var exposedTool struct {
+		InputSchema json.RawMessage `json:"inputSchema"`
+	}
+	if err := json.Unmarshal(exposed, &exposedTool); err != nil {
+		t.Fatalf("Failed to unmarshal overridden tool: %v", err)
+	}
+	if string(exposedTool.InputSchema) != string(overrideSchema) {
+		t.Errorf("Exposed inputSchema = %s, want %s", exposedTool.InputSchema, overrideSchema)
+	}
+
+	if untouched := tools["git_status"]; untouched.RawInputSchema != nil {
+		t.Errorf("Tool without override got RawInputSchema %s", untouched.RawInputSchema)
+	}
+
+	// Forwarding must use the original tool name and pass arguments through unchanged
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "git_checkout_branch"
+	request.Params.Arguments = map[string]interface{}{"branch": "main"}
+	if _, err := agg.CallTool(context.Background(), request); err != nil {
+		t.Fatalf("CallTool() error = %v", err)
+	}
+	if len(mockClient.Calls) != 1 {
+		t.Fatalf("Got %d forwarded calls, want 1", len(mockClient.Calls))
+	}
+	forwarded := mockClient.Calls[0]
+	if forwarded.Params.Name != "checkout-branch" {
+		t.Errorf("Forwarded name = %q, want %q", forwarded.Params.Name, "checkout-branch")
+	}
+	if !reflect.DeepEqual(forwarded.Params.Arguments, request.Params.Arguments) {
+		t.Errorf("Forwarded arguments = %v, want %v", forwarded.Params.Arguments, request.Params.Arguments)
+	}
+}
+
+// TestConcurrentDiscoveryAndGetTools is meant to be run with -race to catch unsynchronized access
+func TestConcurrentDiscoveryAndGetTools(t *testing.T) {
+	const serverCount = 5
+
+	agg := NewMCPAggregator()
+	for i := 0; i < serverCount; i++ {
+		serverConfig := config.ServerConfig{
+			Name:    fmt.Sprintf("server%d", i),
+			Command: "test-command",
+		}
+		agg.clients[serverConfig.Name] = &MockClient{
+			Tools: []mcp.Tool{
+				{Name: "tool1", Description: "Tool 1"},
+				{Name: "tool2", Description: "Tool 2"},
+			},
+		}
+		agg.configs[serverConfig.Name] = &serverConfig
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < serverCount; i++ {
+		serverName := fmt.Sprintf("server%d", i)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if err := agg.discoverTools(context.Background(), serverName); err != nil {
+					t.Errorf("discoverTools(%s) error = %v", serverName, err)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				agg.GetTools()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := len(agg.GetTools()); got != serverCount*2 {
+		t.Errorf("Got %d tools, want %d", got, serverCount*2)
+	}
+}
+
+func TestAllowedArgumentValues(t *testing.T) {
+	tests := []struct {
+		name          string
+		arguments     map[string]interface{}
+		wantError     bool
+		wantForwarded bool
+	}{
+		{
+			name:          "Allowed value is forwarded",
+			arguments:     map[string]interface{}{"branch": "main"},
+			wantError:     false,
+			wantForwarded: true,
+		},
+		{
+			name:          "Disallowed value is rejected",
+			arguments:     map[string]interface{}{"branch": "release"},
+			wantError:     true,
+			wantForwarded: false,
+		},
+		{
+			name:          "Allowed numeric value is forwarded",
+			arguments:     map[string]interface{}{"branch": "dev", "depth": float64(1)},
+			wantError:     false,
+			wantForwarded: true,
+		},
+		{
+			name:          "Disallowed numeric value is rejected",
+			arguments:     map[string]interface{}{"branch": "dev", "depth": float64(50)},
+			wantError:     true,
+			wantForwarded: false,
+		},
+		{
+			name:          "Missing constrained parameter is forwarded",
+			arguments:     map[string]interface{}{"force": true},
+			wantError:     false,
+			wantForwarded: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConfig := config.ServerConfig{
+				Name:    "git",
+				Command: "test-command",
+				Tools: &config.ToolsConfig{
+					Overrides: map[string]config.ToolOverride{
+						"push": {
+							AllowedValues: map[string][]interface{}{
+								"branch": {"main", "dev"},
+								"depth":  {1, 2},
+							},
+						},
+					},
+				},
+			}
+			mockClient := &MockClient{
+				Tools: []mcp.Tool{{Name: "push", Description: "Push a branch"}},
+			}
+
+			agg := NewMCPAggregator()
+			agg.clients[serverConfig.Name] = mockClient
+			agg.configs[serverConfig.Name] = &serverConfig
+			if err := agg.discoverTools(context.Background(), serverConfig.Name); err != nil {
+				t.Fatalf("discoverTools() error = %v", err)
+			}
+
+			request := mcp.CallToolRequest{}
+			request.Params.Name = "git_push"
+			request.Params.Arguments = tt.arguments
+			result, err := agg.CallTool(context.Background(), request)
+			if err != nil {
+				t.Fatalf("CallTool() error = %v", err)
+			}
+			if result.IsError != tt.wantError {
+				t.Errorf("CallTool() IsError = %v, want %v", result.IsError, tt.wantError)
+			}
+			if forwarded := len(mockClient.Calls) > 0; forwarded != tt.wantForwarded {
+				t.Errorf("Call forwarded = %v, want %v", forwarded, tt.wantForwarded)
+			}
+		})
+	}
+}
+
+func TestUnsanitizedTools(t *testing.T) {
+	serverConfig := config.ServerConfig{
+		Name:    "shortcut",
+		Command: "test-command",
+		Tools: &config.ToolsConfig{
+			// Names are matched like those of the allowed and denied tools, with - and _ alike
+			Unsanitized: []string{"search-stories", "list_epics"},
+		},
+	}
+	mockClient := &MockClient{
+		Tools: []mcp.Tool{
+			{Name: "search-stories", Description: "Search stories"},
+			{Name: "get-story", Description: "Get a story"},
+			{Name: "list-epics", Description: "List epics"},
+		},
+	}
+
+	agg := NewMCPAggregator()
+	agg.clients[serverConfig.Name] = mockClient
+	agg.configs[serverConfig.Name] = &serverConfig
+	if err := agg.discoverTools(context.Background(), serverConfig.Name); err != nil {
+		t.Fatalf("discoverTools() error = %v", err)
+	}
+
+	gotNames := make(map[string]bool)
+	for _, tool := range agg.GetTools() {
+		gotNames[tool.Name] = true
+	}
+	for _, name := range []string{"shortcut_search-stories", "shortcut_get_story", "shortcut_list-epics"} {
+		if !gotNames[name] {
+			t.Errorf("Missing tool %s, got %v", name, gotNames)
+		}
+	}
+	if len(gotNames) != 3 {
+		t.Errorf("Got %d tools, want 3", len(gotNames))
+	}
+
+	// Both the exempt and the sanitized tool must map back to their original names
+	for exposedName, originalName := range map[string]string{
+		"shortcut_search-stories": "search-stories",
+		"shortcut_get_story":      "get-story",
+	} {
+		request := mcp.CallToolRequest{}
+		request.Params.Name = exposedName
+		if _, err := agg.CallTool(context.Background(), request); err != nil {
+			t.Fatalf("CallTool(%s) error = %v", exposedName, err)
+		}
+		forwarded := mockClient.Calls[len(mockClient.Calls)-1]
+		if forwarded.Params.Name != originalName {
+			t.Errorf("CallTool(%s) forwarded %q, want %q", exposedName, forwarded.Params.Name, originalName)
+		}
+	}
+}
+
+func TestDescriptionOverrides(t *testing.T) {
+	mockClient := &MockClient{
+		Tools: []mcp.Tool{
+			{Name: "search-issues", Description: "Searches issues using the very verbose upstream description"},
+			{Name: "get-issue", Description: "Get an issue"},
+		},
+	}
+
+	agg := NewMCPAggregator()
+	agg.clients["github"] = mockClient
+	agg.configs["github"] = &config.ServerConfig{
+		Name:    "github",
+		Command: "test-command",
+		DescriptionOverrides: map[string]string{
+			"search-issues": "Search issues by query",
+			"unknown-tool":  "Never used",
+		},
+	}
+	if err := agg.discoverTools(context.Background(), "github"); err != nil {
+		t.Fatalf("discoverTools() error = %v", err)
+	}
+
+	descriptions := make(map[string]string)
+	for _, tool := range agg.GetTools() {
+		descriptions[tool.Name] = tool.Description
+	}
+	want := map[string]string{
+		"github_search_issues": "[github] Search issues by query",
+		"github_get_issue":     "[github] Get an issue",
+	}
+	if !reflect.DeepEqual(descriptions, want) {
+		t.Errorf("Tool descriptions = %v, want %v", descriptions, want)
+	}
+}
+
+func TestToolPresets(t *testing.T) {
+	serverConfig := config.ServerConfig{
+		Name:    "deploy",
+		Command: "test-command",
+		Tools: &config.ToolsConfig{
+			Presets: map[string]config.ToolPreset{
+				"deploy-staging": {
+					Tool:        "deploy",
+					Args:        map[string]interface{}{"env": "staging"},
+					Description: "Deploy to staging",
+				},
+				"deploy-production": {
+					Tool: "deploy",
+					Args: map[string]interface{}{"env": "production", "confirm": true},
+				},
+			},
+		},
+	}
+	mockClient := &MockClient{
+		Tools: []mcp.Tool{{
+			Name:        "deploy",
+			Description: "Deploy the service",
+			InputSchema: mcp.ToolInputSchema{
+				Type: "object",
+				Properties: map[string]interface{}{
+					"env":     map[string]interface{}{"type": "string"},
+					"version": map[string]interface{}{"type": "string"},
+					"confirm": map[string]interface{}{"type": "boolean"},
+				},
+				Required: []string{"env", "version"},
+			},
+		}},
+	}
+
+	agg := NewMCPAggregator()
+	agg.clients[serverConfig.Name] = mockClient
+	agg.configs[serverConfig.Name] = &serverConfig
+	if err := agg.discoverTools(context.Background(), serverConfig.Name); err != nil {
+		t.Fatalf("discoverTools() error = %v", err)
+	}
+
+	tools := agg.GetTools()
+	exposed := make(map[string]mcp.Tool)
+	for _, tool := range tools {
+		exposed[tool.Name] = tool
+	}
+	for _, name := range []string{"deploy_deploy", "deploy_deploy_staging", "deploy_deploy_production"} {
+		if _, ok := exposed[name]; !ok {
+			t.Errorf("Missing tool %s", name)
+		}
+	}
+	staging := exposed["deploy_deploy_staging"]
+	if staging.Description != "[deploy] Deploy to staging" {
+		t.Errorf("Preset description = %q, want %q", staging.Description, "[deploy] Deploy to staging")
+	}
+	if _, ok := staging.InputSchema.Properties["env"]; ok {
+		t.Error("Preset parameter env should be hidden from the schema")
+	}
+	if !reflect.DeepEqual(staging.InputSchema.Required, []string{"version"}) {
+		t.Errorf("Preset required = %v, want [version]", staging.InputSchema.Required)
+	}
+
+	tests := []struct {
+		name     string
+		toolName string
+		want     map[string]interface{}
+	}{
+		{
+			name:     "Staging preset",
+			toolName: "deploy_deploy_staging",
+			want:     map[string]interface{}{"env": "staging", "version": "1.2.3"},
+		},
+		{
+			name:     "Production preset",
+			toolName: "deploy_deploy_production",
+			want:     map[string]interface{}{"env": "production", "confirm": true, "version": "1.2.3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient.Calls = nil
+
+			request := mcp.CallToolRequest{}
+			request.Params.Name = tt.toolName
+			// The client can't override preset arguments
+			request.Params.Arguments = map[string]interface{}{"env": "dev", "version": "1.2.3"}
+			if _, err := agg.CallTool(context.Background(), request); err != nil {
+				t.Fatalf("CallTool() error = %v", err)
+			}
+
+			if len(mockClient.Calls) != 1 {
+				t.Fatalf("Forwarded %d calls, want 1", len(mockClient.Calls))
+			}
+			call := mockClient.Calls[0]
+			if call.Params.Name != "deploy" {
+				t.Errorf("Forwarded tool = %s, want deploy", call.Params.Name)
+			}
+			if !reflect.DeepEqual(call.Params.Arguments, tt.want) {
+				t.Errorf("Forwarded arguments = %v, want %v", call.Params.Arguments, tt.want)
+			}
+			if request.Params.Arguments["env"] != "dev" {
+				t.Error("Caller's arguments were modified")
+			}
+		})
+	}
+}
+
+func TestToolPresetsFiltered(t *testing.T) {
+	mockClient := &MockClient{Tools: []mcp.Tool{{Name: "deploy"}, {Name: "status"}, {Name: "rollback"}}}
+	serverConfig := config.ServerConfig{
+		Name:    "deploy",
+		Command: "test-command",
+		Tools: &config.ToolsConfig{
+			Allowed: []string{"status", "rollback"},
+			Denied:  []string{"rollback"},
+			Presets: map[string]config.ToolPreset{
+				"deploy_staging":  {Tool: "deploy", Args: map[string]interface{}{"env": "staging"}},
+				"status_staging":  {Tool: "status", Args: map[string]interface{}{"env": "staging"}},
+				"rollback_latest": {Tool: "rollback", Args: map[string]interface{}{"version": "latest"}},
+			},
+		},
+	}
+
+	agg := NewMCPAggregator()
+	agg.clients[serverConfig.Name] = mockClient
+	agg.configs[serverConfig.Name] = &serverConfig
+	if err := agg.discoverTools(context.Background(), serverConfig.Name); err != nil {
+		t.Fatalf("discoverTools() error = %v", err)
+	}
+
+	// Presets of tools that aren't allowed or are denied are skipped like the tools themselves
+	var names []string
+	for _, tool := range agg.GetTools() {
+		names = append(names, tool.Name)
+	}
+	sort.Strings(names)
+	if want := []string{"deploy_status", "deploy_status_staging"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("GetTools() names = %v, want %v", names, want)
+	}
+}
+
+// hangingClient is a mock client whose tools/list never answers
+type hangingClient struct {
+	MockClient
+	closed bool
+}
+
+func (m *hangingClient) ListTools(ctx context.Context, request mcp.ListToolsRequest) (*mcp.ListToolsResult, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (m *hangingClient) Close() error {
+	m.closed = true
+	return nil
+}
+
+func TestDiscoveryTimeout(t *testing.T) {
+	hanging := &hangingClient{}
+	healthy := &MockClient{Tools: []mcp.Tool{{Name: "tool1", Description: "Tool 1"}}}
+
+	agg := NewMCPAggregator(
+		WithDiscoveryTimeout(50*time.Millisecond),
+		WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+			if serverCfg.Name == "hanging" {
+				return hanging, nil
+			}
+			return healthy, nil
+		}),
+	)
+
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{Name: "hanging", Command: "test-command"},
+			{Name: "healthy", Command: "test-command"},
+		},
+		LogLevel: config.LogLevelError,
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- agg.Initialize(context.Background(), cfg) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Initialize() error = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Initialize() blocked on a server that hangs on tools/list")
+	}
+	defer agg.Close()
+
+	if !hanging.closed {
+		t.Error("Hanging server was not closed")
+	}
+	if agg.ServerCount() != 1 {
+		t.Errorf("ServerCount() = %d, want 1", agg.ServerCount())
+	}
+	if _, ok := agg.tools["healthy_tool1"]; !ok {
+		t.Error("Tools of the healthy server were not registered")
+	}
+}
+
+func TestDualNames(t *testing.T) {
+	github := &MockClient{Tools: []mcp.Tool{{Name: "create-issue"}, {Name: "search"}}}
+	gitlab := &MockClient{Tools: []mcp.Tool{{Name: "search"}, {Name: "merge"}}}
+
+	agg := NewMCPAggregator()
+	agg.dualNames = true
+	agg.clients["github"] = github
+	agg.clients["gitlab"] = gitlab
+	for _, serverName := range []string{"github", "gitlab"} {
+		if err := agg.discoverTools(context.Background(), serverName); err != nil {
+			t.Fatalf("discoverTools(%s) error = %v", serverName, err)
+		}
+	}
+
+	exposed := make(map[string]bool)
+	for _, tool := range agg.GetTools() {
+		exposed[tool.Name] = true
+	}
+	want := []string{
+		"github_create_issue", "github_search", "gitlab_search", "gitlab_merge",
+		"create_issue", "merge",
+	}
+	for _, name := range want {
+		if !exposed[name] {
+			t.Errorf("Missing tool %s", name)
+		}
+	}
+	if exposed["search"] {
+		t.Error("Colliding unprefixed name search should not be exposed")
+	}
+	if len(exposed) != len(want) || agg.ToolCount() != len(want) {
+		t.Errorf("Exposed %d tools (ToolCount %d), want %d", len(exposed), agg.ToolCount(), len(want))
+	}
+
+	tests := []struct {
+		toolName string
+		client   *MockClient
+		original string
+	}{
+		{"create_issue", github, "create-issue"},
+		{"github_create_issue", github, "create-issue"},
+		{"merge", gitlab, "merge"},
+		{"gitlab_search", gitlab, "search"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.toolName, func(t *testing.T) {
+			github.Calls, gitlab.Calls = nil, nil
+
+			request := mcp.CallToolRequest{}
+			request.Params.Name = tt.toolName
+			if _, err := agg.CallTool(context.Background(), request); err != nil {
+				t.Fatalf("CallTool() error = %v", err)
+			}
+			if len(tt.client.Calls) != 1 || len(github.Calls)+len(gitlab.Calls) != 1 {
+				t.Fatalf("Call was not forwarded to the right server")
+			}
+			if tt.client.Calls[0].Params.Name != tt.original {
+				t.Errorf("Forwarded tool = %s, want %s", tt.client.Calls[0].Params.Name, tt.original)
+			}
+		})
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "search"
+	if _, err := agg.CallTool(context.Background(), request); err == nil {
+		t.Error("CallTool() with colliding unprefixed name returned no error")
+	}
+}
+
+// slowInitClient is a mock client whose initialize handshake never completes
+type slowInitClient struct {
+	MockClient
+	closed bool
+}
+
+func (m *slowInitClient) Initialize(ctx context.Context, request mcp.InitializeRequest) (*mcp.InitializeResult, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (m *slowInitClient) Close() error {
+	m.closed = true
+	return nil
+}
+
+func TestInitTimeout(t *testing.T) {
+	slow := &slowInitClient{}
+	healthy := &MockClient{Tools: []mcp.Tool{{Name: "tool1", Description: "Tool 1"}}}
+
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		if serverCfg.Name == "slow" {
+			return slow, nil
+		}
+		return healthy, nil
+	}))
+	agg.initTimeout = 20 * time.Millisecond
+
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{Name: "slow", Command: "test-command"},
+			{Name: "healthy", Command: "test-command"},
+		},
+		LogLevel: config.LogLevelError,
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- agg.Initialize(context.Background(), cfg) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Initialize() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Initialize() didn't honor the init timeout")
+	}
+	defer agg.Close()
+
+	if !slow.closed {
+		t.Error("Timed out server was not closed")
+	}
+	if agg.ServerCount() != 1 {
+		t.Errorf("ServerCount() = %d, want 1", agg.ServerCount())
+	}
+}
+
+func TestServerTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		seconds int
+		want    time.Duration
+	}{
+		{name: "Not configured", seconds: 0, want: time.Minute},
+		{name: "Configured", seconds: 5, want: 5 * time.Second},
+		{name: "Negative", seconds: -1, want: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serverTimeout(tt.seconds, time.Minute); got != tt.want {
+				t.Errorf("serverTimeout(%d) = %v, want %v", tt.seconds, got, tt.want)
+			}
+		})
+	}
+}
+
+// countingClient is a mock client that counts tools/list requests
+type countingClient struct {
+	MockClient
+	listCalls atomic.Int64
+}
+
+func (m *countingClient) ListTools(ctx context.Context, request mcp.ListToolsRequest) (*mcp.ListToolsResult, error) {
+	m.listCalls.Add(1)
+	return m.MockClient.ListTools(ctx, request)
+}
+
+// hangingCallClient is a mock client whose tool calls never complete
+type hangingCallClient struct {
+	MockClient
+}
+
+func (m *hangingCallClient) CallTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestCallTimeout(t *testing.T) {
+	agg := NewMCPAggregator(WithCallTimeout(20*time.Millisecond), WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		return &hangingCallClient{MockClient{Tools: []mcp.Tool{{Name: "tool1"}}}}, nil
+	}))
+
+	cfg := &config.Config{
+		Servers:  []config.ServerConfig{{Name: "hung", Command: "test-command"}},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "hung_tool1"
+
+	start := time.Now()
+	result, err := agg.CallTool(context.Background(), request)
+	if err != nil {
+		t.Fatalf("CallTool() error = %v, want a tool error result", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("CallTool() took %v, want about 20ms", elapsed)
+	}
+	if !result.IsError {
+		t.Errorf("CallTool() result IsError = false, want true")
+	}
+
+	// A call cancelled by the caller is not reported as a timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := agg.CallTool(ctx, request); !errors.Is(err, context.Canceled) {
+		t.Errorf("CallTool() with cancelled context error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestCallTimeoutOption(t *testing.T) {
+	agg := NewMCPAggregator(
+		WithCallTimeout(20*time.Millisecond),
+		WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+			return &hangingCallClient{MockClient{Tools: []mcp.Tool{{Name: "tool1"}}}}, nil
+		}),
+	)
+	cfg := &config.Config{
+		Servers:  []config.ServerConfig{{Name: "hung", Command: "test-command"}},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	// Servers without their own call timeout get the one of the aggregator
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "hung_tool1"
+	result, err := agg.CallTool(context.Background(), request)
+	if err != nil {
+		t.Fatalf("CallTool() error = %v, want a tool error result", err)
+	}
+	text, _ := mcp.AsTextContent(result.Content[0])
+	if !result.IsError || text == nil || !strings.Contains(text.Text, "timed out after 20ms") {
+		t.Errorf("CallTool() = %+v, want a timeout after 20ms", result)
+	}
+}
+
+func TestGetToolsUsesCachedSchemas(t *testing.T) {
+	mockClient := &countingClient{MockClient: MockClient{Tools: []mcp.Tool{
+		{Name: "tool1", InputSchema: mcp.ToolInputSchema{Type: "object", Properties: map[string]interface{}{"query": map[string]interface{}{"type": "string"}}}},
+		{Name: "tool2"},
+	}}}
+
+	agg := NewMCPAggregator()
+	agg.clients["test"] = mockClient
+	if err := agg.discoverTools(context.Background(), "test"); err != nil {
+		t.Fatalf("discoverTools() error = %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		tools := agg.GetTools()
+		if len(tools) != 2 {
+			t.Fatalf("GetTools() returned %d tools, want 2", len(tools))
+		}
+		for _, tool := range tools {
+			// The schema is still made valid for tools that don't provide one
+			if tool.InputSchema.Type != "object" || tool.InputSchema.Properties == nil {
+				t.Errorf("Tool %s has invalid schema %+v", tool.Name, tool.InputSchema)
+			}
+		}
+	}
+
+	if calls := mockClient.listCalls.Load(); calls != 1 {
+		t.Errorf("ListTools called %d times, want 1 (discovery only)", calls)
+	}
+}
+
+func BenchmarkGetTools(b *testing.B) {
+	tools := make([]mcp.Tool, 50)
+	for i := range tools {
+		tools[i] = mcp.Tool{Name: fmt.Sprintf("tool%d", i), Description: "Tool"}
+	}
+	mockClient := &countingClient{MockClient: MockClient{Tools: tools}}
+
+	agg := NewMCPAggregator()
+	agg.clients["test"] = mockClient
+	if err := agg.discoverTools(context.Background(), "test"); err != nil {
+		b.Fatalf("discoverTools() error = %v", err)
+	}
+	mockClient.listCalls.Store(0)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		agg.GetTools()
+	}
+	b.ReportMetric(float64(mockClient.listCalls.Load())/float64(b.N), "listcalls/op")
+}
+
+func TestCustomPrefix(t *testing.T) {
+	tests := []struct {
+		name         string
+		serverConfig config.ServerConfig
+		wantTool     string
+	}{
+		{
+			name:         "Server name is the default prefix",
+			serverConfig: config.ServerConfig{Name: "company-internal-github", Command: "test-command"},
+			wantTool:     "company_internal_github_create_pr",
+		},
+		{
+			name:         "Custom prefix replaces the server name",
+			serverConfig: config.ServerConfig{Name: "company-internal-github", Command: "test-command", Prefix: "gh"},
+			wantTool:     "gh_create_pr",
+		},
+		{
+			name:         "Custom prefix is sanitized",
+			serverConfig: config.ServerConfig{Name: "company-internal-github", Command: "test-command", Prefix: "my-gh"},
+			wantTool:     "my_gh_create_pr",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := &MockClient{Tools: []mcp.Tool{{Name: "create-pr", Description: "Create a PR"}}}
+
+			agg := NewMCPAggregator()
+			agg.clients[tt.serverConfig.Name] = mockClient
+			agg.configs[tt.serverConfig.Name] = &tt.serverConfig
+			if err := agg.discoverTools(context.Background(), tt.serverConfig.Name); err != nil {
+				t.Fatalf("discoverTools() error = %v", err)
+			}
+
+			tools := agg.GetTools()
+			if len(tools) != 1 || tools[0].Name != tt.wantTool {
+				t.Fatalf("GetTools() = %+v, want a single tool %s", tools, tt.wantTool)
+			}
+			// The description still names the server the tool comes from
+			if tools[0].Description != "[company-internal-github] Create a PR" {
+				t.Errorf("Description = %q, want the server name", tools[0].Description)
+			}
+
+			request := mcp.CallToolRequest{}
+			request.Params.Name = tt.wantTool
+			if _, err := agg.CallTool(context.Background(), request); err != nil {
+				t.Fatalf("CallTool() error = %v", err)
+			}
+			if len(mockClient.Calls) != 1 || mockClient.Calls[0].Params.Name != "create-pr" {
+				t.Errorf("Forwarded calls = %+v, want create-pr", mockClient.Calls)
+			}
+		})
+	}
+}
+
+func TestDisablePrefix(t *testing.T) {
+	tests := []struct {
+		name          string
+		disablePrefix bool
+		githubConfig  config.ServerConfig
+		wantTools     map[string]string // Exposed name -> server handling the call
+	}{
+		{
+			name:          "Prefixing disabled for all servers",
+			disablePrefix: true,
+			githubConfig:  config.ServerConfig{Name: "github", Command: "test-command"},
+			wantTools: map[string]string{
+				"search":       "github", // The first server registered keeps the colliding name
+				"create_issue": "github",
+				"merge":        "gitlab",
+			},
+		},
+		{
+			name:         "Prefixing disabled for one server",
+			githubConfig: config.ServerConfig{Name: "github", Command: "test-command", NoPrefix: true},
+			wantTools: map[string]string{
+				"search":        "github",
+				"create_issue":  "github",
+				"gitlab_search": "gitlab",
+				"gitlab_merge":  "gitlab",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := map[string]*MockClient{
+				"github": {Tools: []mcp.Tool{{Name: "search"}, {Name: "create-issue"}}},
+				"gitlab": {Tools: []mcp.Tool{{Name: "search"}, {Name: "merge"}}},
+			}
+			gitlabConfig := config.ServerConfig{Name: "gitlab", Command: "test-command"}
+
+			agg := NewMCPAggregator()
+			agg.disablePrefix = tt.disablePrefix
+			agg.clients["github"] = clients["github"]
+			agg.clients["gitlab"] = clients["gitlab"]
+			agg.configs["github"] = &tt.githubConfig
+			agg.configs["gitlab"] = &gitlabConfig
+			for _, serverName := range []string{"github", "gitlab"} {
+				if err := agg.discoverTools(context.Background(), serverName); err != nil {
+					t.Fatalf("discoverTools(%s) error = %v", serverName, err)
+				}
+			}
+
+			tools := agg.GetTools()
+			if len(tools) != len(tt.wantTools) {
+				t.Errorf("GetTools() returned %d tools, want %d", len(tools), len(tt.wantTools))
+			}
+			for _, tool := range tools {
+				if _, ok := tt.wantTools[tool.Name]; !ok {
+					t.Errorf("Unexpected tool %s", tool.Name)
+				}
+			}
+
+			for toolName, serverName := range tt.wantTools {
+				clients["github"].Calls, clients["gitlab"].Calls = nil, nil
+
+				request := mcp.CallToolRequest{}
+				request.Params.Name = toolName
+				if _, err := agg.CallTool(context.Background(), request); err != nil {
+					t.Fatalf("CallTool(%s) error = %v", toolName, err)
+				}
+				if len(clients[serverName].Calls) != 1 {
+					t.Errorf("CallTool(%s) was not forwarded to server %s", toolName, serverName)
+				}
+			}
+		})
+	}
+}
+
+func TestToolNameCollisions(t *testing.T) {
+	// Server a-b with tool c and server a with tool b_c both sanitize to a_b_c
+	clients := map[string]*MockClient{
+		"a-b": {Tools: []mcp.Tool{{Name: "c"}}},
+		"a":   {Tools: []mcp.Tool{{Name: "b_c"}, {Name: "get-user"}, {Name: "get_user"}}},
+	}
+
+	agg := NewMCPAggregator()
+	for _, serverName := range []string{"a-b", "a"} {
+		agg.clients[serverName] = clients[serverName]
+		if err := agg.discoverTools(context.Background(), serverName); err != nil {
+			t.Fatalf("discoverTools(%s) error = %v", serverName, err)
+		}
+	}
+
+	tests := []struct {
+		toolName   string
+		serverName string
+		original   string
+	}{
+		{"a_b_c", "a-b", "c"},
+		{"a_b_c_2", "a", "b_c"},
+		{"a_get_user", "a", "get-user"},
+		{"a_get_user_2", "a", "get_user"},
+	}
+
+	if agg.ToolCount() != len(tests) {
+		t.Errorf("ToolCount() = %d, want %d", agg.ToolCount(), len(tests))
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.toolName, func(t *testing.T) {
+			clients["a-b"].Calls, clients["a"].Calls = nil, nil
+
+			request := mcp.CallToolRequest{}
+			request.Params.Name = tt.toolName
+			if _, err := agg.CallTool(context.Background(), request); err != nil {
+				t.Fatalf("CallTool() error = %v", err)
+			}
+			calls := clients[tt.serverName].Calls
+			if len(calls) != 1 || calls[0].Params.Name != tt.original {
+				t.Errorf("Forwarded calls to %s = %+v, want %s", tt.serverName, calls, tt.original)
+			}
+		})
+	}
+}
+
+func TestInitializeStoresConfigs(t *testing.T) {
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		return &MockClient{Tools: []mcp.Tool{{Name: "tool1"}}}, nil
+	}))
+
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{Name: "server1", Command: "command1", Args: []string{"--flag"}, Env: map[string]string{"KEY": "value"}},
+			{Name: "server2", Command: "command2"},
+		},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	defer agg.Close()
+
+	// Each server keeps its own config, needed later for filtering and respawning
+	for _, serverCfg := range cfg.Servers {
+		stored, ok := agg.configs[serverCfg.Name]
+		if !ok {
+			t.Errorf("Config for server %s not stored", serverCfg.Name)
+			continue
+		}
+		if !reflect.DeepEqual(*stored, serverCfg) {
+			t.Errorf("Stored config = %+v, want %+v", *stored, serverCfg)
+		}
+	}
+}
+
+func TestInitializeAppliesAllowedFilter(t *testing.T) {
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		return &MockClient{Tools: []mcp.Tool{{Name: "search-stories"}, {Name: "get-story"}, {Name: "delete-story"}}}, nil
+	}))
+
+	// Filtering goes through the configs stored by Initialize, not ones set up by the test
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{Name: "filtered", Command: "test-command", Tools: &config.ToolsConfig{Allowed: []string{"search-stories", "get_story"}}},
+			{Name: "nothing", Command: "test-command", Tools: &config.ToolsConfig{Allowed: []string{}}},
+			{Name: "unfiltered", Command: "test-command"},
+		},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	defer agg.Close()
+
+	got := make(map[string]bool)
+	for _, tool := range agg.GetTools() {
+		got[tool.Name] = true
+	}
+	want := map[string]bool{
+		"filtered_search_stories":   true,
+		"filtered_get_story":        true,
+		"unfiltered_search_stories": true,
+		"unfiltered_get_story":      true,
+		"unfiltered_delete_story":   true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetTools() = %v, want %v", got, want)
+	}
+}
+
+func TestInitializeWithClientFactory(t *testing.T) {
+	var created []string
+	factory := ClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		created = append(created, serverCfg.Name)
+		switch serverCfg.Name {
+		case "broken":
+			return &flakyInitClient{failures: 1}, nil
+		case "unbuildable":
+			return nil, errors.New("no such transport")
+		}
+		return &MockClient{Tools: []mcp.Tool{{Name: "search"}}}, nil
+	})
+
+	// Servers that fail to initialize are skipped, the others are discovered
+	agg := NewMCPAggregator(WithClientFactory(factory))
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{Name: "github", Command: "test-command"},
+			{Name: "broken", Command: "test-command"},
+		},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	defer agg.Close()
+	if want := []string{"github", "broken"}; !reflect.DeepEqual(created, want) {
+		t.Errorf("Factory called for %v, want %v", created, want)
+	}
+	tools := agg.GetTools()
+	if len(tools) != 1 || tools[0].Name != "github_search" {
+		t.Errorf("GetTools() = %v, want only github_search", tools)
+	}
+
+	// Without any server initialized Initialize fails
+	empty := NewMCPAggregator(WithClientFactory(factory))
+	cfg.Servers = []config.ServerConfig{{Name: "broken", Command: "test-command"}}
+	if err := empty.Initialize(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), "no servers") {
+		t.Errorf("Initialize() with only failing servers error = %v, want no servers error", err)
+	}
+
+	// Errors of the factory itself are returned
+	failing := NewMCPAggregator(WithClientFactory(factory))
+	cfg.Servers = []config.ServerConfig{{Name: "unbuildable", Command: "test-command"}}
+	if err := failing.Initialize(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), "no such transport") {
+		t.Errorf("Initialize() with failing factory error = %v, want the factory error", err)
+	}
+}
+
+func TestServerLogFile(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	logger.Init(config.LogLevelError, "")
+
+	tests := []struct {
+		name     string
+		logFile  string
+		wantFile bool
+	}{
+		{
+			name:     "Stderr goes to the log file",
+			logFile:  filepath.Join(t.TempDir(), "noisy.log"),
+			wantFile: true,
+		},
+		{
+			name:    "Unopenable log file falls back to the log",
+			logFile: filepath.Join(t.TempDir(), "missing", "noisy.log"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mcpClient, err := newStdioMCPClient(config.ServerConfig{
+				Name:    "noisy",
+				Command: "sh",
+				Args:    []string{"-c", "echo noisy output >&2"},
+				LogFile: tt.logFile,
+			})
+			if err != nil {
+				t.Fatalf("newStdioMCPClient() error = %v", err)
+			}
+			client := mcpClient.(*stdioClient)
+			<-client.done
+
+			data, err := os.ReadFile(tt.logFile)
+			if !tt.wantFile {
+				if err == nil {
+					t.Errorf("Log file %s exists, want a fallback to the log", tt.logFile)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to read log file: %v", err)
+			}
+			if string(data) != "noisy output\n" {
+				t.Errorf("Log file content = %q, want %q", data, "noisy output\n")
+			}
+		})
+	}
+}
+
+func TestCallToolMetrics(t *testing.T) {
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		return &MockClient{Tools: []mcp.Tool{{Name: "search"}}}, nil
+	}))
+	cfg := &config.Config{
+		Servers:   []config.ServerConfig{{Name: "github", Command: "test-command"}},
+		DualNames: true,
+		LogLevel:  config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	registry := metrics.NewRegistry()
+	agg.SetMetrics(registry)
+
+	// The alias is counted under the prefixed name and every unknown name in one series
+	for _, name := range []string{"github_search", "search", "github_missing", "other_missing"} {
+		request := mcp.CallToolRequest{}
+		request.Params.Name = name
+		agg.CallTool(context.Background(), request)
+	}
+
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := recorder.Body.String()
+	for _, want := range []string{
+		`combine_mcp_tool_calls_total{server="github",tool="github_search"} 2`,
+		`combine_mcp_tool_call_errors_total{server="github",tool="github_search"} 0`,
+		`combine_mcp_tool_calls_total{server="",tool="unknown"} 2`,
+		`combine_mcp_tool_call_errors_total{server="",tool="unknown"} 2`,
+		`combine_mcp_tool_call_duration_seconds_count{server="github",tool="github_search"} 2`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Metrics missing %q, got:\n%s", want, body)
+		}
+	}
+	for _, unwanted := range []string{`tool="search"`, `tool="github_missing"`} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("Metrics contain %q, got:\n%s", unwanted, body)
+		}
+	}
+}
+
+// failingCallClient is a mock client whose tool calls fail
+type failingCallClient struct {
+	MockClient
+}
+
+func (m *failingCallClient) CallTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return nil, errors.New("upstream failed")
+}
+
+func TestCallToolTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		if serverCfg.Name == "broken" {
+			return &failingCallClient{MockClient{Tools: []mcp.Tool{{Name: "fetch-page"}}}}, nil
+		}
+		return &MockClient{Tools: []mcp.Tool{{Name: "search-issues"}}}, nil
+	}))
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{Name: "github", Command: "test-command"},
+			{Name: "broken", Command: "test-command"},
+		},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	for _, name := range []string{"github_search_issues", "broken_fetch_page"} {
+		request := mcp.CallToolRequest{}
+		request.Params.Name = name
+		agg.CallTool(context.Background(), request)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Recorded %d spans, want 2", len(spans))
+	}
+	tests := []struct {
+		name         string
+		server       string
+		originalName string
+		wantError    bool
+	}{
+		{name: "github_search_issues", server: "github", originalName: "search-issues"},
+		{name: "broken_fetch_page", server: "broken", originalName: "fetch-page", wantError: true},
+	}
+	for i, tt := range tests {
+		span := spans[i]
+		if span.Name() != tt.name {
+			t.Errorf("Span %d name = %q, want %q", i, span.Name(), tt.name)
+		}
+		attributes := map[attribute.Key]string{}
+		for _, kv := range span.Attributes() {
+			attributes[kv.Key] = kv.Value.AsString()
+		}
+		if attributes["server.name"] != tt.server || attributes["tool.original_name"] != tt.originalName {
+			t.Errorf("Span %s attributes = %v, want server.name %s and tool.original_name %s", tt.name, attributes, tt.server, tt.originalName)
+		}
+		if got := span.Status().Code == codes.Error; got != tt.wantError {
+			t.Errorf("Span %s error status = %v, want %v", tt.name, got, tt.wantError)
+		}
+		if got := len(span.Events()) > 0; got != tt.wantError {
+			t.Errorf("Span %s recorded error = %v, want %v", tt.name, got, tt.wantError)
+		}
+	}
+}
+
+// flakyInitClient is a mock client whose server fails to initialize a number of times before it's ready
+type flakyInitClient struct {
+	MockClient
+	failures int
+	attempts int
+}
+
+func (m *flakyInitClient) Initialize(ctx context.Context, request mcp.InitializeRequest) (*mcp.InitializeResult, error) {
+	m.attempts++
+	if m.attempts <= m.failures {
+		return nil, errors.New("server not ready")
+	}
+	return m.MockClient.Initialize(ctx, request)
+}
+
+func TestInitRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		retries      int
+		backoffMs    int
+		cancelAfter  time.Duration
+		wantAttempts int
+		wantTools    int
+	}{
+		{
+			name:         "No retries by default",
+			failures:     1,
+			wantAttempts: 1,
+		},
+		{
+			name:         "Retry then succeed",
+			failures:     2,
+			retries:      3,
+			backoffMs:    1,
+			wantAttempts: 3,
+			wantTools:    1,
+		},
+		{
+			name:         "Retry then give up",
+			failures:     5,
+			retries:      2,
+			backoffMs:    1,
+			wantAttempts: 3,
+		},
+		{
+			name:         "Cancellation stops retrying",
+			failures:     5,
+			retries:      3,
+			backoffMs:    60000,
+			cancelAfter:  20 * time.Millisecond,
+			wantAttempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flaky := &flakyInitClient{MockClient: MockClient{Tools: []mcp.Tool{{Name: "tool1"}}}, failures: tt.failures}
+			agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+				return flaky, nil
+			}))
+			cfg := &config.Config{
+				Servers: []config.ServerConfig{
+					{Name: "flaky", Command: "test-command", InitRetries: tt.retries, InitRetryBackoffMs: tt.backoffMs},
+				},
+				LogLevel: config.LogLevelError,
+			}
+
+			ctx := context.Background()
+			if tt.cancelAfter > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.cancelAfter)
+				defer cancel()
+			}
+
+			start := time.Now()
+			err := agg.Initialize(ctx, cfg)
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("Initialize() took %s", elapsed)
+			}
+			if (err == nil) != (tt.wantTools > 0) {
+				t.Errorf("Initialize() error = %v", err)
+			}
+			if flaky.attempts != tt.wantAttempts {
+				t.Errorf("Initialize attempts = %d, want %d", flaky.attempts, tt.wantAttempts)
+			}
+			if got := agg.ToolCount(); got != tt.wantTools {
+				t.Errorf("ToolCount() = %d, want %d", got, tt.wantTools)
+			}
+		})
+	}
+}
+
+// readinessClient is a mock client whose readiness tool fails a number of times before it succeeds
+type readinessClient struct {
+	MockClient
+	failures int
+	probes   int
+}
+
+func (m *readinessClient) CallTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	if request.Params.Name != "ping_db" {
+		return m.MockClient.CallTool(ctx, request)
+	}
+	m.probes++
+	if m.probes <= m.failures {
+		result := mcp.NewToolResultText("database not connected")
+		result.IsError = true
+		return result, nil
+	}
+	return mcp.NewToolResultText("ok"), nil
+}
+
+func TestReadinessTool(t *testing.T) {
+	tests := []struct {
+		name        string
+		failures    int
+		timeout     time.Duration
+		wantReady   bool
+		wantSkipped []SkippedServer
+	}{
+		{
+			name:      "Ready after failed probes",
+			failures:  3,
+			wantReady: true,
+		},
+		{
+			name:     "Never ready",
+			failures: 1000,
+			timeout:  50 * time.Millisecond,
+			wantSkipped: []SkippedServer{{
+				Name:   "postgres",
+				Reason: "readiness tool ping_db didn't succeed within 50ms: tool returned an error: database not connected",
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probed := &readinessClient{MockClient: MockClient{Tools: []mcp.Tool{{Name: "query"}}}, failures: tt.failures}
+			agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+				if serverCfg.Name == "postgres" {
+					return probed, nil
+				}
+				return &MockClient{Tools: []mcp.Tool{{Name: "search"}}}, nil
+			}))
+			agg.readinessInterval = 10 * time.Millisecond
+			if tt.timeout > 0 {
+				agg.readinessTimeout = tt.timeout
+			}
+			cfg := &config.Config{
+				Servers: []config.ServerConfig{
+					{Name: "github", Command: "test-command"},
+					{Name: "postgres", Command: "test-command", ReadinessTool: "ping_db"},
+				},
+				LogLevel: config.LogLevelError,
+			}
+			if err := agg.Initialize(context.Background(), cfg); err != nil {
+				t.Fatalf("Initialize() error = %v", err)
+			}
+			defer agg.Close()
+
+			if tt.wantReady && probed.probes != tt.failures+1 {
+				t.Errorf("Readiness tool called %d times, want %d", probed.probes, tt.failures+1)
+			}
+			_, exposed := agg.tools["postgres_query"]
+			if exposed != tt.wantReady {
+				t.Errorf("postgres_query exposed = %v, want %v", exposed, tt.wantReady)
+			}
+			if _, ok := agg.tools["github_search"]; !ok {
+				t.Errorf("github_search isn't exposed")
+			}
+			if got := agg.InitReport().Skipped; !reflect.DeepEqual(got, tt.wantSkipped) {
+				t.Errorf("InitReport().Skipped = %+v, want %+v", got, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+// concurrencyClient is a mock client that tracks how many calls it handles at the same time
+type concurrencyClient struct {
+	MockClient
+	inFlight    atomic.Int32
+	maxInFlight atomic.Int32
+	release     chan struct{}
+}
+
+func (m *concurrencyClient) CallTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	current := m.inFlight.Add(1)
+	defer m.inFlight.Add(-1)
+	for {
+		observed := m.maxInFlight.Load()
+		if current <= observed || m.maxInFlight.CompareAndSwap(observed, current) {
+			break
+		}
+	}
+	<-m.release
+	return &mcp.CallToolResult{}, nil
+}
+
+func TestMaxConcurrentCalls(t *testing.T) {
+	newAggregator := func(t *testing.T, upstream *concurrencyClient, queue bool) *MCPAggregator {
+		agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+			return upstream, nil
+		}))
+		cfg := &config.Config{
+			Servers: []config.ServerConfig{
+				{Name: "serial", Command: "test-command", MaxConcurrentCalls: 2, QueueCalls: queue},
+			},
+			LogLevel: config.LogLevelError,
+		}
+		if err := agg.Initialize(context.Background(), cfg); err != nil {
+			t.Fatalf("Initialize() error = %v", err)
+		}
+		return agg
+	}
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "serial_tool1"
+
+	t.Run("Queued calls wait for a free slot", func(t *testing.T) {
+		upstream := &concurrencyClient{MockClient: MockClient{Tools: []mcp.Tool{{Name: "tool1"}}}, release: make(chan struct{})}
+		agg := newAggregator(t, upstream, true)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 5)
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result, err := agg.CallTool(context.Background(), request)
+				if err == nil && result.IsError {
+					err = errors.New("call rejected")
+				}
+				errs <- err
+			}()
+		}
+		waitFor(t, "calls to reach the limit", func() bool {
+			return upstream.inFlight.Load() == 2
+		})
+		close(upstream.release)
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			if err != nil {
+				t.Errorf("CallTool() error = %v", err)
+			}
+		}
+		if got := upstream.maxInFlight.Load(); got != 2 {
+			t.Errorf("Max concurrent calls = %d, want 2", got)
+		}
+	})
+
+	t.Run("Calls over the limit are rejected", func(t *testing.T) {
+		upstream := &concurrencyClient{MockClient: MockClient{Tools: []mcp.Tool{{Name: "tool1"}}}, release: make(chan struct{})}
+		agg := newAggregator(t, upstream, false)
+		defer close(upstream.release)
+
+		for i := 0; i < 2; i++ {
+			go agg.CallTool(context.Background(), request)
+		}
+		waitFor(t, "calls to reach the limit", func() bool {
+			return upstream.inFlight.Load() == 2
+		})
+
+		result, err := agg.CallTool(context.Background(), request)
+		if err != nil {
+			t.Fatalf("CallTool() error = %v", err)
+		}
+		if !result.IsError {
+			t.Errorf("CallTool() over the limit IsError = false, want a busy error")
+		}
+	})
+
+	t.Run("Queued calls respect the context", func(t *testing.T) {
+		upstream := &concurrencyClient{MockClient: MockClient{Tools: []mcp.Tool{{Name: "tool1"}}}, release: make(chan struct{})}
+		agg := newAggregator(t, upstream, true)
+		defer close(upstream.release)
+
+		for i := 0; i < 2; i++ {
+			go agg.CallTool(context.Background(), request)
+		}
+		waitFor(t, "calls to reach the limit", func() bool {
+			return upstream.inFlight.Load() == 2
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if _, err := agg.CallTool(ctx, request); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("CallTool() error = %v, want context.DeadlineExceeded", err)
+		}
+	})
+}
+
+type timestampClient struct {
+	MockClient
+	mu    sync.Mutex
+	calls []time.Time
+}
+
+func (m *timestampClient) CallTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, time.Now())
+	return &mcp.CallToolResult{}, nil
+}
+
+func TestRateLimit(t *testing.T) {
+	// 1200 calls per minute leave 50ms between calls
+	newAggregator := func(t *testing.T, upstream *timestampClient, reject bool) *MCPAggregator {
+		agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+			return upstream, nil
+		}))
+		cfg := &config.Config{
+			Servers: []config.ServerConfig{
+				{Name: "github", Command: "test-command", RateLimitPerMinute: 1200, RejectRateLimited: reject},
+			},
+			LogLevel: config.LogLevelError,
+		}
+		if err := agg.Initialize(context.Background(), cfg); err != nil {
+			t.Fatalf("Initialize() error = %v", err)
+		}
+		return agg
+	}
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "github_tool1"
+
+	t.Run("Calls over the limit wait for their turn", func(t *testing.T) {
+		upstream := &timestampClient{MockClient: MockClient{Tools: []mcp.Tool{{Name: "tool1"}}}}
+		agg := newAggregator(t, upstream, false)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if result, err := agg.CallTool(context.Background(), request); err != nil || result.IsError {
+					t.Errorf("CallTool() = %+v, %v, want a result", result, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		upstream.mu.Lock()
+		defer upstream.mu.Unlock()
+		if len(upstream.calls) != 4 {
+			t.Fatalf("Forwarded %d calls, want 4", len(upstream.calls))
+		}
+		// The limiter spaces calls by 50ms, leaving some slack for the scheduler
+		if spread := upstream.calls[3].Sub(upstream.calls[0]); spread < 130*time.Millisecond {
+			t.Errorf("4 calls were forwarded within %v, want them spread over about 150ms", spread)
+		}
+	})
+
+	t.Run("Calls over the limit are rejected", func(t *testing.T) {
+		upstream := &timestampClient{MockClient: MockClient{Tools: []mcp.Tool{{Name: "tool1"}}}}
+		agg := newAggregator(t, upstream, true)
+
+		if result, err := agg.CallTool(context.Background(), request); err != nil || result.IsError {
+			t.Fatalf("First CallTool() = %+v, %v, want a result", result, err)
+		}
+		result, err := agg.CallTool(context.Background(), request)
+		if err != nil {
+			t.Fatalf("CallTool() error = %v", err)
+		}
+		if !result.IsError {
+			t.Fatalf("CallTool() over the limit IsError = false, want a rate limit error")
+		}
+		if text, ok := mcp.AsTextContent(result.Content[0]); !ok || !strings.Contains(text.Text, "try again in") {
+			t.Errorf("CallTool() over the limit content = %+v, want when to try again", result.Content[0])
+		}
+
+		// Rejected calls don't use up the limit
+		time.Sleep(60 * time.Millisecond)
+		if result, err := agg.CallTool(context.Background(), request); err != nil || result.IsError {
+			t.Errorf("CallTool() after waiting = %+v, %v, want a result", result, err)
+		}
+		upstream.mu.Lock()
+		defer upstream.mu.Unlock()
+		if len(upstream.calls) != 2 {
+			t.Errorf("Forwarded %d calls, want 2", len(upstream.calls))
+		}
+	})
+
+	t.Run("Waiting calls respect the context", func(t *testing.T) {
+		upstream := &timestampClient{MockClient: MockClient{Tools: []mcp.Tool{{Name: "tool1"}}}}
+		agg := newAggregator(t, upstream, false)
+
+		if _, err := agg.CallTool(context.Background(), request); err != nil {
+			t.Fatalf("First CallTool() error = %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if _, err := agg.CallTool(ctx, request); err == nil {
+			t.Errorf("CallTool() with a context shorter than the wait error = nil, want an error")
+		}
+	})
+}
+
+func TestServerStatuses(t *testing.T) {
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		if serverCfg.Name == "github" {
+			return &MockClient{Tools: []mcp.Tool{{Name: "search"}, {Name: "create-issue"}}}, nil
+		}
+		return &MockClient{Tools: []mcp.Tool{{Name: "query"}}}, nil
+	}))
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{Name: "github", Command: "test-command"},
+			{Name: "db", Command: "test-command"},
+		},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	want := []ServerStatus{
+		{Name: "db", ServerName: "mock-server", ServerVersion: "1.0.0", Tools: 1, ToolPrefix: "db_"},
+		{Name: "github", ServerName: "mock-server", ServerVersion: "1.0.0", Tools: 2, ToolPrefix: "github_"},
+	}
+	if got := agg.ServerStatuses(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ServerStatuses() = %+v, want %+v", got, want)
+	}
+}
+
+func TestToolsByServer(t *testing.T) {
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		if serverCfg.Name == "github" {
+			return &MockClient{Tools: []mcp.Tool{{Name: "search"}, {Name: "delete-repo"}, {Name: "create-issue"}}}, nil
+		}
+		return &MockClient{Tools: []mcp.Tool{{Name: "query"}, {Name: "drop-table"}}}, nil
+	}))
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{Name: "github", Command: "test-command", Tools: &config.ToolsConfig{Denied: []string{"delete-repo"}}},
+			{Name: "db", Command: "test-command", Tools: &config.ToolsConfig{Allowed: []string{}}},
+		},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	want := []ServerTools{
+		{Name: "db", Filtered: []string{"drop-table", "query"}},
+		{Name: "github", Tools: []string{"github_create_issue", "github_search"}, Filtered: []string{"delete-repo"}},
+	}
+	if got := agg.ToolsByServer(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ToolsByServer() = %+v, want %+v", got, want)
+	}
+}
+
+func TestServerWorkingDir(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	logger.Init(config.LogLevelError, "")
+
+	workingDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve working directory: %v", err)
+	}
+	logFile := filepath.Join(t.TempDir(), "pwd.log")
+
+	mcpClient, err := newStdioMCPClient(config.ServerConfig{
+		Name:       "fs",
+		Command:    "sh",
+		Args:       []string{"-c", "pwd -P >&2"},
+		LogFile:    logFile,
+		WorkingDir: workingDir,
+	})
+	if err != nil {
+		t.Fatalf("newStdioMCPClient() error = %v", err)
+	}
+	<-mcpClient.(*stdioClient).done
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != workingDir {
+		t.Errorf("Server ran in %s, want %s", got, workingDir)
+	}
+}
+
+func TestServerArgsEnv(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	logger.Init(config.LogLevelError, "")
+	t.Setenv("COMBINE_MCP_TEST_REGION", "eu")
+	t.Setenv("COMBINE_MCP_TEST_ARGS", "  --region=eu\t--verbose ")
+
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	configData := `{"mcpServers": {"cloud": {
+		"command": "sh",
+		"args": ["-c", "echo \"$$*\" >&2", "sh", "--zone=${COMBINE_MCP_TEST_REGION}-1"],
+		"argsEnv": "COMBINE_MCP_TEST_ARGS"
+	}}}`
+	if err := os.WriteFile(configPath, []byte(configData), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	t.Setenv(config.DefaultEnvVar, configPath)
+	cfg, err := config.LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	serverCfg := cfg.Servers[0]
+	serverCfg.LogFile = filepath.Join(t.TempDir(), "args.log")
+	mcpClient, err := newStdioMCPClient(serverCfg)
+	if err != nil {
+		t.Fatalf("newStdioMCPClient() error = %v", err)
+	}
+	<-mcpClient.(*stdioClient).done
+
+	data, err := os.ReadFile(serverCfg.LogFile)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	want := "--zone=eu-1 --region=eu --verbose"
+	if got := strings.TrimSpace(string(data)); got != want {
+		t.Errorf("Server got args %q, want %q", got, want)
+	}
+	if len(serverCfg.Args) != 4 {
+		t.Errorf("Config args = %v, want the appended args left out", serverCfg.Args)
+	}
+}
+
+func TestServerCleanEnv(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	logger.Init(config.LogLevelError, "")
+	t.Setenv("COMBINE_MCP_TEST_UNRELATED", "leaked")
+	t.Setenv("COMBINE_MCP_TEST_INHERITED", "inherited")
+
+	tests := []struct {
+		name       string
+		cleanEnv   bool
+		inheritEnv []string
+		want       string
+	}{
+		{
+			name: "Whole environment by default",
+			want: "unrelated=leaked inherited=inherited own=own",
+		},
+		{
+			name:     "Clean environment",
+			cleanEnv: true,
+			want:     "unrelated= inherited= own=own",
+		},
+		{
+			name:       "Inherited variables",
+			inheritEnv: []string{"COMBINE_MCP_TEST_INHERITED", "COMBINE_MCP_TEST_UNSET"},
+			want:       "unrelated= inherited=inherited own=own",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logFile := filepath.Join(t.TempDir(), "env.log")
+			mcpClient, err := newStdioMCPClient(config.ServerConfig{
+				Name:       "env",
+				Command:    "sh",
+				Args:       []string{"-c", `echo "unrelated=$COMBINE_MCP_TEST_UNRELATED inherited=$COMBINE_MCP_TEST_INHERITED own=$OWN" >&2`},
+				Env:        map[string]string{"OWN": "own"},
+				LogFile:    logFile,
+				CleanEnv:   tt.cleanEnv,
+				InheritEnv: tt.inheritEnv,
+			})
+			if err != nil {
+				t.Fatalf("newStdioMCPClient() error = %v", err)
+			}
+			<-mcpClient.(*stdioClient).done
+
+			data, err := os.ReadFile(logFile)
+			if err != nil {
+				t.Fatalf("Failed to read log file: %v", err)
+			}
+			if got := strings.TrimSpace(string(data)); got != tt.want {
+				t.Errorf("Server environment = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	logger.Init(config.LogLevelError, "")
+
+	tests := []struct {
+		name     string
+		script   string
+		wantExit string
+		minTime  time.Duration
+		maxTime  time.Duration
+	}{
+		{
+			name:     "Terminated by SIGTERM",
+			script:   "exec sleep 60",
+			wantExit: "signal: terminated",
+		},
+		{
+			// Ignored signals stay ignored across exec
+			name:     "Killed after ignoring SIGTERM",
+			script:   `trap "" TERM; exec sleep 60`,
+			wantExit: "signal: killed",
+			minTime:  200 * time.Millisecond,
+		},
+		{
+			// The shell's child keeps the output open unless it is terminated with the shell
+			name:     "Terminated with its children",
+			script:   "sleep 60 & wait",
+			wantExit: "signal: terminated",
+			maxTime:  time.Second,
+		},
+		{
+			// A process that left the group keeps the output open until it exits
+			name:     "Output closed after a child escaped",
+			script:   "setsid sleep 3 & exec sleep 60",
+			wantExit: "signal: terminated",
+			minTime:  300 * time.Millisecond,
+			maxTime:  2 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := exec.LookPath("setsid"); err != nil && strings.Contains(tt.script, "setsid") {
+				t.Skip("setsid is not available")
+			}
+			mcpClient, err := newStdioMCPClient(config.ServerConfig{
+				Name:            "stubborn",
+				Command:         "sh",
+				Args:            []string{"-c", tt.script},
+				ShutdownGraceMs: 200,
+			})
+			if err != nil {
+				t.Fatalf("newStdioMCPClient() error = %v", err)
+			}
+			client := mcpClient.(*stdioClient)
+			client.killWait = 100 * time.Millisecond
+			// Give the shell time to set up the trap before it is signalled
+			time.Sleep(100 * time.Millisecond)
+
+			start := time.Now()
+			if err := client.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			elapsed := time.Since(start)
+			maxTime := tt.maxTime
+			if maxTime == 0 {
+				maxTime = 5 * time.Second
+			}
+			if elapsed < tt.minTime || elapsed > maxTime {
+				t.Errorf("Close() took %v, want between %v and %v", elapsed, tt.minTime, maxTime)
+			}
+			if err := client.ExitErr(); err == nil || err.Error() != tt.wantExit {
+				t.Errorf("Process exit = %v, want %s", err, tt.wantExit)
+			}
+		})
+	}
+}
+
+// redactionHelperEnvVar makes the test binary start a server with secrets, logging to the file it names
+const redactionHelperEnvVar = "COMBINE_MCP_REDACTION_LOG"
+
+// TestSecretRedactionHelper is not a real test, it is the process whose log TestSecretRedaction inspects
+func TestSecretRedactionHelper(t *testing.T) {
+	logPath := os.Getenv(redactionHelperEnvVar)
+	if logPath == "" {
+		return
+	}
+	if err := logger.Init(config.LogLevelDebug, logPath); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+	defer logger.Close()
+
+	mcpClient, err := newStdioMCPClient(config.ServerConfig{
+		Name:    "github",
+		Command: os.Args[0],
+		Args:    []string{"-test.run=^$", "--token", "ghp_secret_value"},
+		Env:     map[string]string{"GITHUB_TOKEN": "ghp_secret_value", "GITHUB_OWNER": "octo"},
+	})
+	if err != nil {
+		t.Fatalf("newStdioMCPClient() error = %v", err)
+	}
+	mcpClient.Close()
+}
+
+func TestSecretRedaction(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "combine-mcp.log")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSecretRedactionHelper$")
+	cmd.Env = append(os.Environ(), redactionHelperEnvVar+"="+logPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Helper process failed: %v\n%s", err, output)
+	}
+
+	logged, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	if strings.Contains(string(logged), "ghp_secret_value") {
+		t.Errorf("Log contains the token:\n%s", logged)
+	}
+	for _, want := range []string{"GITHUB_TOKEN=***", "GITHUB_OWNER=octo", "--token ***"} {
+		if !strings.Contains(string(logged), want) {
+			t.Errorf("Log doesn't contain %q:\n%s", want, logged)
+		}
+	}
+}
+
+func TestServersWithoutTools(t *testing.T) {
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		if serverCfg.Name == "github" {
+			return &MockClient{Tools: []mcp.Tool{{Name: "search"}}}, nil
+		}
+		return &MockClient{Tools: []mcp.Tool{}}, nil
+	}))
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{Name: "github", Command: "test-command"},
+			{Name: "empty", Command: "test-command"},
+		},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	// A server without tools stays connected and is reported with no tools
+	want := []ServerStatus{
+		{Name: "empty", ServerName: "mock-server", ServerVersion: "1.0.0", Tools: 0},
+		{Name: "github", ServerName: "mock-server", ServerVersion: "1.0.0", Tools: 1, ToolPrefix: "github_"},
+	}
+	if got := agg.ServerStatuses(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ServerStatuses() = %+v, want %+v", got, want)
+	}
+
+	// Servers that connected without tools are not mistaken for failed ones
+	toolless := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		return &MockClient{Tools: []mcp.Tool{}}, nil
+	}))
+	cfg.Servers = []config.ServerConfig{{Name: "empty", Command: "test-command"}}
+	if err := toolless.Initialize(context.Background(), cfg); err != nil {
+		t.Errorf("Initialize() with only toolless servers error = %v, want nil", err)
+	}
+	if toolless.ServerCount() != 1 {
+		t.Errorf("ServerCount() = %d, want 1", toolless.ServerCount())
+	}
+
+	failing := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		return &flakyInitClient{failures: 1}, nil
+	}))
+	if err := failing.Initialize(context.Background(), cfg); err == nil {
+		t.Errorf("Initialize() with only failed servers error = nil, want an error")
+	}
+}
+
+func TestInitReport(t *testing.T) {
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		if serverCfg.Name == "broken" {
+			return &flakyInitClient{failures: 1}, nil
+		}
+		return &MockClient{Tools: []mcp.Tool{{Name: "search"}}}, nil
+	}))
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{Name: "github", Command: "test-command"},
+			{Name: "broken", Command: "test-command"},
+			{Name: "blocked", Command: "forbidden-command"},
+			{Name: "shortcut", Command: "test-command"},
+		},
+		AllowedCommands: []string{"test-command"},
+		LogLevel:        config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	want := InitReport{
+		Initialized: []string{"github", "shortcut"},
+		Skipped: []SkippedServer{
+			{Name: "broken", Reason: "server not ready"},
+			{Name: "blocked", Reason: "command forbidden-command of server blocked is not allowed by MCP_ALLOWED_COMMANDS"},
+		},
+	}
+	report := agg.InitReport()
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("InitReport() = %+v, want %+v", report, want)
+	}
+
+	wantSummary := "Servers initialized: 2 of 4\n" +
+		"  initialized  github\n" +
+		"  initialized  shortcut\n" +
+		"  skipped      broken: server not ready\n" +
+		"  skipped      blocked: command forbidden-command of server blocked is not allowed by MCP_ALLOWED_COMMANDS"
+	if got := report.String(); got != wantSummary {
+		t.Errorf("InitReport().String() = %q, want %q", got, wantSummary)
+	}
+
+	// The report is also kept when every server fails
+	failing := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		return &flakyInitClient{failures: 1}, nil
+	}))
+	cfg.Servers = []config.ServerConfig{{Name: "broken", Command: "test-command"}}
+	if err := failing.Initialize(context.Background(), cfg); err == nil {
+		t.Fatalf("Initialize() with only failed servers error = nil, want an error")
+	}
+	if got := failing.InitReport(); len(got.Initialized) != 0 || len(got.Skipped) != 1 {
+		t.Errorf("InitReport() = %+v, want only the broken server skipped", got)
+	}
+}
+
+func TestStats(t *testing.T) {
+	tests := []struct {
+		name      string
+		dualNames bool
+		want      Stats
+	}{
+		{
+			name: "Prefixed names",
+			want: Stats{Tools: 3, ToolsByServer: map[string]int{"github": 2, "shortcut": 1, "empty": 0}, Connected: 3, Failed: 1},
+		},
+		{
+			// Unprefixed aliases count as tools of the server they call
+			name:      "Dual names",
+			dualNames: true,
+			want:      Stats{Tools: 6, ToolsByServer: map[string]int{"github": 4, "shortcut": 2, "empty": 0}, Connected: 3, Failed: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+				switch serverCfg.Name {
+				case "github":
+					return &MockClient{Tools: []mcp.Tool{{Name: "search"}, {Name: "get_issue"}}}, nil
+				case "shortcut":
+					return &MockClient{Tools: []mcp.Tool{{Name: "get_story"}}}, nil
+				case "empty":
+					return &MockClient{}, nil
+				}
+				return &flakyInitClient{failures: 1}, nil
+			}))
+			cfg := &config.Config{
+				Servers: []config.ServerConfig{
+					{Name: "github", Command: "test-command"},
+					{Name: "shortcut", Command: "test-command"},
+					{Name: "empty", Command: "test-command"},
+					{Name: "broken", Command: "test-command"},
+				},
+				DualNames: tt.dualNames,
+				LogLevel:  config.LogLevelError,
+			}
+			if err := agg.Initialize(context.Background(), cfg); err != nil {
+				t.Fatalf("Initialize() error = %v", err)
+			}
+			defer agg.Close()
+
+			if got := agg.Stats(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Stats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArgDefaults(t *testing.T) {
+	serverConfig := config.ServerConfig{
+		Name:    "github",
+		Command: "test-command",
+		ArgDefaults: map[string]map[string]interface{}{
+			"search-repos": {"org": "mycorp", "per_page": 50},
+		},
+	}
+	mockClient := &MockClient{
+		Tools: []mcp.Tool{
+			{
+				Name: "search-repos",
+				InputSchema: mcp.ToolInputSchema{
+					Type: "object",
+					Properties: map[string]interface{}{
+						"org":      map[string]interface{}{"type": "string"},
+						"query":    map[string]interface{}{"type": "string"},
+						"per_page": map[string]interface{}{"type": "number"},
+					},
+					Required: []string{"org", "query"},
+				},
+			},
+			{Name: "get-user"},
+		},
+	}
+
+	agg := NewMCPAggregator()
+	agg.clients[serverConfig.Name] = mockClient
+	agg.configs[serverConfig.Name] = &serverConfig
+	if err := agg.discoverTools(context.Background(), serverConfig.Name); err != nil {
+		t.Fatalf("discoverTools() error = %v", err)
+	}
+
+	// Parameters with defaults stay in the schema but are no longer required
+	for _, tool := range agg.GetTools() {
+		if tool.Name != "github_search_repos" {
+			continue
+		}
+		if _, ok := tool.InputSchema.Properties["org"]; !ok {
+			t.Error("Parameter org with a default should stay in the schema")
+		}
+		if !reflect.DeepEqual(tool.InputSchema.Required, []string{"query"}) {
+			t.Errorf("Required = %v, want [query]", tool.InputSchema.Required)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		toolName  string
+		arguments map[string]interface{}
+		want      map[string]interface{}
+	}{
+		{
+			name:      "Defaults applied",
+			toolName:  "github_search_repos",
+			arguments: map[string]interface{}{"query": "aggregator"},
+			want:      map[string]interface{}{"org": "mycorp", "per_page": 50, "query": "aggregator"},
+		},
+		{
+			name:      "Client overrides a default",
+			toolName:  "github_search_repos",
+			arguments: map[string]interface{}{"query": "aggregator", "org": "other"},
+			want:      map[string]interface{}{"org": "other", "per_page": 50, "query": "aggregator"},
+		},
+		{
+			name:      "Tool without defaults",
+			toolName:  "github_get_user",
+			arguments: map[string]interface{}{"login": "octocat"},
+			want:      map[string]interface{}{"login": "octocat"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient.Calls = nil
+
+			request := mcp.CallToolRequest{}
+			request.Params.Name = tt.toolName
+			request.Params.Arguments = tt.arguments
+			if _, err := agg.CallTool(context.Background(), request); err != nil {
+				t.Fatalf("CallTool() error = %v", err)
+			}
+
+			if len(mockClient.Calls) != 1 {
+				t.Fatalf("Forwarded %d calls, want 1", len(mockClient.Calls))
+			}
+			if got := mockClient.Calls[0].Params.Arguments; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Forwarded arguments = %v, want %v", got, tt.want)
+			}
+			if _, ok := tt.arguments["per_page"]; ok {
+				t.Error("The caller's arguments were modified")
+			}
+		})
+	}
+}
+
+func TestToolDelimiter(t *testing.T) {
+	tests := []struct {
+		name      string
+		delimiter string
+		want      map[string]string // Exposed name -> server
+	}{
+		{
+			name: "Default delimiter is ambiguous",
+			want: map[string]string{"my_db_list_tables": "my_db", "my_db_list_tables_2": "my"},
+		},
+		{
+			name:      "Double underscore",
+			delimiter: "__",
+			want:      map[string]string{"my_db__list_tables": "my_db", "my__db_list_tables": "my"},
+		},
+		{
+			name:      "Dot",
+			delimiter: ".",
+			want:      map[string]string{"my_db.list_tables": "my_db", "my.db_list_tables": "my"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agg := NewMCPAggregator()
+			agg.delimiter = tt.delimiter
+			agg.clients["my_db"] = &MockClient{Tools: []mcp.Tool{{Name: "list_tables"}}}
+			agg.clients["my"] = &MockClient{Tools: []mcp.Tool{{Name: "db_list_tables"}}}
+			for _, serverName := range []string{"my_db", "my"} {
+				if err := agg.discoverTools(context.Background(), serverName); err != nil {
+					t.Fatalf("discoverTools(%s) error = %v", serverName, err)
+				}
+			}
+
+			got := make(map[string]string)
+			for _, tool := range agg.GetTools() {
+				serverName, _ := agg.ToolServer(tool.Name)
+				got[tool.Name] = serverName
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Exposed tools = %v, want %v", got, tt.want)
+			}
+
+			// The origin is stored with the mapping instead of being parsed from the name
+			for _, status := range agg.ServerStatuses() {
+				wantPrefix := status.Name + tt.delimiter
+				if tt.delimiter == "" {
+					wantPrefix = status.Name + "_"
+				}
+				if status.ToolPrefix != wantPrefix {
+					t.Errorf("ToolPrefix of server %s = %q, want %q", status.Name, status.ToolPrefix, wantPrefix)
+				}
+			}
+		})
+	}
+}
+
+func TestToolOrigin(t *testing.T) {
+	agg := NewMCPAggregator()
+	agg.clients["github"] = &MockClient{Tools: []mcp.Tool{{Name: "search-repos"}}}
+	agg.configs["github"] = &config.ServerConfig{
+		Name:    "github",
+		Command: "npx",
+		Args:    []string{"-y", "@modelcontextprotocol/server-github", "--token=ghp_secret"},
+	}
+	agg.clients["docs"] = &MockClient{Tools: []mcp.Tool{{Name: "lookup"}}}
+	agg.configs["docs"] = &config.ServerConfig{Name: "docs", Transport: config.TransportSSE, URL: "http://localhost:8080/sse?token=abc123"}
+	for _, serverName := range []string{"github", "docs"} {
+		if err := agg.discoverTools(context.Background(), serverName); err != nil {
+			t.Fatalf("discoverTools(%s) error = %v", serverName, err)
+		}
+	}
+
+	serverName, originalName, command, args, ok := agg.ToolOrigin("github_search_repos")
+	if !ok {
+		t.Fatalf("ToolOrigin() of a known tool ok = false")
+	}
+	wantArgs := []string{"-y", "@modelcontextprotocol/server-github", "--token=***"}
+	if serverName != "github" || originalName != "search-repos" || command != "npx" || !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("ToolOrigin() = %s, %s, %s, %v, want github, search-repos, npx, %v", serverName, originalName, command, args, wantArgs)
+	}
+
+	// Remote servers are identified by their URL, without the secrets in it
+	if _, _, command, args, ok := agg.ToolOrigin("docs_lookup"); !ok || command != "http://localhost:8080/sse?token=***" || args != nil {
+		t.Errorf("ToolOrigin() of a remote tool = %s, %v, %v, want its URL without args", command, args, ok)
+	}
+
+	if serverName, _, _, _, ok := agg.ToolOrigin("github_unknown"); ok {
+		t.Errorf("ToolOrigin() of an unknown tool = %s, true, want false", serverName)
+	}
+}
+
+// versionRecordingClient is a mock client that records the protocol version it was initialized with
+type versionRecordingClient struct {
+	MockClient
+	protocolVersion string
+}
+
+func (c *versionRecordingClient) Initialize(ctx context.Context, request mcp.InitializeRequest) (*mcp.InitializeResult, error) {
+	c.protocolVersion = request.Params.ProtocolVersion
+	return c.MockClient.Initialize(ctx, request)
+}
+
+func TestProtocolVersion(t *testing.T) {
+	clients := make(map[string]*versionRecordingClient)
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		client := &versionRecordingClient{MockClient: MockClient{Tools: []mcp.Tool{{Name: "tool"}}}}
+		clients[serverCfg.Name] = client
+		return client, nil
+	}))
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{Name: "legacy", Command: "test-command", ProtocolVersion: "2024-11-05"},
+			{Name: "current", Command: "test-command"},
+		},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	if got := clients["legacy"].protocolVersion; got != "2024-11-05" {
+		t.Errorf("Protocol version sent to pinned server = %q, want %q", got, "2024-11-05")
+	}
+	if got := clients["current"].protocolVersion; got != mcp.LATEST_PROTOCOL_VERSION {
+		t.Errorf("Protocol version sent to unpinned server = %q, want %q", got, mcp.LATEST_PROTOCOL_VERSION)
+	}
+}
+
+func TestStdoutGuard(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []Option
+		wantGuard bool
+	}{
+		{name: "Off by default for library use", wantGuard: false},
+		{name: "Enabled", opts: []Option{WithStdoutGuard(true)}, wantGuard: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := os.Stdout
+			var duringInit *os.File
+			agg := NewMCPAggregator(tt.opts...)
+			agg.clientFactory = func(serverCfg config.ServerConfig) (MCPClient, error) {
+				duringInit = os.Stdout
+				return &MockClient{Tools: []mcp.Tool{{Name: "tool"}}}, nil
+			}
+			cfg := &config.Config{
+				Servers:  []config.ServerConfig{{Name: "server", Command: "test-command"}},
+				LogLevel: config.LogLevelError,
+			}
+			if err := agg.Initialize(context.Background(), cfg); err != nil {
+				t.Fatalf("Initialize() error = %v", err)
+			}
+
+			if guarded := duringInit != original; guarded != tt.wantGuard {
+				t.Errorf("os.Stdout replaced during Initialize = %v, want %v", guarded, tt.wantGuard)
+			}
+			if os.Stdout != original {
+				t.Errorf("os.Stdout not restored after Initialize")
+			}
+		})
+	}
+}
+
+func TestServerErrorResponse(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	logger.Init(config.LogLevelError, "")
+
+	errorResponse := `{"jsonrpc":"2.0","id":1,"error":{"code":-32602,"message":"unknown tool","data":{"tool":"missing"}}}`
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, errorResponse)
+	}))
+	defer httpServer.Close()
+
+	stdioServer, err := newStdioMCPClient(config.ServerConfig{
+		Name:    "stdio",
+		Command: "sh",
+		Args:    []string{"-c", "read -r line; echo \"$0\"; sleep 5", errorResponse},
+	})
+	if err != nil {
+		t.Fatalf("newStdioMCPClient() error = %v", err)
+	}
+	defer stdioServer.Close()
+
+	clients := map[string]MCPClient{
+		"stdio": stdioServer,
+		"http":  newHTTPClient(httpServer.URL, nil),
+	}
+	for name, mcpClient := range clients {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			_, err := mcpClient.ListTools(ctx, mcp.ListToolsRequest{})
+
+			var rpcErr *RPCError
+			if !errors.As(err, &rpcErr) {
+				t.Fatalf("ListTools() error = %v, want an RPCError", err)
+			}
+			if rpcErr.Code != mcp.INVALID_PARAMS || string(rpcErr.Data) != `{"tool":"missing"}` {
+				t.Errorf("RPCError code = %d, data = %s, want %d and the data of the server", rpcErr.Code, rpcErr.Data, mcp.INVALID_PARAMS)
+			}
+			if want := "unknown tool (code -32602)"; !strings.Contains(err.Error(), want) {
+				t.Errorf("ListTools() error = %q, want it to contain %q", err, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package aggregator
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/nazar256/combine-mcp/pkg/config"
+)
+
+// checkCommandAllowed returns an error if the server would run a command that isn't on the allowlist.
+// A nil allowlist allows every command, and remote servers don't run one.
+func checkCommandAllowed(serverCfg config.ServerConfig, allowed []string) error {
+	if allowed == nil || (serverCfg.Transport != "" && serverCfg.Transport != config.TransportStdio) {
+		return nil
+	}
+	if !commandAllowed(serverCfg.Command, allowed) {
+		return fmt.Errorf("command %s of server %s is not allowed by %s", serverCfg.Command, serverCfg.Name, config.AllowedCommandsEnvVar)
+	}
+	return nil
+}
+
+// commandAllowed reports whether a command matches an entry of the allowlist.
+// Absolute paths match the command as it is resolved through PATH, other entries match its basename.
+func commandAllowed(command string, allowed []string) bool {
+	resolved := command
+	if path, err := exec.LookPath(command); err == nil {
+		if abs, err := filepath.Abs(path); err == nil {
+			resolved = abs
+		}
+	}
+
+	for _, entry := range allowed {
+		if filepath.IsAbs(entry) {
+			if filepath.Clean(entry) == filepath.Clean(resolved) {
+				return true
+			}
+			continue
+		}
+		if entry == filepath.Base(command) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package aggregator
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/config"
+)
+
+func TestCommandAllowed(t *testing.T) {
+	shPath, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not available")
+	}
+	shPath, err = filepath.Abs(shPath)
+	if err != nil {
+		t.Fatalf("Failed to resolve sh: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		command string
+		allowed []string
+		want    bool
+	}{
+		{name: "Basename entry", command: "npx", allowed: []string{"docker", "npx"}, want: true},
+		{name: "Basename entry matches a path", command: "/usr/local/bin/npx", allowed: []string{"npx"}, want: true},
+		{name: "Absolute entry matches the path", command: "/usr/local/bin/npx", allowed: []string{"/usr/local/bin/npx"}, want: true},
+		{name: "Absolute entry matches a command resolved through PATH", command: "sh", allowed: []string{shPath}, want: true},
+		{name: "Absolute entry of another path", command: "/tmp/npx", allowed: []string{"/usr/local/bin/npx"}, want: false},
+		{name: "Command not on the list", command: "curl", allowed: []string{"npx", "docker"}, want: false},
+		{name: "Empty list", command: "npx", allowed: []string{}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := commandAllowed(tt.command, tt.allowed); got != tt.want {
+				t.Errorf("commandAllowed(%q, %v) = %v, want %v", tt.command, tt.allowed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitializeAllowedCommands(t *testing.T) {
+	var started []string
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		started = append(started, serverCfg.Name)
+		return &MockClient{Tools: []mcp.Tool{{Name: "search"}}}, nil
+	}))
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{Name: "allowed", Command: "npx"},
+			{Name: "blocked", Command: "/tmp/evil/backdoor"},
+			{Name: "remote", Transport: config.TransportHTTP, URL: "http://localhost:8080/mcp"},
+		},
+		LogLevel:        config.LogLevelError,
+		AllowedCommands: []string{"npx"},
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	want := []string{"allowed", "remote"}
+	if len(started) != len(want) || started[0] != want[0] || started[1] != want[1] {
+		t.Errorf("Started servers = %v, want %v", started, want)
+	}
+	if _, exists := agg.clients["blocked"]; exists {
+		t.Errorf("Blocked server was connected")
+	}
+	if agg.ToolCount() != 2 {
+		t.Errorf("ToolCount() = %d, want 2", agg.ToolCount())
+	}
+}
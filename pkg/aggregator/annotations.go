@@ -0,0 +1,72 @@
+package aggregator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// ToolAnnotations are the hints a server gives about how a tool behaves.
+// Hints that the server doesn't set are nil.
+type ToolAnnotations struct {
+	ReadOnlyHint    *bool `json:"readOnlyHint,omitempty"`
+	DestructiveHint *bool `json:"destructiveHint,omitempty"`
+}
+
+// annotatedToolLister is implemented by clients that keep the annotations of the tools they list,
+// which mcp.Tool doesn't carry. The annotations are keyed by tool name.
+type annotatedToolLister interface {
+	ListToolsWithAnnotations(ctx context.Context, request mcp.ListToolsRequest) (*mcp.ListToolsResult, map[string]ToolAnnotations, error)
+}
+
+// The clients of servers started as processes and of streamable HTTP servers keep annotations
+var (
+	_ annotatedToolLister = (*stdioClient)(nil)
+	_ annotatedToolLister = (*httpClient)(nil)
+)
+
+// parseToolsResult unmarshals a tools/list result together with the annotations of its tools
+func parseToolsResult(response json.RawMessage) (*mcp.ListToolsResult, map[string]ToolAnnotations, error) {
+	var result mcp.ListToolsResult
+	if err := json.Unmarshal(response, &result); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	var annotated struct {
+		Tools []struct {
+			Name        string           `json:"name"`
+			Annotations *ToolAnnotations `json:"annotations"`
+		} `json:"tools"`
+	}
+	if err := json.Unmarshal(response, &annotated); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal tool annotations: %w", err)
+	}
+	annotations := make(map[string]ToolAnnotations)
+	for _, tool := range annotated.Tools {
+		if tool.Annotations != nil {
+			annotations[tool.Name] = *tool.Annotations
+		}
+	}
+	return &result, annotations, nil
+}
+
+// listTools lists the tools of a client with their annotations, if the client keeps them
+func listTools(ctx context.Context, mcpClient MCPClient) (*mcp.ListToolsResult, map[string]ToolAnnotations, error) {
+	if lister, ok := mcpClient.(annotatedToolLister); ok {
+		return lister.ListToolsWithAnnotations(ctx, mcp.ListToolsRequest{})
+	}
+	result, err := mcpClient.ListTools(ctx, mcp.ListToolsRequest{})
+	return result, nil, err
+}
+
+// allowedReadOnly reports whether a tool may be exposed in read-only mode according to its annotations,
+// and whether it has any. Only tools marked read-only are allowed: readOnlyHint defaults to false, so an
+// annotated tool without it may modify its environment even if it is marked as not destructive.
+func (t *ToolAnnotations) allowedReadOnly() (allowed, known bool) {
+	if t == nil {
+		return true, false
+	}
+	return t.ReadOnlyHint != nil && *t.ReadOnlyHint, true
+}
@@ -0,0 +1,133 @@
+package aggregator
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/config"
+)
+
+type annotatedClient struct {
+	MockClient
+	annotations map[string]ToolAnnotations
+}
+
+func (m *annotatedClient) ListToolsWithAnnotations(ctx context.Context, request mcp.ListToolsRequest) (*mcp.ListToolsResult, map[string]ToolAnnotations, error) {
+	result, err := m.ListTools(ctx, request)
+	return result, m.annotations, err
+}
+
+func hint(value bool) *bool {
+	return &value
+}
+
+func TestReadOnlyMode(t *testing.T) {
+	upstream := func() MCPClient {
+		return &annotatedClient{
+			MockClient: MockClient{Tools: []mcp.Tool{
+				{Name: "search"}, {Name: "delete"}, {Name: "create"}, {Name: "append"}, {Name: "wipe"}, {Name: "unknown"},
+			}},
+			annotations: map[string]ToolAnnotations{
+				"search": {ReadOnlyHint: hint(true)},
+				"delete": {ReadOnlyHint: hint(false), DestructiveHint: hint(true)},
+				"create": {ReadOnlyHint: hint(false)},
+				"append": {DestructiveHint: hint(false)},
+				"wipe":   {DestructiveHint: hint(true)},
+			},
+		}
+	}
+	exposed := func(agg *MCPAggregator) []string {
+		var names []string
+		for _, tool := range agg.GetTools() {
+			names = append(names, tool.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	tests := []struct {
+		name     string
+		readOnly bool
+		client   func() MCPClient
+		want     []string
+	}{
+		{
+			name:     "Read-only mode",
+			readOnly: true,
+			client:   upstream,
+			want:     []string{"github_search", "github_search_issues", "github_unknown"},
+		},
+		{
+			name:   "Read-only mode off",
+			client: upstream,
+			want: []string{
+				"github_append", "github_create", "github_delete", "github_delete_all",
+				"github_search", "github_search_issues", "github_unknown", "github_wipe",
+			},
+		},
+		{
+			// Clients that don't report annotations keep all their tools
+			name:     "Client without annotations",
+			readOnly: true,
+			client: func() MCPClient {
+				return &MockClient{Tools: []mcp.Tool{{Name: "search"}, {Name: "delete"}}}
+			},
+			want: []string{"github_delete", "github_delete_all", "github_search", "github_search_issues"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+				return tt.client(), nil
+			}))
+			cfg := &config.Config{
+				Servers: []config.ServerConfig{{
+					Name:    "github",
+					Command: "test-command",
+					Tools: &config.ToolsConfig{Presets: map[string]config.ToolPreset{
+						"search_issues": {Tool: "search", Args: map[string]interface{}{"type": "issue"}},
+						"delete_all":    {Tool: "delete", Args: map[string]interface{}{"all": true}},
+					}},
+				}},
+				ReadOnlyMode: tt.readOnly,
+				LogLevel:     config.LogLevelError,
+			}
+			if err := agg.Initialize(context.Background(), cfg); err != nil {
+				t.Fatalf("Initialize() error = %v", err)
+			}
+			defer agg.Close()
+
+			if got := exposed(agg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetTools() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseToolsResult(t *testing.T) {
+	response := json.RawMessage(`{"tools":[
+		{"name":"search","inputSchema":{"type":"object"},"annotations":{"readOnlyHint":true,"title":"Search"}},
+		{"name":"delete","inputSchema":{"type":"object"},"annotations":{"destructiveHint":true}},
+		{"name":"plain","inputSchema":{"type":"object"}}
+	]}`)
+
+	result, annotations, err := parseToolsResult(response)
+	if err != nil {
+		t.Fatalf("parseToolsResult() error = %v", err)
+	}
+	if len(result.Tools) != 3 {
+		t.Errorf("parseToolsResult() returned %d tools, want 3", len(result.Tools))
+	}
+	want := map[string]ToolAnnotations{
+		"search": {ReadOnlyHint: hint(true)},
+		"delete": {DestructiveHint: hint(true)},
+	}
+	if !reflect.DeepEqual(annotations, want) {
+		t.Errorf("parseToolsResult() annotations = %+v, want %+v", annotations, want)
+	}
+}
@@ -0,0 +1,116 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// validateArguments checks the arguments of a call against the input schema of the tool.
+// Only required parameters and the JSON types of the parameters are checked, the server validates the rest.
+func validateArguments(schema mcp.ToolInputSchema, arguments map[string]interface{}) error {
+	var problems []error
+	for _, name := range schema.Required {
+		if _, ok := arguments[name]; !ok {
+			problems = append(problems, fmt.Errorf("missing required parameter %s", name))
+		}
+	}
+
+	names := make([]string, 0, len(arguments))
+	for name := range arguments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		property, ok := schema.Properties[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		types := schemaTypes(property["type"])
+		if len(types) == 0 {
+			continue
+		}
+		actual := jsonType(arguments[name])
+		if !matchesType(types, actual) {
+			problems = append(problems, fmt.Errorf("parameter %s must be of type %s, got %s", name, strings.Join(types, " or "), actual))
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// schemaTypes returns the types a JSON schema allows, given as a single type or a list
+func schemaTypes(value interface{}) []string {
+	switch t := value.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		types := make([]string, 0, len(t))
+		for _, item := range t {
+			if name, ok := item.(string); ok {
+				types = append(types, name)
+			}
+		}
+		return types
+	case []string:
+		return t
+	default:
+		return nil
+	}
+}
+
+// matchesType reports whether a value of the given JSON type satisfies one of the allowed types.
+// Integers are numbers too.
+func matchesType(allowed []string, actual string) bool {
+	for _, allowedType := range allowed {
+		if allowedType == actual || (allowedType == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType returns the JSON schema type of a decoded argument value
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	case float32:
+		return jsonType(float64(v))
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return "integer"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		// Values built in code, e.g. typed slices or structs, are classified by their JSON encoding
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "unknown"
+		}
+		var decoded interface{}
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			return "unknown"
+		}
+		return jsonType(decoded)
+	}
+}
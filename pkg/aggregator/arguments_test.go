@@ -0,0 +1,193 @@
+package aggregator
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/config"
+)
+
+func TestValidateArguments(t *testing.T) {
+	schema := mcp.ToolInputSchema{
+		Type: "object",
+		Properties: map[string]interface{}{
+			"query":  map[string]interface{}{"type": "string"},
+			"limit":  map[string]interface{}{"type": "integer"},
+			"score":  map[string]interface{}{"type": "number"},
+			"labels": map[string]interface{}{"type": "array"},
+			"owner":  map[string]interface{}{"type": []interface{}{"string", "null"}},
+			"any":    map[string]interface{}{"description": "Untyped"},
+		},
+		Required: []string{"query"},
+	}
+
+	tests := []struct {
+		name      string
+		arguments map[string]interface{}
+		wantErr   []string
+	}{
+		{
+			name:      "Valid arguments",
+			arguments: map[string]interface{}{"query": "bug", "limit": float64(10), "score": 0.5, "labels": []interface{}{"a"}, "owner": nil, "any": true},
+		},
+		{
+			name:      "Integers are numbers",
+			arguments: map[string]interface{}{"query": "bug", "score": 3},
+		},
+		{
+			name:      "Missing required parameter",
+			arguments: map[string]interface{}{"limit": float64(10)},
+			wantErr:   []string{"missing required parameter query"},
+		},
+		{
+			name:      "Wrong types",
+			arguments: map[string]interface{}{"query": 42, "limit": 2.5, "labels": "a,b"},
+			wantErr: []string{
+				"parameter labels must be of type array, got string",
+				"parameter limit must be of type integer, got number",
+				"parameter query must be of type string, got integer",
+			},
+		},
+		{
+			name:      "One of several types",
+			arguments: map[string]interface{}{"query": "bug", "owner": false},
+			wantErr:   []string{"parameter owner must be of type string or null, got boolean"},
+		},
+		{
+			name:      "Unknown parameters are left to the server",
+			arguments: map[string]interface{}{"query": "bug", "extra": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateArguments(schema, tt.arguments)
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("validateArguments() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("validateArguments() error = nil, want %v", tt.wantErr)
+			}
+			if got := strings.Split(err.Error(), "\n"); strings.Join(got, "|") != strings.Join(tt.wantErr, "|") {
+				t.Errorf("validateArguments() errors = %q, want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateArgsOnCall(t *testing.T) {
+	mockClient := &MockClient{Tools: []mcp.Tool{{
+		Name: "search",
+		InputSchema: mcp.ToolInputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"query": map[string]interface{}{"type": "string"},
+				"limit": map[string]interface{}{"type": "integer"},
+			},
+			Required: []string{"query"},
+		},
+	}}}
+	serverConfig := &config.ServerConfig{Name: "github", Command: "test-command", ValidateArgs: true}
+
+	agg := NewMCPAggregator()
+	agg.clients["github"] = mockClient
+	agg.configs["github"] = serverConfig
+	if err := agg.discoverTools(context.Background(), "github"); err != nil {
+		t.Fatalf("discoverTools() error = %v", err)
+	}
+
+	call := func(arguments map[string]interface{}) *mcp.CallToolResult {
+		t.Helper()
+		request := mcp.CallToolRequest{}
+		request.Params.Name = "github_search"
+		request.Params.Arguments = arguments
+		result, err := agg.CallTool(context.Background(), request)
+		if err != nil {
+			t.Fatalf("CallTool() error = %v", err)
+		}
+		return result
+	}
+
+	// Invalid calls are answered with a tool error and never reach the server
+	for _, arguments := range []map[string]interface{}{
+		{"limit": float64(5)},
+		{"query": "bug", "limit": "five"},
+	} {
+		result := call(arguments)
+		text, _ := mcp.AsTextContent(result.Content[0])
+		if !result.IsError || text == nil || !strings.Contains(text.Text, "Invalid arguments for tool github_search") {
+			t.Errorf("CallTool(%v) = %+v, want an invalid arguments error", arguments, result)
+		}
+	}
+	if len(mockClient.Calls) != 0 {
+		t.Errorf("Forwarded %d invalid calls, want none", len(mockClient.Calls))
+	}
+
+	if result := call(map[string]interface{}{"query": "bug", "limit": float64(5)}); result.IsError {
+		t.Errorf("CallTool() with valid arguments = %+v, want success", result)
+	}
+
+	// Without the flag the server validates the arguments itself
+	serverConfig.ValidateArgs = false
+	call(map[string]interface{}{"limit": "five"})
+	if len(mockClient.Calls) != 2 {
+		t.Errorf("Forwarded %d calls, want the valid one and the unvalidated one", len(mockClient.Calls))
+	}
+}
+
+func TestValidateArgsWithSchemaOverride(t *testing.T) {
+	mockClient := &MockClient{Tools: []mcp.Tool{{
+		Name: "search",
+		InputSchema: mcp.ToolInputSchema{
+			Type:       "object",
+			Properties: map[string]interface{}{"q": map[string]interface{}{"type": "string"}},
+			Required:   []string{"q"},
+		},
+	}}}
+	serverConfig := &config.ServerConfig{
+		Name:         "github",
+		Command:      "test-command",
+		ValidateArgs: true,
+		Tools: &config.ToolsConfig{Overrides: map[string]config.ToolOverride{
+			"search": {Schema: json.RawMessage(`{"type": "object", "properties": {"query": {"type": "string"}, "limit": {"type": "integer"}}, "required": ["query"]}`)},
+		}},
+	}
+
+	agg := NewMCPAggregator()
+	agg.clients["github"] = mockClient
+	agg.configs["github"] = serverConfig
+	if err := agg.discoverTools(context.Background(), "github"); err != nil {
+		t.Fatalf("discoverTools() error = %v", err)
+	}
+
+	// Calls are checked against the published override, not the upstream schema clients never see
+	tests := []struct {
+		name      string
+		arguments map[string]interface{}
+		wantError bool
+	}{
+		{name: "Valid for the override", arguments: map[string]interface{}{"query": "bug", "limit": float64(5)}},
+		{name: "Missing a parameter the override requires", arguments: map[string]interface{}{"q": "bug"}, wantError: true},
+		{name: "Wrong type for the override", arguments: map[string]interface{}{"query": "bug", "limit": "five"}, wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := mcp.CallToolRequest{}
+			request.Params.Name = "github_search"
+			request.Params.Arguments = tt.arguments
+			result, err := agg.CallTool(context.Background(), request)
+			if err != nil {
+				t.Fatalf("CallTool() error = %v", err)
+			}
+			if result.IsError != tt.wantError {
+				t.Errorf("CallTool(%v) IsError = %v, want %v", tt.arguments, result.IsError, tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/config"
+	"github.com/nazar256/combine-mcp/pkg/logger"
+)
+
+// auditEntry is the record of a single tool call in the audit file
+type auditEntry struct {
+	Time       string                 `json:"time"`
+	Tool       string                 `json:"tool"`
+	Server     string                 `json:"server,omitempty"` // Empty if no server has the tool
+	Arguments  map[string]interface{} `json:"arguments,omitempty"`
+	Success    bool                   `json:"success"`
+	Error      string                 `json:"error,omitempty"`
+	DurationMs int64                  `json:"durationMs"`
+}
+
+// auditLog appends a JSON line for every tool call to a file, regardless of the log level
+type auditLog struct {
+	mu   sync.Mutex
+	file *os.File // Nil once closed
+}
+
+// openAuditLog opens the audit file for appending, creating it if needed
+func openAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit file %s: %w", path, err)
+	}
+	return &auditLog{file: file}, nil
+}
+
+// record writes the entry of a finished tool call
+func (l *auditLog) record(entry auditEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		logger.Error("Failed to marshal audit entry for tool %s: %v", entry.Tool, err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		logger.Error("Audit file closed, not recording call to tool %s", entry.Tool)
+		return
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		logger.Error("Failed to write audit entry for tool %s: %v", entry.Tool, err)
+	}
+}
+
+// close closes the audit file, later calls aren't recorded
+func (l *auditLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// newAuditEntry describes a finished tool call, with the values of arguments named like secrets redacted
+func newAuditEntry(start time.Time, tool, serverName string, arguments map[string]interface{}, result *mcp.CallToolResult, err error) auditEntry {
+	entry := auditEntry{
+		Time:       start.UTC().Format(time.RFC3339Nano),
+		Tool:       tool,
+		Server:     serverName,
+		Arguments:  redactArguments(arguments),
+		Success:    err == nil && (result == nil || !result.IsError),
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	switch {
+	case err != nil:
+		entry.Error = err.Error()
+	case result != nil && result.IsError:
+		entry.Error = resultText(result)
+	}
+	return entry
+}
+
+// redactArguments copies the arguments, replacing the values of arguments named like secrets by config.Redacted,
+// also in nested objects
+func redactArguments(arguments map[string]interface{}) map[string]interface{} {
+	if arguments == nil {
+		return nil
+	}
+	redacted := make(map[string]interface{}, len(arguments))
+	for name, value := range arguments {
+		if config.IsSecretName(name) {
+			redacted[name] = config.Redacted
+			continue
+		}
+		redacted[name] = redactValue(value)
+	}
+	return redacted
+}
+
+// redactValue redacts the secrets of objects within an argument value
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return redactArguments(v)
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, item := range v {
+			redacted[i] = redactValue(item)
+		}
+		return redacted
+	}
+	return value
+}
+
+// resultText joins the text contents of a tool result
+func resultText(result *mcp.CallToolResult) string {
+	var texts []string
+	for _, content := range result.Content {
+		if text, ok := mcp.AsTextContent(content); ok {
+			texts = append(texts, text.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
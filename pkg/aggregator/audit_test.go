@@ -0,0 +1,84 @@
+package aggregator
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/config"
+)
+
+func TestAuditFile(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		return &MockClient{Tools: []mcp.Tool{{Name: "search"}}}, nil
+	}))
+	cfg := &config.Config{
+		Servers:   []config.ServerConfig{{Name: "github", Command: "test-command"}},
+		AuditFile: auditPath,
+		// Calls are audited whatever the log level
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "github_search"
+	request.Params.Arguments = map[string]interface{}{
+		"query":   "is:open",
+		"api_key": "sk-123",
+		"auth":    map[string]interface{}{"password": "hunter2", "user": "octocat"},
+	}
+	if _, err := agg.CallTool(context.Background(), request); err != nil {
+		t.Fatalf("CallTool() error = %v", err)
+	}
+	unknown := mcp.CallToolRequest{}
+	unknown.Params.Name = "github_missing"
+	if _, err := agg.CallTool(context.Background(), unknown); err == nil {
+		t.Fatalf("CallTool() of unknown tool error = nil, want an error")
+	}
+	agg.Close()
+
+	data, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("Failed to read audit file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Audit file has %d lines, want 2: %s", len(lines), data)
+	}
+
+	var entries [2]auditEntry
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &entries[i]); err != nil {
+			t.Fatalf("Audit line %d isn't valid JSON: %v (%s)", i, err, line)
+		}
+		if entries[i].Time == "" {
+			t.Errorf("Audit line %d has no time", i)
+		}
+	}
+
+	wantArgs := map[string]interface{}{
+		"query":   "is:open",
+		"api_key": config.Redacted,
+		"auth":    map[string]interface{}{"password": config.Redacted, "user": "octocat"},
+	}
+	if got := entries[0]; got.Tool != "github_search" || got.Server != "github" || !got.Success || got.Error != "" {
+		t.Errorf("Audit entry of successful call = %+v, want github_search of github succeeded", got)
+	}
+	if !reflect.DeepEqual(entries[0].Arguments, wantArgs) {
+		t.Errorf("Audited arguments = %v, want %v", entries[0].Arguments, wantArgs)
+	}
+	if got := entries[1]; got.Tool != "github_missing" || got.Success || !strings.Contains(got.Error, "not found") {
+		t.Errorf("Audit entry of failed call = %+v, want github_missing failed as not found", got)
+	}
+	if strings.Contains(string(data), "sk-123") || strings.Contains(string(data), "hunter2") {
+		t.Errorf("Audit file contains secret argument values: %s", data)
+	}
+}
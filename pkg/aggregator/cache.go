@@ -0,0 +1,138 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/config"
+)
+
+// maxCachedResults bounds the number of cached results, so calls with ever new arguments can't exhaust the memory
+const maxCachedResults = 1000
+
+// resultCache holds the results of cacheable tool calls until their TTL expires
+type resultCache struct {
+	mu         sync.Mutex
+	entries    map[string]cacheEntry
+	maxEntries int
+	now        func() time.Time
+}
+
+type cacheEntry struct {
+	serverName string
+	result     *mcp.CallToolResult
+	expires    time.Time
+}
+
+func newResultCache() *resultCache {
+	return &resultCache{
+		entries:    make(map[string]cacheEntry),
+		maxEntries: maxCachedResults,
+		now:        time.Now,
+	}
+}
+
+// get returns a copy of the cached result for a key if it hasn't expired yet
+func (c *resultCache) get(key string) (*mcp.CallToolResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return cloneResult(entry.result), true
+}
+
+// put caches a copy of a result of a server for the given TTL and evicts every entry that has expired
+// in the meantime. If the cache is still full, the entry closest to expiring makes room.
+func (c *resultCache) put(serverName, key string, result *mcp.CallToolResult, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for existingKey, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, existingKey)
+		}
+	}
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= c.maxEntries {
+		var oldestKey string
+		var oldest time.Time
+		for existingKey, entry := range c.entries {
+			if oldestKey == "" || entry.expires.Before(oldest) {
+				oldestKey, oldest = existingKey, entry.expires
+			}
+		}
+		delete(c.entries, oldestKey)
+	}
+	c.entries[key] = cacheEntry{serverName: serverName, result: cloneResult(result), expires: now.Add(ttl)}
+}
+
+// clearServer drops the cached results of a server, whose tools may have changed
+func (c *resultCache) clearServer(serverName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, entry := range c.entries {
+		if entry.serverName == serverName {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// len returns the number of cached results, including expired ones not evicted yet
+func (c *resultCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// cloneResult copies a result deep enough that callers modifying its content or metadata don't modify the cache
+func cloneResult(result *mcp.CallToolResult) *mcp.CallToolResult {
+	clone := *result
+	clone.Content = slices.Clone(result.Content)
+	clone.Meta = maps.Clone(result.Meta)
+	return &clone
+}
+
+// resultCacheKey identifies a call by its exposed tool name and arguments.
+// Maps are encoded with sorted keys, so the same arguments always produce the same key.
+func resultCacheKey(prefixedName string, arguments map[string]interface{}) string {
+	encoded, err := json.Marshal(arguments)
+	if err != nil {
+		return ""
+	}
+	return prefixedName + "\x00" + string(encoded)
+}
+
+// cacheTTL returns how long the results of a tool are cached, or zero if the tool isn't cacheable
+func cacheTTL(serverConfig *config.ServerConfig, toolName string) time.Duration {
+	if serverConfig == nil || serverConfig.Tools == nil {
+		return 0
+	}
+	cacheable := false
+	for _, name := range serverConfig.Tools.Cacheable {
+		if normalizeToolName(name) == normalizeToolName(toolName) {
+			cacheable = true
+			break
+		}
+	}
+	if !cacheable {
+		return 0
+	}
+
+	ttl := serverConfig.CacheTTLSeconds
+	if override, ok := findToolOverride(serverConfig, toolName); ok && override.CacheTTLSeconds > 0 {
+		ttl = override.CacheTTLSeconds
+	}
+	return time.Duration(ttl) * time.Second
+}
@@ -0,0 +1,132 @@
+package aggregator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/config"
+)
+
+func TestResultCache(t *testing.T) {
+	upstream := &MockClient{Tools: []mcp.Tool{{Name: "list-repos"}, {Name: "create-issue"}}}
+
+	agg := NewMCPAggregator()
+	now := time.Now()
+	agg.resultCache.now = func() time.Time { return now }
+	agg.clientFactory = func(serverCfg config.ServerConfig) (MCPClient, error) {
+		return upstream, nil
+	}
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{{
+			Name:            "github",
+			Command:         "test-command",
+			CacheTTLSeconds: 60,
+			Tools:           &config.ToolsConfig{Cacheable: []string{"list-repos"}},
+		}},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	call := func(name string, arguments map[string]interface{}) {
+		t.Helper()
+		request := mcp.CallToolRequest{}
+		request.Params.Name = name
+		request.Params.Arguments = arguments
+		if _, err := agg.CallTool(context.Background(), request); err != nil {
+			t.Fatalf("CallTool(%s) error = %v", name, err)
+		}
+	}
+
+	steps := []struct {
+		name      string
+		tool      string
+		arguments map[string]interface{}
+		advance   time.Duration
+		wantCalls int
+	}{
+		{name: "First call reaches the server", tool: "github_list_repos", arguments: map[string]interface{}{"owner": "nazar256"}, wantCalls: 1},
+		{name: "Identical call hits the cache", tool: "github_list_repos", arguments: map[string]interface{}{"owner": "nazar256"}, wantCalls: 1},
+		{name: "Other arguments miss the cache", tool: "github_list_repos", arguments: map[string]interface{}{"owner": "other"}, wantCalls: 2},
+		{name: "Tools not marked cacheable aren't cached", tool: "github_create_issue", arguments: map[string]interface{}{"title": "bug"}, wantCalls: 3},
+		{name: "Tools not marked cacheable always reach the server", tool: "github_create_issue", arguments: map[string]interface{}{"title": "bug"}, wantCalls: 4},
+		{name: "Expired results are fetched again", tool: "github_list_repos", arguments: map[string]interface{}{"owner": "nazar256"}, advance: time.Minute, wantCalls: 5},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		call(step.tool, step.arguments)
+		if got := len(upstream.Calls); got != step.wantCalls {
+			t.Errorf("%s: upstream calls = %d, want %d", step.name, got, step.wantCalls)
+		}
+	}
+
+	// Storing the refreshed result evicted the other expired entry
+	if got := agg.resultCache.len(); got != 1 {
+		t.Errorf("Cached results = %d, want 1", got)
+	}
+
+	// The tools may have changed when they are rediscovered, so the results are fetched again
+	for _, rediscover := range []struct {
+		name string
+		run  func()
+	}{
+		{name: "Refresh", run: func() { agg.RefreshTools(context.Background()) }},
+		{name: "Rediscovery", run: func() { agg.rediscoverTools("github") }},
+	} {
+		rediscover.run()
+		calls := len(upstream.Calls)
+		call("github_list_repos", map[string]interface{}{"owner": "nazar256"})
+		if got := len(upstream.Calls); got != calls+1 {
+			t.Errorf("%s: upstream calls = %d, want %d", rediscover.name, got, calls+1)
+		}
+	}
+}
+
+func TestResultCacheCopies(t *testing.T) {
+	cache := newResultCache()
+	cache.put("github", "key", mcp.NewToolResultText("cached"), time.Minute)
+
+	// Callers modifying a result must not change what later calls get
+	result, _ := cache.get("key")
+	result.Content[0] = mcp.NewTextContent("modified")
+	result.Content = append(result.Content, mcp.NewTextContent("appended"))
+
+	result, ok := cache.get("key")
+	if !ok {
+		t.Fatal("get() ok = false, want the cached result")
+	}
+	if len(result.Content) != 1 || result.Content[0].(mcp.TextContent).Text != "cached" {
+		t.Errorf("get() content = %v, want the original result", result.Content)
+	}
+}
+
+func TestResultCacheLimit(t *testing.T) {
+	cache := newResultCache()
+	cache.maxEntries = 2
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	cache.put("github", "long", mcp.NewToolResultText("long"), time.Hour)
+	cache.put("github", "short", mcp.NewToolResultText("short"), time.Minute)
+	cache.put("github", "new", mcp.NewToolResultText("new"), time.Hour)
+
+	// The entry closest to expiring made room for the new one
+	if got := cache.len(); got != 2 {
+		t.Errorf("Cached results = %d, want 2", got)
+	}
+	for key, want := range map[string]bool{"long": true, "short": false, "new": true} {
+		if _, ok := cache.get(key); ok != want {
+			t.Errorf("get(%s) ok = %v, want %v", key, ok, want)
+		}
+	}
+
+	// Only the results of the given server are dropped
+	cache.put("docs", "short", mcp.NewToolResultText("short"), time.Minute)
+	cache.clearServer("github")
+	if _, ok := cache.get("short"); !ok || cache.len() != 1 {
+		t.Errorf("clearServer() left %d results, want only the one of the other server", cache.len())
+	}
+}
@@ -0,0 +1,44 @@
+package aggregator
+
+import (
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Capabilities returns the union of the capabilities the connected servers reported when initialized.
+// Only the presence of a feature is combined: flags for what the aggregator doesn't relay, like resource
+// subscriptions or prompt and resource list_changed notifications, are left out. Tools are always offered,
+// and their list_changed notifications are relayed.
+func (a *MCPAggregator) Capabilities() mcp.ServerCapabilities {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	capabilities := mcp.ServerCapabilities{
+		Tools: &struct {
+			ListChanged bool `json:"listChanged,omitempty"`
+		}{ListChanged: true},
+	}
+	for name := range a.clients {
+		serverCaps := a.caps[name]
+		if serverCaps.Logging != nil {
+			capabilities.Logging = &struct{}{}
+		}
+		if serverCaps.Prompts != nil && capabilities.Prompts == nil {
+			capabilities.Prompts = &struct {
+				ListChanged bool `json:"listChanged,omitempty"`
+			}{}
+		}
+		if serverCaps.Resources != nil && capabilities.Resources == nil {
+			capabilities.Resources = &struct {
+				Subscribe   bool `json:"subscribe,omitempty"`
+				ListChanged bool `json:"listChanged,omitempty"`
+			}{}
+		}
+		for key, value := range serverCaps.Experimental {
+			if capabilities.Experimental == nil {
+				capabilities.Experimental = make(map[string]interface{})
+			}
+			capabilities.Experimental[key] = value
+		}
+	}
+	return capabilities
+}
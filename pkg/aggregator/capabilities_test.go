@@ -0,0 +1,98 @@
+package aggregator
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/config"
+)
+
+// capabilitiesClient is a mock client that reports the given capabilities when initialized
+type capabilitiesClient struct {
+	MockClient
+	capabilities mcp.ServerCapabilities
+}
+
+func (c *capabilitiesClient) Initialize(ctx context.Context, request mcp.InitializeRequest) (*mcp.InitializeResult, error) {
+	result, err := c.MockClient.Initialize(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	result.Capabilities = c.capabilities
+	return result, nil
+}
+
+func TestCapabilities(t *testing.T) {
+	prompts := mcp.ServerCapabilities{
+		Logging: &struct{}{},
+		Prompts: &struct {
+			ListChanged bool `json:"listChanged,omitempty"`
+		}{ListChanged: true},
+	}
+	resources := mcp.ServerCapabilities{
+		Resources: &struct {
+			Subscribe   bool `json:"subscribe,omitempty"`
+			ListChanged bool `json:"listChanged,omitempty"`
+		}{Subscribe: true, ListChanged: true},
+		Experimental: map[string]interface{}{"streaming": map[string]interface{}{}},
+	}
+
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		switch serverCfg.Name {
+		case "prompts":
+			return &capabilitiesClient{MockClient: MockClient{Tools: []mcp.Tool{{Name: "tool"}}}, capabilities: prompts}, nil
+		case "resources":
+			return &capabilitiesClient{MockClient: MockClient{Tools: []mcp.Tool{{Name: "tool"}}}, capabilities: resources}, nil
+		default:
+			return &capabilitiesClient{MockClient: MockClient{Tools: []mcp.Tool{{Name: "tool"}}}}, nil
+		}
+	}))
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{Name: "prompts", Command: "test-command"},
+			{Name: "resources", Command: "test-command"},
+			{Name: "plain", Command: "test-command"},
+		},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	// Features any server offers are combined, flags the aggregator doesn't relay are dropped
+	want := mcp.ServerCapabilities{
+		Experimental: map[string]interface{}{"streaming": map[string]interface{}{}},
+		Logging:      &struct{}{},
+		Prompts: &struct {
+			ListChanged bool `json:"listChanged,omitempty"`
+		}{},
+		Resources: &struct {
+			Subscribe   bool `json:"subscribe,omitempty"`
+			ListChanged bool `json:"listChanged,omitempty"`
+		}{},
+		Tools: &struct {
+			ListChanged bool `json:"listChanged,omitempty"`
+		}{ListChanged: true},
+	}
+	if got := agg.Capabilities(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Capabilities() = %+v, want %+v", got, want)
+	}
+
+	// Without servers offering them, only tools are advertised
+	plain := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		return &capabilitiesClient{MockClient: MockClient{Tools: []mcp.Tool{{Name: "tool"}}}}, nil
+	}))
+	cfg.Servers = []config.ServerConfig{{Name: "plain", Command: "test-command"}}
+	if err := plain.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	got := plain.Capabilities()
+	if got.Logging != nil || got.Prompts != nil || got.Resources != nil || got.Experimental != nil {
+		t.Errorf("Capabilities() = %+v, want only tools", got)
+	}
+	if got.Tools == nil {
+		t.Errorf("Capabilities() doesn't offer tools")
+	}
+}
@@ -0,0 +1,32 @@
+package aggregator
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// correlationKey is the context key of the correlation ID of a client request
+type correlationKey struct{}
+
+// NewCorrelationID returns a short random ID to correlate the logs of a client request
+// with the logs of the requests forwarded to servers on its behalf
+func NewCorrelationID() string {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		// Correlation only helps reading the logs, a call doesn't fail for the lack of it
+		return "unknown"
+	}
+	return hex.EncodeToString(id)
+}
+
+// WithCorrelationID returns a copy of ctx carrying the correlation ID of the client request it serves
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID returns the correlation ID ctx carries, or "" if it carries none
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
@@ -0,0 +1,145 @@
+package aggregator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/config"
+)
+
+// slowCallClient is a mock client whose tool calls take a while and fail if the client is closed meanwhile
+type slowCallClient struct {
+	MockClient
+	delay   time.Duration
+	started chan struct{}
+	closed  chan struct{}
+}
+
+func (m *slowCallClient) CallTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	close(m.started)
+	select {
+	case <-time.After(m.delay):
+		return mcp.NewToolResultText("done"), nil
+	case <-m.closed:
+		return nil, errors.New("client closed")
+	}
+}
+
+func (m *slowCallClient) Close() error {
+	close(m.closed)
+	return nil
+}
+
+func TestCloseDrainsCalls(t *testing.T) {
+	tests := []struct {
+		name         string
+		delay        time.Duration
+		drainTimeout time.Duration
+		wantErr      bool
+	}{
+		{
+			name:         "Call in flight completes",
+			delay:        50 * time.Millisecond,
+			drainTimeout: 5 * time.Second,
+		},
+		{
+			name:         "Call outlasting the grace period is aborted",
+			delay:        time.Minute,
+			drainTimeout: 20 * time.Millisecond,
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := &slowCallClient{
+				MockClient: MockClient{Tools: []mcp.Tool{{Name: "tool1"}}},
+				delay:      tt.delay,
+				started:    make(chan struct{}),
+				closed:     make(chan struct{}),
+			}
+			agg := NewMCPAggregator(
+				WithDrainTimeout(tt.drainTimeout),
+				WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+					return upstream, nil
+				}),
+			)
+			cfg := &config.Config{
+				Servers:  []config.ServerConfig{{Name: "slow", Command: "test-command"}},
+				LogLevel: config.LogLevelError,
+			}
+			if err := agg.Initialize(context.Background(), cfg); err != nil {
+				t.Fatalf("Initialize() error = %v", err)
+			}
+
+			request := mcp.CallToolRequest{}
+			request.Params.Name = "slow_tool1"
+			callErr := make(chan error, 1)
+			go func() {
+				_, err := agg.CallTool(context.Background(), request)
+				callErr <- err
+			}()
+			<-upstream.started
+
+			start := time.Now()
+			agg.Close()
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("Close() took %v", elapsed)
+			}
+
+			if err := <-callErr; (err != nil) != tt.wantErr {
+				t.Errorf("CallTool() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// No new calls are accepted once closing
+			if _, err := agg.CallTool(context.Background(), request); !errors.Is(err, errAggregatorClosed) {
+				t.Errorf("CallTool() after Close() error = %v, want errAggregatorClosed", err)
+			}
+		})
+	}
+}
+
+func TestGetToolsDuringSlowCall(t *testing.T) {
+	upstream := &slowCallClient{
+		MockClient: MockClient{Tools: []mcp.Tool{{Name: "tool1"}}},
+		delay:      time.Minute,
+		started:    make(chan struct{}),
+		closed:     make(chan struct{}),
+	}
+	agg := NewMCPAggregator(
+		WithDrainTimeout(20*time.Millisecond),
+		WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+			return upstream, nil
+		}),
+	)
+	cfg := &config.Config{
+		Servers:  []config.ServerConfig{{Name: "slow", Command: "test-command"}},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	defer agg.Close()
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "slow_tool1"
+	go agg.CallTool(context.Background(), request)
+	<-upstream.started
+
+	// Listing tools reads the tools cached at discovery, so a call the server is slow to answer doesn't hold it up
+	tools := make(chan []mcp.Tool, 1)
+	go func() {
+		tools <- agg.GetTools()
+	}()
+	select {
+	case got := <-tools:
+		if len(got) != 1 || got[0].Name != "slow_tool1" {
+			t.Errorf("GetTools() = %+v, want slow_tool1", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("GetTools() was blocked by a tool call in flight")
+	}
+}
@@ -0,0 +1,85 @@
+package aggregator
+
+import (
+	"context"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/config"
+	"github.com/nazar256/combine-mcp/pkg/logger"
+)
+
+// unhealthyAfterFailures is the number of consecutive failed health checks after which a server is unhealthy
+const unhealthyAfterFailures = 3
+
+// monitorHealth starts pinging a server if health checks are configured for it
+func (a *MCPAggregator) monitorHealth(serverCfg config.ServerConfig) {
+	if serverCfg.HealthCheckSeconds <= 0 {
+		return
+	}
+	interval := time.Duration(serverCfg.HealthCheckSeconds) * time.Second
+	if a.healthCheckInterval > 0 {
+		interval = a.healthCheckInterval
+	}
+
+	go a.checkHealth(serverCfg.Name, interval)
+}
+
+// checkHealth pings a server on every interval until the aggregator is closed.
+// The tools of a server that stops answering are unregistered, and registered again once it recovers.
+func (a *MCPAggregator) checkHealth(serverName string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	failures := 0
+	healthy := true
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+		}
+
+		// A server whose process exited is handled by its supervisor, if any
+		a.mu.RLock()
+		mcpClient, exists := a.clients[serverName]
+		a.mu.RUnlock()
+		if !exists {
+			continue
+		}
+
+		err := a.ping(mcpClient, interval)
+		if err != nil {
+			failures++
+			logger.Error("Health check of server %s failed (%d/%d): %v", serverName, failures, unhealthyAfterFailures, err)
+			if healthy && failures >= unhealthyAfterFailures {
+				logger.Error("Server %s is unhealthy, unregistering its tools", serverName)
+				healthy = false
+				a.replaceServerTools(serverName, nil)
+				a.notifyToolsChanged()
+			}
+			continue
+		}
+
+		failures = 0
+		if !healthy {
+			logger.Info("Server %s is healthy again, registering its tools", serverName)
+			if err := a.discoverTools(context.Background(), serverName); err != nil {
+				logger.Error("Failed to rediscover tools for server %s: %v", serverName, err)
+				continue
+			}
+			healthy = true
+			a.notifyToolsChanged()
+		}
+	}
+}
+
+// ping checks that a server still answers, bounded by the health check interval
+func (a *MCPAggregator) ping(mcpClient MCPClient, interval time.Duration) error {
+	timeout := min(interval, a.discoveryTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	_, err := mcpClient.ListTools(ctx, mcp.ListToolsRequest{})
+	return err
+}
@@ -0,0 +1,63 @@
+package aggregator
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/config"
+)
+
+// stallingClient is a mock client whose server can stop answering while its process keeps running
+type stallingClient struct {
+	MockClient
+	stalled atomic.Bool
+}
+
+func (c *stallingClient) ListTools(ctx context.Context, request mcp.ListToolsRequest) (*mcp.ListToolsResult, error) {
+	if c.stalled.Load() {
+		return nil, errors.New("server not responding")
+	}
+	return c.MockClient.ListTools(ctx, request)
+}
+
+func TestHealthCheck(t *testing.T) {
+	upstream := &stallingClient{MockClient: MockClient{Tools: []mcp.Tool{{Name: "tool1"}, {Name: "tool2"}}}}
+
+	agg := NewMCPAggregator()
+	agg.healthCheckInterval = 5 * time.Millisecond
+	agg.clientFactory = func(serverCfg config.ServerConfig) (MCPClient, error) {
+		return upstream, nil
+	}
+	var changes atomic.Int32
+	agg.OnToolsChanged(func() {
+		changes.Add(1)
+	})
+
+	cfg := &config.Config{
+		Servers:  []config.ServerConfig{{Name: "flaky", Command: "test-command", HealthCheckSeconds: 1}},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	defer agg.Close()
+
+	// The server stops answering mid-run
+	upstream.stalled.Store(true)
+	waitFor(t, "tools of the unhealthy server to be unregistered", func() bool {
+		return agg.ToolCount() == 0 && changes.Load() == 1
+	})
+	if agg.ServerCount() != 1 {
+		t.Errorf("ServerCount() = %d, want the unhealthy server to stay connected", agg.ServerCount())
+	}
+
+	// Once it answers again its tools come back
+	upstream.stalled.Store(false)
+	waitFor(t, "tools of the recovered server to be registered", func() bool {
+		return agg.ToolCount() == 2 && changes.Load() == 2
+	})
+}
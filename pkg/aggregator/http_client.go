@@ -0,0 +1,351 @@
+package aggregator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/logger"
+)
+
+// sessionIDHeader carries the session assigned by a streamable HTTP server
+const sessionIDHeader = "Mcp-Session-Id"
+
+// httpClient is an MCP client for remote servers using the streamable HTTP transport.
+// Every message is POSTed to the server URL, which answers with plain JSON or an event stream.
+type httpClient struct {
+	url         string
+	httpClient  *http.Client
+	requestID   atomic.Int64
+	initOptions map[string]interface{} // Sent as initializationOptions in the initialize request
+
+	mu            sync.Mutex
+	sessionID     string
+	notifications []func(notification mcp.JSONRPCNotification)
+}
+
+// newHTTPClient returns a client for the streamable HTTP endpoint at url, initializing the server with the given options
+func newHTTPClient(url string, initOptions map[string]interface{}) *httpClient {
+	return &httpClient{
+		url:         url,
+		httpClient:  &http.Client{},
+		initOptions: initOptions,
+	}
+}
+
+// post sends a single JSON-RPC message to the server
+func (c *httpClient) post(ctx context.Context, message interface{}) (*http.Response, error) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	c.mu.Lock()
+	if c.sessionID != "" {
+		req.Header.Set(sessionIDHeader, c.sessionID)
+	}
+	c.mu.Unlock()
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if sessionID := resp.Header.Get(sessionIDHeader); sessionID != "" {
+		c.mu.Lock()
+		c.sessionID = sessionID
+		c.mu.Unlock()
+	}
+	return resp, nil
+}
+
+// notify sends a notification, which the server acknowledges without a body
+func (c *httpClient) notify(ctx context.Context, method string) error {
+	notification := mcp.JSONRPCNotification{
+		JSONRPC:      mcp.JSONRPC_VERSION,
+		Notification: mcp.Notification{Method: method},
+	}
+	resp, err := c.post(ctx, notification)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// sendRequest sends a request to the server and waits for its result
+func (c *httpClient) sendRequest(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	id := c.requestID.Add(1)
+	request := mcp.JSONRPCRequest{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		ID:      id,
+		Params:  params,
+		Request: mcp.Request{Method: method},
+	}
+	if correlationID := CorrelationID(ctx); correlationID != "" {
+		logger.Debug("Sending %s to %s: id=%d, correlation=%s", method, c.url, id, correlationID)
+	}
+
+	resp, err := c.post(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return c.readStream(ctx, resp.Body, id)
+	}
+
+	var message rpcMessage
+	if err := json.NewDecoder(resp.Body).Decode(&message); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return responseResult(message)
+}
+
+// readStream reads server-sent events until the response to the request with the given id arrives
+func (c *httpClient) readStream(ctx context.Context, body io.Reader, id int64) (json.RawMessage, error) {
+	reader := bufio.NewReader(body)
+	var data strings.Builder
+
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case strings.HasPrefix(line, "data:"):
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		case line == "" && data.Len() > 0:
+			// A blank line ends the event
+			var message rpcMessage
+			if jsonErr := json.Unmarshal([]byte(data.String()), &message); jsonErr != nil {
+				logger.Debug("Ignoring non-JSON event from server: %s", data.String())
+			} else if message.Method != "" {
+				c.handleServerMessage(ctx, message, []byte(data.String()))
+			} else {
+				var responseID int64
+				if json.Unmarshal(message.ID, &responseID) == nil && responseID == id {
+					return responseResult(message)
+				}
+			}
+			data.Reset()
+		}
+
+		if err != nil {
+			if err == io.EOF {
+				return nil, errors.New("event stream ended without a response")
+			}
+			return nil, fmt.Errorf("failed to read event stream: %w", err)
+		}
+	}
+}
+
+// handleServerMessage handles requests and notifications the server interleaves with its response
+func (c *httpClient) handleServerMessage(ctx context.Context, message rpcMessage, data []byte) {
+	if len(message.ID) == 0 {
+		logger.Debug("Received notification from server: %s", message.Method)
+		c.handleNotification(data)
+		return
+	}
+
+	response := map[string]interface{}{
+		"jsonrpc": mcp.JSONRPC_VERSION,
+		"id":      message.ID,
+	}
+	if message.Method == string(mcp.MethodPing) {
+		response["result"] = struct{}{}
+	} else {
+		response["error"] = RPCError{Code: mcp.METHOD_NOT_FOUND, Message: fmt.Sprintf("method %s not supported", message.Method)}
+	}
+
+	resp, err := c.post(ctx, response)
+	if err != nil {
+		logger.Error("Failed to answer %s request from server: %v", message.Method, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// OnNotification registers a handler that is called for every notification from the server
+func (c *httpClient) OnNotification(handler func(notification mcp.JSONRPCNotification)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.notifications = append(c.notifications, handler)
+}
+
+// handleNotification passes a notification from the server to the registered handlers
+func (c *httpClient) handleNotification(data []byte) {
+	var notification mcp.JSONRPCNotification
+	if err := json.Unmarshal(data, &notification); err != nil {
+		logger.Debug("Ignoring malformed notification from server: %v", err)
+		return
+	}
+
+	c.mu.Lock()
+	handlers := append([]func(mcp.JSONRPCNotification){}, c.notifications...)
+	c.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(notification)
+	}
+}
+
+// responseResult extracts the result of a JSON-RPC response
+func responseResult(message rpcMessage) (json.RawMessage, error) {
+	if message.Error != nil {
+		return nil, message.Error
+	}
+	return message.Result, nil
+}
+
+// Initialize performs the MCP initialization handshake
+func (c *httpClient) Initialize(ctx context.Context, request mcp.InitializeRequest) (*mcp.InitializeResult, error) {
+	// Capabilities must always be present, even if empty
+	params := struct {
+		ProtocolVersion       string                 `json:"protocolVersion"`
+		ClientInfo            mcp.Implementation     `json:"clientInfo"`
+		Capabilities          mcp.ClientCapabilities `json:"capabilities"`
+		InitializationOptions map[string]interface{} `json:"initializationOptions,omitempty"`
+	}{
+		ProtocolVersion:       request.Params.ProtocolVersion,
+		ClientInfo:            request.Params.ClientInfo,
+		Capabilities:          request.Params.Capabilities,
+		InitializationOptions: c.initOptions,
+	}
+
+	response, err := c.sendRequest(ctx, string(mcp.MethodInitialize), params)
+	if err != nil {
+		return nil, err
+	}
+
+	var result mcp.InitializeResult
+	if err := json.Unmarshal(response, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if err := c.notify(ctx, "notifications/initialized"); err != nil {
+		return nil, fmt.Errorf("failed to send initialized notification: %w", err)
+	}
+
+	return &result, nil
+}
+
+// ListTools requests the list of tools from the server
+func (c *httpClient) ListTools(ctx context.Context, request mcp.ListToolsRequest) (*mcp.ListToolsResult, error) {
+	result, _, err := c.ListToolsWithAnnotations(ctx, request)
+	return result, err
+}
+
+// ListToolsWithAnnotations requests the list of tools from the server, keeping the annotations of the tools
+func (c *httpClient) ListToolsWithAnnotations(ctx context.Context, request mcp.ListToolsRequest) (*mcp.ListToolsResult, map[string]ToolAnnotations, error) {
+	response, err := c.sendRequest(ctx, string(mcp.MethodToolsList), request.Params)
+	if err != nil {
+		return nil, nil, err
+	}
+	return parseToolsResult(response)
+}
+
+// CallTool invokes a tool on the server
+func (c *httpClient) CallTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	response, err := c.sendRequest(ctx, string(mcp.MethodToolsCall), request.Params)
+	if err != nil {
+		return nil, err
+	}
+	return mcp.ParseCallToolResult(&response)
+}
+
+// CreateMessage sends a sampling request to the server, for servers designated to answer them
+func (c *httpClient) CreateMessage(ctx context.Context, params json.RawMessage) (json.RawMessage, error) {
+	return c.sendRequest(ctx, MethodCreateMessage, params)
+}
+
+// ListResources requests the list of resources from the server
+func (c *httpClient) ListResources(ctx context.Context, request mcp.ListResourcesRequest) (*mcp.ListResourcesResult, error) {
+	response, err := c.sendRequest(ctx, string(mcp.MethodResourcesList), request.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	var result mcp.ListResourcesResult
+	if err := json.Unmarshal(response, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return &result, nil
+}
+
+// ReadResource reads a resource from the server
+func (c *httpClient) ReadResource(ctx context.Context, request mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+	response, err := c.sendRequest(ctx, string(mcp.MethodResourcesRead), request.Params)
+	if err != nil {
+		return nil, err
+	}
+	return mcp.ParseReadResourceResult(&response)
+}
+
+// ListPrompts requests the list of prompts from the server
+func (c *httpClient) ListPrompts(ctx context.Context, request mcp.ListPromptsRequest) (*mcp.ListPromptsResult, error) {
+	response, err := c.sendRequest(ctx, string(mcp.MethodPromptsList), request.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	var result mcp.ListPromptsResult
+	if err := json.Unmarshal(response, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return &result, nil
+}
+
+// GetPrompt gets a prompt from the server
+func (c *httpClient) GetPrompt(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+	response, err := c.sendRequest(ctx, string(mcp.MethodPromptsGet), request.Params)
+	if err != nil {
+		return nil, err
+	}
+	return mcp.ParseGetPromptResult(&response)
+}
+
+// Close ends the session on the server, if one was assigned
+func (c *httpClient) Close() error {
+	c.mu.Lock()
+	sessionID := c.sessionID
+	c.mu.Unlock()
+	if sessionID == "" {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, c.url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set(sessionIDHeader, sessionID)
+
+	// Servers may not support ending sessions explicitly, so the outcome is only logged
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		logger.Debug("Failed to end session %s: %v", sessionID, err)
+		return nil
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,134 @@
+package aggregator
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/logger"
+)
+
+// MethodToolsListChanged is the notification servers send when their list of tools changes
+const MethodToolsListChanged = "notifications/tools/list_changed"
+
+// defaultListChangedDebounce is how long to wait for further list_changed notifications before rediscovering
+const defaultListChangedDebounce = 200 * time.Millisecond
+
+// notificationClient is implemented by clients that can report notifications from their server
+type notificationClient interface {
+	OnNotification(handler func(notification mcp.JSONRPCNotification))
+}
+
+// Every transport's client must report notifications
+var (
+	_ notificationClient = (*stdioClient)(nil)
+	_ notificationClient = (*sseClient)(nil)
+	_ notificationClient = (*httpClient)(nil)
+)
+
+// connectNotifications subscribes to the notifications of a server
+func (a *MCPAggregator) connectNotifications(serverName string, mcpClient MCPClient) {
+	client, ok := mcpClient.(notificationClient)
+	if !ok {
+		return
+	}
+	client.OnNotification(func(notification mcp.JSONRPCNotification) {
+		if notification.Method != MethodToolsListChanged {
+			return
+		}
+
+		// Notifications of a client that has been replaced, e.g. after a restart, are stale
+		a.mu.RLock()
+		current := a.clients[serverName] == mcpClient
+		a.mu.RUnlock()
+		if !current {
+			return
+		}
+
+		logger.Debug("Tools of server %s changed", serverName)
+		a.scheduleRediscovery(serverName)
+	})
+}
+
+// scheduleRediscovery rediscovers the tools of a server once its list_changed notifications have settled,
+// so a burst of changes only causes a single rediscovery
+func (a *MCPAggregator) scheduleRediscovery(serverName string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if timer, pending := a.rediscoveries[serverName]; pending {
+		timer.Reset(a.listChangedDebounce)
+		return
+	}
+	a.rediscoveries[serverName] = time.AfterFunc(a.listChangedDebounce, func() {
+		a.mu.Lock()
+		delete(a.rediscoveries, serverName)
+		a.mu.Unlock()
+
+		a.rediscoverTools(serverName)
+	})
+}
+
+// RefreshTools rediscovers the tools of every connected server and notifies the downstream client.
+// It returns the exposed names of the tools that were added and removed. Servers that fail to list
+// their tools keep their previous tools and are reported in the error.
+func (a *MCPAggregator) RefreshTools(ctx context.Context) (added, removed []string, err error) {
+	a.mu.RLock()
+	before := make(map[string]bool, len(a.tools))
+	for name := range a.tools {
+		before[name] = true
+	}
+	serverNames := make([]string, 0, len(a.clients))
+	for name := range a.clients {
+		serverNames = append(serverNames, name)
+	}
+	a.mu.RUnlock()
+	sort.Strings(serverNames)
+
+	var errs []error
+	for _, serverName := range serverNames {
+		logger.Info("Refreshing tools of server %s", serverName)
+		// A changed tool may answer differently, so its cached results are dropped
+		a.resultCache.clearServer(serverName)
+		if err := a.discoverTools(ctx, serverName); err != nil {
+			logger.Error("Failed to refresh tools for server %s: %v", serverName, err)
+			errs = append(errs, err)
+		}
+	}
+
+	a.mu.RLock()
+	for name := range a.tools {
+		if !before[name] {
+			added = append(added, name)
+		}
+		delete(before, name)
+	}
+	a.mu.RUnlock()
+	for name := range before {
+		removed = append(removed, name)
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	a.notifyToolsChanged()
+	return added, removed, errors.Join(errs...)
+}
+
+// rediscoverTools refreshes the tools of a server and notifies the downstream client
+func (a *MCPAggregator) rediscoverTools(serverName string) {
+	select {
+	case <-a.done:
+		return
+	default:
+	}
+
+	logger.Info("Rediscovering tools of server %s", serverName)
+	a.resultCache.clearServer(serverName)
+	if err := a.discoverTools(context.Background(), serverName); err != nil {
+		logger.Error("Failed to rediscover tools for server %s: %v", serverName, err)
+		return
+	}
+	a.notifyToolsChanged()
+}
@@ -0,0 +1,157 @@
+package aggregator
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/config"
+)
+
+// notifyingClient is a mock client whose server can change its tools and announce it
+type notifyingClient struct {
+	mu        sync.Mutex
+	tools     []mcp.Tool
+	listCalls int
+	handlers  []func(mcp.JSONRPCNotification)
+}
+
+func (c *notifyingClient) Initialize(ctx context.Context, request mcp.InitializeRequest) (*mcp.InitializeResult, error) {
+	return &mcp.InitializeResult{ServerInfo: mcp.Implementation{Name: "notifying-server", Version: "1.0.0"}}, nil
+}
+
+func (c *notifyingClient) ListTools(ctx context.Context, request mcp.ListToolsRequest) (*mcp.ListToolsResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listCalls++
+	return &mcp.ListToolsResult{Tools: append([]mcp.Tool(nil), c.tools...)}, nil
+}
+
+func (c *notifyingClient) CallTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return &mcp.CallToolResult{}, nil
+}
+
+func (c *notifyingClient) Close() error {
+	return nil
+}
+
+func (c *notifyingClient) OnNotification(handler func(notification mcp.JSONRPCNotification)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers = append(c.handlers, handler)
+}
+
+// addTool adds a tool on the server and sends the given notification the given number of times
+func (c *notifyingClient) addTool(tool mcp.Tool, method string, times int) {
+	c.mu.Lock()
+	c.tools = append(c.tools, tool)
+	handlers := append([]func(mcp.JSONRPCNotification){}, c.handlers...)
+	c.mu.Unlock()
+
+	notification := mcp.JSONRPCNotification{JSONRPC: mcp.JSONRPC_VERSION, Notification: mcp.Notification{Method: method}}
+	for i := 0; i < times; i++ {
+		for _, handler := range handlers {
+			handler(notification)
+		}
+	}
+}
+
+func (c *notifyingClient) listCallCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.listCalls
+}
+
+func TestToolsListChanged(t *testing.T) {
+	upstream := &notifyingClient{tools: []mcp.Tool{{Name: "tool1"}}}
+
+	agg := NewMCPAggregator()
+	agg.listChangedDebounce = 10 * time.Millisecond
+	agg.clientFactory = func(serverCfg config.ServerConfig) (MCPClient, error) {
+		return upstream, nil
+	}
+
+	var changesMu sync.Mutex
+	changes := 0
+	agg.OnToolsChanged(func() {
+		changesMu.Lock()
+		changes++
+		changesMu.Unlock()
+	})
+
+	cfg := &config.Config{
+		Servers:  []config.ServerConfig{{Name: "dynamic", Command: "test-command"}},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	defer agg.Close()
+
+	// Other notifications don't trigger a rediscovery
+	upstream.addTool(mcp.Tool{Name: "tool2"}, "notifications/resources/list_changed", 1)
+	time.Sleep(50 * time.Millisecond)
+	if got := agg.ToolCount(); got != 1 {
+		t.Fatalf("ToolCount() = %d after unrelated notification, want 1", got)
+	}
+
+	// A burst of list_changed notifications causes a single rediscovery
+	upstream.addTool(mcp.Tool{Name: "tool3"}, MethodToolsListChanged, 5)
+	waitFor(t, "tool rediscovery", func() bool {
+		return agg.ToolCount() == 3
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	if got := upstream.listCallCount(); got != 2 {
+		t.Errorf("ListTools called %d times, want 2 (discovery + 1 rediscovery)", got)
+	}
+	changesMu.Lock()
+	defer changesMu.Unlock()
+	if changes != 1 {
+		t.Errorf("Tools changed %d times, want 1", changes)
+	}
+}
+
+func TestRefreshTools(t *testing.T) {
+	upstream := &notifyingClient{tools: []mcp.Tool{{Name: "tool1"}, {Name: "tool2"}}}
+
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		return upstream, nil
+	}))
+	changes := 0
+	agg.OnToolsChanged(func() {
+		changes++
+	})
+	cfg := &config.Config{
+		Servers:  []config.ServerConfig{{Name: "dynamic", Command: "test-command"}},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	// Tools change on the server without a notification
+	upstream.mu.Lock()
+	upstream.tools = []mcp.Tool{{Name: "tool1"}, {Name: "tool3"}, {Name: "tool4"}}
+	upstream.mu.Unlock()
+
+	added, removed, err := agg.RefreshTools(context.Background())
+	if err != nil {
+		t.Fatalf("RefreshTools() error = %v", err)
+	}
+	if want := []string{"dynamic_tool3", "dynamic_tool4"}; !reflect.DeepEqual(added, want) {
+		t.Errorf("RefreshTools() added = %v, want %v", added, want)
+	}
+	if want := []string{"dynamic_tool2"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("RefreshTools() removed = %v, want %v", removed, want)
+	}
+	if got := agg.ToolCount(); got != 3 {
+		t.Errorf("ToolCount() = %d, want 3", got)
+	}
+	if changes != 1 {
+		t.Errorf("Tools changed %d times, want 1", changes)
+	}
+}
@@ -0,0 +1,59 @@
+package aggregator
+
+import (
+	"time"
+
+	"github.com/nazar256/combine-mcp/pkg/config"
+	"github.com/nazar256/combine-mcp/pkg/metrics"
+)
+
+// Option configures an MCPAggregator when it is created
+type Option func(*MCPAggregator)
+
+// WithStdoutGuard makes Initialize redirect os.Stdout to stderr while it starts the servers, so nothing they
+// or their libraries print corrupts the JSON-RPC stream on stdout. It replaces the process-wide os.Stdout,
+// so it is off by default and only meant for programs that serve MCP over their own stdout.
+func WithStdoutGuard(enabled bool) Option {
+	return func(a *MCPAggregator) {
+		a.stdoutGuard = enabled
+	}
+}
+
+// WithCallTimeout sets the time allowed for tool calls of servers that don't configure callTimeoutSeconds
+func WithCallTimeout(timeout time.Duration) Option {
+	return func(a *MCPAggregator) {
+		a.callTimeout = timeout
+	}
+}
+
+// WithDiscoveryTimeout sets the time allowed for listing the tools of a server
+func WithDiscoveryTimeout(timeout time.Duration) Option {
+	return func(a *MCPAggregator) {
+		a.discoveryTimeout = timeout
+	}
+}
+
+// WithDrainTimeout sets the grace period Close gives tool calls in flight before the servers are stopped
+func WithDrainTimeout(timeout time.Duration) Option {
+	return func(a *MCPAggregator) {
+		a.drainTimeout = timeout
+	}
+}
+
+// WithMetrics makes the aggregator record every tool call in the given registry, like SetMetrics
+func WithMetrics(registry *metrics.Registry) Option {
+	return func(a *MCPAggregator) {
+		a.metrics = registry
+	}
+}
+
+// ClientFactory creates the client of a server. Initialize and the supervisor call it instead of starting
+// processes or connecting themselves, so tests can inject mocks and run the whole initialization.
+type ClientFactory func(serverCfg config.ServerConfig) (MCPClient, error)
+
+// WithClientFactory replaces how clients of servers are created, e.g. to inject clients that don't start processes
+func WithClientFactory(factory ClientFactory) Option {
+	return func(a *MCPAggregator) {
+		a.clientFactory = factory
+	}
+}
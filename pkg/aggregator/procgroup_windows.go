@@ -0,0 +1,19 @@
+//go:build windows
+
+package aggregator
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup does nothing on Windows, where processes aren't signalled as a group
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalProcessGroup sends a signal to the server process only, Windows has no process groups to signal
+func signalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	if sig == syscall.SIGKILL {
+		return cmd.Process.Kill()
+	}
+	return cmd.Process.Signal(sig)
+}
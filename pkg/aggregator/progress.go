@@ -0,0 +1,97 @@
+package aggregator
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/logger"
+)
+
+// MethodProgress is the notification servers send to report the progress of a request
+const MethodProgress = "notifications/progress"
+
+// ProgressHandler relays a progress notification of a server to the downstream client.
+// It is called with the context of the tool call the progress belongs to.
+type ProgressHandler func(ctx context.Context, params map[string]interface{})
+
+// progressCall is a tool call in flight whose caller asked for progress notifications
+type progressCall struct {
+	ctx        context.Context
+	serverName string
+}
+
+// SetProgressHandler sets the handler that relays progress notifications to the downstream client
+func (a *MCPAggregator) SetProgressHandler(handler ProgressHandler) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.progressHandler = handler
+}
+
+// progressKey identifies a progress token, which can be a string or a number
+func progressKey(token mcp.ProgressToken) (string, bool) {
+	if token == nil {
+		return "", false
+	}
+	encoded, err := json.Marshal(token)
+	if err != nil {
+		return "", false
+	}
+	return string(encoded), true
+}
+
+// trackProgress routes the progress notifications of a server carrying the call's progress token
+// to the progress handler until the returned function is called.
+// The token is forwarded to the server unchanged, as part of the request.
+func (a *MCPAggregator) trackProgress(ctx context.Context, serverName string, request mcp.CallToolRequest) func() {
+	if request.Params.Meta == nil {
+		return func() {}
+	}
+	key, ok := progressKey(request.Params.Meta.ProgressToken)
+	if !ok {
+		return func() {}
+	}
+
+	a.mu.Lock()
+	a.progressCalls[key] = progressCall{ctx: ctx, serverName: serverName}
+	a.mu.Unlock()
+	return func() {
+		a.mu.Lock()
+		delete(a.progressCalls, key)
+		a.mu.Unlock()
+	}
+}
+
+// connectProgress relays the progress notifications of a server for calls in flight
+func (a *MCPAggregator) connectProgress(serverName string, mcpClient MCPClient) {
+	client, ok := mcpClient.(notificationClient)
+	if !ok {
+		return
+	}
+	client.OnNotification(func(notification mcp.JSONRPCNotification) {
+		if notification.Method != MethodProgress {
+			return
+		}
+		a.relayProgress(serverName, notification.Params.AdditionalFields)
+	})
+}
+
+// relayProgress passes a progress notification on if it belongs to a call in flight to the server.
+// Progress for unknown tokens, e.g. of calls that have already finished, is dropped.
+func (a *MCPAggregator) relayProgress(serverName string, params map[string]interface{}) {
+	key, ok := progressKey(params["progressToken"])
+	if !ok {
+		return
+	}
+
+	a.mu.RLock()
+	call, inFlight := a.progressCalls[key]
+	handler := a.progressHandler
+	a.mu.RUnlock()
+
+	if !inFlight || call.serverName != serverName || handler == nil {
+		logger.Debug("Dropping progress notification of server %s for token %s", serverName, key)
+		return
+	}
+	handler(call.ctx, params)
+}
@@ -0,0 +1,128 @@
+package aggregator
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/config"
+)
+
+// progressClient reports progress for the token of each call before answering it
+type progressClient struct {
+	MockClient
+	mu       sync.Mutex
+	handlers []func(notification mcp.JSONRPCNotification)
+}
+
+func (m *progressClient) OnNotification(handler func(notification mcp.JSONRPCNotification)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers = append(m.handlers, handler)
+}
+
+func (m *progressClient) notify(token mcp.ProgressToken, progress float64) {
+	m.mu.Lock()
+	handlers := append([]func(mcp.JSONRPCNotification){}, m.handlers...)
+	m.mu.Unlock()
+
+	notification := mcp.JSONRPCNotification{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		Notification: mcp.Notification{
+			Method: MethodProgress,
+			Params: mcp.NotificationParams{AdditionalFields: map[string]interface{}{
+				"progressToken": token,
+				"progress":      progress,
+				"total":         float64(2),
+			}},
+		},
+	}
+	for _, handler := range handlers {
+		handler(notification)
+	}
+}
+
+func (m *progressClient) CallTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	var token mcp.ProgressToken
+	if request.Params.Meta != nil {
+		token = request.Params.Meta.ProgressToken
+	}
+	m.notify(token, 1)
+	m.notify("another-call", 1)
+	m.notify(token, 2)
+	return m.MockClient.CallTool(ctx, request)
+}
+
+func TestProgressRelay(t *testing.T) {
+	type relayed struct {
+		ctx    context.Context
+		params map[string]interface{}
+	}
+	var mu sync.Mutex
+	var got []relayed
+	snapshot := func() []relayed {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]relayed(nil), got...)
+	}
+
+	mockClient := &progressClient{MockClient: MockClient{Tools: []mcp.Tool{{Name: "build"}}}}
+	agg := NewMCPAggregator()
+	agg.SetProgressHandler(func(ctx context.Context, params map[string]interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, relayed{ctx, params})
+	})
+	agg.clients["ci"] = mockClient
+	agg.configs["ci"] = &config.ServerConfig{Name: "ci", Command: "test-command"}
+	agg.connectProgress("ci", mockClient)
+	if err := agg.discoverTools(context.Background(), "ci"); err != nil {
+		t.Fatalf("discoverTools() error = %v", err)
+	}
+
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "caller")
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "ci_build"
+	request.Params.Meta = &struct {
+		ProgressToken mcp.ProgressToken `json:"progressToken,omitempty"`
+	}{ProgressToken: float64(7)}
+	if _, err := agg.CallTool(ctx, request); err != nil {
+		t.Fatalf("CallTool() error = %v", err)
+	}
+
+	// Only the progress for the call's token is relayed, in the context of the call
+	progress := snapshot()
+	if len(progress) != 2 {
+		t.Fatalf("Relayed %d progress notifications, want 2: %+v", len(progress), progress)
+	}
+	for i, p := range progress {
+		if p.params["progressToken"] != float64(7) || p.params["progress"] != float64(i+1) {
+			t.Errorf("Progress %d = %v, want progress %d for token 7", i, p.params, i+1)
+		}
+		if p.ctx.Value(ctxKey{}) != "caller" {
+			t.Errorf("Progress %d wasn't relayed in the context of the call", i)
+		}
+	}
+
+	// The token reaches the server unchanged
+	if meta := mockClient.Calls[0].Params.Meta; meta == nil || meta.ProgressToken != float64(7) {
+		t.Errorf("Forwarded progress token = %+v, want 7", meta)
+	}
+
+	// Once the call is done, its token isn't tracked anymore
+	mockClient.notify(float64(7), 3)
+	if len(snapshot()) != 2 {
+		t.Errorf("Progress after the call finished was relayed")
+	}
+
+	// Calls without a progress token don't relay anything
+	request.Params.Meta = nil
+	if _, err := agg.CallTool(ctx, request); err != nil {
+		t.Fatalf("CallTool() error = %v", err)
+	}
+	if len(snapshot()) != 2 {
+		t.Errorf("Progress of a call without a token was relayed")
+	}
+}
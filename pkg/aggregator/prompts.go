@@ -0,0 +1,163 @@
+package aggregator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/config"
+	"github.com/nazar256/combine-mcp/pkg/logger"
+)
+
+// promptClient is implemented by clients that can list and get the prompts of their server
+type promptClient interface {
+	ListPrompts(ctx context.Context, request mcp.ListPromptsRequest) (*mcp.ListPromptsResult, error)
+	GetPrompt(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error)
+}
+
+// Every client of a transport with prompts must satisfy promptClient
+var (
+	_ promptClient = (*stdioClient)(nil)
+	_ promptClient = (*sseClient)(nil)
+	_ promptClient = (*httpClient)(nil)
+)
+
+type promptMapping struct {
+	serverName   string
+	originalName string
+	prompt       mcp.Prompt // Upstream prompt as discovered
+}
+
+// discoverPrompts discovers all prompts available on a server and registers them with a prefix
+func (a *MCPAggregator) discoverPrompts(ctx context.Context, serverName string) error {
+	a.mu.RLock()
+	mcpClient, exists := a.clients[serverName]
+	serverConfig := a.configs[serverName]
+	disablePrefix := a.disablePrefix
+	sanitizeMode := a.sanitizeMode
+	delimiter := a.delimiter
+	a.mu.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("client for server %s not found", serverName)
+	}
+	prompts, ok := mcpClient.(promptClient)
+	if !ok {
+		logger.Debug("Client for server %s can't list prompts", serverName)
+		return nil
+	}
+
+	logger.Debug("Discovering prompts for server %s...", serverName)
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, a.discoveryTimeout)
+	defer cancel()
+	promptsResp, err := prompts.ListPrompts(ctxWithTimeout, mcp.ListPromptsRequest{})
+	if err != nil {
+		return fmt.Errorf("failed to list prompts for server %s: %w", serverName, err)
+	}
+	logger.Debug("Found %d prompts for server %s", len(promptsResp.Prompts), serverName)
+
+	mappings := make(map[string]promptMapping, len(promptsResp.Prompts))
+	sanitizedPrefix := config.SanitizeToolName(toolPrefix(serverName, serverConfig, disablePrefix), sanitizeMode)
+	for _, prompt := range promptsResp.Prompts {
+		prefixedName := exposedToolName(sanitizedPrefix, delimiter, config.SanitizeToolName(prompt.Name, sanitizeMode))
+		if existing, duplicate := mappings[prefixedName]; duplicate {
+			logger.Warn("Prompt %s of server %s collides with prompt %s as %s, skipping it",
+				prompt.Name, serverName, existing.originalName, prefixedName)
+			continue
+		}
+
+		logger.Debug("Registering prompt: %s -> %s", prompt.Name, prefixedName)
+		mappings[prefixedName] = promptMapping{
+			serverName:   serverName,
+			originalName: prompt.Name,
+			prompt:       prompt,
+		}
+	}
+
+	a.replaceServerPrompts(serverName, mappings)
+	return nil
+}
+
+// discoverServerPrompts discovers the prompts of a server if it advertised any
+func (a *MCPAggregator) discoverServerPrompts(ctx context.Context, serverName string, initResult *mcp.InitializeResult) {
+	if initResult.Capabilities.Prompts == nil {
+		return
+	}
+	if err := a.discoverPrompts(ctx, serverName); err != nil {
+		logger.Error("Failed to discover prompts for server %s: %v", serverName, err)
+	}
+}
+
+// replaceServerPrompts swaps the registered prompts of a server for the given mappings
+func (a *MCPAggregator) replaceServerPrompts(serverName string, mappings map[string]promptMapping) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for prefixedName, mapping := range a.prompts {
+		if mapping.serverName == serverName {
+			delete(a.prompts, prefixedName)
+		}
+	}
+	for prefixedName, mapping := range mappings {
+		// Without prefixes, servers can expose the same name and the first one registered keeps it
+		if existing, taken := a.prompts[prefixedName]; taken {
+			logger.Warn("Prompt name %s of server %s collides with server %s, keeping the prompt of server %s",
+				prefixedName, serverName, existing.serverName, existing.serverName)
+			continue
+		}
+		a.prompts[prefixedName] = mapping
+	}
+}
+
+// GetPrompts returns a list of all prompts from all servers with prefixed names
+func (a *MCPAggregator) GetPrompts() []mcp.Prompt {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	allPrompts := make([]mcp.Prompt, 0, len(a.prompts))
+	for prefixedName, mapping := range a.prompts {
+		prompt := mapping.prompt
+		prompt.Name = prefixedName
+
+		// Update the description to indicate the source server
+		if prompt.Description != "" {
+			prompt.Description = fmt.Sprintf("[%s] %s", mapping.serverName, prompt.Description)
+		}
+		allPrompts = append(allPrompts, prompt)
+	}
+
+	sort.Slice(allPrompts, func(i, j int) bool {
+		return allPrompts[i].Name < allPrompts[j].Name
+	})
+	return allPrompts
+}
+
+// GetPrompt gets a prompt from the server that owns it
+func (a *MCPAggregator) GetPrompt(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+	prefixedName := request.Params.Name
+
+	a.mu.RLock()
+	mapping, exists := a.prompts[prefixedName]
+	mcpClient := a.clients[mapping.serverName]
+	a.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("prompt %s not found", prefixedName)
+	}
+	prompts, ok := mcpClient.(promptClient)
+	if !ok {
+		return nil, fmt.Errorf("client for server %s not found", mapping.serverName)
+	}
+
+	upstreamRequest := request
+	upstreamRequest.Params.Name = mapping.originalName
+	return prompts.GetPrompt(ctx, upstreamRequest)
+}
+
+// PromptCount returns the number of prompts registered across all servers
+func (a *MCPAggregator) PromptCount() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.prompts)
+}
@@ -0,0 +1,101 @@
+package aggregator
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/config"
+)
+
+// promptMockClient is a mock client of a server that ships prompts
+type promptMockClient struct {
+	MockClient
+	Prompts []mcp.Prompt
+	Gets    []mcp.GetPromptRequest
+}
+
+func (m *promptMockClient) Initialize(ctx context.Context, request mcp.InitializeRequest) (*mcp.InitializeResult, error) {
+	result, err := m.MockClient.Initialize(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	result.Capabilities.Prompts = &struct {
+		ListChanged bool `json:"listChanged,omitempty"`
+	}{}
+	return result, nil
+}
+
+func (m *promptMockClient) ListPrompts(ctx context.Context, request mcp.ListPromptsRequest) (*mcp.ListPromptsResult, error) {
+	return &mcp.ListPromptsResult{Prompts: m.Prompts}, nil
+}
+
+func (m *promptMockClient) GetPrompt(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+	m.Gets = append(m.Gets, request)
+	return &mcp.GetPromptResult{
+		Messages: []mcp.PromptMessage{{Role: mcp.RoleUser, Content: mcp.NewTextContent("Review " + request.Params.Arguments["file"])}},
+	}, nil
+}
+
+func TestPrompts(t *testing.T) {
+	git := &promptMockClient{
+		Prompts: []mcp.Prompt{
+			{Name: "code-review", Description: "Review code", Arguments: []mcp.PromptArgument{{Name: "file", Required: true}}},
+			{Name: "commit-message"},
+		},
+	}
+
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		if serverCfg.Name == "git" {
+			return git, nil
+		}
+		return &MockClient{Tools: []mcp.Tool{{Name: "tool1"}}}, nil
+	}))
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{Name: "git", Command: "test-command"},
+			{Name: "tools", Command: "test-command"},
+		},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	prompts := agg.GetPrompts()
+	var names []string
+	for _, prompt := range prompts {
+		names = append(names, prompt.Name)
+	}
+	wantNames := []string{"git_code_review", "git_commit_message"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Fatalf("GetPrompts() names = %v, want %v", names, wantNames)
+	}
+	if prompts[0].Description != "[git] Review code" {
+		t.Errorf("Description = %q, want %q", prompts[0].Description, "[git] Review code")
+	}
+	if len(prompts[0].Arguments) != 1 || prompts[0].Arguments[0].Name != "file" {
+		t.Errorf("Arguments = %v, want the upstream arguments", prompts[0].Arguments)
+	}
+
+	// Requests are routed to the owning server with the original name
+	request := mcp.GetPromptRequest{}
+	request.Params.Name = "git_code_review"
+	request.Params.Arguments = map[string]string{"file": "main.go"}
+	result, err := agg.GetPrompt(context.Background(), request)
+	if err != nil {
+		t.Fatalf("GetPrompt() error = %v", err)
+	}
+	if len(git.Gets) != 1 || git.Gets[0].Params.Name != "code-review" || git.Gets[0].Params.Arguments["file"] != "main.go" {
+		t.Errorf("Upstream requests = %+v, want code-review with file main.go", git.Gets)
+	}
+	if len(result.Messages) != 1 || result.Messages[0].Content != mcp.NewTextContent("Review main.go") {
+		t.Errorf("GetPrompt() messages = %+v", result.Messages)
+	}
+
+	request.Params.Name = "code-review"
+	if _, err := agg.GetPrompt(context.Background(), request); err == nil {
+		t.Errorf("GetPrompt() of an unprefixed name succeeded")
+	}
+}
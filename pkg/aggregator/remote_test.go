@@ -0,0 +1,141 @@
+package aggregator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/nazar256/combine-mcp/pkg/config"
+)
+
+// newEchoServer returns an MCP server with a single tool echoing its text argument
+func newEchoServer() *server.MCPServer {
+	mcpServer := server.NewMCPServer("remote-server", "1.0.0", server.WithToolCapabilities(false))
+	mcpServer.AddTool(mcp.NewTool("echo", mcp.WithDescription("Echo text"), mcp.WithString("text")),
+		func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return mcp.NewToolResultText(fmt.Sprint(request.Params.Arguments["text"])), nil
+		})
+	return mcpServer
+}
+
+// streamableHandler serves an MCP server over a minimal streamable HTTP transport.
+// Tool calls are answered as an event stream preceded by a ping, other requests as plain JSON.
+type streamableHandler struct {
+	mcpServer *server.MCPServer
+
+	mu          sync.Mutex
+	sessions    map[string]bool
+	pinged      bool
+	deleted     bool
+	initializes []json.RawMessage // Params of the initialize requests received
+}
+
+func (h *streamableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sessionID := r.Header.Get(sessionIDHeader)
+	if r.Method == http.MethodDelete {
+		h.deleted = h.sessions[sessionID]
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	var message rpcMessage
+	if err := json.Unmarshal(body, &message); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if message.Method == string(mcp.MethodInitialize) {
+		h.initializes = append(h.initializes, message.Params)
+		sessionID = fmt.Sprintf("session-%d", len(h.sessions)+1)
+		h.sessions[sessionID] = true
+		w.Header().Set(sessionIDHeader, sessionID)
+	} else if !h.sessions[sessionID] {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+
+	// Notifications and responses to our ping are only acknowledged
+	if message.Method == "" || len(message.ID) == 0 {
+		if message.Method == "" && message.Result != nil {
+			h.pinged = true
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	response, _ := json.Marshal(h.mcpServer.HandleMessage(r.Context(), body))
+	if message.Method != string(mcp.MethodToolsCall) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	fmt.Fprintf(w, "data: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\",\"params\":{}}\n\n")
+	fmt.Fprintf(w, "data: {\"jsonrpc\":\"2.0\",\"id\":\"ping-1\",\"method\":\"ping\"}\n\n")
+	fmt.Fprintf(w, "data: %s\n\n", response)
+}
+
+func TestRemoteTransports(t *testing.T) {
+	sseServer := server.NewTestServer(newEchoServer())
+	defer sseServer.Close()
+
+	handler := &streamableHandler{mcpServer: newEchoServer(), sessions: make(map[string]bool)}
+	httpServer := httptest.NewServer(handler)
+	defer httpServer.Close()
+
+	agg := NewMCPAggregator()
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{Name: "sse", Transport: config.TransportSSE, URL: sseServer.URL + "/sse"},
+			{Name: "http", Transport: config.TransportHTTP, URL: httpServer.URL},
+		},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	if got := agg.ToolCount(); got != 2 {
+		t.Fatalf("ToolCount() = %d, want 2", got)
+	}
+
+	for _, name := range []string{"sse_echo", "http_echo"} {
+		request := mcp.CallToolRequest{}
+		request.Params.Name = name
+		request.Params.Arguments = map[string]interface{}{"text": "hello"}
+
+		result, err := agg.CallTool(context.Background(), request)
+		if err != nil {
+			t.Fatalf("CallTool(%s) error = %v", name, err)
+		}
+		if len(result.Content) != 1 {
+			t.Fatalf("CallTool(%s) returned %d content items, want 1", name, len(result.Content))
+		}
+		text, ok := result.Content[0].(mcp.TextContent)
+		if !ok || text.Text != "hello" {
+			t.Errorf("CallTool(%s) content = %#v, want text %q", name, result.Content[0], "hello")
+		}
+	}
+
+	agg.Close()
+
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	if !handler.pinged {
+		t.Errorf("HTTP client didn't answer the ping interleaved with the tool result")
+	}
+	if !handler.deleted {
+		t.Errorf("HTTP client didn't end its session on close")
+	}
+}
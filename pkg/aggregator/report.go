@@ -0,0 +1,91 @@
+package aggregator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// InitReport lists which servers Initialize connected and which it skipped
+type InitReport struct {
+	Initialized []string        `json:"initialized"`
+	Skipped     []SkippedServer `json:"skipped,omitempty"`
+}
+
+// SkippedServer is a server that Initialize couldn't connect, with the reason why
+type SkippedServer struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// String summarizes the report with one line per server, in config order
+func (r InitReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Servers initialized: %d of %d", len(r.Initialized), len(r.Initialized)+len(r.Skipped))
+	for _, name := range r.Initialized {
+		fmt.Fprintf(&b, "\n  initialized  %s", name)
+	}
+	for _, skipped := range r.Skipped {
+		fmt.Fprintf(&b, "\n  skipped      %s: %s", skipped.Name, skipped.Reason)
+	}
+	return b.String()
+}
+
+// InitReport returns the servers the last call to Initialize connected and skipped
+func (a *MCPAggregator) InitReport() InitReport {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return InitReport{
+		Initialized: append([]string(nil), a.initReport.Initialized...),
+		Skipped:     append([]SkippedServer(nil), a.initReport.Skipped...),
+	}
+}
+
+// reportInitialized records that Initialize connected a server
+func (a *MCPAggregator) reportInitialized(serverName string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.initReport.Initialized = append(a.initReport.Initialized, serverName)
+}
+
+// reportSkipped records that Initialize skipped a server
+func (a *MCPAggregator) reportSkipped(serverName string, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.initReport.Skipped = append(a.initReport.Skipped, SkippedServer{Name: serverName, Reason: err.Error()})
+}
+
+// Stats summarizes the tools and servers of the aggregator, for reporting without a metrics endpoint
+type Stats struct {
+	Tools         int            `json:"tools"`         // Tools exposed across all servers, including unprefixed aliases
+	ToolsByServer map[string]int `json:"toolsByServer"` // Tools exposed for every connected server
+	Connected     int            `json:"connected"`     // Servers that are connected
+	Failed        int            `json:"failed"`        // Servers skipped by the last Initialize that aren't connected since
+}
+
+// Stats counts the exposed tools and the connected and failed servers
+func (a *MCPAggregator) Stats() Stats {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	stats := Stats{
+		Tools:         len(a.tools) + len(a.aliases),
+		ToolsByServer: make(map[string]int, len(a.clients)),
+		Connected:     len(a.clients),
+	}
+	for name := range a.clients {
+		stats.ToolsByServer[name] = 0
+	}
+	for _, mapping := range a.tools {
+		stats.ToolsByServer[mapping.serverName]++
+	}
+	for _, prefixedName := range a.aliases {
+		stats.ToolsByServer[a.tools[prefixedName].serverName]++
+	}
+	for _, skipped := range a.initReport.Skipped {
+		if _, ok := a.clients[skipped.Name]; !ok {
+			stats.Failed++
+		}
+	}
+	return stats
+}
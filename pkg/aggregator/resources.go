@@ -0,0 +1,176 @@
+package aggregator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/config"
+	"github.com/nazar256/combine-mcp/pkg/logger"
+)
+
+// resourceClient is implemented by clients that can list and read the resources of their server
+type resourceClient interface {
+	ListResources(ctx context.Context, request mcp.ListResourcesRequest) (*mcp.ListResourcesResult, error)
+	ReadResource(ctx context.Context, request mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error)
+}
+
+// Every client of a transport with resources must satisfy resourceClient
+var (
+	_ resourceClient = (*stdioClient)(nil)
+	_ resourceClient = (*sseClient)(nil)
+	_ resourceClient = (*httpClient)(nil)
+)
+
+type resourceMapping struct {
+	serverName  string
+	originalURI string
+	resource    mcp.Resource // Upstream resource as discovered
+}
+
+// discoverResources discovers all resources available on a server and registers them with a prefixed URI
+func (a *MCPAggregator) discoverResources(ctx context.Context, serverName string) error {
+	a.mu.RLock()
+	mcpClient, exists := a.clients[serverName]
+	serverConfig := a.configs[serverName]
+	disablePrefix := a.disablePrefix
+	sanitizeMode := a.sanitizeMode
+	delimiter := a.delimiter
+	a.mu.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("client for server %s not found", serverName)
+	}
+	resources, ok := mcpClient.(resourceClient)
+	if !ok {
+		logger.Debug("Client for server %s can't list resources", serverName)
+		return nil
+	}
+
+	logger.Debug("Discovering resources for server %s...", serverName)
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, a.discoveryTimeout)
+	defer cancel()
+	resourcesResp, err := resources.ListResources(ctxWithTimeout, mcp.ListResourcesRequest{})
+	if err != nil {
+		return fmt.Errorf("failed to list resources for server %s: %w", serverName, err)
+	}
+	logger.Debug("Found %d resources for server %s", len(resourcesResp.Resources), serverName)
+
+	// URIs are prefixed like tool names, so resources of different servers can't clash
+	mappings := make(map[string]resourceMapping, len(resourcesResp.Resources))
+	sanitizedPrefix := config.SanitizeToolName(toolPrefix(serverName, serverConfig, disablePrefix), sanitizeMode)
+	for _, resource := range resourcesResp.Resources {
+		prefixedURI := exposedToolName(sanitizedPrefix, delimiter, resource.URI)
+		logger.Debug("Registering resource: %s -> %s", resource.URI, prefixedURI)
+		mappings[prefixedURI] = resourceMapping{
+			serverName:  serverName,
+			originalURI: resource.URI,
+			resource:    resource,
+		}
+	}
+
+	a.replaceServerResources(serverName, mappings)
+	return nil
+}
+
+// discoverServerResources discovers the resources of a server if it advertised any
+func (a *MCPAggregator) discoverServerResources(ctx context.Context, serverName string, initResult *mcp.InitializeResult) {
+	if initResult.Capabilities.Resources == nil {
+		return
+	}
+	if err := a.discoverResources(ctx, serverName); err != nil {
+		logger.Error("Failed to discover resources for server %s: %v", serverName, err)
+	}
+}
+
+// replaceServerResources swaps the registered resources of a server for the given mappings
+func (a *MCPAggregator) replaceServerResources(serverName string, mappings map[string]resourceMapping) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for prefixedURI, mapping := range a.resources {
+		if mapping.serverName == serverName {
+			delete(a.resources, prefixedURI)
+		}
+	}
+	for prefixedURI, mapping := range mappings {
+		// Without prefixes, servers can expose the same URI and the first one registered keeps it
+		if existing, taken := a.resources[prefixedURI]; taken {
+			logger.Warn("Resource %s of server %s collides with server %s, keeping the resource of server %s",
+				prefixedURI, serverName, existing.serverName, existing.serverName)
+			continue
+		}
+		a.resources[prefixedURI] = mapping
+	}
+}
+
+// GetResources returns a list of all resources from all servers with prefixed URIs
+func (a *MCPAggregator) GetResources() []mcp.Resource {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	allResources := make([]mcp.Resource, 0, len(a.resources))
+	for prefixedURI, mapping := range a.resources {
+		resource := mapping.resource
+		resource.URI = prefixedURI
+		if resource.Description != "" {
+			resource.Description = fmt.Sprintf("[%s] %s", mapping.serverName, resource.Description)
+		}
+		allResources = append(allResources, resource)
+	}
+
+	sort.Slice(allResources, func(i, j int) bool {
+		return allResources[i].URI < allResources[j].URI
+	})
+	return allResources
+}
+
+// ReadResource reads a resource from the server that owns it
+func (a *MCPAggregator) ReadResource(ctx context.Context, request mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+	prefixedURI := request.Params.URI
+
+	a.mu.RLock()
+	mapping, exists := a.resources[prefixedURI]
+	mcpClient := a.clients[mapping.serverName]
+	a.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("resource %s not found", prefixedURI)
+	}
+	resources, ok := mcpClient.(resourceClient)
+	if !ok {
+		return nil, fmt.Errorf("client for server %s not found", mapping.serverName)
+	}
+
+	upstreamRequest := request
+	upstreamRequest.Params.URI = mapping.originalURI
+	result, err := resources.ReadResource(ctx, upstreamRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	// The contents carry the upstream URI, which the client doesn't know
+	for i, content := range result.Contents {
+		switch c := content.(type) {
+		case mcp.TextResourceContents:
+			if c.URI == mapping.originalURI {
+				c.URI = prefixedURI
+			}
+			result.Contents[i] = c
+		case mcp.BlobResourceContents:
+			if c.URI == mapping.originalURI {
+				c.URI = prefixedURI
+			}
+			result.Contents[i] = c
+		}
+	}
+	return result, nil
+}
+
+// ResourceCount returns the number of resources registered across all servers
+func (a *MCPAggregator) ResourceCount() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.resources)
+}
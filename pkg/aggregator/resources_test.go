@@ -0,0 +1,113 @@
+package aggregator
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/config"
+)
+
+// resourceMockClient is a mock client of a server that publishes resources
+type resourceMockClient struct {
+	MockClient
+	Resources []mcp.Resource
+	Contents  map[string]string
+	Reads     []string
+}
+
+func (m *resourceMockClient) Initialize(ctx context.Context, request mcp.InitializeRequest) (*mcp.InitializeResult, error) {
+	result, err := m.MockClient.Initialize(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	result.Capabilities.Resources = &struct {
+		Subscribe   bool `json:"subscribe,omitempty"`
+		ListChanged bool `json:"listChanged,omitempty"`
+	}{}
+	return result, nil
+}
+
+func (m *resourceMockClient) ListResources(ctx context.Context, request mcp.ListResourcesRequest) (*mcp.ListResourcesResult, error) {
+	return &mcp.ListResourcesResult{Resources: m.Resources}, nil
+}
+
+func (m *resourceMockClient) ReadResource(ctx context.Context, request mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+	m.Reads = append(m.Reads, request.Params.URI)
+	text, ok := m.Contents[request.Params.URI]
+	if !ok {
+		return nil, errors.New("resource not found")
+	}
+	return &mcp.ReadResourceResult{
+		Contents: []mcp.ResourceContents{mcp.TextResourceContents{URI: request.Params.URI, Text: text}},
+	}, nil
+}
+
+func TestResources(t *testing.T) {
+	clients := map[string]MCPClient{
+		"files": &resourceMockClient{
+			Resources: []mcp.Resource{{URI: "file:///notes.txt", Name: "notes", Description: "Notes"}},
+			Contents:  map[string]string{"file:///notes.txt": "remember the milk"},
+		},
+		"docs": &resourceMockClient{
+			Resources: []mcp.Resource{{URI: "file:///notes.txt", Name: "notes"}},
+			Contents:  map[string]string{"file:///notes.txt": "read the docs"},
+		},
+		// Servers without resources don't advertise the capability and are never asked for them
+		"tools": &MockClient{Tools: []mcp.Tool{{Name: "tool1"}}},
+	}
+
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		return clients[serverCfg.Name], nil
+	}))
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{Name: "files", Command: "test-command"},
+			{Name: "docs", Command: "test-command"},
+			{Name: "tools", Command: "test-command"},
+		},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+
+	// The same upstream URI is exposed once per server
+	var uris []string
+	for _, resource := range agg.GetResources() {
+		uris = append(uris, resource.URI)
+		if resource.URI == "files_file:///notes.txt" && resource.Description != "[files] Notes" {
+			t.Errorf("Description = %q, want %q", resource.Description, "[files] Notes")
+		}
+	}
+	wantURIs := []string{"docs_file:///notes.txt", "files_file:///notes.txt"}
+	if !reflect.DeepEqual(uris, wantURIs) {
+		t.Errorf("GetResources() URIs = %v, want %v", uris, wantURIs)
+	}
+
+	// Reads are routed to the owning server with the upstream URI
+	request := mcp.ReadResourceRequest{}
+	request.Params.URI = "files_file:///notes.txt"
+	result, err := agg.ReadResource(context.Background(), request)
+	if err != nil {
+		t.Fatalf("ReadResource() error = %v", err)
+	}
+	files := clients["files"].(*resourceMockClient)
+	if !reflect.DeepEqual(files.Reads, []string{"file:///notes.txt"}) {
+		t.Errorf("Upstream reads = %v, want [file:///notes.txt]", files.Reads)
+	}
+	if len(clients["docs"].(*resourceMockClient).Reads) != 0 {
+		t.Errorf("Read was routed to the wrong server")
+	}
+	want := mcp.TextResourceContents{URI: "files_file:///notes.txt", Text: "remember the milk"}
+	if len(result.Contents) != 1 || result.Contents[0] != want {
+		t.Errorf("ReadResource() contents = %v, want [%v]", result.Contents, want)
+	}
+
+	request.Params.URI = "file:///notes.txt"
+	if _, err := agg.ReadResource(context.Background(), request); err == nil {
+		t.Errorf("ReadResource() of an unprefixed URI succeeded")
+	}
+}
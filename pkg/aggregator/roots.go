@@ -0,0 +1,111 @@
+package aggregator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/nazar256/combine-mcp/pkg/logger"
+)
+
+const (
+	// MethodListRoots is the method upstream servers use to ask the client for its roots
+	MethodListRoots = "roots/list"
+	// MethodRootsListChanged is the notification the client sends when its roots change
+	MethodRootsListChanged = "notifications/roots/list_changed"
+)
+
+// errRootsUnsupported is returned for roots requests the downstream client can't answer
+var errRootsUnsupported = errors.New("roots are not supported by the client")
+
+// RootsHandler asks the downstream client for its roots on behalf of an upstream server
+// and returns the client's result
+type RootsHandler func(ctx context.Context, serverName string) (json.RawMessage, error)
+
+// rootsClient is implemented by clients that can receive roots requests from their server
+// and tell it that the roots changed
+type rootsClient interface {
+	SetRootsHandler(handler func(ctx context.Context, params json.RawMessage) (json.RawMessage, error))
+	NotifyRootsChanged() error
+}
+
+var _ rootsClient = (*stdioClient)(nil)
+
+// SetRootsHandler sets the handler that relays roots requests to the downstream client
+func (a *MCPAggregator) SetRootsHandler(handler RootsHandler) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rootsHandler = handler
+}
+
+// SetClientRoots records the roots capability the downstream client declared when it initialized.
+// Servers started afterwards only advertise roots if the client does.
+func (a *MCPAggregator) SetClientRoots(supported bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.clientRoots = &supported
+	logger.Debug("Downstream client roots support: %v", supported)
+}
+
+// rootsAdvertised reports whether roots should be advertised to an upstream server.
+// Servers are usually started before the downstream client connects, so roots are
+// advertised while the client's support is still unknown.
+func (a *MCPAggregator) rootsAdvertised(mcpClient MCPClient) bool {
+	if _, ok := mcpClient.(rootsClient); !ok {
+		return false
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.rootsHandler != nil && (a.clientRoots == nil || *a.clientRoots)
+}
+
+// connectRoots routes roots requests from the server to the downstream client
+func (a *MCPAggregator) connectRoots(serverName string, mcpClient MCPClient) {
+	client, ok := mcpClient.(rootsClient)
+	if !ok {
+		return
+	}
+	client.SetRootsHandler(func(ctx context.Context, params json.RawMessage) (json.RawMessage, error) {
+		return a.relayRoots(ctx, serverName)
+	})
+}
+
+// relayRoots asks the downstream client for its roots on behalf of a server
+func (a *MCPAggregator) relayRoots(ctx context.Context, serverName string) (json.RawMessage, error) {
+	a.mu.RLock()
+	handler := a.rootsHandler
+	supported := a.clientRoots == nil || *a.clientRoots
+	a.mu.RUnlock()
+
+	if handler == nil || !supported {
+		logger.Error("Rejecting roots request from server %s: %v", serverName, errRootsUnsupported)
+		return nil, errRootsUnsupported
+	}
+
+	logger.Debug("Relaying roots request from server %s", serverName)
+	result, err := handler(ctx, serverName)
+	if err != nil {
+		return nil, fmt.Errorf("roots request failed: %w", err)
+	}
+	return result, nil
+}
+
+// NotifyRootsChanged tells every connected server that can ask for roots that the client's roots changed
+func (a *MCPAggregator) NotifyRootsChanged() {
+	a.mu.RLock()
+	clients := make(map[string]rootsClient, len(a.clients))
+	for name, mcpClient := range a.clients {
+		if client, ok := mcpClient.(rootsClient); ok {
+			clients[name] = client
+		}
+	}
+	a.mu.RUnlock()
+
+	for name, client := range clients {
+		logger.Debug("Relaying roots change to server %s", name)
+		if err := client.NotifyRootsChanged(); err != nil {
+			logger.Error("Failed to notify server %s of changed roots: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package aggregator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/config"
+)
+
+// rootsHelperEnvVar makes the test binary act as an upstream server that asks for roots
+const rootsHelperEnvVar = "COMBINE_MCP_ROOTS_UPSTREAM"
+
+// TestRootsUpstreamHelper is not a real test, it is the upstream server run by TestRootsRelay.
+// The roots tool asks the client for its roots and answers with them, the changes tool
+// answers with the number of roots changes it was notified of.
+func TestRootsUpstreamHelper(t *testing.T) {
+	if os.Getenv(rootsHelperEnvVar) != "1" {
+		return
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	write := func(message interface{}) {
+		data, _ := json.Marshal(message)
+		fmt.Fprintln(os.Stdout, string(data))
+	}
+	read := func() rpcMessage {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			os.Exit(0)
+		}
+		var message rpcMessage
+		json.Unmarshal(line, &message)
+		return message
+	}
+	answer := func(id json.RawMessage, text string, isError bool) {
+		write(map[string]interface{}{"jsonrpc": "2.0", "id": id, "result": map[string]interface{}{
+			"content": []interface{}{map[string]interface{}{"type": "text", "text": text}},
+			"isError": isError,
+		}})
+	}
+
+	rootsAdvertised := false
+	changes := 0
+	for {
+		message := read()
+		switch message.Method {
+		case "initialize":
+			var params struct {
+				Capabilities mcp.ClientCapabilities `json:"capabilities"`
+			}
+			json.Unmarshal(message.Params, &params)
+			rootsAdvertised = params.Capabilities.Roots != nil && params.Capabilities.Roots.ListChanged
+			write(map[string]interface{}{"jsonrpc": "2.0", "id": message.ID, "result": map[string]interface{}{
+				"protocolVersion": mcp.LATEST_PROTOCOL_VERSION,
+				"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+				"serverInfo":      map[string]interface{}{"name": "roots-upstream", "version": "1.0.0"},
+			}})
+		case "tools/list":
+			write(map[string]interface{}{"jsonrpc": "2.0", "id": message.ID, "result": map[string]interface{}{
+				"tools": []interface{}{
+					map[string]interface{}{"name": "roots", "inputSchema": map[string]interface{}{"type": "object"}},
+					map[string]interface{}{"name": "changes", "inputSchema": map[string]interface{}{"type": "object"}},
+				},
+			}})
+		case MethodRootsListChanged:
+			changes++
+		case "tools/call":
+			var params struct {
+				Name string `json:"name"`
+			}
+			json.Unmarshal(message.Params, &params)
+			if params.Name == "changes" {
+				answer(message.ID, fmt.Sprint(changes), false)
+				continue
+			}
+			if !rootsAdvertised {
+				answer(message.ID, "roots capability not advertised", true)
+				continue
+			}
+
+			write(map[string]interface{}{"jsonrpc": "2.0", "id": "roots-1", "method": MethodListRoots})
+			response := read()
+			for string(response.ID) != `"roots-1"` {
+				response = read()
+			}
+			if response.Error != nil {
+				answer(message.ID, response.Error.Message, true)
+				continue
+			}
+			answer(message.ID, string(response.Result), false)
+		}
+	}
+}
+
+func TestRootsRelay(t *testing.T) {
+	agg := NewMCPAggregator()
+
+	var mu sync.Mutex
+	var relayedFrom string
+	agg.SetRootsHandler(func(ctx context.Context, serverName string) (json.RawMessage, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		relayedFrom = serverName
+		return json.RawMessage(`{"roots":[{"uri":"file:///work/project","name":"project"}]}`), nil
+	})
+
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{
+				Name:    "upstream",
+				Command: os.Args[0],
+				Args:    []string{"-test.run=^TestRootsUpstreamHelper$"},
+				Env:     map[string]string{rootsHelperEnvVar: "1"},
+			},
+		},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	defer agg.Close()
+
+	callText := func(name string) (string, bool) {
+		t.Helper()
+		request := mcp.CallToolRequest{}
+		request.Params.Name = name
+		result, err := agg.CallTool(context.Background(), request)
+		if err != nil {
+			t.Fatalf("CallTool(%s) error = %v", name, err)
+		}
+		text, _ := mcp.AsTextContent(result.Content[0])
+		return text.Text, result.IsError
+	}
+
+	// The server is told roots are available and receives the client's roots
+	text, isError := callText("upstream_roots")
+	if isError || !strings.Contains(text, "file:///work/project") {
+		t.Errorf("Roots result = %q (error %v), want the client's roots", text, isError)
+	}
+	mu.Lock()
+	from := relayedFrom
+	mu.Unlock()
+	if from != "upstream" {
+		t.Errorf("Roots relayed from %q, want %q", from, "upstream")
+	}
+
+	// Changes of the client's roots reach the server
+	agg.NotifyRootsChanged()
+	if text, _ := callText("upstream_changes"); text != "1" {
+		t.Errorf("Roots changes seen by the server = %s, want 1", text)
+	}
+
+	// Once the client turns out not to support roots, requests are rejected without reaching it
+	mu.Lock()
+	relayedFrom = ""
+	mu.Unlock()
+	agg.SetClientRoots(false)
+	if _, isError := callText("upstream_roots"); !isError {
+		t.Errorf("Roots result IsError = false, want the roots rejection")
+	}
+	mu.Lock()
+	from = relayedFrom
+	mu.Unlock()
+	if from != "" {
+		t.Errorf("Roots request was relayed to a client without roots support")
+	}
+}
@@ -0,0 +1,114 @@
+package aggregator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/nazar256/combine-mcp/pkg/logger"
+)
+
+// MethodCreateMessage is the method upstream servers use to request LLM sampling from the client
+const MethodCreateMessage = "sampling/createMessage"
+
+// errSamplingUnsupported is returned for sampling requests the downstream client can't answer
+var errSamplingUnsupported = errors.New("sampling is not supported by the client")
+
+// SamplingHandler relays a sampling request from an upstream server to the downstream client
+// and returns the client's result
+type SamplingHandler func(ctx context.Context, serverName string, params json.RawMessage) (json.RawMessage, error)
+
+// samplerClient is implemented by clients whose server can answer sampling requests itself
+type samplerClient interface {
+	CreateMessage(ctx context.Context, params json.RawMessage) (json.RawMessage, error)
+}
+
+// samplingClient is implemented by clients that can receive sampling requests from their server
+type samplingClient interface {
+	SetSamplingHandler(handler func(ctx context.Context, params json.RawMessage) (json.RawMessage, error))
+}
+
+// SetSamplingHandler sets the handler that relays sampling requests to the downstream client
+func (a *MCPAggregator) SetSamplingHandler(handler SamplingHandler) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.samplingHandler = handler
+}
+
+// SetClientSamplingSupport records whether the downstream client supports sampling.
+// Servers started afterwards only advertise sampling if it does.
+func (a *MCPAggregator) SetClientSamplingSupport(supported bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.clientSampling = &supported
+	logger.Debug("Downstream client sampling support: %v", supported)
+}
+
+// samplingAdvertised reports whether sampling should be advertised to upstream servers.
+// Servers are usually started before the downstream client connects, so sampling is
+// advertised while its support is still unknown. With a fallback server it is always advertised.
+func (a *MCPAggregator) samplingAdvertised() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.samplingFallback != "" || a.samplingHandler != nil && (a.clientSampling == nil || *a.clientSampling)
+}
+
+// connectSampling routes sampling requests from the server to the downstream client
+func (a *MCPAggregator) connectSampling(serverName string, mcpClient MCPClient) {
+	client, ok := mcpClient.(samplingClient)
+	if !ok {
+		return
+	}
+	client.SetSamplingHandler(func(ctx context.Context, params json.RawMessage) (json.RawMessage, error) {
+		return a.relaySampling(ctx, serverName, params)
+	})
+}
+
+// relaySampling forwards a sampling request from a server to the downstream client
+func (a *MCPAggregator) relaySampling(ctx context.Context, serverName string, params json.RawMessage) (json.RawMessage, error) {
+	a.mu.RLock()
+	handler := a.samplingHandler
+	supported := a.clientSampling == nil || *a.clientSampling
+	fallback := a.samplingFallback
+	a.mu.RUnlock()
+
+	if handler == nil || !supported {
+		// The fallback server doesn't get its own requests back, it would only wait on itself
+		if fallback != "" && fallback != serverName {
+			return a.relaySamplingToFallback(ctx, serverName, fallback, params)
+		}
+		logger.Error("Rejecting sampling request from server %s: %v", serverName, errSamplingUnsupported)
+		return nil, errSamplingUnsupported
+	}
+
+	logger.Debug("Relaying sampling request from server %s", serverName)
+	result, err := handler(ctx, serverName, params)
+	if err != nil {
+		return nil, fmt.Errorf("sampling request failed: %w", err)
+	}
+	return result, nil
+}
+
+// relaySamplingToFallback forwards a sampling request the client can't answer to the designated fallback server
+func (a *MCPAggregator) relaySamplingToFallback(ctx context.Context, serverName, fallback string, params json.RawMessage) (json.RawMessage, error) {
+	a.mu.RLock()
+	mcpClient, ok := a.clients[fallback]
+	a.mu.RUnlock()
+	if !ok {
+		logger.Error("Rejecting sampling request from server %s: fallback server %s is not connected", serverName, fallback)
+		return nil, fmt.Errorf("%w, and the sampling fallback server %s is not connected", errSamplingUnsupported, fallback)
+	}
+	sampler, ok := mcpClient.(samplerClient)
+	if !ok {
+		logger.Error("Rejecting sampling request from server %s: fallback server %s can't receive sampling requests", serverName, fallback)
+		return nil, fmt.Errorf("%w, and the sampling fallback server %s can't receive sampling requests", errSamplingUnsupported, fallback)
+	}
+
+	logger.Debug("Relaying sampling request from server %s to fallback server %s", serverName, fallback)
+	result, err := sampler.CreateMessage(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("sampling request to fallback server %s failed: %w", fallback, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,243 @@
+package aggregator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/config"
+)
+
+// upstreamHelperEnvVar makes the test binary act as an upstream server that requests sampling
+const upstreamHelperEnvVar = "COMBINE_MCP_SAMPLING_UPSTREAM"
+
+// TestSamplingUpstreamHelper is not a real test, it is the upstream server run by TestSamplingRelay.
+// On tools/call it asks the client for sampling and answers with the sampled text.
+func TestSamplingUpstreamHelper(t *testing.T) {
+	if os.Getenv(upstreamHelperEnvVar) != "1" {
+		return
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	write := func(message interface{}) {
+		data, _ := json.Marshal(message)
+		fmt.Fprintln(os.Stdout, string(data))
+	}
+	read := func() rpcMessage {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			os.Exit(0)
+		}
+		var message rpcMessage
+		json.Unmarshal(line, &message)
+		return message
+	}
+
+	for {
+		message := read()
+		switch message.Method {
+		case "initialize":
+			write(map[string]interface{}{"jsonrpc": "2.0", "id": message.ID, "result": map[string]interface{}{
+				"protocolVersion": mcp.LATEST_PROTOCOL_VERSION,
+				"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+				"serverInfo":      map[string]interface{}{"name": "sampling-upstream", "version": "1.0.0"},
+			}})
+		case "tools/list":
+			write(map[string]interface{}{"jsonrpc": "2.0", "id": message.ID, "result": map[string]interface{}{
+				"tools": []interface{}{map[string]interface{}{"name": "ask", "inputSchema": map[string]interface{}{"type": "object"}}},
+			}})
+		case "tools/call":
+			// The sampling request uses an id of the upstream's own choosing
+			write(map[string]interface{}{"jsonrpc": "2.0", "id": "sampling-1", "method": MethodCreateMessage, "params": map[string]interface{}{
+				"messages":  []interface{}{map[string]interface{}{"role": "user", "content": map[string]interface{}{"type": "text", "text": "Say hi"}}},
+				"maxTokens": 10,
+			}})
+
+			response := read()
+			for string(response.ID) != `"sampling-1"` {
+				response = read()
+			}
+
+			text := "no result"
+			isError := false
+			if response.Error != nil {
+				text = response.Error.Message
+				isError = true
+			} else {
+				var result struct {
+					Content struct {
+						Text string `json:"text"`
+					} `json:"content"`
+				}
+				json.Unmarshal(response.Result, &result)
+				text = result.Content.Text
+			}
+			write(map[string]interface{}{"jsonrpc": "2.0", "id": message.ID, "result": map[string]interface{}{
+				"content": []interface{}{map[string]interface{}{"type": "text", "text": text}},
+				"isError": isError,
+			}})
+		}
+	}
+}
+
+func TestSamplingRelay(t *testing.T) {
+	agg := NewMCPAggregator()
+
+	var mu sync.Mutex
+	var relayedFrom string
+	var relayedParams json.RawMessage
+	relayed := func() (string, json.RawMessage) {
+		mu.Lock()
+		defer mu.Unlock()
+		return relayedFrom, relayedParams
+	}
+	agg.SetSamplingHandler(func(ctx context.Context, serverName string, params json.RawMessage) (json.RawMessage, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		relayedFrom = serverName
+		relayedParams = params
+		return json.RawMessage(`{"role":"assistant","content":{"type":"text","text":"Hi!"},"model":"test-model"}`), nil
+	})
+
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{
+				Name:    "upstream",
+				Command: os.Args[0],
+				Args:    []string{"-test.run=^TestSamplingUpstreamHelper$"},
+				Env:     map[string]string{upstreamHelperEnvVar: "1"},
+			},
+		},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	defer agg.Close()
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "upstream_ask"
+
+	result, err := agg.CallTool(context.Background(), request)
+	if err != nil {
+		t.Fatalf("CallTool() error = %v", err)
+	}
+	if text, ok := mcp.AsTextContent(result.Content[0]); !ok || text.Text != "Hi!" || result.IsError {
+		t.Errorf("CallTool() result = %+v, want the sampled text", result)
+	}
+	from, params := relayed()
+	if from != "upstream" {
+		t.Errorf("Sampling relayed from %q, want %q", from, "upstream")
+	}
+	if !strings.Contains(string(params), "Say hi") {
+		t.Errorf("Relayed params = %s, want the upstream's messages", params)
+	}
+
+	// Once the client turns out not to support sampling, requests are rejected without reaching it
+	mu.Lock()
+	relayedFrom = ""
+	mu.Unlock()
+	agg.SetClientSamplingSupport(false)
+	result, err = agg.CallTool(context.Background(), request)
+	if err != nil {
+		t.Fatalf("CallTool() error = %v", err)
+	}
+	if !result.IsError {
+		t.Errorf("CallTool() IsError = false, want the sampling rejection")
+	}
+	if from, _ := relayed(); from != "" {
+		t.Errorf("Sampling was relayed to a client without sampling support")
+	}
+}
+
+// samplingMockClient is a mock server that requests sampling through the handler the aggregator sets
+type samplingMockClient struct {
+	MockClient
+	handler func(ctx context.Context, params json.RawMessage) (json.RawMessage, error)
+}
+
+func (m *samplingMockClient) SetSamplingHandler(handler func(ctx context.Context, params json.RawMessage) (json.RawMessage, error)) {
+	m.handler = handler
+}
+
+// samplerMockClient is a mock server that answers sampling requests
+type samplerMockClient struct {
+	samplingMockClient
+	requests []json.RawMessage
+}
+
+func (m *samplerMockClient) CreateMessage(ctx context.Context, params json.RawMessage) (json.RawMessage, error) {
+	m.requests = append(m.requests, params)
+	return json.RawMessage(`{"role":"assistant","content":{"type":"text","text":"From the fallback"},"model":"fallback-model"}`), nil
+}
+
+func TestSamplingFallback(t *testing.T) {
+	upstream := &samplingMockClient{}
+	llm := &samplerMockClient{}
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		if serverCfg.Name == "llm" {
+			return llm, nil
+		}
+		return upstream, nil
+	}))
+
+	var clientRequests int
+	agg.SetSamplingHandler(func(ctx context.Context, serverName string, params json.RawMessage) (json.RawMessage, error) {
+		clientRequests++
+		return json.RawMessage(`{"role":"assistant","content":{"type":"text","text":"From the client"},"model":"client-model"}`), nil
+	})
+
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{Name: "upstream", Command: "test-command"},
+			{Name: "llm", Command: "test-command"},
+		},
+		SamplingFallback: "llm",
+		LogLevel:         config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	defer agg.Close()
+	if upstream.handler == nil {
+		t.Fatal("No sampling handler set on the upstream client")
+	}
+
+	params := json.RawMessage(`{"messages":[{"role":"user","content":{"type":"text","text":"Say hi"}}],"maxTokens":10}`)
+
+	// The client answers sampling requests while it supports sampling
+	result, err := upstream.handler(context.Background(), params)
+	if err != nil {
+		t.Fatalf("Sampling error = %v", err)
+	}
+	if !strings.Contains(string(result), "From the client") || clientRequests != 1 || len(llm.requests) != 0 {
+		t.Errorf("Sampling result = %s, want it answered by the client", result)
+	}
+
+	// Once the client turns out not to support sampling, the fallback server answers
+	agg.SetClientSamplingSupport(false)
+	result, err = upstream.handler(context.Background(), params)
+	if err != nil {
+		t.Fatalf("Sampling error = %v", err)
+	}
+	if !strings.Contains(string(result), "From the fallback") || clientRequests != 1 {
+		t.Errorf("Sampling result = %s, want it answered by the fallback server", result)
+	}
+	if len(llm.requests) != 1 || string(llm.requests[0]) != string(params) {
+		t.Errorf("Fallback server received %s, want the upstream's params", llm.requests)
+	}
+
+	// The fallback server's own requests aren't sent back to it
+	if _, err := llm.handler(context.Background(), params); err == nil {
+		t.Error("Sampling request of the fallback server succeeded, want it rejected")
+	}
+	if len(llm.requests) != 1 {
+		t.Errorf("Fallback server received %d requests, want its own request not sent back to it", len(llm.requests))
+	}
+}
@@ -0,0 +1,60 @@
+package aggregator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nazar256/combine-mcp/pkg/logger"
+)
+
+// errorMarkers are the words that make a line of a server's stderr logged as an error
+var errorMarkers = []string{"error", "fatal", "panic", "exception"}
+
+// forwardStderr returns the write end of a pipe to pass as the stderr of a server process.
+// Every line written to it is logged tagged with the server name, until all writers have closed it.
+func forwardStderr(serverName string) (*os.File, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
+	}
+	go logStderr(serverName, reader)
+	return writer, nil
+}
+
+// logStderr logs the lines read from the stderr of a server: lines that look like errors as errors,
+// and the rest at the debug level, so servers stay quiet unless something is wrong
+func logStderr(serverName string, stderr io.ReadCloser) {
+	defer stderr.Close()
+
+	reader := bufio.NewReader(stderr)
+	for {
+		line, err := reader.ReadString('\n')
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			if isErrorLine(line) {
+				logger.Error("[%s] %s", serverName, line)
+			} else {
+				logger.Debug("[%s] %s", serverName, line)
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				logger.Error("Error reading stderr of server %s: %v", serverName, err)
+			}
+			return
+		}
+	}
+}
+
+// isErrorLine reports whether a line of a server's stderr reports an error
+func isErrorLine(line string) bool {
+	lower := strings.ToLower(line)
+	for _, marker := range errorMarkers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package aggregator
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nazar256/combine-mcp/pkg/config"
+	"github.com/nazar256/combine-mcp/pkg/logger"
+)
+
+// stderrHelperEnvVar makes the test binary start a server writing to stderr, logging to the file it names
+const stderrHelperEnvVar = "COMBINE_MCP_STDERR_LOG"
+
+// TestServerStderrHelper is not a real test, it is the process whose log TestServerStderr inspects
+func TestServerStderrHelper(t *testing.T) {
+	logPath := os.Getenv(stderrHelperEnvVar)
+	if logPath == "" {
+		return
+	}
+	if err := logger.Init(config.LogLevelDebug, logPath); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+	defer logger.Close()
+
+	mcpClient, err := newStdioMCPClient(config.ServerConfig{
+		Name:    "noisy",
+		Command: "sh",
+		Args:    []string{"-c", "echo starting up >&2; echo 'Error: connection refused' >&2; printf 'no newline' >&2"},
+	})
+	if err != nil {
+		t.Fatalf("newStdioMCPClient() error = %v", err)
+	}
+	<-mcpClient.(*stdioClient).done
+
+	// The lines are logged as they are read from the pipe, which may take a moment after the process exited
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if logged, _ := os.ReadFile(logPath); strings.Contains(string(logged), "[noisy] no newline") {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("The stderr of the server wasn't logged")
+}
+
+func TestServerStderr(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	logPath := filepath.Join(t.TempDir(), "combine-mcp.log")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestServerStderrHelper$")
+	cmd.Env = append(os.Environ(), stderrHelperEnvVar+"="+logPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Helper process failed: %v\n%s", err, output)
+	}
+
+	logged, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	wantLines := map[string]string{
+		"[noisy] starting up":               "DEBUG: ",
+		"[noisy] Error: connection refused": "ERROR: ",
+		"[noisy] no newline":                "DEBUG: ",
+	}
+	for _, line := range strings.Split(string(logged), "\n") {
+		for suffix, prefix := range wantLines {
+			if strings.HasSuffix(line, suffix) {
+				if !strings.HasPrefix(line, prefix) {
+					t.Errorf("Logged %q, want it logged with %q", line, prefix)
+				}
+				delete(wantLines, suffix)
+			}
+		}
+	}
+	for suffix := range wantLines {
+		t.Errorf("Log doesn't contain %q:\n%s", suffix, logged)
+	}
+}
@@ -0,0 +1,524 @@
+package aggregator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/logger"
+)
+
+// defaultKillWait bounds how long Close waits for the output of a killed server to end.
+// Processes that left the process group of the server may keep it open indefinitely.
+const defaultKillWait = 5 * time.Second
+
+// errProcessExited is returned for requests that can't be answered because the server process is gone
+var errProcessExited = errors.New("server process exited")
+
+// RPCError is the error object of a JSON-RPC response.
+// Requests a server answers with an error fail with it, so callers can tell its code and data.
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// Error returns the message of the server along with its code
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// rpcMessage is any JSON-RPC message received from a server
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// rpcResponse is the outcome of a request sent to the server
+type rpcResponse struct {
+	result json.RawMessage
+	err    error
+}
+
+// stdioClient is an MCP client that talks to a subprocess over stdio.
+// Unlike the mcp-go stdio client it owns the exec.Cmd, so it can report when the process exits.
+type stdioClient struct {
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	rawStdout io.ReadCloser // Closed by Close if the output of a killed process doesn't end
+	stdout    *bufio.Reader
+	requestID atomic.Int64
+
+	mu              sync.Mutex
+	responses       map[int64]chan rpcResponse
+	samplingHandler func(ctx context.Context, params json.RawMessage) (json.RawMessage, error)
+	rootsHandler    func(ctx context.Context, params json.RawMessage) (json.RawMessage, error)
+	notifications   []func(notification mcp.JSONRPCNotification)
+	writeMu         sync.Mutex
+
+	done    chan struct{} // Closed once the process has exited
+	exitErr error
+	stderr  *os.File // Receives the stderr of the process, closed once it has exited
+
+	name          string        // Name of the server, for logging
+	shutdownGrace time.Duration // Time the process gets to exit after SIGTERM before it is killed
+	killWait      time.Duration // Time the output of the killed process gets to end before it is closed
+
+	initOptions map[string]interface{} // Sent as initializationOptions in the initialize request
+}
+
+// newStdioClient starts the command and returns a client connected to its stdin/stdout.
+// The file the command's stderr goes to, if any, is closed once the process has exited.
+func newStdioClient(name string, cmd *exec.Cmd, stderr *os.File, shutdownGrace time.Duration) (*stdioClient, error) {
+	started := false
+	defer func() {
+		if !started && stderr != nil {
+			stderr.Close()
+		}
+	}()
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+
+	// Signals reach the whole process group, not only a wrapper like npx
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start command: %w", err)
+	}
+	started = true
+
+	c := &stdioClient{
+		cmd:           cmd,
+		stdin:         stdin,
+		rawStdout:     stdout,
+		stdout:        bufio.NewReader(stdout),
+		responses:     make(map[int64]chan rpcResponse),
+		done:          make(chan struct{}),
+		stderr:        stderr,
+		name:          name,
+		shutdownGrace: shutdownGrace,
+		killWait:      defaultKillWait,
+	}
+	go c.readMessages()
+
+	return c, nil
+}
+
+// Done returns a channel that is closed once the server process has exited
+func (c *stdioClient) Done() <-chan struct{} {
+	return c.done
+}
+
+// ExitErr returns the error the server process exited with, or nil for a clean exit.
+// It is only meaningful after Done is closed.
+func (c *stdioClient) ExitErr() error {
+	<-c.done
+	return c.exitErr
+}
+
+// readMessages routes messages from the server until its stdout is closed, then reaps the process
+func (c *stdioClient) readMessages() {
+	for {
+		line, err := c.stdout.ReadBytes('\n')
+		if len(line) > 0 {
+			c.handleMessage(line)
+		}
+		if err != nil {
+			// Close gives up on the output of a process that won't end by closing it
+			if err != io.EOF && !errors.Is(err, os.ErrClosed) {
+				logger.Error("Error reading from server process: %v", err)
+			}
+			break
+		}
+	}
+
+	// Stdout is closed, so all output has been consumed and the process can be reaped
+	c.exitErr = c.cmd.Wait()
+	if c.stderr != nil {
+		c.stderr.Close()
+	}
+
+	// Fail every request that is still waiting for an answer
+	c.mu.Lock()
+	for id, ch := range c.responses {
+		ch <- rpcResponse{err: errProcessExited}
+		delete(c.responses, id)
+	}
+	c.mu.Unlock()
+
+	close(c.done)
+}
+
+// handleMessage dispatches a single message received from the server
+func (c *stdioClient) handleMessage(line []byte) {
+	var message rpcMessage
+	if err := json.Unmarshal(line, &message); err != nil {
+		logger.Debug("Ignoring non-JSON output from server process: %s", line)
+		return
+	}
+
+	// Requests initiated by the server carry both a method and an id
+	if message.Method != "" {
+		if len(message.ID) > 0 {
+			c.handleServerRequest(message)
+		} else {
+			logger.Debug("Received notification from server process: %s", message.Method)
+			c.handleNotification(line)
+		}
+		return
+	}
+
+	var id int64
+	if err := json.Unmarshal(message.ID, &id); err != nil {
+		logger.Debug("Ignoring response with unknown id %s", message.ID)
+		return
+	}
+
+	c.mu.Lock()
+	ch, exists := c.responses[id]
+	delete(c.responses, id)
+	c.mu.Unlock()
+
+	if !exists {
+		return
+	}
+	if message.Error != nil {
+		ch <- rpcResponse{err: message.Error}
+		return
+	}
+	ch <- rpcResponse{result: message.Result}
+}
+
+// OnNotification registers a handler that is called for every notification from the server
+func (c *stdioClient) OnNotification(handler func(notification mcp.JSONRPCNotification)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.notifications = append(c.notifications, handler)
+}
+
+// handleNotification passes a notification from the server to the registered handlers
+func (c *stdioClient) handleNotification(line []byte) {
+	var notification mcp.JSONRPCNotification
+	if err := json.Unmarshal(line, &notification); err != nil {
+		logger.Debug("Ignoring malformed notification from server process: %v", err)
+		return
+	}
+
+	c.mu.Lock()
+	handlers := append([]func(mcp.JSONRPCNotification){}, c.notifications...)
+	c.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(notification)
+	}
+}
+
+// SetSamplingHandler sets the handler that answers sampling requests from the server
+func (c *stdioClient) SetSamplingHandler(handler func(ctx context.Context, params json.RawMessage) (json.RawMessage, error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.samplingHandler = handler
+}
+
+// SetRootsHandler sets the handler that answers roots requests from the server
+func (c *stdioClient) SetRootsHandler(handler func(ctx context.Context, params json.RawMessage) (json.RawMessage, error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rootsHandler = handler
+}
+
+// NotifyRootsChanged tells the server that the roots of the client changed
+func (c *stdioClient) NotifyRootsChanged() error {
+	return c.writeMessage(mcp.JSONRPCNotification{
+		JSONRPC:      mcp.JSONRPC_VERSION,
+		Notification: mcp.Notification{Method: MethodRootsListChanged},
+	})
+}
+
+// handleServerRequest answers requests the server sends to us as its client
+func (c *stdioClient) handleServerRequest(message rpcMessage) {
+	var handler func(ctx context.Context, params json.RawMessage) (json.RawMessage, error)
+	switch message.Method {
+	case string(mcp.MethodPing):
+		c.answer(message, struct{}{}, nil)
+		return
+	case MethodCreateMessage:
+		c.mu.Lock()
+		handler = c.samplingHandler
+		c.mu.Unlock()
+	case MethodListRoots:
+		c.mu.Lock()
+		handler = c.rootsHandler
+		c.mu.Unlock()
+	}
+	if handler == nil {
+		c.answer(message, nil, &RPCError{Code: mcp.METHOD_NOT_FOUND, Message: fmt.Sprintf("method %s not supported", message.Method)})
+		return
+	}
+
+	// Relayed requests wait on the client, so they must not block reading further messages
+	go func() {
+		result, err := handler(context.Background(), message.Params)
+		if err != nil {
+			c.answer(message, nil, &RPCError{Code: mcp.INTERNAL_ERROR, Message: err.Error()})
+			return
+		}
+		c.answer(message, result, nil)
+	}()
+}
+
+// answer sends the response to a request from the server, echoing its id
+func (c *stdioClient) answer(request rpcMessage, result interface{}, rpcErr *RPCError) {
+	response := map[string]interface{}{
+		"jsonrpc": mcp.JSONRPC_VERSION,
+		"id":      request.ID,
+	}
+	if rpcErr != nil {
+		response["error"] = rpcErr
+	} else {
+		response["result"] = result
+	}
+
+	if err := c.writeMessage(response); err != nil {
+		logger.Error("Failed to answer %s request from server process: %v", request.Method, err)
+	}
+}
+
+// writeMessage sends a single JSON-RPC message to the server
+func (c *stdioClient) writeMessage(message interface{}) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.stdin.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	return nil
+}
+
+// sendRequest sends a request to the server and waits for its result
+func (c *stdioClient) sendRequest(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	id := c.requestID.Add(1)
+	ch := make(chan rpcResponse, 1)
+
+	c.mu.Lock()
+	select {
+	case <-c.done:
+		c.mu.Unlock()
+		return nil, errProcessExited
+	default:
+	}
+	c.responses[id] = ch
+	c.mu.Unlock()
+
+	request := mcp.JSONRPCRequest{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		ID:      id,
+		Params:  params,
+		Request: mcp.Request{Method: method},
+	}
+	// Only requests made for a client request are logged, not the aggregator's own pings and discovery
+	if correlationID := CorrelationID(ctx); correlationID != "" {
+		logger.Debug("Sending %s to server %s: id=%d, correlation=%s", method, c.name, id, correlationID)
+	}
+	if err := c.writeMessage(request); err != nil {
+		c.forget(id)
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		c.forget(id)
+		return nil, ctx.Err()
+	case response := <-ch:
+		return response.result, response.err
+	}
+}
+
+// forget stops waiting for the response to a request
+func (c *stdioClient) forget(id int64) {
+	c.mu.Lock()
+	delete(c.responses, id)
+	c.mu.Unlock()
+}
+
+// Initialize performs the MCP initialization handshake
+func (c *stdioClient) Initialize(ctx context.Context, request mcp.InitializeRequest) (*mcp.InitializeResult, error) {
+	// Capabilities must always be present, even if empty
+	params := struct {
+		ProtocolVersion       string                 `json:"protocolVersion"`
+		ClientInfo            mcp.Implementation     `json:"clientInfo"`
+		Capabilities          mcp.ClientCapabilities `json:"capabilities"`
+		InitializationOptions map[string]interface{} `json:"initializationOptions,omitempty"`
+	}{
+		ProtocolVersion:       request.Params.ProtocolVersion,
+		ClientInfo:            request.Params.ClientInfo,
+		Capabilities:          request.Params.Capabilities,
+		InitializationOptions: c.initOptions,
+	}
+
+	response, err := c.sendRequest(ctx, string(mcp.MethodInitialize), params)
+	if err != nil {
+		return nil, err
+	}
+
+	var result mcp.InitializeResult
+	if err := json.Unmarshal(response, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	notification := mcp.JSONRPCNotification{
+		JSONRPC:      mcp.JSONRPC_VERSION,
+		Notification: mcp.Notification{Method: "notifications/initialized"},
+	}
+	if err := c.writeMessage(notification); err != nil {
+		return nil, fmt.Errorf("failed to send initialized notification: %w", err)
+	}
+
+	return &result, nil
+}
+
+// ListTools requests the list of tools from the server
+func (c *stdioClient) ListTools(ctx context.Context, request mcp.ListToolsRequest) (*mcp.ListToolsResult, error) {
+	result, _, err := c.ListToolsWithAnnotations(ctx, request)
+	return result, err
+}
+
+// ListToolsWithAnnotations requests the list of tools from the server, keeping the annotations of the tools
+func (c *stdioClient) ListToolsWithAnnotations(ctx context.Context, request mcp.ListToolsRequest) (*mcp.ListToolsResult, map[string]ToolAnnotations, error) {
+	response, err := c.sendRequest(ctx, string(mcp.MethodToolsList), request.Params)
+	if err != nil {
+		return nil, nil, err
+	}
+	return parseToolsResult(response)
+}
+
+// CallTool invokes a tool on the server
+func (c *stdioClient) CallTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	response, err := c.sendRequest(ctx, string(mcp.MethodToolsCall), request.Params)
+	if err != nil {
+		return nil, err
+	}
+	return mcp.ParseCallToolResult(&response)
+}
+
+// CreateMessage sends a sampling request to the server, for servers designated to answer them
+func (c *stdioClient) CreateMessage(ctx context.Context, params json.RawMessage) (json.RawMessage, error) {
+	return c.sendRequest(ctx, MethodCreateMessage, params)
+}
+
+// ListResources requests the list of resources from the server
+func (c *stdioClient) ListResources(ctx context.Context, request mcp.ListResourcesRequest) (*mcp.ListResourcesResult, error) {
+	response, err := c.sendRequest(ctx, string(mcp.MethodResourcesList), request.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	var result mcp.ListResourcesResult
+	if err := json.Unmarshal(response, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return &result, nil
+}
+
+// ReadResource reads a resource from the server
+func (c *stdioClient) ReadResource(ctx context.Context, request mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+	response, err := c.sendRequest(ctx, string(mcp.MethodResourcesRead), request.Params)
+	if err != nil {
+		return nil, err
+	}
+	return mcp.ParseReadResourceResult(&response)
+}
+
+// ListPrompts requests the list of prompts from the server
+func (c *stdioClient) ListPrompts(ctx context.Context, request mcp.ListPromptsRequest) (*mcp.ListPromptsResult, error) {
+	response, err := c.sendRequest(ctx, string(mcp.MethodPromptsList), request.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	var result mcp.ListPromptsResult
+	if err := json.Unmarshal(response, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return &result, nil
+}
+
+// GetPrompt gets a prompt from the server
+func (c *stdioClient) GetPrompt(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+	response, err := c.sendRequest(ctx, string(mcp.MethodPromptsGet), request.Params)
+	if err != nil {
+		return nil, err
+	}
+	return mcp.ParseGetPromptResult(&response)
+}
+
+// Close ends the server process: its stdin is closed and it is sent SIGTERM,
+// and it is killed if it hasn't exited after the shutdown grace period
+func (c *stdioClient) Close() error {
+	if err := c.stdin.Close(); err != nil {
+		logger.Debug("Failed to close stdin of server %s: %v", c.name, err)
+	}
+	select {
+	case <-c.done:
+		logger.Debug("Server %s process had already exited", c.name)
+		return nil
+	default:
+	}
+
+	// Servers usually exit once their stdin is closed, SIGTERM also stops those that don't watch it
+	if err := signalProcessGroup(c.cmd, syscall.SIGTERM); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		// Not every platform supports SIGTERM
+		logger.Debug("Failed to send SIGTERM to server %s, killing it: %v", c.name, err)
+	} else {
+		timer := time.NewTimer(c.shutdownGrace)
+		defer timer.Stop()
+		select {
+		case <-c.done:
+			logger.Debug("Server %s process exited after SIGTERM", c.name)
+			return nil
+		case <-timer.C:
+			logger.Warn("Server %s process didn't exit within %v of SIGTERM, killing it", c.name, c.shutdownGrace)
+		}
+	}
+
+	if err := signalProcessGroup(c.cmd, syscall.SIGKILL); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("failed to kill server process: %w", err)
+	}
+
+	// A process that escaped the group, e.g. with setsid, may still hold the output open
+	timer := time.NewTimer(c.killWait)
+	defer timer.Stop()
+	select {
+	case <-c.done:
+		logger.Debug("Server %s process killed", c.name)
+	case <-timer.C:
+		logger.Warn("Output of server %s is still open %v after killing it, closing it", c.name, c.killWait)
+		c.rawStdout.Close()
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+package aggregator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/nazar256/combine-mcp/pkg/config"
+	"github.com/nazar256/combine-mcp/pkg/logger"
+)
+
+// errAggregatorClosed is returned when a server can't be started because the aggregator is shutting down
+var errAggregatorClosed = errors.New("aggregator is closed")
+
+// maxRestartBackoff caps the exponential delay between restart attempts
+const maxRestartBackoff = 30 * time.Second
+
+// maxReconnectAttempts bounds how often a tool call respawns an unreachable server before failing
+const maxReconnectAttempts = 3
+
+// exitNotifier is implemented by clients that can report when their server process exits
+type exitNotifier interface {
+	Done() <-chan struct{}
+	ExitErr() error
+}
+
+// restartPolicy holds the effective restart settings of a server
+type restartPolicy struct {
+	mode     string
+	backoff  time.Duration
+	maxBurst int
+	window   time.Duration
+}
+
+// newRestartPolicy applies defaults to the restart settings of a server
+func newRestartPolicy(serverCfg config.ServerConfig) restartPolicy {
+	policy := restartPolicy{
+		mode:     serverCfg.Restart,
+		backoff:  time.Duration(serverCfg.RestartBackoffMs) * time.Millisecond,
+		maxBurst: serverCfg.RestartMaxBurst,
+		window:   time.Duration(serverCfg.RestartWindowSeconds) * time.Second,
+	}
+	if policy.mode == "" {
+		policy.mode = config.RestartNo
+	}
+	if policy.backoff == 0 {
+		policy.backoff = config.DefaultRestartBackoffMs * time.Millisecond
+	}
+	if policy.maxBurst == 0 {
+		policy.maxBurst = config.DefaultRestartMaxBurst
+	}
+	if policy.window == 0 {
+		policy.window = config.DefaultRestartWindowSeconds * time.Second
+	}
+	return policy
+}
+
+// nextRestartBackoff doubles the delay before the next attempt to bring a server back, up to maxRestartBackoff
+func nextRestartBackoff(backoff time.Duration) time.Duration {
+	return min(backoff*2, maxRestartBackoff)
+}
+
+// shouldRestart reports whether a process that exited with exitErr must be restarted
+func (p restartPolicy) shouldRestart(exitErr error) bool {
+	switch p.mode {
+	case config.RestartAlways:
+		return true
+	case config.RestartOnFailure:
+		return exitErr != nil
+	default:
+		return false
+	}
+}
+
+// superviseServer starts watching a server process if its restart policy asks for it
+func (a *MCPAggregator) superviseServer(serverCfg config.ServerConfig, mcpClient MCPClient) {
+	policy := newRestartPolicy(serverCfg)
+	if policy.mode == config.RestartNo {
+		return
+	}
+
+	if _, ok := mcpClient.(exitNotifier); !ok {
+		logger.Debug("Client for server %s can't report process exits, restart policy %s ignored", serverCfg.Name, policy.mode)
+		return
+	}
+
+	go a.supervise(serverCfg, mcpClient, policy)
+}
+
+// supervise restarts a server process whenever it exits, until the restart burst limit is hit
+func (a *MCPAggregator) supervise(serverCfg config.ServerConfig, mcpClient MCPClient, policy restartPolicy) {
+	var restarts []time.Time
+
+	for {
+		notifier, ok := mcpClient.(exitNotifier)
+		if !ok {
+			return
+		}
+
+		select {
+		case <-a.done:
+			return
+		case <-notifier.Done():
+		}
+
+		// The aggregator may have been closed while the process was exiting
+		select {
+		case <-a.done:
+			return
+		default:
+		}
+
+		exitErr := notifier.ExitErr()
+		logger.Error("Server %s exited: %v", serverCfg.Name, exitErr)
+		a.removeServer(serverCfg.Name, mcpClient)
+
+		if !policy.shouldRestart(exitErr) {
+			logger.Info("Not restarting server %s (restart policy: %s)", serverCfg.Name, policy.mode)
+			return
+		}
+
+		mcpClient = a.restartServer(serverCfg, policy, &restarts)
+		if mcpClient == nil {
+			return
+		}
+	}
+}
+
+// restartServer tries to bring a server back with exponential backoff.
+// It returns nil once the burst limit is reached or the aggregator is closed.
+func (a *MCPAggregator) restartServer(serverCfg config.ServerConfig, policy restartPolicy, restarts *[]time.Time) MCPClient {
+	backoff := policy.backoff
+
+	for {
+		// Only restarts within the window count towards the burst limit
+		now := time.Now()
+		recent := (*restarts)[:0]
+		for _, restartTime := range *restarts {
+			if now.Sub(restartTime) < policy.window {
+				recent = append(recent, restartTime)
+			}
+		}
+		*restarts = recent
+
+		if len(*restarts) >= policy.maxBurst {
+			logger.Error("Server %s restarted %d times within %v, giving up", serverCfg.Name, len(*restarts), policy.window)
+			return nil
+		}
+
+		logger.Info("Restarting server %s in %v", serverCfg.Name, backoff)
+		select {
+		case <-a.done:
+			return nil
+		case <-time.After(backoff):
+		}
+
+		*restarts = append(*restarts, time.Now())
+		mcpClient, err := a.startServer(serverCfg)
+		if err == nil {
+			logger.Info("Server %s restarted", serverCfg.Name)
+			a.notifyToolsChanged()
+			return mcpClient
+		}
+
+		logger.Error("Failed to restart server %s: %v", serverCfg.Name, err)
+		backoff = nextRestartBackoff(backoff)
+	}
+}
+
+// startInBackground keeps trying to start a server that failed to start initially, with exponential backoff.
+// Once it is up, its tools are announced and it is supervised and health checked like any other server.
+func (a *MCPAggregator) startInBackground(serverCfg config.ServerConfig) {
+	backoff := newRestartPolicy(serverCfg).backoff
+
+	go func() {
+		for {
+			logger.Info("Retrying to start server %s in %v", serverCfg.Name, backoff)
+			select {
+			case <-a.done:
+				return
+			case <-time.After(backoff):
+			}
+
+			mcpClient, err := a.startServer(serverCfg)
+			if errors.Is(err, errAggregatorClosed) {
+				return
+			}
+			if err == nil {
+				logger.Info("Server %s started", serverCfg.Name)
+				a.notifyToolsChanged()
+				a.superviseServer(serverCfg, mcpClient)
+				a.monitorHealth(serverCfg)
+				return
+			}
+
+			logger.Error("Failed to start server %s: %v", serverCfg.Name, err)
+			backoff = nextRestartBackoff(backoff)
+		}
+	}()
+}
+
+// startServer creates, initializes and registers a client for a server outside of the initial startup
+func (a *MCPAggregator) startServer(serverCfg config.ServerConfig) (MCPClient, error) {
+	mcpClient, err := a.clientFactory(serverCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	initResult, err := a.initializeReady(context.Background(), serverCfg, mcpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	// Don't register a new client if the aggregator was closed in the meantime
+	a.mu.Lock()
+	select {
+	case <-a.done:
+		a.mu.Unlock()
+		mcpClient.Close()
+		return nil, errAggregatorClosed
+	default:
+	}
+	a.clients[serverCfg.Name] = mcpClient
+	a.infos[serverCfg.Name] = initResult.ServerInfo
+	a.caps[serverCfg.Name] = initResult.Capabilities
+	a.mu.Unlock()
+
+	if err := a.discoverTools(context.Background(), serverCfg.Name); err != nil {
+		logger.Error("Failed to discover tools for server %s: %v", serverCfg.Name, err)
+	}
+	a.discoverServerResources(context.Background(), serverCfg.Name, initResult)
+	a.discoverServerPrompts(context.Background(), serverCfg.Name, initResult)
+	return mcpClient, nil
+}
+
+// isBrokenClientError reports whether a call failed because the server process can't be reached anymore
+func isBrokenClientError(err error) bool {
+	return errors.Is(err, errProcessExited) ||
+		errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, os.ErrClosed) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// reconnectsOnCall reports whether a tool call may respawn the server.
+// Servers with a restart policy are left to their supervisor, so the two never race.
+func (a *MCPAggregator) reconnectsOnCall(serverName string) bool {
+	a.mu.RLock()
+	serverCfg := a.configs[serverName]
+	a.mu.RUnlock()
+	return serverCfg != nil && newRestartPolicy(*serverCfg).mode == config.RestartNo
+}
+
+// reconnect respawns a server from its stored config and rediscovers its tools.
+// A server whose process is still running, e.g. because a concurrent call already reconnected it, is kept.
+func (a *MCPAggregator) reconnect(serverName string) error {
+	a.reconnectMu.Lock()
+	defer a.reconnectMu.Unlock()
+
+	a.mu.RLock()
+	serverCfg := a.configs[serverName]
+	oldClient := a.clients[serverName]
+	a.mu.RUnlock()
+
+	if serverCfg == nil {
+		return fmt.Errorf("no config stored for server %s", serverName)
+	}
+
+	if notifier, ok := oldClient.(exitNotifier); ok {
+		select {
+		case <-notifier.Done():
+		default:
+			logger.Debug("Server %s is running, not reconnecting", serverName)
+			return nil
+		}
+	}
+	if oldClient != nil {
+		oldClient.Close()
+	}
+
+	if _, err := a.startServer(*serverCfg); err != nil {
+		return err
+	}
+	logger.Info("Server %s reconnected", serverName)
+	a.notifyToolsChanged()
+	return nil
+}
+
+// removeServer unregisters the client and tools of a server whose process is gone
+func (a *MCPAggregator) removeServer(serverName string, mcpClient MCPClient) {
+	a.mu.Lock()
+	if a.clients[serverName] == mcpClient {
+		delete(a.clients, serverName)
+	}
+	a.mu.Unlock()
+
+	a.replaceServerTools(serverName, nil)
+	a.replaceServerResources(serverName, nil)
+	a.replaceServerPrompts(serverName, nil)
+	a.notifyToolsChanged()
+}
@@ -0,0 +1,381 @@
+package aggregator
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/config"
+)
+
+// crashingClient is a mock client whose server process can be made to exit
+type crashingClient struct {
+	MockClient
+	done     chan struct{}
+	exitErr  error
+	exitOnce sync.Once
+}
+
+func newCrashingClient() *crashingClient {
+	return &crashingClient{
+		MockClient: MockClient{Tools: []mcp.Tool{{Name: "tool1", Description: "Tool 1"}}},
+		done:       make(chan struct{}),
+	}
+}
+
+func (c *crashingClient) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *crashingClient) ExitErr() error {
+	<-c.done
+	return c.exitErr
+}
+
+func (c *crashingClient) crash(err error) {
+	c.exitOnce.Do(func() {
+		c.exitErr = err
+		close(c.done)
+	})
+}
+
+func (c *crashingClient) CallTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	select {
+	case <-c.done:
+		return nil, errProcessExited
+	default:
+		return c.MockClient.CallTool(ctx, request)
+	}
+}
+
+func (c *crashingClient) Close() error {
+	c.crash(nil)
+	return nil
+}
+
+// waitFor polls until the condition holds or fails the test after a timeout
+func waitFor(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", description)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRestartPolicy(t *testing.T) {
+	var mu sync.Mutex
+	var created []*crashingClient
+	clientAt := func(i int) *crashingClient {
+		mu.Lock()
+		defer mu.Unlock()
+		if i >= len(created) {
+			return nil
+		}
+		return created[i]
+	}
+	createdCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(created)
+	}
+
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		c := newCrashingClient()
+		mu.Lock()
+		created = append(created, c)
+		mu.Unlock()
+		return c, nil
+	}))
+
+	var changes int
+	var changesMu sync.Mutex
+	agg.OnToolsChanged(func() {
+		changesMu.Lock()
+		changes++
+		changesMu.Unlock()
+	})
+
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{
+				Name:                 "flaky",
+				Command:              "test-command",
+				Restart:              config.RestartOnFailure,
+				RestartBackoffMs:     1,
+				RestartMaxBurst:      2,
+				RestartWindowSeconds: 60,
+			},
+		},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	defer agg.Close()
+
+	currentClient := func() MCPClient {
+		agg.mu.RLock()
+		defer agg.mu.RUnlock()
+		return agg.clients["flaky"]
+	}
+
+	// Each crash within the burst limit brings up a fresh client with its tools
+	for restart := 1; restart <= 2; restart++ {
+		clientAt(restart - 1).crash(errors.New("exit status 1"))
+		waitFor(t, "server restart", func() bool {
+			c := clientAt(restart)
+			return c != nil && currentClient() == c && agg.ToolCount() == 1
+		})
+
+		request := mcp.CallToolRequest{}
+		request.Params.Name = "flaky_tool1"
+		if _, err := agg.CallTool(context.Background(), request); err != nil {
+			t.Fatalf("CallTool() after restart %d error = %v", restart, err)
+		}
+	}
+
+	// The next crash exceeds the burst limit, so the server stays down
+	clientAt(2).crash(errors.New("exit status 1"))
+	waitFor(t, "server removal", func() bool {
+		return currentClient() == nil && agg.ToolCount() == 0
+	})
+	time.Sleep(20 * time.Millisecond)
+	if got := createdCount(); got != 3 {
+		t.Errorf("Created %d clients, want 3 (initial + 2 restarts)", got)
+	}
+
+	changesMu.Lock()
+	defer changesMu.Unlock()
+	if changes < 3 {
+		t.Errorf("Got %d tools-changed notifications, want at least 3", changes)
+	}
+}
+
+func TestRestartPolicyOnCleanExit(t *testing.T) {
+	policy := newRestartPolicy(config.ServerConfig{Restart: config.RestartOnFailure})
+	if policy.shouldRestart(nil) {
+		t.Errorf("on-failure policy restarts after a clean exit")
+	}
+	if !policy.shouldRestart(errors.New("exit status 1")) {
+		t.Errorf("on-failure policy doesn't restart after a failure")
+	}
+
+	policy = newRestartPolicy(config.ServerConfig{Restart: config.RestartAlways})
+	if !policy.shouldRestart(nil) {
+		t.Errorf("always policy doesn't restart after a clean exit")
+	}
+
+	policy = newRestartPolicy(config.ServerConfig{})
+	if policy.shouldRestart(errors.New("exit status 1")) {
+		t.Errorf("default policy restarts after a failure")
+	}
+}
+
+func TestReconnectOnCall(t *testing.T) {
+	var mu sync.Mutex
+	var created []*crashingClient
+	var failCreate bool
+	var attemptTimes []time.Time
+
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		attemptTimes = append(attemptTimes, time.Now())
+		if failCreate {
+			return nil, errors.New("command not found")
+		}
+		c := newCrashingClient()
+		created = append(created, c)
+		return c, nil
+	}))
+
+	var changes int
+	agg.OnToolsChanged(func() { changes++ })
+
+	cfg := &config.Config{
+		Servers:  []config.ServerConfig{{Name: "flaky", Command: "test-command", RestartBackoffMs: 5}},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	defer agg.Close()
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "flaky_tool1"
+
+	// A call to a dead server respawns it and is retried on the new process
+	created[0].crash(errors.New("exit status 1"))
+	if _, err := agg.CallTool(context.Background(), request); err != nil {
+		t.Fatalf("CallTool() after crash error = %v", err)
+	}
+	if len(created) != 2 {
+		t.Fatalf("Created %d clients, want 2", len(created))
+	}
+	if len(created[1].Calls) != 1 {
+		t.Errorf("Retried call was not forwarded to the new client")
+	}
+	if changes != 1 {
+		t.Errorf("Tools changed %d times, want 1", changes)
+	}
+
+	// Reconnecting a running server keeps it
+	if err := agg.reconnect("flaky"); err != nil {
+		t.Fatalf("reconnect() error = %v", err)
+	}
+	if len(created) != 2 {
+		t.Errorf("reconnect() replaced a running server")
+	}
+
+	// When the server can't be respawned, the call fails after a bounded number of attempts
+	mu.Lock()
+	failCreate = true
+	attemptTimes = nil
+	mu.Unlock()
+	created[1].crash(errors.New("exit status 1"))
+	if _, err := agg.CallTool(context.Background(), request); !errors.Is(err, errProcessExited) {
+		t.Errorf("CallTool() error = %v, want %v", err, errProcessExited)
+	}
+	if len(attemptTimes) != maxReconnectAttempts {
+		t.Fatalf("Reconnect attempts = %d, want %d", len(attemptTimes), maxReconnectAttempts)
+	}
+
+	// The attempts are spaced by the restart backoff, doubling after every failure
+	wantDelay := 5 * time.Millisecond
+	for i := 1; i < len(attemptTimes); i++ {
+		if delay := attemptTimes[i].Sub(attemptTimes[i-1]); delay < wantDelay {
+			t.Errorf("Delay before attempt %d = %v, want at least %v", i+1, delay, wantDelay)
+		}
+		wantDelay *= 2
+	}
+}
+
+func TestAllowEmptyStart(t *testing.T) {
+	var mu sync.Mutex
+	available := false
+	newAggregator := func() *MCPAggregator {
+		return NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if !available {
+				return &flakyInitClient{failures: 1}, nil
+			}
+			return newCrashingClient(), nil
+		}))
+	}
+	cfg := &config.Config{
+		Servers:  []config.ServerConfig{{Name: "late", Command: "test-command", RestartBackoffMs: 5}},
+		LogLevel: config.LogLevelError,
+	}
+
+	// Without the option an aggregator without servers fails to start
+	strict := newAggregator()
+	if err := strict.Initialize(context.Background(), cfg); err == nil {
+		t.Fatalf("Initialize() without servers error = nil, want an error")
+	}
+	strict.Close()
+
+	cfg.AllowEmptyStart = true
+	agg := newAggregator()
+	defer agg.Close()
+	changes := make(chan struct{}, 10)
+	agg.OnToolsChanged(func() { changes <- struct{}{} })
+
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() with empty start allowed error = %v", err)
+	}
+	if agg.ServerCount() != 0 || agg.ToolCount() != 0 {
+		t.Fatalf("Started with %d servers and %d tools, want none", agg.ServerCount(), agg.ToolCount())
+	}
+
+	// The server is retried in the background and its tools are announced once it comes up
+	mu.Lock()
+	available = true
+	mu.Unlock()
+	waitFor(t, "the server to start in the background", func() bool {
+		return agg.ToolCount() == 1
+	})
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Tools changed callback not invoked after the server started")
+	}
+	if got := agg.ServerCount(); got != 1 {
+		t.Errorf("ServerCount() = %d, want 1", got)
+	}
+}
+
+// warmingUpClient is a crashing client whose readiness tool fails until the client is marked ready
+type warmingUpClient struct {
+	*crashingClient
+	ready bool
+}
+
+func (c *warmingUpClient) CallTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	if request.Params.Name == "ping_db" && !c.ready {
+		result := mcp.NewToolResultText("database not connected")
+		result.IsError = true
+		return result, nil
+	}
+	return c.crashingClient.CallTool(ctx, request)
+}
+
+func TestReadinessOnReconnect(t *testing.T) {
+	var mu sync.Mutex
+	var created []*warmingUpClient
+	ready := true
+
+	agg := NewMCPAggregator(WithClientFactory(func(serverCfg config.ServerConfig) (MCPClient, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		c := &warmingUpClient{crashingClient: newCrashingClient(), ready: ready}
+		created = append(created, c)
+		return c, nil
+	}))
+	agg.readinessInterval = 5 * time.Millisecond
+	agg.readinessTimeout = 30 * time.Millisecond
+
+	cfg := &config.Config{
+		Servers:  []config.ServerConfig{{Name: "postgres", Command: "test-command", ReadinessTool: "ping_db"}},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	defer agg.Close()
+
+	// A respawned server that never gets ready isn't registered again
+	mu.Lock()
+	ready = false
+	mu.Unlock()
+	created[0].crash(errors.New("exit status 1"))
+	if err := agg.reconnect("postgres"); err == nil || !strings.Contains(err.Error(), "readiness tool ping_db") {
+		t.Fatalf("reconnect() error = %v, want a readiness error", err)
+	}
+	if agg.clients["postgres"] == created[1] {
+		t.Errorf("Server that isn't ready was registered")
+	}
+	select {
+	case <-created[1].done:
+	default:
+		t.Errorf("Client of the server that isn't ready wasn't closed")
+	}
+
+	// Once the readiness tool works, the respawned server is used
+	mu.Lock()
+	ready = true
+	mu.Unlock()
+	if err := agg.reconnect("postgres"); err != nil {
+		t.Fatalf("reconnect() error = %v", err)
+	}
+	if agg.clients["postgres"] != created[2] {
+		t.Errorf("Ready server wasn't registered")
+	}
+}
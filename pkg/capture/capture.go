@@ -0,0 +1,54 @@
+// Package capture keeps stray output off stdout, which carries the JSON-RPC messages of the stdio transport
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DefaultBufferSize is the size of the buffer stray output is copied with if none is given
+const DefaultBufferSize = 4096
+
+// Stdout replaces os.Stdout with a pipe whose output is copied to stderr, reading it in chunks of
+// bufferSize bytes, or DefaultBufferSize if it's not positive. The returned function restores os.Stdout once everything written so far is copied.
+func Stdout(bufferSize int) (restore func(), err error) {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+
+	original := os.Stdout
+	stderr := os.Stderr
+	os.Stdout = writer
+
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		defer reader.Close()
+		buffer := make([]byte, bufferSize)
+		for {
+			n, err := reader.Read(buffer)
+			if n > 0 {
+				stderr.Write(buffer[:n])
+			}
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					fmt.Fprintf(stderr, "Error reading from stdout pipe: %v\n", err)
+				}
+				return
+			}
+		}
+	}()
+
+	return func() {
+		writer.Close()
+		<-copied
+		os.Stdout = original
+	}, nil
+}
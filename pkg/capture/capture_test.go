@@ -0,0 +1,42 @@
+package capture
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestStdout(t *testing.T) {
+	original := os.Stdout
+	stderrReader, stderrWriter, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	originalStderr := os.Stderr
+	os.Stderr = stderrWriter
+	defer func() { os.Stderr = originalStderr }()
+
+	// A buffer smaller than the output is read in several chunks
+	restore, err := Stdout(4)
+	if err != nil {
+		t.Fatalf("Stdout() error = %v", err)
+	}
+	if os.Stdout == original {
+		t.Fatal("Stdout() didn't replace os.Stdout")
+	}
+	fmt.Fprint(os.Stdout, "stray output")
+	restore()
+	stderrWriter.Close()
+
+	if os.Stdout != original {
+		t.Errorf("restore() didn't restore os.Stdout")
+	}
+	captured, err := io.ReadAll(stderrReader)
+	if err != nil {
+		t.Fatalf("Failed to read stderr: %v", err)
+	}
+	if string(captured) != "stray output" {
+		t.Errorf("Stderr = %q, want the output written to stdout", captured)
+	}
+}
@@ -3,26 +3,84 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
 const (
 	// DefaultEnvVar is the environment variable that contains the path to the config file
 	DefaultEnvVar = "MCP_CONFIG"
+	// StdinConfigPath as the path of the config file reads the config from stdin
+	StdinConfigPath = "-"
+	// ConfigJSONEnvVar is the environment variable that contains the config itself, taking precedence over the config file
+	ConfigJSONEnvVar = "MCP_CONFIG_JSON"
 	// LogLevelEnvVar is the environment variable that controls logging level
 	LogLevelEnvVar = "MCP_LOG_LEVEL"
 	// LogToFileEnvVar is the environment variable that specifies log file path
 	LogToFileEnvVar = "MCP_LOG_FILE"
+	// LogMaxSizeEnvVar is the environment variable that sets the size in MB at which the log file is rotated
+	LogMaxSizeEnvVar = "MCP_LOG_MAX_SIZE_MB"
+	// LogMaxBackupsEnvVar is the environment variable that limits how many rotated log files are kept
+	LogMaxBackupsEnvVar = "MCP_LOG_MAX_BACKUPS"
+	// MaintenanceEnvVar is the environment variable that enables maintenance mode
+	MaintenanceEnvVar = "MCP_MAINTENANCE"
+	// MaintenanceMessageEnvVar is the environment variable that overrides the maintenance message
+	MaintenanceMessageEnvVar = "MCP_MAINTENANCE_MESSAGE"
+	// DeadLetterFileEnvVar is the environment variable that specifies where undeliverable responses are logged
+	DeadLetterFileEnvVar = "MCP_DEAD_LETTER_FILE"
+	// DualNamesEnvVar is the environment variable that exposes tools under their unprefixed names as well
+	DualNamesEnvVar = "MCP_DUAL_NAMES"
+	// ReadOnlyEnvVar is the environment variable that only exposes tools annotated as read-only
+	ReadOnlyEnvVar = "MCP_READ_ONLY"
+	// ValidateOnlyEnvVar is the environment variable that makes the aggregator only validate its config and exit
+	ValidateOnlyEnvVar = "MCP_VALIDATE_ONLY"
+	// ListToolsEnvVar is the environment variable that makes the aggregator print the aggregated tools as JSON and exit
+	ListToolsEnvVar = "MCP_LIST_TOOLS"
+	// AuditFileEnvVar is the environment variable that sets the file every tool call is recorded in
+	AuditFileEnvVar = "MCP_AUDIT_FILE"
+	// MetricsAddrEnvVar is the environment variable that sets the listen address of the metrics endpoint
+	MetricsAddrEnvVar = "MCP_METRICS_ADDR"
+	// ServerNameEnvVar is the environment variable that overrides the name the aggregator reports to its client
+	ServerNameEnvVar = "MCP_SERVER_NAME"
+	// ServerVersionEnvVar is the environment variable that overrides the version the aggregator reports to its client
+	ServerVersionEnvVar = "MCP_SERVER_VERSION"
+	// AllowedCommandsEnvVar is the environment variable that restricts the commands servers may be started with
+	AllowedCommandsEnvVar = "MCP_ALLOWED_COMMANDS"
+	// AllowEmptyStartEnvVar is the environment variable that lets the aggregator start without any server and keep starting them in the background
+	AllowEmptyStartEnvVar = "MCP_ALLOW_EMPTY_START"
+	// DisableStdoutCaptureEnvVar is the environment variable that stops stray stdout output from being rerouted to stderr
+	DisableStdoutCaptureEnvVar = "MCP_DISABLE_STDOUT_CAPTURE"
+	// StdoutCaptureBufferEnvVar is the environment variable that sets the buffer size in bytes used to reroute stray stdout output
+	StdoutCaptureBufferEnvVar = "MCP_STDOUT_CAPTURE_BUFFER"
+	// ServeModeEnvVar is the environment variable that selects how the aggregator is served to its client: stdio or http
+	ServeModeEnvVar = "MCP_SERVE_MODE"
+	// ServeAddrEnvVar is the environment variable that sets the listen address of the streamable HTTP transport
+	ServeAddrEnvVar = "MCP_SERVE_ADDR"
+	// AllowedOriginsEnvVar is the environment variable that lists the browser origins allowed to use the streamable HTTP transport
+	AllowedOriginsEnvVar = "MCP_ALLOWED_ORIGINS"
+	// InstructionsEnvVar is the environment variable that overrides the instructions the aggregator gives its client
+	InstructionsEnvVar = "MCP_INSTRUCTIONS"
 )
 
+// DefaultMaintenanceMessage is returned for tool calls while maintenance mode is on and no message is configured
+const DefaultMaintenanceMessage = "This tool is temporarily unavailable due to maintenance. Please try again later."
+
 // LogLevel represents the log verbosity level
 type LogLevel int
 
 const (
 	// LogLevelError only logs errors
 	LogLevelError LogLevel = iota
-	// LogLevelInfo logs info and errors
+	// LogLevelWarn logs warnings and errors
+	LogLevelWarn
+	// LogLevelInfo logs info, warnings and errors
 	LogLevelInfo
 	// LogLevelDebug logs everything including debug information
 	LogLevelDebug
@@ -30,40 +88,230 @@ const (
 	LogLevelTrace
 )
 
+// ToolOverride represents per-tool overrides applied when a tool is exposed
+type ToolOverride struct {
+	Schema          json.RawMessage          `json:"schema,omitempty"`          // Replaces the upstream input schema
+	AllowedValues   map[string][]interface{} `json:"allowedValues,omitempty"`   // Keyed by parameter name
+	CacheTTLSeconds int                      `json:"cacheTTLSeconds,omitempty"` // Replaces the server's cache TTL for a cacheable tool
+}
+
+// ToolPreset represents a virtual tool that forwards to an upstream tool with fixed arguments
+type ToolPreset struct {
+	Tool        string                 `json:"tool"`                  // Original name of the upstream tool
+	Args        map[string]interface{} `json:"args,omitempty"`        // Arguments merged into every call
+	Description string                 `json:"description,omitempty"` // Replaces the upstream description
+}
+
 // ToolsConfig represents the tool filtering configuration for a server
 type ToolsConfig struct {
-	Allowed []string `json:"allowed,omitempty"`
+	Allowed     []string                `json:"allowed,omitempty"`
+	Denied      []string                `json:"denied,omitempty"`      // Tools removed after the allowed list is applied
+	Overrides   map[string]ToolOverride `json:"overrides,omitempty"`   // Keyed by original tool name
+	Unsanitized []string                `json:"unsanitized,omitempty"` // Tools that keep their original name
+	Presets     map[string]ToolPreset   `json:"presets,omitempty"`     // Keyed by virtual tool name
+	Cacheable   []string                `json:"cacheable,omitempty"`   // Read-only tools whose results are cached
+
+	// Regular expressions matched against original tool names, in addition to the exact-match lists above
+	AllowedPatterns []string `json:"allowedPatterns,omitempty"`
+	DeniedPatterns  []string `json:"deniedPatterns,omitempty"`
 }
 
+// Restart policies for server processes
+const (
+	// RestartNo never restarts a server process (default)
+	RestartNo = "no"
+	// RestartOnFailure restarts a server process that exited with an error
+	RestartOnFailure = "on-failure"
+	// RestartAlways restarts a server process whenever it exits
+	RestartAlways = "always"
+)
+
+// Sanitization modes for exposed tool names
+const (
+	// SanitizeCursor replaces dashes with underscores, which Cursor requires (default)
+	SanitizeCursor = "cursor"
+	// SanitizeNone exposes tool names unchanged
+	SanitizeNone = "none"
+	// SanitizeStrict replaces dashes with underscores and strips every other character outside [a-zA-Z0-9_]
+	SanitizeStrict = "strict"
+)
+
+// DefaultDelimiter joins prefixes and tool names if no delimiter is configured
+const DefaultDelimiter = "_"
+
+// Modes the aggregator is served to its client in
+const (
+	// ServeModeStdio serves the client over stdin/stdout (default)
+	ServeModeStdio = "stdio"
+	// ServeModeHTTP serves clients over the streamable HTTP transport
+	ServeModeHTTP = "http"
+)
+
+// DefaultServeAddr is the listen address of the streamable HTTP transport if none is configured
+const DefaultServeAddr = "localhost:8080"
+
+// Transports used to reach servers
+const (
+	// TransportStdio runs the server as a subprocess speaking over stdin/stdout (default)
+	TransportStdio = "stdio"
+	// TransportSSE connects to a remote server using the HTTP+SSE transport
+	TransportSSE = "sse"
+	// TransportHTTP connects to a remote server using the streamable HTTP transport
+	TransportHTTP = "http"
+)
+
+// DefaultInitTimeoutSeconds is the time allowed for a server's initialize handshake if not configured
+const DefaultInitTimeoutSeconds = 60
+
+// DefaultReadinessTimeoutSeconds is the time a server's readiness tool is retried for if not configured
+const DefaultReadinessTimeoutSeconds = 30
+
+// DefaultCallTimeoutSeconds is the time allowed for a tool call if not configured
+const DefaultCallTimeoutSeconds = 120
+
+// DefaultShutdownGraceMs is the time a server process gets to exit after SIGTERM before it is killed, if not configured
+const DefaultShutdownGraceMs = 5000
+
+// DefaultSlowCallThresholdMs is the duration above which a tool call is logged as slow if not configured
+const DefaultSlowCallThresholdMs = 10000
+
+// DefaultInitRetryBackoffMs is the delay before retrying a failed initialization if not configured
+const DefaultInitRetryBackoffMs = 1000
+
+// Restart policy defaults
+const (
+	// DefaultRestartBackoffMs is the delay before the first restart attempt
+	DefaultRestartBackoffMs = 1000
+	// DefaultRestartMaxBurst is the number of restarts allowed within the restart window
+	DefaultRestartMaxBurst = 5
+	// DefaultRestartWindowSeconds is the window in which restarts are counted
+	DefaultRestartWindowSeconds = 60
+)
+
 // ServerConfig represents the configuration for a single MCP server
 type ServerConfig struct {
-	Name    string            `json:"name"`
-	Command string            `json:"command"`
-	Args    []string          `json:"args,omitempty"`
-	Env     map[string]string `json:"env,omitempty"`
-	Tools   *ToolsConfig      `json:"tools,omitempty"` // Optional tool filtering
+	Name      string            `json:"name"`
+	Transport string            `json:"transport,omitempty"` // How the server is reached: stdio (default), sse or http
+	URL       string            `json:"url,omitempty"`       // Endpoint of a remote server
+	Command   string            `json:"command"`
+	Args      []string          `json:"args,omitempty"`
+	ArgsEnv   string            `json:"argsEnv,omitempty"` // Env var whose whitespace-separated contents are appended to args
+	Env       map[string]string `json:"env,omitempty"`
+	Tools     *ToolsConfig      `json:"tools,omitempty"`    // Optional tool filtering
+	Prefix    string            `json:"prefix,omitempty"`   // Replaces the server name in exposed tool names
+	NoPrefix  bool              `json:"noPrefix,omitempty"` // Exposes tools under their sanitized original names
+
+	DescriptionOverrides map[string]string `json:"descriptionOverrides,omitempty"` // Replace upstream tool descriptions, keyed by original tool name
+	LogFile              string            `json:"logFile,omitempty"`              // Receives the stderr of the server process instead of our stderr
+	WorkingDir           string            `json:"workingDir,omitempty"`           // Directory the server process runs in, ours if empty
+	SecretEnv            []string          `json:"secretEnv,omitempty"`            // Env vars whose values are never logged, besides those named like secrets
+	EnvFile              string            `json:"envFile,omitempty"`              // KEY=VALUE file whose variables are passed unless env sets them
+	CleanEnv             bool              `json:"cleanEnv,omitempty"`             // Passes only env and the inherited variables instead of our whole environment
+	InheritEnv           []string          `json:"inheritEnv,omitempty"`           // Variables of our environment passed to the server, implies cleanEnv if set
+	ProtocolVersion      string            `json:"protocolVersion,omitempty"`      // MCP version requested when initializing the server, the latest if empty
+
+	ArgDefaults map[string]map[string]interface{} `json:"argDefaults,omitempty"` // Arguments passed unless the client provides them, keyed by original tool name
+	InitOptions map[string]interface{}            `json:"initOptions,omitempty"` // Sent as initializationOptions in the initialize request
+
+	ReadinessTool           string `json:"readinessTool,omitempty"`           // Tool called without arguments after initialization until it succeeds
+	ReadinessTimeoutSeconds int    `json:"readinessTimeoutSeconds,omitempty"` // Time the readiness tool is retried for before the server is skipped
+
+	InitTimeoutSeconds int `json:"initTimeoutSeconds,omitempty"` // Time allowed for the initialize handshake
+	InitRetries        int `json:"initRetries,omitempty"`        // Further attempts to start a server that failed to initialize
+	InitRetryBackoffMs int `json:"initRetryBackoffMs,omitempty"` // Delay before the first retry, doubled on each further attempt
+	CallTimeoutSeconds int `json:"callTimeoutSeconds,omitempty"` // Time allowed for a single tool call
+	HealthCheckSeconds int `json:"healthCheckSeconds,omitempty"` // Interval between pings of the server, disabled if zero
+
+	CacheTTLSeconds    int  `json:"cacheTTLSeconds,omitempty"`    // How long results of cacheable tools are reused
+	MaxConcurrentCalls int  `json:"maxConcurrentCalls,omitempty"` // Limits simultaneous tool calls to the server, unlimited if zero
+	QueueCalls         bool `json:"queueCalls,omitempty"`         // Waits for a free slot instead of rejecting calls over the limit
+	ValidateArgs       bool `json:"validateArgs,omitempty"`       // Rejects calls whose arguments don't match the tool's input schema
+	RateLimitPerMinute int  `json:"rateLimitPerMinute,omitempty"` // Limits tool calls started per minute, spread evenly, unlimited if zero
+	RejectRateLimited  bool `json:"rejectRateLimited,omitempty"`  // Rejects calls over the rate limit instead of waiting for their turn
+
+	Restart              string `json:"restart,omitempty"`              // Restart policy: no, on-failure or always
+	RestartBackoffMs     int    `json:"restartBackoffMs,omitempty"`     // Initial delay between restarts, doubled on each failed attempt
+	RestartMaxBurst      int    `json:"restartMaxBurst,omitempty"`      // Maximum restarts within the restart window
+	RestartWindowSeconds int    `json:"restartWindowSeconds,omitempty"` // Window in which restarts are counted
+	ShutdownGraceMs      int    `json:"shutdownGraceMs,omitempty"`      // Time the process gets to exit after SIGTERM before it is killed
 }
 
 // Config represents the complete configuration for the MCP aggregator
 type Config struct {
-	Servers  []ServerConfig `json:"servers"`
-	LogLevel LogLevel       `json:"-"`
-	LogFile  string         `json:"-"`
+	Servers             []ServerConfig `json:"servers"`
+	Defaults            *ServerConfig  `json:"defaults,omitempty"`            // Settings inherited by every server
+	DisablePrefix       bool           `json:"disablePrefix,omitempty"`       // Exposes all tools without a server prefix
+	Passthrough         bool           `json:"passthrough,omitempty"`         // Proxies a single server, exposing its tools under their own names
+	StartupStaggerMs    int            `json:"startupStaggerMs,omitempty"`    // Delay between launching servers at startup, none if 0
+	SlowCallThresholdMs int            `json:"slowCallThresholdMs,omitempty"` // Tool calls taking longer are logged as warnings, DefaultSlowCallThresholdMs if 0
+	SoftErrors          bool           `json:"softErrors,omitempty"`          // Reports failed tool calls as tool errors instead of protocol errors
+	DownstreamLogging   bool           `json:"-"`                             // Advertises the logging capability to the client, unless the config turns it off
+	SamplingFallback    string         `json:"samplingFallback,omitempty"`    // Server that answers sampling requests the client can't
+	SanitizeMode        string         `json:"sanitizeMode,omitempty"`        // How tool names are sanitized: cursor, none or strict
+	Delimiter           string         `json:"delimiter,omitempty"`           // Joins prefixes and tool names, DefaultDelimiter if empty
+	NameTemplate        string         `json:"nameTemplate,omitempty"`        // Builds exposed tool names with text/template instead of joining prefix and name
+	ServerName          string         `json:"serverName,omitempty"`          // Name reported to the client, the built-in name if empty
+	ServerVersion       string         `json:"serverVersion,omitempty"`       // Version reported to the client, the built-in version if empty
+	LogLevel            LogLevel       `json:"-"`
+	LogFile             string         `json:"-"`
+	Maintenance         bool           `json:"-"`
+	MaintenanceMessage  string         `json:"-"`
+	DeadLetterFile      string         `json:"-"`
+	DualNames           bool           `json:"-"`
+	ReadOnlyMode        bool           `json:"-"` // Only tools that don't modify anything according to their annotations are exposed
+	MetricsAddr         string         `json:"-"` // Metrics endpoint is only served if set
+	AuditFile           string         `json:"-"` // Receives a JSON line for every tool call if set
+	FromStdin           bool           `json:"-"` // The config was read from stdin, which then can't carry the stdio transport
+	AllowedCommands     []string       `json:"-"` // Absolute paths or basenames servers may be started with, any if nil
+	StdoutCapture       StdoutCapture  `json:"-"`
+	AllowEmptyStart     bool           `json:"-"` // Servers that fail to start are retried in the background instead of failing startup
+	ServeMode           string         `json:"-"` // How the client is served, ServeModeStdio if empty
+	ServeAddr           string         `json:"-"` // Listen address in ServeModeHTTP, DefaultServeAddr if empty
+	AllowedOrigins      []string       `json:"-"` // Origins besides local ones whose browser requests are served in ServeModeHTTP
+	Instructions        string         `json:"-"` // Instructions for the client, explaining the tool naming if empty
+	ServerFormats       []string       `json:"-"` // Formats the servers were given in: servers, mcpServers or both
+	Warnings            []string       `json:"-"` // Problems found while loading that don't prevent startup
 }
 
 // rawConfig is used to parse different config formats
 type rawConfig struct {
 	// Array format
 	Servers []ServerConfig `json:"servers"`
-	// Object format
-	MCPServers map[string]struct {
-		Command string            `json:"command"`
-		Args    []string          `json:"args,omitempty"`
-		Env     map[string]string `json:"env,omitempty"`
-		Tools   *ToolsConfig      `json:"tools,omitempty"`
-	} `json:"mcpServers"`
+	// Object format, keyed by server name
+	MCPServers map[string]ServerConfig `json:"mcpServers"`
+	// Settings inherited by every server that doesn't override them
+	Defaults *ServerConfig `json:"defaults"`
+	// Expose all tools without a server prefix
+	DisablePrefix bool `json:"disablePrefix"`
+	// Proxy a single server, exposing its tools under their own names
+	Passthrough bool `json:"passthrough"`
+	// Delay between launching servers at startup
+	StartupStaggerMs int `json:"startupStaggerMs"`
+	// Duration above which tool calls are logged as slow
+	SlowCallThresholdMs int `json:"slowCallThresholdMs"`
+	// Report failed tool calls as tool errors
+	SoftErrors bool `json:"softErrors"`
+	// Advertise the logging capability to the client, true if not set
+	DownstreamLogging *bool `json:"downstreamLogging"`
+	// Server that answers sampling requests if the client doesn't support sampling
+	SamplingFallback string `json:"samplingFallback"`
+	// How tool names are sanitized
+	SanitizeMode string `json:"sanitizeMode"`
+	// Joins prefixes and tool names
+	Delimiter string `json:"delimiter"`
+	// Builds exposed tool names from the server and tool names
+	NameTemplate string `json:"nameTemplate"`
+	// Identity reported to the client
+	ServerName    string `json:"serverName"`
+	ServerVersion string `json:"serverVersion"`
+	// Files whose servers are loaded before the servers of this config, relative to its directory
+	Include []string `json:"include"`
 }
 
+// numericLogLevels maps the numeric values of MCP_LOG_LEVEL to log levels. The warn level came later
+// and is only selected by name, so existing numeric settings aren't shifted by it.
+var numericLogLevels = []LogLevel{LogLevelError, LogLevelInfo, LogLevelDebug, LogLevelTrace}
+
 // GetLogLevel returns the configured log level from environment variables
 func GetLogLevel() LogLevel {
 	levelStr := os.Getenv(LogLevelEnvVar)
@@ -71,29 +319,31 @@ func GetLogLevel() LogLevel {
 		return LogLevelInfo // Default to info
 	}
 
+	levelStr = strings.ToLower(strings.TrimSpace(levelStr))
 	levelInt, err := strconv.Atoi(levelStr)
 	if err != nil {
-		// Handle string values
+		// Handle string values, with common aliases mapped to the nearest level
 		switch levelStr {
 		case "error":
 			return LogLevelError
+		case "warn", "warning":
+			return LogLevelWarn
 		case "info":
 			return LogLevelInfo
 		case "debug":
 			return LogLevelDebug
-		case "trace":
+		case "trace", "verbose":
 			return LogLevelTrace
 		default:
 			return LogLevelInfo
 		}
 	}
 
-	// Handle numeric values
-	level := LogLevel(levelInt)
-	if level < LogLevelError || level > LogLevelTrace {
+	// Handle numeric values, which keep the meaning they had before the warn level was added
+	if levelInt < 0 || levelInt >= len(numericLogLevels) {
 		return LogLevelInfo
 	}
-	return level
+	return numericLogLevels[levelInt]
 }
 
 // GetLogFile returns the log file path from environment variables
@@ -101,61 +351,608 @@ func GetLogFile() string {
 	return os.Getenv(LogToFileEnvVar)
 }
 
+// GetLogRotation returns the size in MB at which the log file is rotated and how many rotated files are kept.
+// Zero disables rotation or keeps every rotated file, respectively.
+func GetLogRotation() (maxSizeMB, maxBackups int) {
+	maxSizeMB, err := strconv.Atoi(os.Getenv(LogMaxSizeEnvVar))
+	if err != nil || maxSizeMB < 0 {
+		maxSizeMB = 0
+	}
+	maxBackups, err = strconv.Atoi(os.Getenv(LogMaxBackupsEnvVar))
+	if err != nil || maxBackups < 0 {
+		maxBackups = 0
+	}
+	return maxSizeMB, maxBackups
+}
+
+// GetMaintenance returns whether maintenance mode is enabled and the message to return for tool calls
+func GetMaintenance() (bool, string) {
+	enabled, err := strconv.ParseBool(os.Getenv(MaintenanceEnvVar))
+	if err != nil {
+		enabled = false
+	}
+
+	return enabled, os.Getenv(MaintenanceMessageEnvVar)
+}
+
+// GetDualNames returns whether tools should also be exposed under their unprefixed names
+func GetDualNames() bool {
+	enabled, err := strconv.ParseBool(os.Getenv(DualNamesEnvVar))
+	if err != nil {
+		return false
+	}
+	return enabled
+}
+
+// GetReadOnlyMode returns whether only tools annotated as read-only are exposed
+func GetReadOnlyMode() bool {
+	enabled, err := strconv.ParseBool(os.Getenv(ReadOnlyEnvVar))
+	if err != nil {
+		return false
+	}
+	return enabled
+}
+
+// GetValidateOnly returns whether only the config should be validated, without starting the servers
+func GetValidateOnly() bool {
+	enabled, err := strconv.ParseBool(os.Getenv(ValidateOnlyEnvVar))
+	if err != nil {
+		return false
+	}
+	return enabled
+}
+
+// GetAllowEmptyStart returns whether the aggregator may start while no server is available
+func GetAllowEmptyStart() bool {
+	enabled, err := strconv.ParseBool(os.Getenv(AllowEmptyStartEnvVar))
+	if err != nil {
+		return false
+	}
+	return enabled
+}
+
+// StdoutCapture controls how stray output written to stdout is rerouted to stderr
+type StdoutCapture struct {
+	Disabled   bool // Leaves stdout alone, which risks corrupting the JSON-RPC messages of the stdio transport
+	BufferSize int  // Size in bytes of the buffer output is copied with, the default if zero
+}
+
+// GetStdoutCapture returns how stray stdout output should be captured
+func GetStdoutCapture() StdoutCapture {
+	disabled, err := strconv.ParseBool(os.Getenv(DisableStdoutCaptureEnvVar))
+	if err != nil {
+		disabled = false
+	}
+	bufferSize, err := strconv.Atoi(os.Getenv(StdoutCaptureBufferEnvVar))
+	if err != nil || bufferSize < 0 {
+		bufferSize = 0
+	}
+	return StdoutCapture{Disabled: disabled, BufferSize: bufferSize}
+}
+
+// GetListTools returns whether the aggregated tools should be printed instead of serving them
+func GetListTools() bool {
+	enabled, err := strconv.ParseBool(os.Getenv(ListToolsEnvVar))
+	if err != nil {
+		return false
+	}
+	return enabled
+}
+
+// GetAllowedCommands returns the commands servers may be started with, or nil if any command is allowed.
+// The list is separated like PATH: by colons, or semicolons on Windows.
+func GetAllowedCommands() []string {
+	value := os.Getenv(AllowedCommandsEnvVar)
+	if value == "" {
+		return nil
+	}
+	commands := []string{}
+	for _, command := range filepath.SplitList(value) {
+		if command = strings.TrimSpace(command); command != "" {
+			commands = append(commands, command)
+		}
+	}
+	return commands
+}
+
+// GetAllowedOrigins returns the origins besides local ones that may use the streamable HTTP transport,
+// separated by commas, such as https://app.example.com
+func GetAllowedOrigins() []string {
+	var origins []string
+	for _, origin := range strings.Split(os.Getenv(AllowedOriginsEnvVar), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// GetServerIdentity returns the name and version the aggregator reports to its client,
+// taking the environment variables over the configured values
+func GetServerIdentity(name, version string) (string, string) {
+	if envName := os.Getenv(ServerNameEnvVar); envName != "" {
+		name = envName
+	}
+	if envVersion := os.Getenv(ServerVersionEnvVar); envVersion != "" {
+		version = envVersion
+	}
+	return name, version
+}
+
 // LoadConfig loads the configuration from the specified environment variable
 func LoadConfig(envVar string) (*Config, error) {
+	return loadConfig(envVar, os.Stdin)
+}
+
+// loadConfig loads the configuration from the specified environment variable,
+// reading it from stdin until EOF if the variable is StdinConfigPath
+func loadConfig(envVar string, stdin io.Reader) (*Config, error) {
 	if envVar == "" {
 		envVar = DefaultEnvVar
 	}
 
-	configPath := os.Getenv(envVar)
-	if configPath == "" {
-		return nil, fmt.Errorf("environment variable %s not set", envVar)
+	// Inline config spares mounting a file in containerized setups
+	configPath := ""
+	configData := []byte(os.Getenv(ConfigJSONEnvVar))
+	if len(configData) == 0 {
+		configPath = os.Getenv(envVar)
+		if configPath == "" {
+			return nil, fmt.Errorf("environment variable %s not set", envVar)
+		}
+
+		if configPath == StdinConfigPath {
+			config, err := ParseConfig(stdin)
+			if err != nil {
+				return nil, err
+			}
+			config.FromStdin = true
+			return config, nil
+		}
+
+		var err error
+		configData, err = os.ReadFile(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading config file: %w", err)
+		}
 	}
 
-	configData, err := os.ReadFile(configPath)
+	return parseConfig(configPath, configData)
+}
+
+// ParseConfig parses and validates a config read from r, so it can come from any source instead of a file.
+// Both JSON and YAML are accepted, included files are resolved against the working directory,
+// and settings made by environment variables apply like with LoadConfig.
+func ParseConfig(r io.Reader) (*Config, error) {
+	configData, err := io.ReadAll(r)
 	if err != nil {
-		return nil, fmt.Errorf("error reading config file: %w", err)
+		return nil, fmt.Errorf("error reading config: %w", err)
 	}
+	return parseConfig("", configData)
+}
 
+// parseConfig parses and validates the config data read from configPath, which is empty if it wasn't read from a file
+func parseConfig(configPath string, configData []byte) (*Config, error) {
 	// Try to parse the config in different formats
-	var raw rawConfig
-	if err := json.Unmarshal(configData, &raw); err != nil {
-		return nil, fmt.Errorf("error parsing config file: %w", err)
+	raw, err := parseRawConfig(configPath, configData)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config: %w", err)
 	}
 
 	var config Config
 	config.LogLevel = GetLogLevel()
 	config.LogFile = GetLogFile()
+	config.Maintenance, config.MaintenanceMessage = GetMaintenance()
+	config.DeadLetterFile = os.Getenv(DeadLetterFileEnvVar)
+	config.DualNames = GetDualNames()
+	config.ReadOnlyMode = GetReadOnlyMode()
+	config.MetricsAddr = os.Getenv(MetricsAddrEnvVar)
+	config.AuditFile = os.Getenv(AuditFileEnvVar)
+	config.AllowedCommands = GetAllowedCommands()
+	config.StdoutCapture = GetStdoutCapture()
+	config.AllowEmptyStart = GetAllowEmptyStart()
+	config.ServeMode = os.Getenv(ServeModeEnvVar)
+	config.ServeAddr = os.Getenv(ServeAddrEnvVar)
+	config.AllowedOrigins = GetAllowedOrigins()
+	config.Instructions = os.Getenv(InstructionsEnvVar)
+	config.DisablePrefix = raw.DisablePrefix
+	config.Passthrough = raw.Passthrough
+	config.StartupStaggerMs = raw.StartupStaggerMs
+	config.SlowCallThresholdMs = raw.SlowCallThresholdMs
+	config.SoftErrors = raw.SoftErrors
+	config.DownstreamLogging = raw.DownstreamLogging == nil || *raw.DownstreamLogging
+	config.SamplingFallback = raw.SamplingFallback
+	config.SanitizeMode = raw.SanitizeMode
+	config.Delimiter = raw.Delimiter
+	config.NameTemplate = raw.NameTemplate
+	config.ServerName, config.ServerVersion = GetServerIdentity(raw.ServerName, raw.ServerVersion)
+
+	// Servers of included files come first, so a shared bundle can be extended locally
+	var chain []string
+	baseDir := "."
+	if configPath != "" {
+		absPath, err := filepath.Abs(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving config path: %w", err)
+		}
+		chain = []string{absPath}
+		baseDir = filepath.Dir(absPath)
+	}
+	config.Servers, config.Warnings, err = includedServers(raw.Include, baseDir, chain)
+	if err != nil {
+		return nil, err
+	}
+	servers, warnings := rawServers(raw)
+	config.Servers = append(config.Servers, servers...)
+	config.Warnings = append(config.Warnings, warnings...)
+	config.ServerFormats = serverFormats(raw)
+
+	// Apply the defaults before validation, they may provide required settings such as the command
+	if raw.Defaults != nil {
+		config.Defaults = raw.Defaults
+		for i := range config.Servers {
+			config.Servers[i] = applyServerDefaults(config.Servers[i], *raw.Defaults)
+		}
+	}
 
-	// Check if we have servers in the array format
+	// Expand environment variable references so secrets don't have to be committed in the config
+	for i := range config.Servers {
+		config.Warnings = append(config.Warnings, expandServerEnv(&config.Servers[i])...)
+	}
+
+	// Leaving out the allowed list exposes every tool, while an empty one exposes none
+	for _, server := range config.Servers {
+		if server.Tools != nil && server.Tools.Allowed != nil && len(server.Tools.Allowed) == 0 && len(server.Tools.AllowedPatterns) == 0 {
+			config.Warnings = append(config.Warnings, fmt.Sprintf("server %s has an empty tools.allowed list, none of its tools are exposed", server.Name))
+		}
+	}
+
+	if err := Validate(&config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// rawServers returns the servers of a config in both formats, with the warnings about servers defined in both.
+// The array format comes first and takes precedence over an object server of the same name.
+func rawServers(raw rawConfig) ([]ServerConfig, []string) {
+	servers := slices.Clone(raw.Servers)
+	var warnings []string
+
+	// Convert the object format to our standard format, in name order so the result is stable
+	for _, name := range slices.Sorted(maps.Keys(raw.MCPServers)) {
+		if slices.ContainsFunc(raw.Servers, func(server ServerConfig) bool { return server.Name == name }) {
+			warnings = append(warnings, fmt.Sprintf("server %s is defined in both servers and mcpServers, using the one in servers", name))
+			continue
+		}
+		server := raw.MCPServers[name]
+		server.Name = name
+		servers = append(servers, server)
+	}
+	return servers, warnings
+}
+
+// serverFormats returns the formats the servers of a config are given in
+func serverFormats(raw rawConfig) []string {
+	var formats []string
 	if len(raw.Servers) > 0 {
-		config.Servers = raw.Servers
-	} else if len(raw.MCPServers) > 0 {
-		// Convert the object format to our standard format
-		for name, server := range raw.MCPServers {
-			config.Servers = append(config.Servers, ServerConfig{
-				Name:    name,
-				Command: server.Command,
-				Args:    server.Args,
-				Env:     server.Env,
-				Tools:   server.Tools,
-			})
+		formats = append(formats, "servers")
+	}
+	if len(raw.MCPServers) > 0 {
+		formats = append(formats, "mcpServers")
+	}
+	return formats
+}
+
+// includedServers loads the servers of included config files, resolving relative paths against baseDir.
+// The chain holds the absolute paths of the including files, so include cycles are detected.
+func includedServers(includes []string, baseDir string, chain []string) ([]ServerConfig, []string, error) {
+	var servers []ServerConfig
+	var warnings []string
+	for _, include := range includes {
+		path := include
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error resolving included config %s: %w", include, err)
+		}
+		if slices.Contains(chain, path) {
+			return nil, nil, fmt.Errorf("include cycle: %s", strings.Join(append(slices.Clone(chain), path), " -> "))
 		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading included config: %w", err)
+		}
+		raw, err := parseRawConfig(path, data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error parsing included config %s: %w", path, err)
+		}
+
+		nested, nestedWarnings, err := includedServers(raw.Include, filepath.Dir(path), append(slices.Clone(chain), path))
+		if err != nil {
+			return nil, nil, err
+		}
+		own, ownWarnings := rawServers(raw)
+		servers = append(servers, nested...)
+		servers = append(servers, own...)
+		warnings = append(warnings, nestedWarnings...)
+		warnings = append(warnings, ownWarnings...)
 	}
+	return servers, warnings, nil
+}
 
-	if len(config.Servers) == 0 {
-		return nil, fmt.Errorf("no servers defined in config")
+// parseRawConfig parses a YAML config if the file has a YAML extension, and JSON otherwise.
+// A JSON config that fails to parse is retried as YAML before giving up.
+func parseRawConfig(configPath string, configData []byte) (rawConfig, error) {
+	var raw rawConfig
+
+	switch strings.ToLower(filepath.Ext(configPath)) {
+	case ".yaml", ".yml":
+		jsonData, err := yamlToJSON(configData)
+		if err != nil {
+			return raw, err
+		}
+		err = json.Unmarshal(jsonData, &raw)
+		return raw, err
+	}
+
+	jsonErr := json.Unmarshal(configData, &raw)
+	if jsonErr == nil {
+		return raw, nil
+	}
+
+	// Report the JSON error if the content isn't YAML either
+	jsonData, err := yamlToJSON(configData)
+	if err != nil {
+		return raw, jsonErr
+	}
+	raw = rawConfig{}
+	if err := json.Unmarshal(jsonData, &raw); err != nil {
+		return raw, jsonErr
+	}
+	return raw, nil
+}
+
+// yamlToJSON converts a YAML document to JSON, so it is decoded with the same rules as a JSON config
+func yamlToJSON(data []byte) ([]byte, error) {
+	var document interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	return json.Marshal(document)
+}
+
+// expandServerEnv expands $VAR and ${VAR} references in the url, command, args and env values of a server.
+// It returns a warning for each referenced variable that isn't set.
+func expandServerEnv(server *ServerConfig) []string {
+	var warnings []string
+	expand := func(field, value string) string {
+		return os.Expand(value, func(name string) string {
+			// $$ escapes a literal dollar sign
+			if name == "$" {
+				return "$"
+			}
+			expanded, ok := os.LookupEnv(name)
+			if !ok {
+				warnings = append(warnings, fmt.Sprintf("server %s %s references unset environment variable %s", server.Name, field, name))
+			}
+			return expanded
+		})
 	}
 
-	// Validate server configuration
-	for i, server := range config.Servers {
-		if server.Name == "" {
-			return nil, fmt.Errorf("server at index %d missing name", i)
+	server.URL = expand("url", server.URL)
+	server.Command = expand("command", server.Command)
+	server.LogFile = expand("logFile", server.LogFile)
+	server.WorkingDir = expand("workingDir", server.WorkingDir)
+	server.EnvFile = expand("envFile", server.EnvFile)
+	if server.Args != nil {
+		args := make([]string, len(server.Args))
+		for i, arg := range server.Args {
+			args[i] = expand("args", arg)
 		}
-		if server.Command == "" {
-			return nil, fmt.Errorf("server %s missing command", server.Name)
+		server.Args = args
+	}
+	if server.Env != nil {
+		env := make(map[string]string, len(server.Env))
+		for key, value := range server.Env {
+			env[key] = expand("env "+key, value)
 		}
+		server.Env = env
 	}
+	return warnings
+}
 
-	return &config, nil
+// WithArgsEnv returns the server with the whitespace-separated contents of its args env var appended to its args.
+// The variable is read whenever the server is started, so one config can pass different args in every environment.
+func (s ServerConfig) WithArgsEnv() ServerConfig {
+	if s.ArgsEnv == "" {
+		return s
+	}
+	extra := strings.Fields(os.Getenv(s.ArgsEnv))
+	if len(extra) == 0 {
+		return s
+	}
+	s.Args = append(append([]string(nil), s.Args...), extra...)
+	return s
+}
+
+// applyServerDefaults fills in the settings a server doesn't set from the defaults.
+// Maps are merged with the server's entries taking precedence, lists are inherited only if the server has none.
+func applyServerDefaults(server, defaults ServerConfig) ServerConfig {
+	if server.Transport == "" {
+		server.Transport = defaults.Transport
+	}
+	if server.Command == "" {
+		server.Command = defaults.Command
+	}
+	if server.Args == nil && defaults.Args != nil {
+		server.Args = append([]string(nil), defaults.Args...)
+	}
+	if server.ArgsEnv == "" {
+		server.ArgsEnv = defaults.ArgsEnv
+	}
+	// A server with its own prefix doesn't want its tools unprefixed
+	if server.Prefix == "" {
+		server.NoPrefix = server.NoPrefix || defaults.NoPrefix
+	}
+	if server.WorkingDir == "" {
+		server.WorkingDir = defaults.WorkingDir
+	}
+	server.Env = mergeMaps(defaults.Env, server.Env)
+	if server.SecretEnv == nil && defaults.SecretEnv != nil {
+		server.SecretEnv = append([]string(nil), defaults.SecretEnv...)
+	}
+	if server.EnvFile == "" {
+		server.EnvFile = defaults.EnvFile
+	}
+	server.CleanEnv = server.CleanEnv || defaults.CleanEnv
+	if server.InheritEnv == nil && defaults.InheritEnv != nil {
+		server.InheritEnv = append([]string(nil), defaults.InheritEnv...)
+	}
+	if server.ProtocolVersion == "" {
+		server.ProtocolVersion = defaults.ProtocolVersion
+	}
+	server.DescriptionOverrides = mergeMaps(defaults.DescriptionOverrides, server.DescriptionOverrides)
+	server.ArgDefaults = mergeMaps(defaults.ArgDefaults, server.ArgDefaults)
+	server.InitOptions = mergeMaps(defaults.InitOptions, server.InitOptions)
+	server.Tools = mergeToolsConfig(defaults.Tools, server.Tools)
+
+	if server.ReadinessTool == "" {
+		server.ReadinessTool = defaults.ReadinessTool
+	}
+	if server.ReadinessTimeoutSeconds == 0 {
+		server.ReadinessTimeoutSeconds = defaults.ReadinessTimeoutSeconds
+	}
+	if server.InitTimeoutSeconds == 0 {
+		server.InitTimeoutSeconds = defaults.InitTimeoutSeconds
+	}
+	if server.InitRetries == 0 {
+		server.InitRetries = defaults.InitRetries
+	}
+	if server.InitRetryBackoffMs == 0 {
+		server.InitRetryBackoffMs = defaults.InitRetryBackoffMs
+	}
+	if server.CallTimeoutSeconds == 0 {
+		server.CallTimeoutSeconds = defaults.CallTimeoutSeconds
+	}
+	if server.HealthCheckSeconds == 0 {
+		server.HealthCheckSeconds = defaults.HealthCheckSeconds
+	}
+	if server.CacheTTLSeconds == 0 {
+		server.CacheTTLSeconds = defaults.CacheTTLSeconds
+	}
+	if server.MaxConcurrentCalls == 0 {
+		server.MaxConcurrentCalls = defaults.MaxConcurrentCalls
+		server.QueueCalls = server.QueueCalls || defaults.QueueCalls
+	}
+	server.ValidateArgs = server.ValidateArgs || defaults.ValidateArgs
+	if server.RateLimitPerMinute == 0 {
+		server.RateLimitPerMinute = defaults.RateLimitPerMinute
+		server.RejectRateLimited = server.RejectRateLimited || defaults.RejectRateLimited
+	}
+	if server.Restart == "" {
+		server.Restart = defaults.Restart
+	}
+	if server.RestartBackoffMs == 0 {
+		server.RestartBackoffMs = defaults.RestartBackoffMs
+	}
+	if server.RestartMaxBurst == 0 {
+		server.RestartMaxBurst = defaults.RestartMaxBurst
+	}
+	if server.RestartWindowSeconds == 0 {
+		server.RestartWindowSeconds = defaults.RestartWindowSeconds
+	}
+	if server.ShutdownGraceMs == 0 {
+		server.ShutdownGraceMs = defaults.ShutdownGraceMs
+	}
+	return server
+}
+
+// mergeToolsConfig merges the default tools config with a server's own
+func mergeToolsConfig(defaults, server *ToolsConfig) *ToolsConfig {
+	if defaults == nil {
+		return server
+	}
+
+	merged := ToolsConfig{}
+	if server != nil {
+		merged = *server
+	}
+	if merged.Allowed == nil && defaults.Allowed != nil {
+		merged.Allowed = append([]string(nil), defaults.Allowed...)
+	}
+	if merged.Denied == nil && defaults.Denied != nil {
+		merged.Denied = append([]string(nil), defaults.Denied...)
+	}
+	if merged.Unsanitized == nil && defaults.Unsanitized != nil {
+		merged.Unsanitized = append([]string(nil), defaults.Unsanitized...)
+	}
+	if merged.Cacheable == nil && defaults.Cacheable != nil {
+		merged.Cacheable = append([]string(nil), defaults.Cacheable...)
+	}
+	if merged.AllowedPatterns == nil && defaults.AllowedPatterns != nil {
+		merged.AllowedPatterns = append([]string(nil), defaults.AllowedPatterns...)
+	}
+	if merged.DeniedPatterns == nil && defaults.DeniedPatterns != nil {
+		merged.DeniedPatterns = append([]string(nil), defaults.DeniedPatterns...)
+	}
+	var serverOverrides map[string]ToolOverride
+	var serverPresets map[string]ToolPreset
+	if server != nil {
+		serverOverrides, serverPresets = server.Overrides, server.Presets
+	}
+	merged.Overrides = mergeMaps(defaults.Overrides, serverOverrides)
+	merged.Presets = mergeMaps(defaults.Presets, serverPresets)
+	return &merged
+}
+
+// mergeMaps returns a new map with the entries of both maps, override's entries taking precedence
+func mergeMaps[K comparable, V any](base, override map[K]V) map[K]V {
+	if base == nil {
+		return override
+	}
+	merged := make(map[K]V, len(base)+len(override))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range override {
+		merged[key] = value
+	}
+	return merged
+}
+
+// validateToolSchema checks that a schema override is a JSON schema usable as a tool input schema
+func validateToolSchema(schema json.RawMessage) error {
+	var parsed map[string]interface{}
+	if err := json.Unmarshal(schema, &parsed); err != nil {
+		return fmt.Errorf("schema must be a JSON object: %w", err)
+	}
+
+	// Tool input schemas always describe an object of arguments
+	if schemaType, exists := parsed["type"]; exists && schemaType != "object" {
+		return fmt.Errorf("schema type must be \"object\", got %v", schemaType)
+	}
+
+	if properties, exists := parsed["properties"]; exists {
+		if _, ok := properties.(map[string]interface{}); !ok {
+			return fmt.Errorf("schema properties must be an object")
+		}
+	}
+
+	if required, exists := parsed["required"]; exists {
+		list, ok := required.([]interface{})
+		if !ok {
+			return fmt.Errorf("schema required must be an array")
+		}
+		for _, item := range list {
+			if _, ok := item.(string); !ok {
+				return fmt.Errorf("schema required entries must be strings")
+			}
+		}
+	}
+
+	return nil
 }
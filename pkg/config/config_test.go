@@ -2,9 +2,11 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -19,7 +21,9 @@ func TestLoadConfig(t *testing.T) {
 			},
 		},
 		// Set expected LogLevel to match default (what GetLogLevel returns)
-		LogLevel: LogLevelInfo,
+		LogLevel:          LogLevelInfo,
+		ServerFormats:     []string{"servers"},
+		DownstreamLogging: true,
 	}
 
 	validConfigJSON, err := json.Marshal(validConfig)
@@ -120,3 +124,975 @@ func TestLoadConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadConfigSchemaOverride(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr bool
+	}{
+		{
+			name:    "Valid schema",
+			schema:  `{"type":"object","properties":{"branch":{"type":"string"}},"required":["branch"]}`,
+			wantErr: false,
+		},
+		{
+			name:    "Schema is not an object",
+			schema:  `["branch"]`,
+			wantErr: true,
+		},
+		{
+			name:    "Schema type is not object",
+			schema:  `{"type":"string"}`,
+			wantErr: true,
+		},
+		{
+			name:    "Properties is not an object",
+			schema:  `{"type":"object","properties":["branch"]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Required is not a string array",
+			schema:  `{"type":"object","required":[1]}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configJSON := `{
+				"mcpServers": {
+					"git": {
+						"command": "git-mcp",
+						"tools": {
+							"overrides": {
+								"checkout-branch": {"schema": ` + tt.schema + `}
+							}
+						}
+					}
+				}
+			}`
+			configPath := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(configPath, []byte(configJSON), 0644); err != nil {
+				t.Fatalf("Failed to write test config file: %v", err)
+			}
+			t.Setenv("TEST_CONFIG", configPath)
+
+			cfg, err := LoadConfig("TEST_CONFIG")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			override := cfg.Servers[0].Tools.Overrides["checkout-branch"]
+			if string(override.Schema) != tt.schema {
+				t.Errorf("Schema override = %s, want %s", override.Schema, tt.schema)
+			}
+		})
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	configJSON := `{
+		"defaults": {
+			"command": "npx",
+			"args": ["-y", "mcp-server"],
+			"env": {"LOG_LEVEL": "info", "REGION": "eu"},
+			"workingDir": "DEFAULT_DIR",
+			"noPrefix": true,
+			"restart": "on-failure",
+			"restartMaxBurst": 3,
+			"tools": {
+				"allowed": ["search"],
+				"overrides": {"search": {"allowedValues": {"scope": ["public"]}}}
+			}
+		},
+		"servers": [
+			{
+				"name": "inherits",
+				"env": {"REGION": "us", "TOKEN": "secret"}
+			},
+			{
+				"name": "overrides",
+				"command": "docker",
+				"args": ["run", "image"],
+				"workingDir": "SERVER_DIR",
+				"prefix": "img",
+				"restart": "always",
+				"tools": {
+					"allowed": ["get"],
+					"overrides": {"get": {"allowedValues": {"id": [1]}}}
+				}
+			}
+		]
+	}`
+	// The working directories must exist to pass validation
+	defaultDir, serverDir := t.TempDir(), t.TempDir()
+	configJSON = strings.NewReplacer("DEFAULT_DIR", defaultDir, "SERVER_DIR", serverDir).Replace(configJSON)
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configPath, []byte(configJSON), 0644); err != nil {
+		t.Fatalf("Failed to write test config file: %v", err)
+	}
+	t.Setenv("TEST_CONFIG", configPath)
+
+	cfg, err := LoadConfig("TEST_CONFIG")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		server        ServerConfig
+		wantCommand   string
+		wantArgs      []string
+		wantEnv       map[string]string
+		wantDir       string
+		wantNoPrefix  bool
+		wantRestart   string
+		wantMaxBurst  int
+		wantAllowed   []string
+		wantOverrides []string
+	}{
+		{
+			name:          "Inherits defaults",
+			server:        cfg.Servers[0],
+			wantCommand:   "npx",
+			wantArgs:      []string{"-y", "mcp-server"},
+			wantEnv:       map[string]string{"LOG_LEVEL": "info", "REGION": "us", "TOKEN": "secret"},
+			wantDir:       defaultDir,
+			wantNoPrefix:  true,
+			wantRestart:   RestartOnFailure,
+			wantMaxBurst:  3,
+			wantAllowed:   []string{"search"},
+			wantOverrides: []string{"search"},
+		},
+		{
+			name:          "Overrides defaults",
+			server:        cfg.Servers[1],
+			wantCommand:   "docker",
+			wantArgs:      []string{"run", "image"},
+			wantEnv:       map[string]string{"LOG_LEVEL": "info", "REGION": "eu"},
+			wantDir:       serverDir,
+			wantNoPrefix:  false,
+			wantRestart:   RestartAlways,
+			wantMaxBurst:  3,
+			wantAllowed:   []string{"get"},
+			wantOverrides: []string{"get", "search"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.server.Command != tt.wantCommand {
+				t.Errorf("Command = %q, want %q", tt.server.Command, tt.wantCommand)
+			}
+			if !reflect.DeepEqual(tt.server.Args, tt.wantArgs) {
+				t.Errorf("Args = %v, want %v", tt.server.Args, tt.wantArgs)
+			}
+			if !reflect.DeepEqual(tt.server.Env, tt.wantEnv) {
+				t.Errorf("Env = %v, want %v", tt.server.Env, tt.wantEnv)
+			}
+			if tt.server.WorkingDir != tt.wantDir {
+				t.Errorf("WorkingDir = %q, want %q", tt.server.WorkingDir, tt.wantDir)
+			}
+			if tt.server.NoPrefix != tt.wantNoPrefix {
+				t.Errorf("NoPrefix = %v, want %v", tt.server.NoPrefix, tt.wantNoPrefix)
+			}
+			if tt.server.Restart != tt.wantRestart {
+				t.Errorf("Restart = %q, want %q", tt.server.Restart, tt.wantRestart)
+			}
+			if tt.server.RestartMaxBurst != tt.wantMaxBurst {
+				t.Errorf("RestartMaxBurst = %d, want %d", tt.server.RestartMaxBurst, tt.wantMaxBurst)
+			}
+			if tt.server.Tools == nil {
+				t.Fatal("Tools = nil, want the merged tools config")
+			}
+			if !reflect.DeepEqual(tt.server.Tools.Allowed, tt.wantAllowed) {
+				t.Errorf("Tools.Allowed = %v, want %v", tt.server.Tools.Allowed, tt.wantAllowed)
+			}
+			for _, toolName := range tt.wantOverrides {
+				if _, ok := tt.server.Tools.Overrides[toolName]; !ok {
+					t.Errorf("Missing override for tool %s", toolName)
+				}
+			}
+			if len(tt.server.Tools.Overrides) != len(tt.wantOverrides) {
+				t.Errorf("Tools.Overrides has %d entries, want %d", len(tt.server.Tools.Overrides), len(tt.wantOverrides))
+			}
+		})
+	}
+
+	// Merging must not leak one server's settings into the defaults shared with the others
+	if _, leaked := cfg.Defaults.Env["TOKEN"]; leaked {
+		t.Error("Server env leaked into the defaults")
+	}
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	tests := []struct {
+		name     string
+		jsonData string
+		yamlFile string
+		yamlData string
+	}{
+		{
+			name: "Object format",
+			jsonData: `{
+				"mcpServers": {
+					"shortcut": {
+						"command": "npx",
+						"args": ["-y", "@shortcut/mcp"],
+						"env": {"SHORTCUT_API_TOKEN": "token"},
+						"tools": {
+							"allowed": ["search-stories"],
+							"overrides": {
+								"search-stories": {
+									"schema": {"type": "object", "properties": {"query": {"type": "string"}}},
+									"allowedValues": {"limit": [10, 20]}
+								}
+							}
+						}
+					}
+				}
+			}`,
+			yamlFile: "config.yaml",
+			yamlData: `
+mcpServers:
+  shortcut:
+    command: npx
+    args: ["-y", "@shortcut/mcp"]
+    env:
+      SHORTCUT_API_TOKEN: token
+    tools:
+      allowed:
+        - search-stories
+      overrides:
+        search-stories:
+          schema:
+            type: object
+            properties:
+              query:
+                type: string
+          allowedValues:
+            limit: [10, 20]
+`,
+		},
+		{
+			name: "Array format",
+			jsonData: `{
+				"servers": [
+					{"name": "github", "command": "github-mcp", "restart": "on-failure", "restartMaxBurst": 3}
+				]
+			}`,
+			yamlFile: "config.yml",
+			yamlData: `
+servers:
+  - name: github
+    command: github-mcp
+    restart: on-failure
+    restartMaxBurst: 3
+`,
+		},
+		{
+			name:     "YAML without YAML extension",
+			jsonData: `{"servers": [{"name": "github", "command": "github-mcp"}]}`,
+			yamlFile: "config.conf",
+			yamlData: `
+servers:
+  - name: github
+    command: github-mcp
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(LogLevelEnvVar, "debug")
+			t.Setenv(LogToFileEnvVar, "/tmp/combine-mcp.log")
+
+			tempDir := t.TempDir()
+			jsonPath := filepath.Join(tempDir, "config.json")
+			if err := os.WriteFile(jsonPath, []byte(tt.jsonData), 0644); err != nil {
+				t.Fatalf("Failed to write JSON config file: %v", err)
+			}
+			yamlPath := filepath.Join(tempDir, tt.yamlFile)
+			if err := os.WriteFile(yamlPath, []byte(tt.yamlData), 0644); err != nil {
+				t.Fatalf("Failed to write YAML config file: %v", err)
+			}
+
+			t.Setenv("TEST_CONFIG", jsonPath)
+			jsonConfig, err := LoadConfig("TEST_CONFIG")
+			if err != nil {
+				t.Fatalf("LoadConfig() JSON error = %v", err)
+			}
+
+			t.Setenv("TEST_CONFIG", yamlPath)
+			yamlConfig, err := LoadConfig("TEST_CONFIG")
+			if err != nil {
+				t.Fatalf("LoadConfig() YAML error = %v", err)
+			}
+
+			// Schema overrides are kept as raw JSON, so they are compared semantically
+			for _, cfg := range []*Config{jsonConfig, yamlConfig} {
+				for _, server := range cfg.Servers {
+					if server.Tools == nil {
+						continue
+					}
+					for name, override := range server.Tools.Overrides {
+						var schema interface{}
+						if override.Schema != nil {
+							if err := json.Unmarshal(override.Schema, &schema); err != nil {
+								t.Fatalf("Invalid schema override: %v", err)
+							}
+							override.Schema, _ = json.Marshal(schema)
+						}
+						server.Tools.Overrides[name] = override
+					}
+				}
+			}
+
+			if !reflect.DeepEqual(jsonConfig, yamlConfig) {
+				t.Errorf("YAML config = %+v, want %+v", yamlConfig, jsonConfig)
+			}
+			if yamlConfig.LogLevel != LogLevelDebug || yamlConfig.LogFile != "/tmp/combine-mcp.log" {
+				t.Errorf("YAML config log settings = %v, %q, want env-controlled values", yamlConfig.LogLevel, yamlConfig.LogFile)
+			}
+		})
+	}
+
+	t.Run("Invalid YAML", func(t *testing.T) {
+		yamlPath := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(yamlPath, []byte("servers: [unclosed"), 0644); err != nil {
+			t.Fatalf("Failed to write YAML config file: %v", err)
+		}
+		t.Setenv("TEST_CONFIG", yamlPath)
+		if _, err := LoadConfig("TEST_CONFIG"); err == nil {
+			t.Error("LoadConfig() with invalid YAML returned no error")
+		}
+	})
+}
+
+func TestLoadConfigEnvExpansion(t *testing.T) {
+	t.Setenv("COMBINE_MCP_TEST_HOME", "/home/tester")
+	t.Setenv("COMBINE_MCP_TEST_TOKEN", "secret")
+	t.Setenv("COMBINE_MCP_TEST_SUBDIR", "bin")
+	// The value of a variable isn't expanded again
+	t.Setenv("COMBINE_MCP_TEST_NESTED", "${COMBINE_MCP_TEST_TOKEN}")
+
+	tests := []struct {
+		name         string
+		server       string
+		wantCommand  string
+		wantArgs     []string
+		wantEnv      map[string]string
+		wantWarnings int
+	}{
+		{
+			name:        "Braced and bare references",
+			server:      `{"name": "test", "command": "${COMBINE_MCP_TEST_HOME}/$COMBINE_MCP_TEST_SUBDIR/server", "args": ["--token=$COMBINE_MCP_TEST_TOKEN"], "env": {"TOKEN": "${COMBINE_MCP_TEST_TOKEN}"}}`,
+			wantCommand: "/home/tester/bin/server",
+			wantArgs:    []string{"--token=secret"},
+			wantEnv:     map[string]string{"TOKEN": "secret"},
+		},
+		{
+			name:        "Variable values are not expanded again",
+			server:      `{"name": "test", "command": "server", "env": {"VALUE": "${COMBINE_MCP_TEST_NESTED}"}}`,
+			wantCommand: "server",
+			wantEnv:     map[string]string{"VALUE": "${COMBINE_MCP_TEST_TOKEN}"},
+		},
+		{
+			name:        "Escaped dollar sign",
+			server:      `{"name": "test", "command": "server", "args": ["--price=$$5", "$${COMBINE_MCP_TEST_TOKEN}"]}`,
+			wantCommand: "server",
+			wantArgs:    []string{"--price=$5", "${COMBINE_MCP_TEST_TOKEN}"},
+		},
+		{
+			name:         "Missing variables expand to empty strings",
+			server:       `{"name": "test", "command": "server", "args": ["--key=${COMBINE_MCP_TEST_MISSING}"], "env": {"KEY": "$COMBINE_MCP_TEST_MISSING"}}`,
+			wantCommand:  "server",
+			wantArgs:     []string{"--key="},
+			wantEnv:      map[string]string{"KEY": ""},
+			wantWarnings: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(configPath, []byte(`{"servers": [`+tt.server+`]}`), 0644); err != nil {
+				t.Fatalf("Failed to write test config file: %v", err)
+			}
+			t.Setenv("TEST_CONFIG", configPath)
+
+			cfg, err := LoadConfig("TEST_CONFIG")
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+
+			server := cfg.Servers[0]
+			if server.Command != tt.wantCommand {
+				t.Errorf("Command = %q, want %q", server.Command, tt.wantCommand)
+			}
+			if !reflect.DeepEqual(server.Args, tt.wantArgs) {
+				t.Errorf("Args = %q, want %q", server.Args, tt.wantArgs)
+			}
+			if !reflect.DeepEqual(server.Env, tt.wantEnv) {
+				t.Errorf("Env = %v, want %v", server.Env, tt.wantEnv)
+			}
+			if len(cfg.Warnings) != tt.wantWarnings {
+				t.Errorf("Warnings = %q, want %d warnings", cfg.Warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestLoadConfigInitTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout int
+		wantErr bool
+	}{
+		{name: "Unset timeout", timeout: 0, wantErr: false},
+		{name: "Positive timeout", timeout: 120, wantErr: false},
+		{name: "Negative timeout", timeout: -1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configJSON := fmt.Sprintf(`{"servers": [{"name": "test", "command": "server", "initTimeoutSeconds": %d}]}`, tt.timeout)
+			configPath := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(configPath, []byte(configJSON), 0644); err != nil {
+				t.Fatalf("Failed to write test config file: %v", err)
+			}
+			t.Setenv("TEST_CONFIG", configPath)
+
+			cfg, err := LoadConfig("TEST_CONFIG")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && cfg.Servers[0].InitTimeoutSeconds != tt.timeout {
+				t.Errorf("InitTimeoutSeconds = %d, want %d", cfg.Servers[0].InitTimeoutSeconds, tt.timeout)
+			}
+		})
+	}
+}
+
+func TestLoadConfigTransport(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  string
+		wantErr bool
+	}{
+		{name: "Default stdio", server: `{"name": "test", "command": "server"}`, wantErr: false},
+		{name: "Stdio without command", server: `{"name": "test", "transport": "stdio"}`, wantErr: true},
+		{name: "SSE", server: `{"name": "test", "transport": "sse", "url": "http://localhost:8080/sse"}`, wantErr: false},
+		{name: "HTTP", server: `{"name": "test", "transport": "http", "url": "https://example.com/mcp"}`, wantErr: false},
+		{name: "Remote without url", server: `{"name": "test", "transport": "http"}`, wantErr: true},
+		{name: "Remote with invalid url", server: `{"name": "test", "transport": "sse", "url": "localhost:8080"}`, wantErr: true},
+		{name: "Unknown transport", server: `{"name": "test", "transport": "websocket", "url": "http://localhost"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configJSON := fmt.Sprintf(`{"servers": [%s]}`, tt.server)
+			configPath := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(configPath, []byte(configJSON), 0644); err != nil {
+				t.Fatalf("Failed to write test config file: %v", err)
+			}
+			t.Setenv("TEST_CONFIG", configPath)
+
+			if _, err := LoadConfig("TEST_CONFIG"); (err != nil) != tt.wantErr {
+				t.Errorf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigInlineJSON(t *testing.T) {
+	fileConfig := `{"servers": [{"name": "from-file", "command": "server"}]}`
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configPath, []byte(fileConfig), 0644); err != nil {
+		t.Fatalf("Failed to write test config file: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		configPath string
+		inline     string
+		wantServer string
+		wantErr    bool
+	}{
+		{
+			name:       "Only inline config",
+			inline:     `{"mcpServers": {"inline": {"command": "server", "args": ["--stdio"]}}}`,
+			wantServer: "inline",
+		},
+		{
+			name:       "Inline config takes precedence",
+			configPath: configPath,
+			inline:     `{"servers": [{"name": "inline", "command": "server"}]}`,
+			wantServer: "inline",
+		},
+		{
+			name:       "File config without inline config",
+			configPath: configPath,
+			wantServer: "from-file",
+		},
+		{
+			name:    "Inline config is validated",
+			inline:  `{"servers": [{"name": "inline"}]}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_CONFIG", tt.configPath)
+			t.Setenv(ConfigJSONEnvVar, tt.inline)
+
+			cfg, err := LoadConfig("TEST_CONFIG")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && cfg.Servers[0].Name != tt.wantServer {
+				t.Errorf("Server name = %q, want %q", cfg.Servers[0].Name, tt.wantServer)
+			}
+		})
+	}
+}
+
+func TestParseConfigBothFormats(t *testing.T) {
+	data := `{
+		"servers": [
+			{"name": "github", "command": "github-server"},
+			{"name": "docs", "command": "docs-server"}
+		],
+		"mcpServers": {
+			"shortcut": {"command": "shortcut-server"},
+			"github": {"command": "other-github-server"},
+			"browser": {"command": "browser-server"}
+		}
+	}`
+	cfg, err := ParseConfig(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+
+	// Array servers come first, object servers follow in name order unless the array defines them too
+	want := []ServerConfig{
+		{Name: "github", Command: "github-server"},
+		{Name: "docs", Command: "docs-server"},
+		{Name: "browser", Command: "browser-server"},
+		{Name: "shortcut", Command: "shortcut-server"},
+	}
+	if !reflect.DeepEqual(cfg.Servers, want) {
+		t.Errorf("ParseConfig() servers = %+v, want %+v", cfg.Servers, want)
+	}
+	if want := []string{"servers", "mcpServers"}; !reflect.DeepEqual(cfg.ServerFormats, want) {
+		t.Errorf("ParseConfig() formats = %v, want %v", cfg.ServerFormats, want)
+	}
+	if len(cfg.Warnings) != 1 || !strings.Contains(cfg.Warnings[0], "server github is defined in both") {
+		t.Errorf("ParseConfig() warnings = %q, want one about github defined twice", cfg.Warnings)
+	}
+}
+
+func TestParseConfigEmptyAllowed(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         string
+		wantWarnings int
+	}{
+		{
+			name:         "Empty allowed list in JSON",
+			data:         `{"mcpServers": {"github": {"command": "github-server", "tools": {"allowed": []}}}}`,
+			wantWarnings: 1,
+		},
+		{
+			name:         "Empty allowed list in YAML",
+			data:         "mcpServers:\n  github:\n    command: github-server\n    tools:\n      allowed: []\n",
+			wantWarnings: 1,
+		},
+		{
+			name:         "Empty allowed list with patterns",
+			data:         `{"mcpServers": {"github": {"command": "github-server", "tools": {"allowed": [], "allowedPatterns": ["^list_"]}}}}`,
+			wantWarnings: 0,
+		},
+		{
+			name:         "No allowed list",
+			data:         `{"mcpServers": {"github": {"command": "github-server", "tools": {"denied": ["delete"]}}}}`,
+			wantWarnings: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ParseConfig(strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("ParseConfig() error = %v", err)
+			}
+			if len(cfg.Warnings) != tt.wantWarnings {
+				t.Fatalf("ParseConfig() warnings = %q, want %d", cfg.Warnings, tt.wantWarnings)
+			}
+			if tt.wantWarnings > 0 && !strings.Contains(cfg.Warnings[0], "server github has an empty tools.allowed list") {
+				t.Errorf("ParseConfig() warning = %q, want one about the empty allowed list of github", cfg.Warnings[0])
+			}
+		})
+	}
+}
+
+func TestParseConfigDownstreamLogging(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want bool
+	}{
+		{name: "Not set", data: `{"mcpServers": {"github": {"command": "npx"}}}`, want: true},
+		{name: "Enabled", data: `{"downstreamLogging": true, "mcpServers": {"github": {"command": "npx"}}}`, want: true},
+		{name: "Disabled", data: `{"downstreamLogging": false, "mcpServers": {"github": {"command": "npx"}}}`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ParseConfig(strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("ParseConfig() error = %v", err)
+			}
+			if cfg.DownstreamLogging != tt.want {
+				t.Errorf("ParseConfig() DownstreamLogging = %v, want %v", cfg.DownstreamLogging, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	want := []ServerConfig{{
+		Name:    "github",
+		Command: "npx",
+		Args:    []string{"-y", "@modelcontextprotocol/server-github"},
+		Env:     map[string]string{"GITHUB_OWNER": "octo"},
+	}}
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{
+			name: "Object format JSON",
+			data: `{"mcpServers": {"github": {
+				"command": "npx",
+				"args": ["-y", "@modelcontextprotocol/server-github"],
+				"env": {"GITHUB_OWNER": "octo"}
+			}}}`,
+		},
+		{
+			name: "Object format YAML",
+			data: `mcpServers:
+  github:
+    command: npx
+    args: ["-y", "@modelcontextprotocol/server-github"]
+    env:
+      GITHUB_OWNER: octo
+`,
+		},
+		{
+			name: "Array format JSON",
+			data: `{"servers": [{
+				"name": "github",
+				"command": "npx",
+				"args": ["-y", "@modelcontextprotocol/server-github"],
+				"env": {"GITHUB_OWNER": "octo"}
+			}]}`,
+		},
+		{
+			name: "Array format YAML",
+			data: `servers:
+  - name: github
+    command: npx
+    args: ["-y", "@modelcontextprotocol/server-github"]
+    env:
+      GITHUB_OWNER: octo
+`,
+		},
+		{
+			name:    "Invalid array format config",
+			data:    `{"servers": [{"name": "github", "command": ""}]}`,
+			wantErr: "command",
+		},
+		{
+			name:    "Invalid config",
+			data:    `{"mcpServers": {"github": {"command": ""}}}`,
+			wantErr: "command",
+		},
+		{
+			name:    "Unparsable config",
+			data:    "{",
+			wantErr: "error parsing config",
+		},
+		{
+			name:    "Duplicate server names",
+			data:    `{"servers": [{"name": "github", "command": "npx"}, {"name": "github", "command": "docker"}]}`,
+			wantErr: "duplicate server name github at index 1, first defined at index 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ParseConfig(strings.NewReader(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ParseConfig() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseConfig() error = %v", err)
+			}
+			if !reflect.DeepEqual(cfg.Servers, want) {
+				t.Errorf("ParseConfig() servers = %+v, want %+v", cfg.Servers, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigStdin(t *testing.T) {
+	t.Setenv("TEST_CONFIG", StdinConfigPath)
+	t.Setenv(ConfigJSONEnvVar, "")
+
+	stdin := strings.NewReader("mcpServers:\n  github:\n    command: npx\n")
+	cfg, err := loadConfig("TEST_CONFIG", stdin)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if len(cfg.Servers) != 1 || cfg.Servers[0].Name != "github" || cfg.Servers[0].Command != "npx" {
+		t.Errorf("Servers = %+v, want the github server read from stdin", cfg.Servers)
+	}
+	if !cfg.FromStdin {
+		t.Errorf("FromStdin = false, want true")
+	}
+
+	if _, err := loadConfig("TEST_CONFIG", strings.NewReader("")); err == nil {
+		t.Errorf("loadConfig() with empty stdin error = nil, want an error")
+	}
+}
+
+func TestLoadConfigInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		return path
+	}
+
+	// Includes are resolved relative to the directory of the including file
+	writeFile("shared/common.json", `{"include": ["nested/extra.yaml"], "servers": [{"name": "github", "command": "npx"}]}`)
+	writeFile("shared/nested/extra.yaml", "mcpServers:\n  docs:\n    command: docs-server\n")
+	mainConfig := writeFile("config.json", `{"include": ["shared/common.json"], "servers": [{"name": "local", "command": "local-server"}]}`)
+
+	// a.json -> b.json -> a.json
+	cyclicConfig := writeFile("cycle/a.json", `{"include": ["b.json"], "servers": [{"name": "a", "command": "a"}]}`)
+	writeFile("cycle/b.json", `{"include": ["a.json"], "servers": [{"name": "b", "command": "b"}]}`)
+
+	missingConfig := writeFile("missing.json", `{"include": ["nowhere.json"], "servers": [{"name": "local", "command": "local-server"}]}`)
+
+	tests := []struct {
+		name        string
+		configPath  string
+		wantServers []string
+		wantErr     string
+	}{
+		{
+			name:        "Included servers come before local ones",
+			configPath:  mainConfig,
+			wantServers: []string{"docs", "github", "local"},
+		},
+		{
+			name:       "Include cycle",
+			configPath: cyclicConfig,
+			wantErr:    "include cycle",
+		},
+		{
+			name:       "Missing include",
+			configPath: missingConfig,
+			wantErr:    "error reading included config",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_CONFIG", tt.configPath)
+			t.Setenv(ConfigJSONEnvVar, "")
+
+			cfg, err := LoadConfig("TEST_CONFIG")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+
+			var names []string
+			for _, server := range cfg.Servers {
+				names = append(names, server.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantServers) {
+				t.Errorf("Servers = %v, want %v", names, tt.wantServers)
+			}
+		})
+	}
+}
+
+func TestLoadConfigServerIdentity(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		envName     string
+		envVersion  string
+		wantName    string
+		wantVersion string
+	}{
+		{
+			name:   "Unset identity",
+			config: `{"servers": [{"name": "test", "command": "server"}]}`,
+		},
+		{
+			name:        "Configured identity",
+			config:      `{"serverName": "acme-tools", "serverVersion": "2.1.0", "servers": [{"name": "test", "command": "server"}]}`,
+			wantName:    "acme-tools",
+			wantVersion: "2.1.0",
+		},
+		{
+			name:        "Environment overrides the config",
+			config:      `{"serverName": "acme-tools", "serverVersion": "2.1.0", "servers": [{"name": "test", "command": "server"}]}`,
+			envName:     "acme-env",
+			wantName:    "acme-env",
+			wantVersion: "2.1.0",
+		},
+		{
+			name:        "Environment only",
+			config:      `{"servers": [{"name": "test", "command": "server"}]}`,
+			envName:     "acme-env",
+			envVersion:  "3.0.0",
+			wantName:    "acme-env",
+			wantVersion: "3.0.0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(configPath, []byte(tt.config), 0644); err != nil {
+				t.Fatalf("Failed to write test config file: %v", err)
+			}
+			t.Setenv("TEST_CONFIG", configPath)
+			t.Setenv(ServerNameEnvVar, tt.envName)
+			t.Setenv(ServerVersionEnvVar, tt.envVersion)
+
+			cfg, err := LoadConfig("TEST_CONFIG")
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+			if cfg.ServerName != tt.wantName || cfg.ServerVersion != tt.wantVersion {
+				t.Errorf("Identity = %q %q, want %q %q", cfg.ServerName, cfg.ServerVersion, tt.wantName, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestGetLogLevel(t *testing.T) {
+	tests := []struct {
+		value string
+		want  LogLevel
+	}{
+		{value: "", want: LogLevelInfo},
+		{value: "error", want: LogLevelError},
+		{value: "ERROR", want: LogLevelError},
+		{value: "Info", want: LogLevelInfo},
+		{value: "DEBUG", want: LogLevelDebug},
+		{value: " trace\n", want: LogLevelTrace},
+		{value: "warn", want: LogLevelWarn},
+		{value: "Warning", want: LogLevelWarn},
+		{value: "VERBOSE", want: LogLevelTrace},
+		{value: "0", want: LogLevelError},
+		{value: "1", want: LogLevelInfo},
+		{value: " 2 ", want: LogLevelDebug},
+		{value: "3", want: LogLevelTrace},
+		{value: "4", want: LogLevelInfo},
+		{value: "7", want: LogLevelInfo},
+		{value: "loud", want: LogLevelInfo},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv(LogLevelEnvVar, tt.value)
+			if got := GetLogLevel(); got != tt.want {
+				t.Errorf("GetLogLevel() with %q = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogLevelOrder(t *testing.T) {
+	// Messages are logged if the configured level is at least theirs, so levels go from least to most verbose
+	levels := []LogLevel{LogLevelError, LogLevelWarn, LogLevelInfo, LogLevelDebug, LogLevelTrace}
+	for i := 1; i < len(levels); i++ {
+		if levels[i-1] >= levels[i] {
+			t.Errorf("Log level %d isn't less verbose than log level %d", levels[i-1], levels[i])
+		}
+	}
+}
+
+func TestGetAllowedCommands(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{name: "Unset", value: "", want: nil},
+		{name: "Single command", value: "npx", want: []string{"npx"}},
+		{name: "Paths and basenames", value: "/usr/bin/docker:npx: uvx", want: []string{"/usr/bin/docker", "npx", "uvx"}},
+		{name: "Only separators allow nothing", value: "::", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(AllowedCommandsEnvVar, tt.value)
+			if got := GetAllowedCommands(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetAllowedCommands() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetStdoutCapture(t *testing.T) {
+	tests := []struct {
+		name     string
+		disabled string
+		buffer   string
+		want     StdoutCapture
+	}{
+		{name: "Unset", want: StdoutCapture{}},
+		{name: "Disabled", disabled: "true", want: StdoutCapture{Disabled: true}},
+		{name: "Buffer size", buffer: "65536", want: StdoutCapture{BufferSize: 65536}},
+		{name: "Invalid values fall back to the defaults", disabled: "maybe", buffer: "-1", want: StdoutCapture{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(DisableStdoutCaptureEnvVar, tt.disabled)
+			t.Setenv(StdoutCaptureBufferEnvVar, tt.buffer)
+			if got := GetStdoutCapture(); got != tt.want {
+				t.Errorf("GetStdoutCapture() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ReadEnvFile parses a file of KEY=VALUE lines. Blank lines and lines starting with # are skipped,
+// values may be enclosed in single or double quotes, and $VAR and ${VAR} references are expanded.
+func ReadEnvFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env file: %w", err)
+	}
+
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, found := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return nil, fmt.Errorf("env file %s line %d is not KEY=VALUE: %q", path, lineNumber, line)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env[key] = os.Expand(value, func(name string) string {
+			// $$ escapes a literal dollar sign
+			if name == "$" {
+				return "$"
+			}
+			return os.Getenv(name)
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read env file: %w", err)
+	}
+	return env, nil
+}
+
+// WithEnvFile returns the server with the variables of its env file merged into its env.
+// Variables set in env take precedence, and values read from the file are treated as secrets.
+func (s ServerConfig) WithEnvFile() (ServerConfig, error) {
+	if s.EnvFile == "" {
+		return s, nil
+	}
+
+	fileEnv, err := ReadEnvFile(s.EnvFile)
+	if err != nil {
+		return s, fmt.Errorf("server %s: %w", s.Name, err)
+	}
+	secretEnv := append([]string(nil), s.SecretEnv...)
+	for key := range fileEnv {
+		if _, inline := s.Env[key]; !inline {
+			secretEnv = append(secretEnv, key)
+		}
+	}
+	s.Env = mergeMaps(fileEnv, s.Env)
+	s.SecretEnv = secretEnv
+	return s, nil
+}
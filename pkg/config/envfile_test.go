@@ -0,0 +1,103 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadEnvFile(t *testing.T) {
+	t.Setenv("ENV_FILE_HOME", "/home/octo")
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:    "Plain values",
+			content: "GITHUB_TOKEN=ghp_secret\nGITHUB_OWNER = octo\n",
+			want:    map[string]string{"GITHUB_TOKEN": "ghp_secret", "GITHUB_OWNER": "octo"},
+		},
+		{
+			name:    "Comments and blank lines",
+			content: "# GitHub access\n\n  # indented comment\nGITHUB_TOKEN=ghp_secret\n",
+			want:    map[string]string{"GITHUB_TOKEN": "ghp_secret"},
+		},
+		{
+			name:    "Quotes, export and equal signs in values",
+			content: "export GREETING=\"hello world\"\nQUERY='a=b'\nFILTER=x=y\nEMPTY=\n",
+			want:    map[string]string{"GREETING": "hello world", "QUERY": "a=b", "FILTER": "x=y", "EMPTY": ""},
+		},
+		{
+			name:    "References are expanded",
+			content: "CONFIG_DIR=${ENV_FILE_HOME}/.config\nPRICE=$$5\nUNSET=${ENV_FILE_UNSET}\n",
+			want:    map[string]string{"CONFIG_DIR": "/home/octo/.config", "PRICE": "$5", "UNSET": ""},
+		},
+		{
+			name:    "Line without value",
+			content: "GITHUB_TOKEN=ghp_secret\nGITHUB_OWNER\n",
+			wantErr: "line 2 is not KEY=VALUE",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ".env")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("Failed to write env file: %v", err)
+			}
+
+			got, err := ReadEnvFile(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ReadEnvFile() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadEnvFile() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadEnvFile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("GITHUB_TOKEN=from-file\nGITHUB_OWNER=file-owner\n"), 0600); err != nil {
+		t.Fatalf("Failed to write env file: %v", err)
+	}
+
+	server := ServerConfig{
+		Name:    "github",
+		Command: "github-mcp",
+		EnvFile: path,
+		Env:     map[string]string{"GITHUB_OWNER": "inline-owner"},
+	}
+	got, err := server.WithEnvFile()
+	if err != nil {
+		t.Fatalf("WithEnvFile() error = %v", err)
+	}
+
+	// Inline env takes precedence over the file
+	wantEnv := map[string]string{"GITHUB_TOKEN": "from-file", "GITHUB_OWNER": "inline-owner"}
+	if !reflect.DeepEqual(got.Env, wantEnv) {
+		t.Errorf("Env = %v, want %v", got.Env, wantEnv)
+	}
+	if !reflect.DeepEqual(got.SecretEnv, []string{"GITHUB_TOKEN"}) {
+		t.Errorf("SecretEnv = %v, want the variables read from the file", got.SecretEnv)
+	}
+	if len(server.Env) != 1 {
+		t.Errorf("WithEnvFile() modified the env of the original server: %v", server.Env)
+	}
+
+	server.EnvFile = filepath.Join(t.TempDir(), "missing.env")
+	if _, err := server.WithEnvFile(); err == nil || !strings.Contains(err.Error(), "server github: failed to read env file") {
+		t.Errorf("WithEnvFile() with a missing file error = %v, want a read error naming the server", err)
+	}
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// ToolNameData holds the fields a name template can use to build the exposed name of a tool
+type ToolNameData struct {
+	Server    string // Prefix of the server: its configured prefix, or its name
+	Tool      string // Original name of the tool
+	Sanitized string // Name of the tool as sanitized according to the sanitize mode
+}
+
+// ParseNameTemplate parses a template that builds exposed tool names.
+// It is tried on a sample tool, so references to fields that don't exist are reported now rather than on discovery.
+func ParseNameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("nameTemplate").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ExecuteNameTemplate(tmpl, ToolNameData{Server: "server", Tool: "tool-name", Sanitized: "tool_name"}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// ExecuteNameTemplate builds the exposed name of a tool, which must not be empty
+func ExecuteNameTemplate(tmpl *template.Template, data ToolNameData) (string, error) {
+	var name strings.Builder
+	if err := tmpl.Execute(&name, data); err != nil {
+		return "", err
+	}
+	if name.Len() == 0 {
+		return "", fmt.Errorf("template produced an empty name for tool %s", data.Tool)
+	}
+	return name.String(), nil
+}
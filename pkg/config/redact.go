@@ -0,0 +1,113 @@
+package config
+
+import (
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Redacted replaces secret values in logs and output
+const Redacted = "***"
+
+// secretNameParts mark the names of env vars and flags that hold secrets
+var secretNameParts = []string{"TOKEN", "SECRET", "KEY", "PASSWORD"}
+
+// IsSecretName reports whether the name of an env var or flag looks like it holds a secret
+func IsSecretName(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, part := range secretNameParts {
+		if strings.Contains(upper, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSecretEnv reports whether the value of an env var of the server must not be shown
+func (s ServerConfig) isSecretEnv(key string) bool {
+	return IsSecretName(key) || slices.Contains(s.SecretEnv, key)
+}
+
+// RedactedEnv returns the env of the server as KEY=value pairs sorted by key,
+// with the values of secret env vars replaced by Redacted
+func (s ServerConfig) RedactedEnv() []string {
+	env := make([]string, 0, len(s.Env))
+	for key, value := range s.Env {
+		if s.isSecretEnv(key) {
+			value = Redacted
+		}
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// RedactedArgs returns the args of the server with secrets replaced by Redacted: the values of secret
+// env vars wherever they appear, and the values of flags named like secrets, as in --api-key=value or --token value
+func (s ServerConfig) RedactedArgs() []string {
+	var secrets []string
+	for key, value := range s.Env {
+		if value != "" && s.isSecretEnv(key) {
+			secrets = append(secrets, value)
+		}
+	}
+
+	args := make([]string, len(s.Args))
+	secretFlag := false
+	for i, arg := range s.Args {
+		isFlag := strings.HasPrefix(arg, "-")
+		switch {
+		case secretFlag && !isFlag:
+			arg = Redacted
+		case isFlag && strings.Contains(arg, "="):
+			if name, _, _ := strings.Cut(arg, "="); IsSecretName(name) {
+				arg = name + "=" + Redacted
+			}
+		}
+		for _, secret := range secrets {
+			arg = strings.ReplaceAll(arg, secret, Redacted)
+		}
+		args[i] = arg
+		secretFlag = isFlag && !strings.Contains(arg, "=") && IsSecretName(arg)
+	}
+	return args
+}
+
+// RedactedURL returns the URL of the server with secrets replaced by Redacted: the credentials
+// in its userinfo, the values of query parameters named like secrets, and the values of secret env vars
+func (s ServerConfig) RedactedURL() string {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		// An unparsable URL may still hold a secret, so it's not shown at all
+		return Redacted
+	}
+
+	// A lone username is often a token itself, as in https://token@host
+	const placeholder = "REDACTED"
+	if u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			u.User = url.UserPassword(u.User.Username(), placeholder)
+		} else {
+			u.User = url.User(placeholder)
+		}
+	}
+	if u.RawQuery != "" {
+		params := strings.Split(u.RawQuery, "&")
+		for i, param := range params {
+			name, _, hasValue := strings.Cut(param, "=")
+			if unescaped, err := url.QueryUnescape(name); err == nil && hasValue && IsSecretName(unescaped) {
+				params[i] = name + "=" + placeholder
+			}
+		}
+		u.RawQuery = strings.Join(params, "&")
+	}
+
+	redacted := strings.ReplaceAll(u.String(), placeholder, Redacted)
+	for key, value := range s.Env {
+		if value != "" && s.isSecretEnv(key) {
+			redacted = strings.ReplaceAll(redacted, value, Redacted)
+		}
+	}
+	return redacted
+}
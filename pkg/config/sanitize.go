@@ -0,0 +1,25 @@
+package config
+
+import "strings"
+
+// SanitizeToolName makes a tool name compatible with clients according to the sanitize mode.
+// The cursor mode, also used if no mode is set, replaces dashes with underscores.
+func SanitizeToolName(name, mode string) string {
+	switch mode {
+	case SanitizeNone:
+		return name
+	case SanitizeStrict:
+		return strings.Map(func(r rune) rune {
+			switch {
+			case r == '-':
+				return '_'
+			case r == '_', 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+				return r
+			default:
+				return -1
+			}
+		}, name)
+	default:
+		return strings.ReplaceAll(name, "-", "_")
+	}
+}
@@ -0,0 +1,71 @@
+package config
+
+import "testing"
+
+func TestSanitizeToolName(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "No dashes",
+			input:    "getuser",
+			expected: "getuser",
+		},
+		{
+			name:     "Single dash",
+			input:    "get-user",
+			expected: "get_user",
+		},
+		{
+			name:     "Multiple dashes",
+			input:    "get-user-details",
+			expected: "get_user_details",
+		},
+		{
+			name:     "Already has underscores",
+			input:    "get_user",
+			expected: "get_user",
+		},
+		{
+			name:     "Mixed dashes and underscores",
+			input:    "get_user-details",
+			expected: "get_user_details",
+		},
+		{
+			name:     "Cursor mode replaces dashes",
+			mode:     SanitizeCursor,
+			input:    "get-user.details",
+			expected: "get_user.details",
+		},
+		{
+			name:     "None mode keeps the name",
+			mode:     SanitizeNone,
+			input:    "get-user.details",
+			expected: "get-user.details",
+		},
+		{
+			name:     "Strict mode replaces dashes and strips other characters",
+			mode:     SanitizeStrict,
+			input:    "get-user.details/v2 (beta)",
+			expected: "get_userdetailsv2beta",
+		},
+		{
+			name:     "Strict mode keeps valid names",
+			mode:     SanitizeStrict,
+			input:    "Get_User2",
+			expected: "Get_User2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := SanitizeToolName(tt.input, tt.mode)
+			if result != tt.expected {
+				t.Errorf("SanitizeToolName(%q, %q) = %q, want %q", tt.input, tt.mode, result, tt.expected)
+			}
+		})
+	}
+}
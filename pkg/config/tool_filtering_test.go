@@ -2,6 +2,7 @@ package config
 
 import (
 	"encoding/json"
+	"reflect"
 	"testing"
 )
 
@@ -38,6 +39,34 @@ func TestToolsConfig(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Valid config with allowed and denied tools",
+			json: `{
+				"servers": [
+					{
+						"name": "test-server",
+						"command": "test-command",
+						"tools": {
+							"allowed": ["tool1", "tool2"],
+							"denied": ["tool2"]
+						}
+					}
+				]
+			}`,
+			want: &Config{
+				Servers: []ServerConfig{
+					{
+						Name:    "test-server",
+						Command: "test-command",
+						Tools: &ToolsConfig{
+							Allowed: []string{"tool1", "tool2"},
+							Denied:  []string{"tool2"},
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
 		{
 			name: "Valid config without tool filtering",
 			json: `{
@@ -155,6 +184,9 @@ func TestToolsConfig(t *testing.T) {
 								t.Errorf("Server[%d].Tools.Allowed[%d] = %v, want %v", i, j, tool, wantServer.Tools.Allowed[j])
 							}
 						}
+						if !reflect.DeepEqual(server.Tools.Denied, wantServer.Tools.Denied) {
+							t.Errorf("Server[%d].Tools.Denied = %v, want %v", i, server.Tools.Denied, wantServer.Tools.Denied)
+						}
 					}
 				}
 			}
@@ -0,0 +1,233 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Validate checks a configuration for problems that would prevent the aggregator from working as configured.
+// It reports every problem found, not just the first one.
+func Validate(cfg *Config) error {
+	var problems []error
+	addProblem := func(format string, v ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, v...))
+	}
+
+	if len(cfg.Servers) == 0 {
+		addProblem("no servers defined in config")
+	} else if cfg.Passthrough && len(cfg.Servers) > 1 {
+		addProblem("passthrough requires exactly one server, found %d", len(cfg.Servers))
+	}
+	switch cfg.SanitizeMode {
+	case "", SanitizeCursor, SanitizeNone, SanitizeStrict:
+	default:
+		addProblem("invalid sanitize mode %q", cfg.SanitizeMode)
+	}
+	switch cfg.ServeMode {
+	case "", ServeModeStdio, ServeModeHTTP:
+	default:
+		addProblem("invalid serve mode %q: only %s and %s are supported", cfg.ServeMode, ServeModeStdio, ServeModeHTTP)
+	}
+	if strings.Trim(cfg.Delimiter, "_.-") != "" {
+		addProblem("invalid delimiter %q: only _, . and - are allowed", cfg.Delimiter)
+	} else if cfg.SanitizeMode == SanitizeStrict && strings.Trim(cfg.Delimiter, "_") != "" {
+		addProblem("delimiter %q is not allowed in strict sanitize mode, which only exposes [a-zA-Z0-9_]", cfg.Delimiter)
+	} else if cfg.SanitizeMode != SanitizeNone && strings.Contains(cfg.Delimiter, "-") {
+		// The cursor mode would turn the delimiter into underscores, the ambiguity it is meant to avoid
+		addProblem("delimiter %q requires sanitize mode %s, the %s mode replaces - with _", cfg.Delimiter, SanitizeNone, SanitizeCursor)
+	}
+	if cfg.StartupStaggerMs < 0 {
+		addProblem("negative startup stagger")
+	}
+	if cfg.SlowCallThresholdMs < 0 {
+		addProblem("negative slow call threshold")
+	}
+	if cfg.NameTemplate != "" {
+		if _, err := ParseNameTemplate(cfg.NameTemplate); err != nil {
+			addProblem("invalid name template: %w", err)
+		}
+	}
+
+	names := make(map[string]int, len(cfg.Servers)) // Index of the first server of each name
+	prefixes := make(map[string][]string)
+	for i, server := range cfg.Servers {
+		if server.Name == "" {
+			addProblem("server at index %d missing name", i)
+			continue
+		}
+		// Servers of the same name would replace each other's client and tools
+		if first, duplicate := names[server.Name]; duplicate {
+			addProblem("duplicate server name %s at index %d, first defined at index %d", server.Name, i, first)
+		} else {
+			names[server.Name] = i
+		}
+
+		problems = append(problems, validateServer(server)...)
+
+		// Tool names are only unique across servers if their prefixes are. A name template builds
+		// names its own way, so the prefix joined with the delimiter doesn't tell whether they collide.
+		if !cfg.DisablePrefix && !server.NoPrefix && cfg.NameTemplate == "" {
+			prefix := server.Prefix
+			if prefix == "" {
+				prefix = server.Name
+			}
+			// Dashes are sanitized to underscores unless sanitization is disabled, so my-server and my_server share a prefix
+			prefix = SanitizeToolName(prefix, cfg.SanitizeMode)
+			prefixes[prefix] = append(prefixes[prefix], server.Name)
+		}
+	}
+
+	if i, ok := names[cfg.SamplingFallback]; cfg.SamplingFallback != "" && !ok {
+		addProblem("sampling fallback %s is not a configured server", cfg.SamplingFallback)
+	} else if ok && cfg.Servers[i].Transport == TransportSSE {
+		// The SSE client can't send requests of its own to the server
+		addProblem("sampling fallback %s uses the %s transport, only %s and %s are supported", cfg.SamplingFallback, TransportSSE, TransportStdio, TransportHTTP)
+	}
+
+	conflicting := make([]string, 0, len(prefixes))
+	for prefix, servers := range prefixes {
+		if len(servers) > 1 {
+			conflicting = append(conflicting, prefix)
+		}
+	}
+	sort.Strings(conflicting)
+	for _, prefix := range conflicting {
+		addProblem("servers %s share the tool prefix %s", strings.Join(prefixes[prefix], ", "), prefix)
+	}
+
+	return errors.Join(problems...)
+}
+
+// validateServer checks the settings of a single server
+func validateServer(server ServerConfig) []error {
+	var problems []error
+	addProblem := func(format string, v ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, v...))
+	}
+
+	switch server.Transport {
+	case "", TransportStdio:
+		if server.Command == "" {
+			addProblem("server %s missing command", server.Name)
+		}
+	case TransportSSE, TransportHTTP:
+		if server.URL == "" {
+			addProblem("server %s missing url", server.Name)
+		} else if parsed, err := url.Parse(server.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			addProblem("server %s has invalid url %q", server.Name, server.URL)
+		}
+	default:
+		addProblem("server %s has invalid transport %q", server.Name, server.Transport)
+	}
+	if server.WorkingDir != "" {
+		if info, err := os.Stat(server.WorkingDir); err != nil {
+			addProblem("server %s has unusable working directory: %w", server.Name, err)
+		} else if !info.IsDir() {
+			addProblem("server %s working directory %s is not a directory", server.Name, server.WorkingDir)
+		}
+	}
+
+	if server.EnvFile != "" {
+		if info, err := os.Stat(server.EnvFile); err != nil {
+			addProblem("server %s has unusable env file: %w", server.Name, err)
+		} else if info.IsDir() {
+			addProblem("server %s env file %s is a directory", server.Name, server.EnvFile)
+		}
+	}
+
+	// Options of configs built in code may hold values that can't be sent, and the SSE client can't send any
+	if len(server.InitOptions) > 0 {
+		if _, err := json.Marshal(server.InitOptions); err != nil {
+			addProblem("server %s has init options that can't be encoded as JSON: %w", server.Name, err)
+		}
+		if server.Transport == TransportSSE {
+			addProblem("server %s uses the %s transport, which doesn't support init options", server.Name, TransportSSE)
+		}
+	}
+
+	switch server.Restart {
+	case "", RestartNo, RestartOnFailure, RestartAlways:
+	default:
+		addProblem("server %s has invalid restart policy %q", server.Name, server.Restart)
+	}
+	if server.InitTimeoutSeconds < 0 {
+		addProblem("server %s has negative init timeout", server.Name)
+	}
+	if server.InitRetries < 0 || server.InitRetryBackoffMs < 0 {
+		addProblem("server %s has negative init retry settings", server.Name)
+	}
+	if server.ReadinessTimeoutSeconds < 0 {
+		addProblem("server %s has negative readiness timeout", server.Name)
+	}
+	if server.CallTimeoutSeconds < 0 {
+		addProblem("server %s has negative call timeout", server.Name)
+	}
+	if server.HealthCheckSeconds < 0 {
+		addProblem("server %s has negative health check interval", server.Name)
+	}
+	if server.CacheTTLSeconds < 0 {
+		addProblem("server %s has negative cache TTL", server.Name)
+	}
+	if server.MaxConcurrentCalls < 0 {
+		addProblem("server %s has negative concurrent call limit", server.Name)
+	}
+	if server.RateLimitPerMinute < 0 {
+		addProblem("server %s has negative rate limit", server.Name)
+	}
+	if server.RestartBackoffMs < 0 || server.RestartMaxBurst < 0 || server.RestartWindowSeconds < 0 {
+		addProblem("server %s has negative restart settings", server.Name)
+	}
+	if server.ShutdownGraceMs < 0 {
+		addProblem("server %s has negative shutdown grace period", server.Name)
+	}
+
+	if server.Tools != nil {
+		for toolName, override := range server.Tools.Overrides {
+			if override.Schema != nil {
+				if err := validateToolSchema(override.Schema); err != nil {
+					addProblem("server %s has invalid schema override for tool %s: %w", server.Name, toolName, err)
+				}
+			}
+			for param, values := range override.AllowedValues {
+				if len(values) == 0 {
+					addProblem("server %s has an empty allowed values list for parameter %s of tool %s", server.Name, param, toolName)
+				}
+			}
+		}
+		for _, toolName := range server.Tools.Cacheable {
+			if server.CacheTTLSeconds <= 0 && overrideCacheTTL(server.Tools, toolName) <= 0 {
+				addProblem("server %s marks tool %s cacheable without a cache TTL", server.Name, toolName)
+			}
+		}
+		for _, pattern := range slices.Concat(server.Tools.AllowedPatterns, server.Tools.DeniedPatterns) {
+			if _, err := regexp.Compile(pattern); err != nil {
+				addProblem("server %s has invalid tool pattern %q: %w", server.Name, pattern, err)
+			}
+		}
+		for presetName, preset := range server.Tools.Presets {
+			if preset.Tool == "" {
+				addProblem("server %s preset %s missing tool", server.Name, presetName)
+			}
+		}
+	}
+	return problems
+}
+
+// overrideCacheTTL returns the cache TTL override of a tool.
+// Like the aggregator, it treats dashes and underscores in tool names as the same.
+func overrideCacheTTL(tools *ToolsConfig, toolName string) int {
+	normalizedName := strings.ReplaceAll(toolName, "-", "_")
+	for name, override := range tools.Overrides {
+		if strings.ReplaceAll(name, "-", "_") == normalizedName {
+			return override.CacheTTLSeconds
+		}
+	}
+	return 0
+}
@@ -0,0 +1,339 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	workingDir := t.TempDir()
+	notADir := filepath.Join(workingDir, "file.txt")
+	if err := os.WriteFile(notADir, nil, 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr []string // Substrings of the expected problems, none for a valid config
+	}{
+		{
+			name: "Valid config",
+			config: Config{Servers: []ServerConfig{
+				{Name: "github", Command: "npx"},
+				{Name: "docs", Transport: TransportSSE, URL: "http://localhost:8080/sse"},
+			}},
+		},
+		{
+			name:    "No servers",
+			config:  Config{},
+			wantErr: []string{"no servers defined"},
+		},
+		{
+			name:    "Missing name",
+			config:  Config{Servers: []ServerConfig{{Command: "npx"}}},
+			wantErr: []string{"server at index 0 missing name"},
+		},
+		{
+			name: "Duplicate server names",
+			config: Config{Servers: []ServerConfig{
+				{Name: "github", Command: "npx", Prefix: "gh"},
+				{Name: "github", Command: "docker"},
+			}},
+			wantErr: []string{"duplicate server name github at index 1, first defined at index 0"},
+		},
+		{
+			name:    "Empty command",
+			config:  Config{Servers: []ServerConfig{{Name: "github"}}},
+			wantErr: []string{"server github missing command"},
+		},
+		{
+			name:    "Remote server without url",
+			config:  Config{Servers: []ServerConfig{{Name: "docs", Transport: TransportHTTP}}},
+			wantErr: []string{"server docs missing url"},
+		},
+		{
+			name: "Conflicting prefixes",
+			config: Config{Servers: []ServerConfig{
+				{Name: "github", Command: "npx", Prefix: "git"},
+				{Name: "git", Command: "git-mcp"},
+			}},
+			wantErr: []string{"servers github, git share the tool prefix git"},
+		},
+		{
+			// Templated names are checked for collisions when the tools are discovered
+			name: "Conflicting prefixes with a name template",
+			config: Config{NameTemplate: "{{.Tool}}", Servers: []ServerConfig{
+				{Name: "github", Command: "npx", Prefix: "git"},
+				{Name: "git", Command: "git-mcp"},
+			}},
+		},
+		{
+			name: "Prefixes conflicting after sanitization",
+			config: Config{Servers: []ServerConfig{
+				{Name: "my-server", Command: "a"},
+				{Name: "my_server", Command: "b"},
+			}},
+			wantErr: []string{"share the tool prefix my_server"},
+		},
+		{
+			name: "Prefixes don't conflict without sanitization",
+			config: Config{SanitizeMode: SanitizeNone, Servers: []ServerConfig{
+				{Name: "my-server", Command: "a"},
+				{Name: "my_server", Command: "b"},
+			}},
+		},
+		{
+			name: "Prefixes conflicting after strict sanitization",
+			config: Config{SanitizeMode: SanitizeStrict, Servers: []ServerConfig{
+				{Name: "my.server", Command: "a"},
+				{Name: "myserver", Command: "b"},
+			}},
+			wantErr: []string{"share the tool prefix myserver"},
+		},
+		{
+			name:    "Invalid sanitize mode",
+			config:  Config{SanitizeMode: "windsurf", Servers: []ServerConfig{{Name: "github", Command: "npx"}}},
+			wantErr: []string{`invalid sanitize mode "windsurf"`},
+		},
+		{
+			name:    "Invalid serve mode",
+			config:  Config{ServeMode: "sse", Servers: []ServerConfig{{Name: "github", Command: "npx"}}},
+			wantErr: []string{`invalid serve mode "sse"`},
+		},
+		{
+			name:   "HTTP serve mode",
+			config: Config{ServeMode: ServeModeHTTP, Servers: []ServerConfig{{Name: "github", Command: "npx"}}},
+		},
+		{
+			name:   "Double underscore delimiter",
+			config: Config{Delimiter: "__", Servers: []ServerConfig{{Name: "github", Command: "npx"}}},
+		},
+		{
+			name:   "Name template",
+			config: Config{NameTemplate: "{{.Tool}}@{{.Server}}", Servers: []ServerConfig{{Name: "github", Command: "npx"}}},
+		},
+		{
+			name:    "Unparsable name template",
+			config:  Config{NameTemplate: "{{.Tool", Servers: []ServerConfig{{Name: "github", Command: "npx"}}},
+			wantErr: []string{"invalid name template"},
+		},
+		{
+			name:    "Name template with unknown field",
+			config:  Config{NameTemplate: "{{.Prefix}}_{{.Tool}}", Servers: []ServerConfig{{Name: "github", Command: "npx"}}},
+			wantErr: []string{"invalid name template", "Prefix"},
+		},
+		{
+			name:    "Invalid delimiter",
+			config:  Config{Delimiter: "/", Servers: []ServerConfig{{Name: "github", Command: "npx"}}},
+			wantErr: []string{`invalid delimiter "/"`},
+		},
+		{
+			name:    "Dot delimiter in strict mode",
+			config:  Config{Delimiter: ".", SanitizeMode: SanitizeStrict, Servers: []ServerConfig{{Name: "github", Command: "npx"}}},
+			wantErr: []string{`delimiter "." is not allowed in strict sanitize mode`},
+		},
+		{
+			name:    "Dash delimiter in cursor mode",
+			config:  Config{Delimiter: "--", Servers: []ServerConfig{{Name: "github", Command: "npx"}}},
+			wantErr: []string{`delimiter "--" requires sanitize mode none`},
+		},
+		{
+			name:   "Dash delimiter without sanitizing",
+			config: Config{Delimiter: "--", SanitizeMode: SanitizeNone, Servers: []ServerConfig{{Name: "github", Command: "npx"}}},
+		},
+		{
+			name: "Unprefixed servers don't conflict",
+			config: Config{DisablePrefix: true, Servers: []ServerConfig{
+				{Name: "github", Command: "npx", Prefix: "git"},
+				{Name: "git", Command: "git-mcp"},
+			}},
+		},
+		{
+			name: "Passthrough with several servers",
+			config: Config{Passthrough: true, Servers: []ServerConfig{
+				{Name: "github", Command: "npx"},
+				{Name: "shortcut", Command: "npx"},
+			}},
+			wantErr: []string{"passthrough requires exactly one server, found 2"},
+		},
+		{
+			name: "Sampling fallback",
+			config: Config{SamplingFallback: "llm", Servers: []ServerConfig{
+				{Name: "github", Command: "npx"},
+				{Name: "llm", Command: "npx"},
+			}},
+		},
+		{
+			name: "Unknown sampling fallback",
+			config: Config{SamplingFallback: "llm", Servers: []ServerConfig{
+				{Name: "github", Command: "npx"},
+			}},
+			wantErr: []string{"sampling fallback llm is not a configured server"},
+		},
+		{
+			name: "Sampling fallback over SSE",
+			config: Config{SamplingFallback: "llm", Servers: []ServerConfig{
+				{Name: "github", Command: "npx"},
+				{Name: "llm", Transport: TransportSSE, URL: "http://localhost:8080/sse"},
+			}},
+			wantErr: []string{"sampling fallback llm uses the sse transport"},
+		},
+		{
+			name: "Init options that can't be encoded",
+			config: Config{Servers: []ServerConfig{
+				{Name: "github", Command: "npx", InitOptions: map[string]interface{}{"callback": func() {}}},
+			}},
+			wantErr: []string{"server github has init options that can't be encoded as JSON"},
+		},
+		{
+			name: "Init options over SSE",
+			config: Config{Servers: []ServerConfig{
+				{Name: "remote", Transport: TransportSSE, URL: "https://example.com/sse", InitOptions: map[string]interface{}{"mode": "fast"}},
+			}},
+			wantErr: []string{"server remote uses the sse transport, which doesn't support init options"},
+		},
+		{
+			name:    "Negative startup stagger",
+			config:  Config{StartupStaggerMs: -1, Servers: []ServerConfig{{Name: "github", Command: "npx"}}},
+			wantErr: []string{"negative startup stagger"},
+		},
+		{
+			name:    "Negative slow call threshold",
+			config:  Config{SlowCallThresholdMs: -1, Servers: []ServerConfig{{Name: "github", Command: "npx"}}},
+			wantErr: []string{"negative slow call threshold"},
+		},
+		{
+			name: "Negative timeouts",
+			config: Config{Servers: []ServerConfig{
+				{Name: "github", Command: "npx", InitTimeoutSeconds: -1, CallTimeoutSeconds: -1},
+			}},
+			wantErr: []string{"negative init timeout", "negative call timeout"},
+		},
+		{
+			name:    "Negative init retries",
+			config:  Config{Servers: []ServerConfig{{Name: "github", Command: "npx", InitRetries: -1}}},
+			wantErr: []string{"negative init retry settings"},
+		},
+		{
+			name:    "Negative health check interval",
+			config:  Config{Servers: []ServerConfig{{Name: "github", Command: "npx", HealthCheckSeconds: -1}}},
+			wantErr: []string{"negative health check interval"},
+		},
+		{
+			name:    "Negative concurrent call limit",
+			config:  Config{Servers: []ServerConfig{{Name: "github", Command: "npx", MaxConcurrentCalls: -1}}},
+			wantErr: []string{"negative concurrent call limit"},
+		},
+		{
+			name:    "Negative readiness timeout",
+			config:  Config{Servers: []ServerConfig{{Name: "github", Command: "npx", ReadinessTool: "ping", ReadinessTimeoutSeconds: -1}}},
+			wantErr: []string{"negative readiness timeout"},
+		},
+		{
+			name:    "Negative shutdown grace period",
+			config:  Config{Servers: []ServerConfig{{Name: "github", Command: "npx", ShutdownGraceMs: -1}}},
+			wantErr: []string{"negative shutdown grace period"},
+		},
+		{
+			name:    "Negative rate limit",
+			config:  Config{Servers: []ServerConfig{{Name: "github", Command: "npx", RateLimitPerMinute: -1}}},
+			wantErr: []string{"negative rate limit"},
+		},
+		{
+			name: "Cacheable tool without TTL",
+			config: Config{Servers: []ServerConfig{
+				{Name: "github", Command: "npx", Tools: &ToolsConfig{Cacheable: []string{"list-repos"}}},
+			}},
+			wantErr: []string{"marks tool list-repos cacheable without a cache TTL"},
+		},
+		{
+			name: "Cacheable tool with TTL override spelled with underscores",
+			config: Config{Servers: []ServerConfig{{Name: "github", Command: "npx", Tools: &ToolsConfig{
+				Cacheable: []string{"list-repos"}, Overrides: map[string]ToolOverride{"list_repos": {CacheTTLSeconds: 60}},
+			}}}},
+		},
+		{
+			name: "Valid tool patterns",
+			config: Config{Servers: []ServerConfig{{Name: "github", Command: "npx", Tools: &ToolsConfig{
+				AllowedPatterns: []string{"^list_", "issue"}, DeniedPatterns: []string{"^delete_.*$"},
+			}}}},
+		},
+		{
+			name: "Invalid tool pattern",
+			config: Config{Servers: []ServerConfig{{Name: "github", Command: "npx", Tools: &ToolsConfig{
+				DeniedPatterns: []string{"delete_(repo"},
+			}}}},
+			wantErr: []string{`server github has invalid tool pattern "delete_(repo"`},
+		},
+		{
+			name:    "Invalid restart policy",
+			config:  Config{Servers: []ServerConfig{{Name: "github", Command: "npx", Restart: "sometimes"}}},
+			wantErr: []string{"invalid restart policy"},
+		},
+		{
+			name: "Invalid schema override",
+			config: Config{Servers: []ServerConfig{{Name: "github", Command: "npx", Tools: &ToolsConfig{
+				Overrides: map[string]ToolOverride{"search": {Schema: json.RawMessage(`{"type":"string"}`)}},
+			}}}},
+			wantErr: []string{"invalid schema override for tool search"},
+		},
+		{
+			name: "Preset without tool",
+			config: Config{Servers: []ServerConfig{{Name: "github", Command: "npx", Tools: &ToolsConfig{
+				Presets: map[string]ToolPreset{"deploy": {}},
+			}}}},
+			wantErr: []string{"preset deploy missing tool"},
+		},
+		{
+			name:   "Existing working directory",
+			config: Config{Servers: []ServerConfig{{Name: "fs", Command: "npx", WorkingDir: workingDir}}},
+		},
+		{
+			name:    "Nonexistent env file",
+			config:  Config{Servers: []ServerConfig{{Name: "fs", Command: "npx", EnvFile: filepath.Join(workingDir, "missing.env")}}},
+			wantErr: []string{"server fs has unusable env file"},
+		},
+		{
+			name:    "Nonexistent working directory",
+			config:  Config{Servers: []ServerConfig{{Name: "fs", Command: "npx", WorkingDir: filepath.Join(workingDir, "missing")}}},
+			wantErr: []string{"server fs has unusable working directory"},
+		},
+		{
+			name:    "Working directory is a file",
+			config:  Config{Servers: []ServerConfig{{Name: "fs", Command: "npx", WorkingDir: notADir}}},
+			wantErr: []string{"is not a directory"},
+		},
+		{
+			name: "Every problem is reported",
+			config: Config{Servers: []ServerConfig{
+				{Name: "github"},
+				{Name: "docs", Transport: "websocket"},
+			}},
+			wantErr: []string{"server github missing command", "server docs has invalid transport"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(&tt.config)
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate() error = nil, want %v", tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate() error = %v, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
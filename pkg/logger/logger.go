@@ -14,12 +14,14 @@ import (
 )
 
 var (
-	logFile        *os.File
+	logFile        *rotatingWriter
 	errorLog       *log.Logger
+	warnLog        *log.Logger
 	infoLog        *log.Logger
 	debugLog       *log.Logger
 	traceLog       *log.Logger
 	errorLogStdout *log.Logger
+	warnLogStdout  *log.Logger
 	infoLogStdout  *log.Logger
 	logLevel       config.LogLevel
 	initOnce       sync.Once
@@ -33,6 +35,7 @@ func Init(level config.LogLevel, logFilePath string) error {
 
 		// Set up stdout writers for essential output only
 		errorLogStdout = log.New(os.Stdout, "ERROR: ", log.Ldate|log.Ltime)
+		warnLogStdout = log.New(os.Stdout, "WARN: ", log.Ldate|log.Ltime)
 		infoLogStdout = log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime)
 
 		// Set up full logging (including debug/trace) to file only
@@ -44,8 +47,9 @@ func Init(level config.LogLevel, logFilePath string) error {
 				return
 			}
 
-			// Open log file
-			logFile, err = os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			// Open log file, rotating it by size if configured
+			maxSizeMB, maxBackups := config.GetLogRotation()
+			logFile, err = newRotatingWriter(logFilePath, int64(maxSizeMB)*1024*1024, maxBackups)
 			if err != nil {
 				err = fmt.Errorf("failed to open log file: %w", err)
 				return
@@ -58,6 +62,7 @@ func Init(level config.LogLevel, logFilePath string) error {
 
 		// Create full loggers with appropriate prefixes (file-only)
 		errorLog = log.New(logWriter, "ERROR: ", log.Ldate|log.Ltime)
+		warnLog = log.New(logWriter, "WARN: ", log.Ldate|log.Ltime)
 		infoLog = log.New(logWriter, "INFO: ", log.Ldate|log.Ltime)
 		debugLog = log.New(logWriter, "DEBUG: ", log.Ldate|log.Ltime)
 		traceLog = log.New(logWriter, "TRACE: ", log.Ldate|log.Ltime)
@@ -88,6 +93,19 @@ func Error(format string, v ...interface{}) {
 	}
 }
 
+// Warn logs a warning if log level is Warn or higher, for conditions that aren't fatal but need attention
+func Warn(format string, v ...interface{}) {
+	if logLevel >= config.LogLevelWarn {
+		// Always log to file
+		warnLog.Printf(format, v...)
+
+		// Only log to stdout if we're not in debug/trace mode, to avoid corrupting JSON
+		if logLevel < config.LogLevelDebug {
+			warnLogStdout.Printf(format, v...)
+		}
+	}
+}
+
 // Info logs an info message if log level is Info or higher
 func Info(format string, v ...interface{}) {
 	if logLevel >= config.LogLevelInfo {
@@ -161,6 +179,11 @@ func LogRPC(direction string, message []byte) {
 	}
 }
 
+// LogRPCCorrelated logs a JSON-RPC message like LogRPC, tagged with the correlation ID of the request it belongs to
+func LogRPCCorrelated(direction, correlationID string, message []byte) {
+	LogRPC(fmt.Sprintf("%s [correlation=%s]", direction, correlationID), message)
+}
+
 // Fatal logs an error message and exits the program
 func Fatal(format string, v ...interface{}) {
 	// Log to file if logger is initialized
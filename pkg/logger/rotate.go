@@ -0,0 +1,137 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the timestamp suffix of rotated log files, chosen so they sort chronologically
+const backupTimeFormat = "20060102T150405.000000000"
+
+// rotatingWriter writes to a log file and rotates it once it grows past a maximum size.
+// The rotated file is renamed with a timestamp suffix and a fresh file is opened in its place.
+type rotatingWriter struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64 // Zero disables rotation
+	maxBackups int   // Zero keeps every rotated file
+	file       *os.File
+	size       int64
+}
+
+// newRotatingWriter opens the log file for appending
+func newRotatingWriter(path string, maxSize int64, maxBackups int) (*rotatingWriter, error) {
+	w := &rotatingWriter{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// open opens the log file and records its current size
+func (w *rotatingWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+// Write writes to the log file, rotating it first if the write would exceed the maximum size
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			// Keep logging to the current file rather than losing messages
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file: %v\n", err)
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current log file with a timestamp suffix and opens a fresh one
+func (w *rotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	backup := w.path + "." + time.Now().Format(backupTimeFormat)
+	renameErr := os.Rename(w.path, backup)
+
+	// The file must be reopened even if renaming failed, so logging can continue
+	if err := w.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	return w.removeOldBackups()
+}
+
+// removeOldBackups deletes the oldest rotated files beyond the configured number of backups
+func (w *rotatingWriter) removeOldBackups() error {
+	if w.maxBackups <= 0 {
+		return nil
+	}
+	backups, err := w.backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > w.maxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// backups returns the rotated files of the log file, oldest first
+func (w *rotatingWriter) backups() ([]string, error) {
+	matches, err := filepath.Glob(w.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []string
+	for _, match := range matches {
+		// Only files with a rotation timestamp are backups
+		if _, err := time.Parse(backupTimeFormat, strings.TrimPrefix(match, w.path+".")); err == nil {
+			backups = append(backups, match)
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// Close closes the log file
+func (w *rotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
@@ -0,0 +1,85 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "combine-mcp.log")
+
+	// An unrelated file next to the log must survive pruning
+	unrelated := path + ".lock"
+	if err := os.WriteFile(unrelated, nil, 0644); err != nil {
+		t.Fatalf("Failed to write unrelated file: %v", err)
+	}
+
+	w, err := newRotatingWriter(path, 100, 2)
+	if err != nil {
+		t.Fatalf("newRotatingWriter() error = %v", err)
+	}
+	defer w.Close()
+
+	line := strings.Repeat("x", 39) + "\n"
+	write := func(times int) {
+		t.Helper()
+		for i := 0; i < times; i++ {
+			if _, err := w.Write([]byte(line)); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+		}
+	}
+
+	// Two lines fit, the third one rotates the file
+	write(2)
+	if backups, _ := w.backups(); len(backups) != 0 {
+		t.Fatalf("Rotated before reaching the maximum size: %v", backups)
+	}
+	write(1)
+	backups, err := w.backups()
+	if err != nil {
+		t.Fatalf("backups() error = %v", err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("Got %d rotated files, want 1", len(backups))
+	}
+	if data, _ := os.ReadFile(backups[0]); string(data) != line+line {
+		t.Errorf("Rotated file content = %q, want the first two lines", data)
+	}
+	if data, _ := os.ReadFile(path); string(data) != line {
+		t.Errorf("Log file content = %q, want the third line", data)
+	}
+
+	// Only the newest backups are retained
+	write(6)
+	backups, err = w.backups()
+	if err != nil {
+		t.Fatalf("backups() error = %v", err)
+	}
+	if len(backups) != 2 {
+		t.Errorf("Got %d rotated files, want 2", len(backups))
+	}
+	if _, err := os.Stat(unrelated); err != nil {
+		t.Errorf("Unrelated file was removed: %v", err)
+	}
+}
+
+func TestRotatingWriterDisabled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "combine-mcp.log")
+	w, err := newRotatingWriter(path, 0, 0)
+	if err != nil {
+		t.Fatalf("newRotatingWriter() error = %v", err)
+	}
+	defer w.Close()
+
+	for i := 0; i < 100; i++ {
+		if _, err := w.Write([]byte("line\n")); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if backups, _ := w.backups(); len(backups) != 0 {
+		t.Errorf("Rotated with rotation disabled: %v", backups)
+	}
+}
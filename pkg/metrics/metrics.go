@@ -0,0 +1,131 @@
+// Package metrics collects tool call metrics and exports them in the Prometheus text format
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the tool call latency histogram
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// Registry counts tool calls by server and tool. It serves the counts over HTTP in the Prometheus text format.
+type Registry struct {
+	mu      sync.Mutex
+	buckets []float64
+	calls   map[callKey]*callStats
+}
+
+type callKey struct {
+	server string
+	tool   string
+}
+
+type callStats struct {
+	calls        uint64
+	errors       uint64
+	bucketCounts []uint64 // Non-cumulative, one per bucket
+	sumSeconds   float64
+}
+
+// NewRegistry creates an empty registry using the default latency buckets
+func NewRegistry() *Registry {
+	return &Registry{
+		buckets: DefaultBuckets,
+		calls:   make(map[callKey]*callStats),
+	}
+}
+
+// ObserveCall records a tool call of a server that took the given duration
+func (r *Registry) ObserveCall(server, tool string, duration time.Duration, failed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := callKey{server: server, tool: tool}
+	stats, ok := r.calls[key]
+	if !ok {
+		stats = &callStats{bucketCounts: make([]uint64, len(r.buckets))}
+		r.calls[key] = stats
+	}
+
+	stats.calls++
+	if failed {
+		stats.errors++
+	}
+	seconds := duration.Seconds()
+	stats.sumSeconds += seconds
+	for i, bound := range r.buckets {
+		if seconds <= bound {
+			stats.bucketCounts[i]++
+			break
+		}
+	}
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprint(w, r.String())
+}
+
+// String renders all metrics in the Prometheus text exposition format
+func (r *Registry) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Sort so the output is stable between scrapes
+	keys := make([]callKey, 0, len(r.calls))
+	for key := range r.calls {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].server != keys[j].server {
+			return keys[i].server < keys[j].server
+		}
+		return keys[i].tool < keys[j].tool
+	})
+
+	var out strings.Builder
+	out.WriteString("# HELP combine_mcp_tool_calls_total Tool calls by server and tool.\n")
+	out.WriteString("# TYPE combine_mcp_tool_calls_total counter\n")
+	for _, key := range keys {
+		fmt.Fprintf(&out, "combine_mcp_tool_calls_total{%s} %d\n", key.labels(), r.calls[key].calls)
+	}
+
+	out.WriteString("# HELP combine_mcp_tool_call_errors_total Failed tool calls by server and tool.\n")
+	out.WriteString("# TYPE combine_mcp_tool_call_errors_total counter\n")
+	for _, key := range keys {
+		fmt.Fprintf(&out, "combine_mcp_tool_call_errors_total{%s} %d\n", key.labels(), r.calls[key].errors)
+	}
+
+	out.WriteString("# HELP combine_mcp_tool_call_duration_seconds Tool call latency by server and tool.\n")
+	out.WriteString("# TYPE combine_mcp_tool_call_duration_seconds histogram\n")
+	for _, key := range keys {
+		stats := r.calls[key]
+		var cumulative uint64
+		for i, bound := range r.buckets {
+			cumulative += stats.bucketCounts[i]
+			fmt.Fprintf(&out, "combine_mcp_tool_call_duration_seconds_bucket{%s,le=\"%g\"} %d\n", key.labels(), bound, cumulative)
+		}
+		fmt.Fprintf(&out, "combine_mcp_tool_call_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", key.labels(), stats.calls)
+		fmt.Fprintf(&out, "combine_mcp_tool_call_duration_seconds_sum{%s} %g\n", key.labels(), stats.sumSeconds)
+		fmt.Fprintf(&out, "combine_mcp_tool_call_duration_seconds_count{%s} %d\n", key.labels(), stats.calls)
+	}
+	return out.String()
+}
+
+// labels renders the label pairs of a call
+func (k callKey) labels() string {
+	return fmt.Sprintf("server=\"%s\",tool=\"%s\"", escapeLabel(k.server), escapeLabel(k.tool))
+}
+
+// labelEscaper escapes label values as required by the text format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	registry.ObserveCall("git", "git_log", 20*time.Millisecond, false)
+	registry.ObserveCall("git", "git_log", 3*time.Second, true)
+	registry.ObserveCall(`we"ird`, "tool", time.Millisecond, false)
+
+	out := registry.String()
+	for _, want := range []string{
+		"# TYPE combine_mcp_tool_calls_total counter",
+		`combine_mcp_tool_calls_total{server="git",tool="git_log"} 2`,
+		`combine_mcp_tool_call_errors_total{server="git",tool="git_log"} 1`,
+		`combine_mcp_tool_call_duration_seconds_bucket{server="git",tool="git_log",le="0.01"} 0`,
+		`combine_mcp_tool_call_duration_seconds_bucket{server="git",tool="git_log",le="0.025"} 1`,
+		`combine_mcp_tool_call_duration_seconds_bucket{server="git",tool="git_log",le="5"} 2`,
+		`combine_mcp_tool_call_duration_seconds_bucket{server="git",tool="git_log",le="+Inf"} 2`,
+		`combine_mcp_tool_call_duration_seconds_sum{server="git",tool="git_log"} 3.02`,
+		`combine_mcp_tool_calls_total{server="we\"ird",tool="tool"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Output missing %q, got:\n%s", want, out)
+		}
+	}
+}
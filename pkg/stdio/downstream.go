@@ -0,0 +1,131 @@
+package stdio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// errClientDisconnected is returned for requests to the client that can't be answered anymore
+var errClientDisconnected = errors.New("client disconnected")
+
+// downstreamResponse is the outcome of a request sent to the client
+type downstreamResponse struct {
+	result json.RawMessage
+	err    error
+}
+
+// downstreamRequests sends requests to the client and routes its responses back to the callers
+type downstreamRequests struct {
+	write  func(message []byte)
+	nextID atomic.Int64
+
+	mu      sync.Mutex
+	pending map[int64]chan downstreamResponse
+	closed  bool
+}
+
+// newDownstreamRequests creates a tracker that writes requests with the given function
+func newDownstreamRequests(write func(message []byte)) *downstreamRequests {
+	return &downstreamRequests{
+		write:   write,
+		pending: make(map[int64]chan downstreamResponse),
+	}
+}
+
+// request sends a request to the client and waits for its result
+func (d *downstreamRequests) request(ctx context.Context, method string, params json.RawMessage) (json.RawMessage, error) {
+	id := d.nextID.Add(1)
+	ch := make(chan downstreamResponse, 1)
+
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil, errClientDisconnected
+	}
+	d.pending[id] = ch
+	d.mu.Unlock()
+
+	message, err := json.Marshal(struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      int64           `json:"id"`
+		Method  string          `json:"method"`
+		Params  json.RawMessage `json:"params,omitempty"`
+	}{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		ID:      id,
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		d.forget(id)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	d.write(message)
+
+	select {
+	case <-ctx.Done():
+		d.forget(id)
+		return nil, ctx.Err()
+	case response := <-ch:
+		return response.result, response.err
+	}
+}
+
+// resolve routes a response from the client to the request waiting for it.
+// It reports whether the message was a response to one of our requests.
+func (d *downstreamRequests) resolve(message []byte) bool {
+	var response struct {
+		ID     *int64          `json:"id"`
+		Method string          `json:"method"`
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(message, &response); err != nil || response.Method != "" || response.ID == nil {
+		return false
+	}
+	if response.Result == nil && response.Error == nil {
+		return false
+	}
+
+	d.mu.Lock()
+	ch, exists := d.pending[*response.ID]
+	delete(d.pending, *response.ID)
+	d.mu.Unlock()
+
+	if exists {
+		if response.Error != nil {
+			ch <- downstreamResponse{err: fmt.Errorf("client error %d: %s", response.Error.Code, response.Error.Message)}
+		} else {
+			ch <- downstreamResponse{result: response.Result}
+		}
+	}
+	// Responses to unknown requests are dropped as well, the server must not answer them
+	return true
+}
+
+// forget stops waiting for the response to a request
+func (d *downstreamRequests) forget(id int64) {
+	d.mu.Lock()
+	delete(d.pending, id)
+	d.mu.Unlock()
+}
+
+// close fails every pending request and rejects new ones
+func (d *downstreamRequests) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	for id, ch := range d.pending {
+		ch <- downstreamResponse{err: errClientDisconnected}
+		delete(d.pending, id)
+	}
+}
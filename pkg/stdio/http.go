@@ -0,0 +1,430 @@
+package stdio
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nazar256/combine-mcp/pkg/logger"
+)
+
+// HTTPEndpoint is the path the streamable HTTP transport is served at
+const HTTPEndpoint = "/mcp"
+
+// sessionIDHeader carries the session the server assigned to the client
+const sessionIDHeader = "Mcp-Session-Id"
+
+// maxHTTPMessageSize limits the size of a message POSTed by a client, so clients can't exhaust our memory
+const maxHTTPMessageSize = 1024 * 1024
+
+// defaultMaxHTTPSessions limits how many sessions are open at once, so clients that never end theirs can't exhaust our memory
+const defaultMaxHTTPSessions = 1000
+
+// defaultHTTPSessionIdleTimeout is how long a session without requests or an open event stream is kept
+const defaultHTTPSessionIdleTimeout = 30 * time.Minute
+
+// errTooManySessions is returned when a client initializes while the session limit is reached
+var errTooManySessions = errors.New("too many sessions")
+
+// httpSession is a client session of the streamable HTTP transport.
+// Notifications for the client are delivered over the event stream it opens with GET, if any.
+type httpSession struct {
+	id            string
+	notifications chan mcp.JSONRPCNotification
+	initialized   atomic.Bool
+	lastSeen      atomic.Int64 // Unix nanoseconds of the last request
+	streams       atomic.Int32 // Open event streams, a session with one never goes idle
+}
+
+// newHTTPSession creates a session with a random identifier and a buffered notification channel
+func newHTTPSession() (*httpSession, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate session id: %w", err)
+	}
+	return &httpSession{
+		id:            hex.EncodeToString(id),
+		notifications: make(chan mcp.JSONRPCNotification, 100),
+	}, nil
+}
+
+// SessionID returns the identifier of the session
+func (s *httpSession) SessionID() string {
+	return s.id
+}
+
+// NotificationChannel returns the channel notifications for the client are sent to
+func (s *httpSession) NotificationChannel() chan<- mcp.JSONRPCNotification {
+	return s.notifications
+}
+
+// Initialize marks the session as ready to receive notifications
+func (s *httpSession) Initialize() {
+	s.initialized.Store(true)
+}
+
+// Initialized reports whether the client has completed initialization
+func (s *httpSession) Initialized() bool {
+	return s.initialized.Load()
+}
+
+// touch records that the client used the session
+func (s *httpSession) touch() {
+	s.lastSeen.Store(time.Now().UnixNano())
+}
+
+// idle reports whether the client hasn't used the session for longer than the timeout
+func (s *httpSession) idle(timeout time.Duration) bool {
+	return s.streams.Load() == 0 && time.Since(time.Unix(0, s.lastSeen.Load())) > timeout
+}
+
+// streamableHTTP serves the aggregator to clients using the streamable HTTP transport.
+// Every message is POSTed to the endpoint and answered with plain JSON, GET opens an event
+// stream for notifications and DELETE ends the session.
+type streamableHTTP struct {
+	server *AggregatorServer
+
+	mu          sync.Mutex
+	sessions    map[string]*httpSession
+	maxSessions int
+	idleTimeout time.Duration // Sessions unused for longer are dropped
+	done        chan struct{} // Closed on shutdown to end the event streams
+	closed      bool
+}
+
+// newStreamableHTTP creates the transport of the server
+func newStreamableHTTP(s *AggregatorServer) *streamableHTTP {
+	return &streamableHTTP{
+		server:      s,
+		sessions:    make(map[string]*httpSession),
+		maxSessions: defaultMaxHTTPSessions,
+		idleTimeout: defaultHTTPSessionIdleTimeout,
+		done:        make(chan struct{}),
+	}
+}
+
+// ServeHTTP handles a request to the endpoint
+func (t *streamableHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Web pages could otherwise drive the tools through the browser, e.g. after DNS rebinding
+	if origin := r.Header.Get("Origin"); origin != "" && !t.server.originAllowed(origin) {
+		logger.Warn("Rejecting HTTP request from origin %s", origin)
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		t.handlePost(w, r)
+	case http.MethodGet:
+		t.handleStream(w, r)
+	case http.MethodDelete:
+		t.handleDelete(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handlePost handles a single JSON-RPC message of the client
+func (t *streamableHTTP) handlePost(w http.ResponseWriter, r *http.Request) {
+	message, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxHTTPMessageSize))
+	if err != nil {
+		http.Error(w, "failed to read message", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Batches are handled like single messages, but can't initialize a session
+	var envelope struct {
+		Method string `json:"method"`
+	}
+	if trimmed := bytes.TrimSpace(message); len(trimmed) == 0 || trimmed[0] != '[' {
+		if err := json.Unmarshal(message, &envelope); err != nil {
+			http.Error(w, "invalid JSON-RPC message", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Clients get a session when they initialize and pass it along with every further message
+	var session *httpSession
+	initializing := envelope.Method == string(mcp.MethodInitialize)
+	if initializing {
+		session, err = newHTTPSession()
+		if err != nil {
+			logger.Error("Failed to create HTTP session: %v", err)
+			http.Error(w, "failed to create session", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		var status int
+		session, status = t.session(r)
+		if session == nil {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		w.Header().Set(sessionIDHeader, session.id)
+	}
+
+	var response []byte
+	ctx := t.server.mcpServer.WithContext(r.Context(), session)
+	t.server.handleMessage(ctx, message, func(line []byte) {
+		response = line
+	})
+
+	// The session is only kept once the client initialized successfully
+	if initializing && initializeSucceeded(response) {
+		if err := t.addSession(session); errors.Is(err, errTooManySessions) {
+			logger.Warn("Rejecting HTTP session: %d sessions are open", t.maxSessions)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			logger.Error("Failed to create HTTP session: %v", err)
+			http.Error(w, "failed to create session", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set(sessionIDHeader, session.id)
+	}
+
+	// Notifications and responses of the client are only acknowledged
+	if response == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// handleStream sends the notifications of the session to the client as server-sent events until it disconnects
+func (t *streamableHTTP) handleStream(w http.ResponseWriter, r *http.Request) {
+	session, status := t.session(r)
+	if session == nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set(sessionIDHeader, session.id)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	session.streams.Add(1)
+	defer func() {
+		session.touch()
+		session.streams.Add(-1)
+	}()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-t.done:
+			return
+		case notification := <-session.notifications:
+			notificationBytes, err := json.Marshal(notification)
+			if err != nil {
+				logger.Error("Failed to marshal notification: %v", err)
+				continue
+			}
+			logger.LogRPC("OUT", notificationBytes)
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", notificationBytes)
+			flusher.Flush()
+		}
+	}
+}
+
+// handleDelete ends the session of the client
+func (t *streamableHTTP) handleDelete(w http.ResponseWriter, r *http.Request) {
+	session, status := t.session(r)
+	if session == nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	t.mu.Lock()
+	delete(t.sessions, session.id)
+	t.mu.Unlock()
+	t.server.mcpServer.UnregisterSession(session.id)
+	logger.Debug("HTTP session %s ended by the client", session.id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// initializeSucceeded reports whether the response to an initialize request is a result rather than an error
+func initializeSucceeded(response []byte) bool {
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	return json.Unmarshal(response, &envelope) == nil && envelope.Result != nil && envelope.Error == nil
+}
+
+// addSession registers the session of a client that initialized.
+// Idle sessions are dropped first, so clients that go away without ending their session don't count against the limit.
+func (t *streamableHTTP) addSession(session *httpSession) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return errors.New("server is shutting down")
+	}
+	for id, existing := range t.sessions {
+		if existing.idle(t.idleTimeout) {
+			t.dropSession(id)
+		}
+	}
+	if len(t.sessions) >= t.maxSessions {
+		return errTooManySessions
+	}
+	if err := t.server.mcpServer.RegisterSession(session); err != nil {
+		return fmt.Errorf("failed to register session: %w", err)
+	}
+	session.touch()
+	t.sessions[session.id] = session
+	logger.Debug("HTTP session %s started", session.id)
+	return nil
+}
+
+// dropSession unregisters a session the client stopped using. The caller must hold t.mu.
+func (t *streamableHTTP) dropSession(id string) {
+	delete(t.sessions, id)
+	t.server.mcpServer.UnregisterSession(id)
+	logger.Debug("HTTP session %s expired", id)
+}
+
+// session returns the session the request belongs to, or nil and the status to answer with
+func (t *streamableHTTP) session(r *http.Request) (*httpSession, int) {
+	id := r.Header.Get(sessionIDHeader)
+	if id == "" {
+		return nil, http.StatusBadRequest
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	session, ok := t.sessions[id]
+	if !ok {
+		return nil, http.StatusNotFound
+	}
+	if session.idle(t.idleTimeout) {
+		t.dropSession(id)
+		return nil, http.StatusNotFound
+	}
+	session.touch()
+	return session, http.StatusOK
+}
+
+// close ends the event streams and unregisters every session
+func (t *streamableHTTP) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	t.closed = true
+	close(t.done)
+	for id := range t.sessions {
+		t.server.mcpServer.UnregisterSession(id)
+	}
+	t.sessions = make(map[string]*httpSession)
+}
+
+// SetAllowedOrigins sets the origins besides local ones whose browser requests are served over HTTP,
+// such as https://app.example.com. Requests without an Origin header don't come from web pages and are always served.
+func (s *AggregatorServer) SetAllowedOrigins(origins []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.allowedOrigins = origins
+}
+
+// originAllowed reports whether a browser request of the origin may be served: the origin is local or allowed
+func (s *AggregatorServer) originAllowed(origin string) bool {
+	if parsed, err := url.Parse(origin); err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && isLoopbackHost(parsed.Hostname()) {
+		return true
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, allowed := range s.allowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLoopbackHost reports whether a host name or address only reaches this machine
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ServeStreamableHTTP serves the MCP server over the streamable HTTP transport at addr until Shutdown is called
+func (s *AggregatorServer) ServeStreamableHTTP(addr string) error {
+	// The transport has no authentication, so anyone who can reach it can call the tools
+	if host, _, err := net.SplitHostPort(addr); err != nil || !isLoopbackHost(host) {
+		logger.Warn("Serving MCP over HTTP on %s, which other hosts may reach: the endpoint has no authentication", addr)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return s.serveHTTP(listener)
+}
+
+// serveHTTP serves the streamable HTTP transport on the listener
+func (s *AggregatorServer) serveHTTP(listener net.Listener) error {
+	transport := newStreamableHTTP(s)
+	mux := http.NewServeMux()
+	mux.Handle(HTTPEndpoint, transport)
+	httpServer := &http.Server{Handler: mux}
+
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		listener.Close()
+		return nil
+	}
+	s.httpServer, s.httpTransport = httpServer, transport
+	s.mu.Unlock()
+
+	logger.Info("Serving MCP over streamable HTTP on %s%s", listener.Addr(), HTTPEndpoint)
+	if err := httpServer.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops serving over HTTP, letting requests in flight finish until ctx is done.
+// It does nothing if the server isn't serving over HTTP.
+func (s *AggregatorServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shutdown = true
+	httpServer, transport := s.httpServer, s.httpTransport
+	s.mu.Unlock()
+
+	if httpServer == nil {
+		return nil
+	}
+	// Event streams never go idle by themselves, so they are ended first
+	transport.close()
+	return httpServer.Shutdown(ctx)
+}
@@ -0,0 +1,191 @@
+package stdio
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nazar256/combine-mcp/pkg/aggregator"
+	"github.com/nazar256/combine-mcp/pkg/config"
+	"github.com/nazar256/combine-mcp/pkg/logger"
+)
+
+func TestServeStreamableHTTP(t *testing.T) {
+	if err := logger.Init(config.LogLevelError, ""); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	s := NewAggregatorServer("test-aggregator", "1.0.0", aggregator.NewMCPAggregator())
+	if err := s.RegisterTools(); err != nil {
+		t.Fatalf("RegisterTools() error = %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- s.serveHTTP(listener)
+	}()
+	url := "http://" + listener.Addr().String() + HTTPEndpoint
+
+	post := func(sessionID, message string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(message))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if sessionID != "" {
+			req.Header.Set(sessionIDHeader, sessionID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST %s error = %v", message, err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := post("", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05","capabilities":{},"clientInfo":{"name":"test","version":"1.0.0"}}}`)
+	sessionID := resp.Header.Get(sessionIDHeader)
+	if resp.StatusCode != http.StatusOK || sessionID == "" {
+		t.Fatalf("initialize status = %d, session = %q, want 200 and a session", resp.StatusCode, sessionID)
+	}
+
+	if resp := post(sessionID, `{"jsonrpc":"2.0","method":"notifications/initialized"}`); resp.StatusCode != http.StatusAccepted {
+		t.Errorf("initialized notification status = %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+
+	resp = post(sessionID, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`)
+	var list struct {
+		Result struct {
+			Tools []struct {
+				Name string `json:"name"`
+			} `json:"tools"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode tools/list response: %v", err)
+	}
+	found := false
+	for _, tool := range list.Result.Tools {
+		found = found || tool.Name == StatusToolName
+	}
+	if !found {
+		t.Errorf("tools/list = %+v, want %s", list.Result.Tools, StatusToolName)
+	}
+
+	// Messages outside of a known session are rejected
+	if resp := post("", `{"jsonrpc":"2.0","id":3,"method":"tools/list"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("tools/list without session status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+	if resp := post("unknown", `{"jsonrpc":"2.0","id":4,"method":"tools/list"}`); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("tools/list with unknown session status = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serveHTTP() error = %v, want nil after Shutdown", err)
+	}
+	if _, err := http.Post(url, "application/json", strings.NewReader(`{}`)); err == nil {
+		t.Errorf("POST after Shutdown succeeded, want the listener closed")
+	}
+}
+
+func TestHTTPOrigin(t *testing.T) {
+	if err := logger.Init(config.LogLevelError, ""); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	s := NewAggregatorServer("test-aggregator", "1.0.0", aggregator.NewMCPAggregator())
+	s.SetAllowedOrigins([]string{"https://app.example.com/"})
+	transport := newStreamableHTTP(s)
+	defer transport.close()
+
+	tests := []struct {
+		origin string
+		want   int
+	}{
+		{origin: "", want: http.StatusOK}, // Not a browser
+		{origin: "http://localhost:3000", want: http.StatusOK},
+		{origin: "http://127.0.0.1", want: http.StatusOK},
+		{origin: "http://[::1]:8080", want: http.StatusOK},
+		{origin: "https://app.example.com", want: http.StatusOK},
+		{origin: "https://evil.example.com", want: http.StatusForbidden},
+		{origin: "http://localhost.evil.example.com", want: http.StatusForbidden},
+		{origin: "null", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, HTTPEndpoint, strings.NewReader(
+				`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05","capabilities":{},"clientInfo":{"name":"test","version":"1.0.0"}}}`))
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			recorder := httptest.NewRecorder()
+			transport.ServeHTTP(recorder, req)
+			if recorder.Code != tt.want {
+				t.Errorf("Status for origin %q = %d, want %d", tt.origin, recorder.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPSessions(t *testing.T) {
+	if err := logger.Init(config.LogLevelError, ""); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	s := NewAggregatorServer("test-aggregator", "1.0.0", aggregator.NewMCPAggregator())
+	transport := newStreamableHTTP(s)
+	transport.maxSessions = 2
+	transport.idleTimeout = 50 * time.Millisecond
+	defer transport.close()
+
+	post := func(sessionID, message string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, HTTPEndpoint, strings.NewReader(message))
+		if sessionID != "" {
+			req.Header.Set(sessionIDHeader, sessionID)
+		}
+		recorder := httptest.NewRecorder()
+		transport.ServeHTTP(recorder, req)
+		return recorder
+	}
+	initialize := `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05","capabilities":{},"clientInfo":{"name":"test","version":"1.0.0"}}}`
+
+	// A failed initialize doesn't open a session
+	if resp := post("", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":"invalid"}`); resp.Header().Get(sessionIDHeader) != "" {
+		t.Errorf("Failed initialize returned session %q, want none", resp.Header().Get(sessionIDHeader))
+	}
+	if len(transport.sessions) != 0 {
+		t.Errorf("Failed initialize left %d sessions, want 0", len(transport.sessions))
+	}
+
+	// Clients beyond the limit are turned away while the open sessions are in use
+	first := post("", initialize).Header().Get(sessionIDHeader)
+	second := post("", initialize).Header().Get(sessionIDHeader)
+	if first == "" || second == "" {
+		t.Fatalf("initialize sessions = %q, %q, want two sessions", first, second)
+	}
+	if resp := post("", initialize); resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("initialize beyond the limit status = %d, want %d", resp.Code, http.StatusServiceUnavailable)
+	}
+
+	// Idle sessions expire and make room for new ones
+	time.Sleep(100 * time.Millisecond)
+	if resp := post("", initialize); resp.Code != http.StatusOK {
+		t.Errorf("initialize after sessions went idle status = %d, want %d", resp.Code, http.StatusOK)
+	}
+	if resp := post(first, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`); resp.Code != http.StatusNotFound {
+		t.Errorf("tools/list with expired session status = %d, want %d", resp.Code, http.StatusNotFound)
+	}
+}
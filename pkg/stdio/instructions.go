@@ -0,0 +1,64 @@
+package stdio
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/nazar256/combine-mcp/pkg/config"
+)
+
+// instructionsExampleTool is the tool whose exposed names illustrate the naming in the default instructions
+const instructionsExampleTool = "create_issue"
+
+// DefaultInstructions explains to the client how the config names the tools of its servers, with the exposed
+// name of an example tool for every server whose tools are renamed. Tools exposed under their own names need
+// no explanation, so it returns "" if no server's tools are renamed, e.g. with disablePrefix or passthrough.
+func DefaultInstructions(cfg *config.Config) string {
+	if cfg.DisablePrefix || cfg.Passthrough {
+		return ""
+	}
+	var nameTemplate *template.Template
+	if cfg.NameTemplate != "" {
+		parsed, err := config.ParseNameTemplate(cfg.NameTemplate)
+		if err != nil {
+			return ""
+		}
+		nameTemplate = parsed
+	}
+	delimiter := cfg.Delimiter
+	if delimiter == "" {
+		delimiter = config.DefaultDelimiter
+	}
+	sanitizedTool := config.SanitizeToolName(instructionsExampleTool, cfg.SanitizeMode)
+
+	var examples []string
+	for _, server := range cfg.Servers {
+		if server.NoPrefix {
+			continue
+		}
+		prefix := server.Prefix
+		if prefix == "" {
+			prefix = server.Name
+		}
+
+		name := config.SanitizeToolName(prefix, cfg.SanitizeMode) + delimiter + sanitizedTool
+		if nameTemplate != nil {
+			templated, err := config.ExecuteNameTemplate(nameTemplate, config.ToolNameData{Server: prefix, Tool: instructionsExampleTool, Sanitized: sanitizedTool})
+			if err != nil {
+				continue
+			}
+			name = config.SanitizeToolName(templated, cfg.SanitizeMode)
+		}
+		if name != sanitizedTool {
+			examples = append(examples, fmt.Sprintf("%s is the %s tool of the %s server", name, instructionsExampleTool, server.Name))
+		}
+	}
+	if len(examples) == 0 {
+		return ""
+	}
+
+	return "This server combines the tools of several MCP servers and names them after the server they belong to: " +
+		strings.Join(examples, ", ") + ". " +
+		"The " + StatusToolName + " tool lists the servers and their tools."
+}
@@ -0,0 +1,71 @@
+package stdio
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nazar256/combine-mcp/pkg/config"
+)
+
+func TestDefaultInstructions(t *testing.T) {
+	servers := []config.ServerConfig{
+		{Name: "github"},
+		{Name: "shortcut-app", Prefix: "sc"},
+		{Name: "docs", NoPrefix: true},
+	}
+
+	tests := []struct {
+		name   string
+		config config.Config
+		want   []string // Substrings of the instructions, none if there are no instructions
+	}{
+		{
+			name:   "Server prefixes",
+			config: config.Config{Servers: servers},
+			want: []string{
+				"github_create_issue is the create_issue tool of the github server, sc_create_issue is the create_issue tool of the shortcut-app server.",
+				StatusToolName,
+			},
+		},
+		{
+			name:   "Delimiter",
+			config: config.Config{Servers: servers[:1], Delimiter: ".", SanitizeMode: config.SanitizeNone},
+			want:   []string{"github.create_issue is the create_issue tool of the github server."},
+		},
+		{
+			name:   "Name template",
+			config: config.Config{Servers: servers[:2], NameTemplate: "{{.Tool}}@{{.Server}}", SanitizeMode: config.SanitizeNone},
+			want:   []string{"create_issue@github is the create_issue tool of the github server, create_issue@sc is the create_issue tool of the shortcut-app server."},
+		},
+		{
+			name:   "Template keeping the tool name",
+			config: config.Config{Servers: servers[:1], NameTemplate: "{{.Tool}}"},
+		},
+		{
+			name:   "Disabled prefixes",
+			config: config.Config{Servers: servers, DisablePrefix: true},
+		},
+		{
+			name:   "Passthrough",
+			config: config.Config{Servers: servers[:1], Passthrough: true},
+		},
+		{
+			name:   "Only unprefixed servers",
+			config: config.Config{Servers: servers[2:]},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DefaultInstructions(&tt.config)
+			if len(tt.want) == 0 && got != "" {
+				t.Errorf("DefaultInstructions() = %q, want none", got)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("DefaultInstructions() = %q, want it to contain %q", got, want)
+				}
+			}
+		})
+	}
+}
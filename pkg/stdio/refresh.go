@@ -0,0 +1,46 @@
+package stdio
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/nazar256/combine-mcp/pkg/logger"
+)
+
+// RefreshToolName is the name of the built-in tool that rediscovers the tools of all servers
+const RefreshToolName = "combine_mcp_refresh"
+
+// refreshTool builds the built-in tool that picks up tools added to servers at runtime
+func (s *AggregatorServer) refreshTool() server.ServerTool {
+	return server.ServerTool{
+		Tool: mcp.NewTool(RefreshToolName,
+			mcp.WithDescription("Rediscover the tools of all servers combine-mcp is connected to, e.g. after tools were added to a server, and list the tools that were added or removed"),
+		),
+		Handler: s.handleRefresh,
+	}
+}
+
+// handleRefresh rediscovers the tools of all servers and summarizes the changes
+func (s *AggregatorServer) handleRefresh(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	added, removed, err := s.aggregator.RefreshTools(ctx)
+
+	summary := fmt.Sprintf("Added tools: %s\nRemoved tools: %s", joinOrNone(added), joinOrNone(removed))
+	if err != nil {
+		logger.Error("Tool refresh failed for some servers: %v", err)
+		result := mcp.NewToolResultText(summary + "\nErrors:\n" + err.Error())
+		result.IsError = true
+		return result, nil
+	}
+	return mcp.NewToolResultText(summary), nil
+}
+
+// joinOrNone lists names separated by commas, or "none" if there are none
+func joinOrNone(names []string) string {
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
@@ -2,25 +2,105 @@ package stdio
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 	"github.com/nazar256/combine-mcp/pkg/aggregator"
+	"github.com/nazar256/combine-mcp/pkg/config"
 	"github.com/nazar256/combine-mcp/pkg/logger"
+	"github.com/nazar256/combine-mcp/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracerName identifies the spans of the server
+const tracerName = "github.com/nazar256/combine-mcp/pkg/stdio"
+
+// rootsListChangedMethod is the notification the client sends when its roots change.
+// It is declared here because NewAggregatorServer's parameter shadows the aggregator package.
+const rootsListChangedMethod = aggregator.MethodRootsListChanged
+
 // AggregatorServer represents the MCP server that aggregates tools from multiple MCP servers
 type AggregatorServer struct {
 	mcpServer  *server.MCPServer
 	aggregator *aggregator.MCPAggregator
+	name       string
+	version    string
+
+	mu                 sync.RWMutex
+	maintenance        bool
+	maintenanceMessage string
+	deadLetterFile     string
+	softErrors         bool
+	slowCallThreshold  time.Duration       // Tool calls taking longer are logged as warnings
+	instructions       string              // Tell the client how to use the aggregated tools
+	allowedOrigins     []string            // Origins besides local ones whose browser requests are served over HTTP
+	downstream         *downstreamRequests // Requests to the connected client, nil while not serving
+	httpServer         *http.Server        // Serves the streamable HTTP transport, nil unless serving over HTTP
+	httpTransport      *streamableHTTP
+	shutdown           bool // Set by Shutdown, so serving over HTTP doesn't start afterwards
+
+	downstreamLogging bool          // Advertises the logging capability to the client
+	passthrough       bool          // Proxies a single server without adding the built-in tools
+	shutdownTimeout   time.Duration // Time requests being handled get to finish once serving is stopped
+}
+
+// defaultShutdownTimeout bounds how long serving waits for the request being handled once it is stopped
+const defaultShutdownTimeout = 10 * time.Second
+
+// Option configures an AggregatorServer when it is created
+type Option func(*AggregatorServer)
+
+// WithDownstreamLogging sets whether the logging capability is advertised to the client, which it is by default.
+// Minimal clients that break on log notifications they don't expect can be served with it turned off.
+func WithDownstreamLogging(enabled bool) Option {
+	return func(s *AggregatorServer) {
+		s.downstreamLogging = enabled
+	}
+}
+
+// WithPassthrough sets whether the server proxies a single server transparently. The built-in tools
+// aren't registered then, so the client sees exactly the server's tools, including any of the same names.
+func WithPassthrough(enabled bool) Option {
+	return func(s *AggregatorServer) {
+		s.passthrough = enabled
+	}
+}
+
+// WithShutdownTimeout sets how long serving over stdio waits for the request being handled to be answered
+// once ctx is cancelled, before it returns anyway
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(s *AggregatorServer) {
+		s.shutdownTimeout = timeout
+	}
 }
 
 // NewAggregatorServer creates a new AggregatorServer
-func NewAggregatorServer(serverName, version string, aggregator *aggregator.MCPAggregator) *AggregatorServer {
+func NewAggregatorServer(serverName, version string, aggregator *aggregator.MCPAggregator, opts ...Option) *AggregatorServer {
+	s := &AggregatorServer{
+		aggregator:        aggregator,
+		name:              serverName,
+		version:           version,
+		downstreamLogging: true,
+		shutdownTimeout:   defaultShutdownTimeout,
+		slowCallThreshold: config.DefaultSlowCallThresholdMs * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
 	// Add debug hooks
 	hooks := &server.Hooks{}
 
@@ -40,6 +120,9 @@ func NewAggregatorServer(serverName, version string, aggregator *aggregator.MCPA
 		logger.Info("Initialize request from: %s %s", message.Params.ClientInfo.Name, message.Params.ClientInfo.Version)
 		logger.Debug("Initialize params: %+v", message.Params)
 
+		// Roots requests from upstream servers can only be relayed if the client declared roots
+		aggregator.SetClientRoots(message.Params.Capabilities.Roots != nil)
+
 		// Check if we have a custom protocol version to use (for compatibility)
 		if protocolVersion := os.Getenv("MCP_PROTOCOL_VERSION"); protocolVersion != "" {
 			logger.Info("Overriding protocol version to %s for compatibility", protocolVersion)
@@ -50,16 +133,23 @@ func NewAggregatorServer(serverName, version string, aggregator *aggregator.MCPA
 	hooks.AddAfterInitialize(func(id any, message *mcp.InitializeRequest, result *mcp.InitializeResult) {
 		logger.Info("Initialize response: server %s %s", result.ServerInfo.Name, result.ServerInfo.Version)
 
-		// Check if we're in Cursor mode
-		if os.Getenv("MCP_CURSOR_MODE") != "" {
-			logger.Info("Cursor compatibility mode enabled - customizing response")
+		// Sampling requests from upstream servers can only be relayed if the client supports them
+		aggregator.SetClientSamplingSupport(message.Params.Capabilities.Sampling != nil)
 
-			// Cursor might expect a specific server name format
-			if result.ServerInfo.Name != "cursor-mcp-server" {
-				logger.Debug("Setting server name to cursor-mcp-server for compatibility")
-				result.ServerInfo.Name = "cursor-mcp-server"
-			}
+		// Advertise what the servers behind the aggregator offer rather than the mcp-go defaults
+		upstream := aggregator.Capabilities()
+		result.Capabilities.Logging = upstream.Logging
+		if !s.downstreamLogging {
+			result.Capabilities.Logging = nil
 		}
+		result.Capabilities.Prompts = upstream.Prompts
+		result.Capabilities.Resources = upstream.Resources
+		result.Capabilities.Experimental = upstream.Experimental
+
+		// Explain how the tools of the servers are named
+		s.mu.RLock()
+		result.Instructions = s.instructions
+		s.mu.RUnlock()
 	})
 
 	hooks.AddBeforeCallTool(func(id any, message *mcp.CallToolRequest) {
@@ -71,49 +161,236 @@ func NewAggregatorServer(serverName, version string, aggregator *aggregator.MCPA
 		logger.Info("Tool call result: %s, success: %v", message.Params.Name, !result.IsError)
 	})
 
-	mcpServer := server.NewMCPServer(
-		serverName,
-		version,
-		server.WithLogging(),
+	serverOpts := []server.ServerOption{
 		server.WithHooks(hooks),
-	)
-
-	return &AggregatorServer{
-		mcpServer:  mcpServer,
-		aggregator: aggregator,
+		server.WithToolCapabilities(true),
+		server.WithResourceCapabilities(false, false),
+		server.WithPromptCapabilities(false),
+	}
+	if s.downstreamLogging {
+		serverOpts = append(serverOpts, server.WithLogging())
 	}
+	mcpServer := server.NewMCPServer(serverName, version, serverOpts...)
+	s.mcpServer = mcpServer
+
+	// Keep the registered tools in sync when servers come and go at runtime
+	aggregator.OnToolsChanged(s.refreshTools)
+
+	// Relay sampling requests from upstream servers to the client
+	aggregator.SetSamplingHandler(s.relaySampling)
+
+	// Relay roots requests from upstream servers to the client, and changes of the client's roots to them
+	aggregator.SetRootsHandler(s.relayRoots)
+	mcpServer.AddNotificationHandler(rootsListChangedMethod, s.relayRootsChanged)
+
+	// Relay the progress of long tool calls to the client that made them
+	aggregator.SetProgressHandler(s.relayProgress)
+
+	return s
 }
 
 // RegisterTools registers all tools from the aggregator to the MCP server
 func (s *AggregatorServer) RegisterTools() error {
+	tools := s.serverTools()
+	logger.Info("Registering %d tools from aggregator", len(tools))
+
+	// Register the tools with the MCP server
+	s.mcpServer.AddTools(tools...)
+	logger.Info("%s", toolSummary(s.aggregator.ToolsByServer()))
+
+	return nil
+}
+
+// RegisterResources registers all resources from the aggregator to the MCP server
+func (s *AggregatorServer) RegisterResources() error {
+	resources := s.aggregator.GetResources()
+	logger.Info("Registering %d resources from aggregator", len(resources))
+
+	for _, resource := range resources {
+		logger.Debug("Registering resource: %s", resource.URI)
+		s.mcpServer.AddResource(resource, s.createResourceHandler(resource.URI))
+	}
+	return nil
+}
+
+// RegisterPrompts registers all prompts from the aggregator to the MCP server
+func (s *AggregatorServer) RegisterPrompts() error {
+	prompts := s.aggregator.GetPrompts()
+	logger.Info("Registering %d prompts from aggregator", len(prompts))
+
+	for _, prompt := range prompts {
+		logger.Debug("Registering prompt: %s", prompt.Name)
+		s.mcpServer.AddPrompt(prompt, s.createPromptHandler(prompt.Name))
+	}
+	return nil
+}
+
+// refreshTools replaces the registered tools with the aggregator's current ones.
+// The MCP server notifies the client with tools/list_changed.
+// Resources and prompts can't be removed from the MCP server, so only newly discovered ones are added;
+// requests for those whose server is gone fail.
+func (s *AggregatorServer) refreshTools() {
+	tools := s.serverTools()
+	logger.Info("Tools changed, re-registering %d tools from aggregator", len(tools))
+	s.mcpServer.SetTools(tools...)
+
+	if err := s.RegisterResources(); err != nil {
+		logger.Error("Failed to register resources: %v", err)
+	}
+	if err := s.RegisterPrompts(); err != nil {
+		logger.Error("Failed to register prompts: %v", err)
+	}
+}
+
+// serverTools builds the tools to register on the MCP server from the aggregator's tools
+func (s *AggregatorServer) serverTools() []server.ServerTool {
 	// Get tools from aggregator
 	tools := s.aggregator.GetTools()
-	logger.Info("Registering %d tools from aggregator", len(tools))
 
-	// Register each tool with the MCP server
+	serverTools := make([]server.ServerTool, 0, len(tools))
 	for _, tool := range tools {
 		logger.Debug("Registering tool: %s", tool.Name)
-		s.mcpServer.AddTool(
-			mcp.Tool{
-				Name:        tool.Name,
-				Description: tool.Description,
-				InputSchema: tool.InputSchema,
+		serverTools = append(serverTools, server.ServerTool{
+			Tool: mcp.Tool{
+				Name:           tool.Name,
+				Description:    tool.Description,
+				InputSchema:    tool.InputSchema,
+				RawInputSchema: tool.RawInputSchema,
 			},
-			s.createToolHandler(tool.Name),
-		)
+			Handler: s.createToolHandler(tool.Name),
+		})
 	}
 
-	return nil
+	// Built-in tools are registered last, so they can't be shadowed by upstream tools
+	if !s.passthrough {
+		serverTools = append(serverTools, s.statusTool(), s.refreshTool())
+	}
+	return serverTools
+}
+
+// SetMaintenance toggles maintenance mode, in which tool calls are answered without reaching upstream servers
+func (s *AggregatorServer) SetMaintenance(enabled bool, message string) {
+	if message == "" {
+		message = config.DefaultMaintenanceMessage
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maintenance = enabled
+	s.maintenanceMessage = message
+
+	if enabled {
+		logger.Info("Maintenance mode enabled, tool calls will not be forwarded")
+	}
+}
+
+// maintenanceResult returns the result to answer tool calls with if maintenance mode is on
+func (s *AggregatorServer) maintenanceResult() (*mcp.CallToolResult, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.maintenance {
+		return nil, false
+	}
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{mcp.NewTextContent(s.maintenanceMessage)},
+		IsError: true,
+	}, true
+}
+
+// SetSoftErrors toggles reporting failed tool calls as tool errors, so the model can react
+// instead of the client seeing a protocol failure
+func (s *AggregatorServer) SetSoftErrors(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.softErrors = enabled
+}
+
+// SetInstructions sets the instructions the client gets in the initialize result, none if empty.
+// DefaultInstructions builds the ones explaining how the config names the tools.
+func (s *AggregatorServer) SetInstructions(instructions string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.instructions = instructions
+}
+
+// SetSlowCallThreshold sets the duration above which tool calls are logged as warnings.
+// A threshold of 0 or less restores the default.
+func (s *AggregatorServer) SetSlowCallThreshold(threshold time.Duration) {
+	if threshold <= 0 {
+		threshold = config.DefaultSlowCallThresholdMs * time.Millisecond
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.slowCallThreshold = threshold
+}
+
+// logCallDuration logs how long a tool call took, as a warning if it took longer than the slow call threshold
+func (s *AggregatorServer) logCallDuration(toolName, serverName string, duration time.Duration) {
+	s.mu.RLock()
+	threshold := s.slowCallThreshold
+	s.mu.RUnlock()
+
+	if duration > threshold {
+		logger.Warn("Slow tool call %s on server %s took %s", toolName, serverName, duration)
+	} else {
+		logger.Debug("Tool call %s on server %s took %s", toolName, serverName, duration)
+	}
+}
+
+// softErrorResult converts a failed tool call into a tool error result if soft errors are enabled
+func (s *AggregatorServer) softErrorResult(toolName string, err error) (*mcp.CallToolResult, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.softErrors {
+		return nil, false
+	}
+
+	message := fmt.Sprintf("tool %s failed: %v", toolName, err)
+	if serverName, ok := s.aggregator.ToolServer(toolName); ok {
+		message = fmt.Sprintf("backing server %s failed: %v", serverName, err)
+	}
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{mcp.NewTextContent(message)},
+		IsError: true,
+	}, true
 }
 
 // createToolHandler creates a handler function for a specific tool
 func (s *AggregatorServer) createToolHandler(toolName string) server.ToolHandlerFunc {
 	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		// Short-circuit the call while upstream servers are under maintenance
+		if result, ok := s.maintenanceResult(); ok {
+			logger.Debug("Maintenance mode: not forwarding tool call %s", toolName)
+			return result, nil
+		}
+
+		ctx, span := otel.Tracer(tracerName).Start(ctx, toolName, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+		serverName, ok := s.aggregator.ToolServer(toolName)
+		if ok {
+			span.SetAttributes(attribute.String("server.name", serverName))
+		}
+
 		// Forward the call to the aggregator
 		logger.Debug("Handling tool call: %s", toolName)
+		start := time.Now()
 		result, err := s.aggregator.CallTool(ctx, request)
+		if ok {
+			s.logCallDuration(toolName, serverName, time.Since(start))
+		}
 		if err != nil {
 			logger.Error("Tool call failed: %s, error: %v", toolName, err)
+			if serverName, originalName, command, args, ok := s.aggregator.ToolOrigin(toolName); ok {
+				logger.Error("Failed tool %s is %s of server %s, started as: %s", toolName, originalName, serverName, strings.Join(append([]string{command}, args...), " "))
+			}
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			if result, ok := s.softErrorResult(toolName, err); ok {
+				return result, nil
+			}
 		} else {
 			logger.Debug("Tool call succeeded: %s", toolName)
 		}
@@ -121,80 +398,442 @@ func (s *AggregatorServer) createToolHandler(toolName string) server.ToolHandler
 	}
 }
 
-// ServeStdio serves the MCP server over stdio with message logging
-func (s *AggregatorServer) ServeStdio() error {
+// createResourceHandler creates a handler function that reads a specific resource through the aggregator
+func (s *AggregatorServer) createResourceHandler(uri string) server.ResourceHandlerFunc {
+	return func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+		logger.Debug("Handling resource read: %s", uri)
+		result, err := s.aggregator.ReadResource(ctx, request)
+		if err != nil {
+			logger.Error("Resource read failed: %s, error: %v", uri, err)
+			return nil, err
+		}
+		return result.Contents, nil
+	}
+}
+
+// createPromptHandler creates a handler function that gets a specific prompt through the aggregator
+func (s *AggregatorServer) createPromptHandler(name string) server.PromptHandlerFunc {
+	return func(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		logger.Debug("Handling prompt request: %s", name)
+		result, err := s.aggregator.GetPrompt(ctx, request)
+		if err != nil {
+			logger.Error("Prompt request failed: %s, error: %v", name, err)
+		}
+		return result, err
+	}
+}
+
+// relaySampling sends a sampling request from an upstream server to the client and returns its result
+func (s *AggregatorServer) relaySampling(ctx context.Context, serverName string, params json.RawMessage) (json.RawMessage, error) {
+	s.mu.RLock()
+	downstream := s.downstream
+	s.mu.RUnlock()
+
+	if downstream == nil {
+		return nil, errClientDisconnected
+	}
+
+	logger.Info("Relaying sampling request from server %s to the client", serverName)
+	return downstream.request(ctx, aggregator.MethodCreateMessage, params)
+}
+
+// relayRoots asks the client for its roots on behalf of an upstream server and returns its result
+func (s *AggregatorServer) relayRoots(ctx context.Context, serverName string) (json.RawMessage, error) {
+	s.mu.RLock()
+	downstream := s.downstream
+	s.mu.RUnlock()
+
+	if downstream == nil {
+		return nil, errClientDisconnected
+	}
+
+	logger.Debug("Relaying roots request from server %s to the client", serverName)
+	return downstream.request(ctx, aggregator.MethodListRoots, nil)
+}
+
+// relayRootsChanged tells the upstream servers that the client's roots changed
+func (s *AggregatorServer) relayRootsChanged(ctx context.Context, notification mcp.JSONRPCNotification) {
+	logger.Debug("Client roots changed, notifying servers")
+	s.aggregator.NotifyRootsChanged()
+}
+
+// relayProgress sends a progress notification of an upstream server to the client of the tool call
+func (s *AggregatorServer) relayProgress(ctx context.Context, params map[string]interface{}) {
+	if err := s.mcpServer.SendNotificationToClient(ctx, aggregator.MethodProgress, params); err != nil {
+		logger.Debug("Failed to relay progress notification: %v", err)
+	}
+}
+
+// ServeStdio serves the MCP server over stdio with message logging until stdin is closed or ctx is cancelled
+func (s *AggregatorServer) ServeStdio(ctx context.Context) error {
+	return s.serve(ctx, os.Stdin, os.Stdout)
+}
+
+// serve reads JSON-RPC messages line by line from in and writes responses to out.
+// Once ctx is cancelled it stops reading and waits up to the shutdown timeout for the request being
+// handled to be answered. Requests still queued are answered with an error.
+func (s *AggregatorServer) serve(ctx context.Context, in io.Reader, out io.Writer) error {
 	logger.Debug("Starting stdio server")
 
-	scanner := bufio.NewScanner(os.Stdin)
-	scanner.Buffer(make([]byte, 1024*1024), 1024*1024) // Increase scanner buffer size
-	ctx := context.Background()
+	// Lines are read whole however long they are, so large tool results or resources don't end the session
+	reader := bufio.NewReader(in)
+
+	// Register the client session so notifications can be delivered
+	session := newStdioSession()
+	if err := s.mcpServer.RegisterSession(session); err != nil {
+		return fmt.Errorf("failed to register session: %w", err)
+	}
+	defer s.mcpServer.UnregisterSession(session.SessionID())
+	// Requests aren't cancelled with ctx, so calls in flight can finish while shutting down
+	sessionCtx := s.mcpServer.WithContext(context.Background(), session)
+
+	// Responses and notifications share stdout, so writes are serialized
+	var writeMu sync.Mutex
+	writeLine := func(message []byte) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		fmt.Fprintln(out, string(message))
+	}
 
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		if len(line) == 0 {
-			continue // Skip empty lines
+	stopNotifications := make(chan struct{})
+	notificationsDone := make(chan struct{})
+	go func() {
+		defer close(notificationsDone)
+		for {
+			select {
+			case <-stopNotifications:
+				return
+			case notification := <-session.notifications:
+				notificationBytes, err := json.Marshal(notification)
+				if err != nil {
+					logger.Error("Failed to marshal notification: %v", err)
+					continue
+				}
+				logger.LogRPC("OUT", notificationBytes)
+				writeLine(notificationBytes)
+			}
 		}
+	}()
+	defer func() {
+		close(stopNotifications)
+		<-notificationsDone
+	}()
+
+	// Client requests are handled one at a time by a worker, so the reader stays free to
+	// route the client's responses to requests we sent it, such as relayed sampling requests
+	downstream := newDownstreamRequests(func(message []byte) {
+		logger.LogRPC("OUT", message)
+		writeLine(message)
+	})
+	requests := make(chan []byte, 100)
+	workerDone := make(chan struct{})
+	go func() {
+		defer close(workerDone)
+		for line := range requests {
+			// Requests still queued when shutting down aren't started, but the client still gets an answer
+			if ctx.Err() != nil {
+				rejectMessage(line, writeLine)
+				continue
+			}
+			s.handleMessage(sessionCtx, line, writeLine)
+		}
+	}()
+	defer func() {
+		close(requests)
+		if ctx.Err() == nil {
+			<-workerDone
+			return
+		}
+		// Responses must be written before the caller closes the aggregator and exits
+		select {
+		case <-workerDone:
+		case <-time.After(s.shutdownTimeout):
+			logger.Error("Request still being handled after %v, stopping anyway", s.shutdownTimeout)
+		}
+	}()
+
+	s.mu.Lock()
+	s.downstream = downstream
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.downstream = nil
+		s.mu.Unlock()
+		downstream.close()
+	}()
+
+	// Reading blocks until the client writes or closes stdin, so it happens in its own goroutine
+	// that is abandoned when ctx is cancelled
+	lines := make(chan []byte)
+	readDone := make(chan error, 1)
+	go func() {
+		for {
+			line, err := reader.ReadBytes('\n')
+			if message := bytes.TrimRight(line, "\r\n"); len(message) > 0 { // Skip empty lines
+				select {
+				case lines <- message:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				readDone <- err
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Debug("Stopping stdio server: %v", ctx.Err())
+			return nil
+		case err := <-readDone:
+			if err != nil {
+				logger.Error("Error reading from stdin: %v", err)
+				return err
+			}
+			return nil
+		case message := <-lines:
+			if downstream.resolve(message) {
+				logger.LogRPC("IN", message)
+				continue
+			}
+			select {
+			case requests <- message:
+			case <-ctx.Done():
+				logger.Debug("Stopping stdio server: %v", ctx.Err())
+				return nil
+			}
+		}
+	}
+}
 
-		// Log incoming message to file only with extra detail
+// handleMessage handles a message or a JSON-RPC batch of messages from the client and writes the response, if any.
+// The responses to a batch are written as a single array, leaving out the messages that need no response.
+func (s *AggregatorServer) handleMessage(ctx context.Context, line []byte, writeLine func([]byte)) {
+	trimmed := bytes.TrimSpace(line)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		s.handleSingleMessage(ctx, line, writeLine)
+		return
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(trimmed, &batch); err != nil || len(batch) == 0 {
+		// Like a malformed single message, an unusable batch is answered with a single error
 		logger.LogRPC("IN", line)
+		logger.Debug("Received invalid batch: %v", err)
+		errorBytes, marshalErr := json.Marshal(mcp.NewJSONRPCError(nil, mcp.INVALID_REQUEST, "Invalid batch", nil))
+		if marshalErr != nil {
+			logger.Error("Failed to marshal error response: %v", marshalErr)
+			return
+		}
+		logger.LogRPC("OUT", errorBytes)
+		writeLine(errorBytes)
+		return
+	}
 
-		// Try to parse the incoming message for better logging
-		var req map[string]interface{}
-		if err := json.Unmarshal(line, &req); err == nil {
-			if method, ok := req["method"].(string); ok {
-				id := "null"
-				if reqID, exists := req["id"]; exists {
-					id = fmt.Sprintf("%v", reqID)
-				}
-				logger.Debug("Received request: method=%s, id=%s", method, id)
+	logger.Debug("Received batch of %d messages", len(batch))
+	responses := make([]json.RawMessage, 0, len(batch))
+	for _, message := range batch {
+		s.handleSingleMessage(ctx, message, func(response []byte) {
+			responses = append(responses, response)
+		})
+	}
+	if len(responses) == 0 {
+		return
+	}
+	responseBytes, err := json.Marshal(responses)
+	if err != nil {
+		logger.Error("Failed to marshal batch response: %v", err)
+		return
+	}
+	writeLine(responseBytes)
+}
+
+// rejectMessage answers the requests of a message or batch the server won't handle because it is shutting down.
+// Notifications need no answer and are dropped.
+func rejectMessage(line []byte, writeLine func([]byte)) {
+	trimmed := bytes.TrimSpace(line)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		if response := shutdownError(trimmed); response != nil {
+			logger.LogRPC("OUT", response)
+			writeLine(response)
+		}
+		return
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(trimmed, &batch); err != nil {
+		return
+	}
+	responses := make([]json.RawMessage, 0, len(batch))
+	for _, message := range batch {
+		if response := shutdownError(message); response != nil {
+			responses = append(responses, response)
+		}
+	}
+	if len(responses) == 0 {
+		return
+	}
+	responseBytes, err := json.Marshal(responses)
+	if err != nil {
+		logger.Error("Failed to marshal batch response: %v", err)
+		return
+	}
+	logger.LogRPC("OUT", responseBytes)
+	writeLine(responseBytes)
+}
+
+// shutdownError returns the error response to a request the server won't handle because it is shutting down,
+// or nil if the message is no request
+func shutdownError(message []byte) []byte {
+	var request struct {
+		ID     interface{} `json:"id"`
+		Method string      `json:"method"`
+	}
+	if err := json.Unmarshal(message, &request); err != nil || request.ID == nil || request.Method == "" {
+		return nil
+	}
+	logger.Debug("Rejecting request %s, id=%v: server is shutting down", request.Method, request.ID)
+	response, err := json.Marshal(mcp.NewJSONRPCError(request.ID, mcp.INTERNAL_ERROR, "Server is shutting down", nil))
+	if err != nil {
+		logger.Error("Failed to marshal error response: %v", err)
+		return nil
+	}
+	return response
+}
+
+// handleSingleMessage handles a single message from the client and writes the response, if any
+func (s *AggregatorServer) handleSingleMessage(ctx context.Context, line []byte, writeLine func([]byte)) {
+	// Every message gets a correlation ID, which the aggregator logs with the requests it forwards for it
+	correlationID := aggregator.NewCorrelationID()
+	ctx = aggregator.WithCorrelationID(ctx, correlationID)
+
+	// Log incoming message to file only with extra detail
+	logger.LogRPCCorrelated("IN", correlationID, line)
+
+	// Try to parse the incoming message for better logging
+	var req map[string]interface{}
+	var requestID interface{}
+	if err := json.Unmarshal(line, &req); err == nil {
+		requestID = req["id"]
+		if method, ok := req["method"].(string); ok {
+			id := "null"
+			if reqID, exists := req["id"]; exists {
+				id = fmt.Sprintf("%v", reqID)
+			}
+			logger.Debug("Received request: method=%s, id=%s, correlation=%s", method, id, correlationID)
+		}
+
+		// Continue the trace of the client, if it passed one along
+		if params, ok := req["params"].(map[string]interface{}); ok {
+			if meta, ok := params["_meta"].(map[string]interface{}); ok {
+				ctx = tracing.ContextFromMeta(ctx, meta)
 			}
 		}
+	}
+
+	// Handle message
+	response := s.mcpServer.HandleMessage(ctx, line)
+	if response != nil {
+		responseBytes, err := json.Marshal(response)
+		if err != nil {
+			logger.Error("Failed to marshal response: %v", err)
+			s.writeDeadLetter(line, response, err)
 
-		// Handle message
-		response := s.mcpServer.HandleMessage(ctx, line)
-		if response != nil {
-			responseBytes, err := json.Marshal(response)
+			// Answer with an error so the client doesn't wait for a response that never comes
+			responseBytes, err = json.Marshal(mcp.NewJSONRPCError(requestID, mcp.INTERNAL_ERROR, "Failed to marshal response", nil))
 			if err != nil {
-				logger.Error("Failed to marshal response: %v", err)
-				continue
+				logger.Error("Failed to marshal error response: %v", err)
+				return
 			}
+		}
 
-			// Log outgoing message to file only with extra detail
-			logger.LogRPC("OUT", responseBytes)
+		// Log outgoing message to file only with extra detail
+		logger.LogRPCCorrelated("OUT", correlationID, responseBytes)
 
-			// Try to parse the response for better logging
-			var resp map[string]interface{}
-			if err := json.Unmarshal(responseBytes, &resp); err == nil {
-				id := "null"
-				if respID, exists := resp["id"]; exists {
-					id = fmt.Sprintf("%v", respID)
-				}
+		// Try to parse the response for better logging
+		var resp map[string]interface{}
+		if err := json.Unmarshal(responseBytes, &resp); err == nil {
+			id := "null"
+			if respID, exists := resp["id"]; exists {
+				id = fmt.Sprintf("%v", respID)
+			}
 
-				if result, exists := resp["result"]; exists {
-					logger.Debug("Sending response: id=%s, success=true", id)
+			if result, exists := resp["result"]; exists {
+				logger.Debug("Sending response: id=%s, success=true", id)
 
-					// For tools/list specifically, log the count of tools
-					if toolsResult, ok := result.(map[string]interface{}); ok {
-						if tools, exists := toolsResult["tools"].([]interface{}); exists {
-							logger.Debug("Response includes %d tools", len(tools))
-						}
+				// For tools/list specifically, log the count of tools
+				if toolsResult, ok := result.(map[string]interface{}); ok {
+					if tools, exists := toolsResult["tools"].([]interface{}); exists {
+						logger.Debug("Response includes %d tools", len(tools))
 					}
-				} else if _, exists := resp["error"]; exists {
-					logger.Debug("Sending response: id=%s, error=true", id)
 				}
+			} else if _, exists := resp["error"]; exists {
+				logger.Debug("Sending response: id=%s, error=true", id)
 			}
-
-			// Write response - this must be the only thing written to stdout
-			// No logging, no extra output, just the pure JSON response
-			// We explicitly use os.Stdout to ensure we're writing to the original stdout
-			fmt.Fprintln(os.Stdout, string(responseBytes))
 		}
+
+		// Write response - this must be the only thing written to stdout
+		// No logging, no extra output, just the pure JSON response
+		writeLine(responseBytes)
 	}
+}
+
+// SetDeadLetterFile sets the file that receives responses which could not be delivered
+func (s *AggregatorServer) SetDeadLetterFile(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deadLetterFile = path
+}
 
-	if err := scanner.Err(); err != nil {
-		logger.Error("Scanner error: %v", err)
-		return err
+// writeDeadLetter appends a dropped response to the dead-letter file, if one is configured
+func (s *AggregatorServer) writeDeadLetter(request []byte, response mcp.JSONRPCMessage, cause error) {
+	s.mu.RLock()
+	path := s.deadLetterFile
+	s.mu.RUnlock()
+
+	if path == "" {
+		return
 	}
 
-	return nil
+	// The response itself can't be marshaled, so its Go representation is recorded instead
+	entry, err := json.Marshal(struct {
+		Time     string          `json:"time"`
+		Error    string          `json:"error"`
+		Request  json.RawMessage `json:"request,omitempty"`
+		Response string          `json:"response"`
+	}{
+		Time:     time.Now().Format(time.RFC3339Nano),
+		Error:    cause.Error(),
+		Request:  validJSONOrNil(request),
+		Response: fmt.Sprintf("%+v", response),
+	})
+	if err != nil {
+		logger.Error("Failed to marshal dead-letter entry: %v", err)
+		return
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		logger.Error("Failed to open dead-letter file %s: %v", path, err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(entry, '\n')); err != nil {
+		logger.Error("Failed to write dead-letter entry: %v", err)
+	}
+}
+
+// validJSONOrNil returns the message if it is valid JSON, so it can be embedded as a raw value
+func validJSONOrNil(message []byte) json.RawMessage {
+	if !json.Valid(message) {
+		return nil
+	}
+	return json.RawMessage(message)
 }
@@ -0,0 +1,965 @@
+package stdio
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/nazar256/combine-mcp/pkg/aggregator"
+	"github.com/nazar256/combine-mcp/pkg/config"
+	"github.com/nazar256/combine-mcp/pkg/logger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	if err := logger.Init(config.LogLevelError, ""); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	s := NewAggregatorServer("test-aggregator", "1.0.0", aggregator.NewMCPAggregator())
+	handler := s.createToolHandler("shortcut_search_stories")
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "shortcut_search_stories"
+
+	s.SetMaintenance(true, "Back in 5 minutes")
+	result, err := handler(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Handler returned error in maintenance mode: %v", err)
+	}
+	if !result.IsError {
+		t.Errorf("Maintenance result IsError = false, want true")
+	}
+	if len(result.Content) != 1 {
+		t.Fatalf("Maintenance result has %d content items, want 1", len(result.Content))
+	}
+	text, ok := mcp.AsTextContent(result.Content[0])
+	if !ok || text.Text != "Back in 5 minutes" {
+		t.Errorf("Maintenance result content = %+v, want %q", result.Content[0], "Back in 5 minutes")
+	}
+
+	s.SetMaintenance(true, "")
+	result, err = handler(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Handler returned error in maintenance mode: %v", err)
+	}
+	if text, ok := mcp.AsTextContent(result.Content[0]); !ok || text.Text != config.DefaultMaintenanceMessage {
+		t.Errorf("Maintenance result content = %+v, want default message", result.Content[0])
+	}
+
+	// With maintenance off the call reaches the aggregator, which doesn't know the tool
+	s.SetMaintenance(false, "")
+	if _, err := handler(context.Background(), request); err == nil {
+		t.Errorf("Handler forwarded call returned no error, want tool not found")
+	}
+}
+
+func TestSoftErrors(t *testing.T) {
+	if err := logger.Init(config.LogLevelError, ""); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	s := NewAggregatorServer("test-aggregator", "1.0.0", aggregator.NewMCPAggregator())
+	handler := s.createToolHandler("shortcut_search_stories")
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "shortcut_search_stories"
+
+	// By default failures are returned as errors
+	if _, err := handler(context.Background(), request); err == nil {
+		t.Fatalf("Handler returned no error with soft errors disabled")
+	}
+
+	s.SetSoftErrors(true)
+	result, err := handler(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Handler returned error with soft errors enabled: %v", err)
+	}
+	if !result.IsError {
+		t.Errorf("Soft error result IsError = false, want true")
+	}
+	text, ok := mcp.AsTextContent(result.Content[0])
+	if !ok || !strings.Contains(text.Text, "shortcut_search_stories") {
+		t.Errorf("Soft error result content = %+v, want a message naming the tool", result.Content[0])
+	}
+}
+
+func TestUnmarshalableResponse(t *testing.T) {
+	if err := logger.Init(config.LogLevelError, ""); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	s := NewAggregatorServer("test-aggregator", "1.0.0", aggregator.NewMCPAggregator())
+	deadLetterPath := filepath.Join(t.TempDir(), "dead-letter.log")
+	s.SetDeadLetterFile(deadLetterPath)
+
+	// A channel can't be marshaled to JSON, so the response for this tool can never be sent as-is
+	s.mcpServer.AddTool(mcp.Tool{Name: "broken"}, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		result := mcp.NewToolResultText("unreachable")
+		result.Meta = map[string]interface{}{"channel": make(chan int)}
+		return result, nil
+	})
+
+	in := strings.NewReader(`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"broken"}}` + "\n")
+	var out bytes.Buffer
+	if err := s.serve(context.Background(), in, &out); err != nil {
+		t.Fatalf("serve() error = %v", err)
+	}
+
+	var response struct {
+		JSONRPC string      `json:"jsonrpc"`
+		ID      interface{} `json:"id"`
+		Error   *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &response); err != nil {
+		t.Fatalf("Response is not valid JSON: %v (%q)", err, out.String())
+	}
+	if response.ID != float64(7) {
+		t.Errorf("Response id = %v, want 7", response.ID)
+	}
+	if response.Error == nil || response.Error.Code != mcp.INTERNAL_ERROR {
+		t.Errorf("Response error = %+v, want code %d", response.Error, mcp.INTERNAL_ERROR)
+	}
+
+	deadLetter, err := os.ReadFile(deadLetterPath)
+	if err != nil {
+		t.Fatalf("Failed to read dead-letter file: %v", err)
+	}
+	if !strings.Contains(string(deadLetter), `"method":"tools/call"`) {
+		t.Errorf("Dead-letter entry doesn't include the request: %s", deadLetter)
+	}
+}
+
+func TestLargeMessages(t *testing.T) {
+	if err := logger.Init(config.LogLevelError, ""); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	s := NewAggregatorServer("test-aggregator", "1.0.0", aggregator.NewMCPAggregator())
+	s.mcpServer.AddTool(mcp.Tool{Name: "echo"}, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		text, _ := request.Params.Arguments["text"].(string)
+		return mcp.NewToolResultText(text), nil
+	})
+
+	// 2MB exceeds the default buffers of line readers, the message must still be read whole
+	text := strings.Repeat("x", 2*1024*1024)
+	in := strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"echo","arguments":{"text":"` + text + `"}}}` + "\n")
+	var out bytes.Buffer
+	if err := s.serve(context.Background(), in, &out); err != nil {
+		t.Fatalf("serve() error = %v", err)
+	}
+
+	var response struct {
+		ID     float64 `json:"id"`
+		Result struct {
+			Content []struct {
+				Text string `json:"text"`
+			} `json:"content"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &response); err != nil {
+		t.Fatalf("Response is not valid JSON: %v", err)
+	}
+	if response.ID != 1 || len(response.Result.Content) != 1 {
+		t.Fatalf("Response = %.200s, want the result of request 1", out.String())
+	}
+	if response.Result.Content[0].Text != text {
+		t.Errorf("Echoed text has %d bytes, want the %d sent", len(response.Result.Content[0].Text), len(text))
+	}
+}
+
+func TestBatchRequests(t *testing.T) {
+	if err := logger.Init(config.LogLevelError, ""); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	s := NewAggregatorServer("test-aggregator", "1.0.0", aggregator.NewMCPAggregator())
+	in := strings.NewReader(strings.Join([]string{
+		`[{"jsonrpc":"2.0","id":1,"method":"ping"},{"jsonrpc":"2.0","method":"notifications/initialized"},{"jsonrpc":"2.0","id":2,"method":"tools/list"}]`,
+		`{"jsonrpc":"2.0","id":3,"method":"ping"}`,
+		`[]`,
+	}, "\n") + "\n")
+	var out bytes.Buffer
+	if err := s.serve(context.Background(), in, &out); err != nil {
+		t.Fatalf("serve() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("serve() wrote %d lines, want 3: %q", len(lines), out.String())
+	}
+
+	// The notification of the batch gets no response
+	var batch []struct {
+		ID     float64         `json:"id"`
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &batch); err != nil {
+		t.Fatalf("Batch response is not an array: %v (%q)", err, lines[0])
+	}
+	if len(batch) != 2 || batch[0].ID != 1 || batch[1].ID != 2 || batch[0].Result == nil || batch[1].Result == nil {
+		t.Errorf("Batch response = %s, want results for requests 1 and 2", lines[0])
+	}
+
+	// Single messages are still answered with a single object
+	var single struct {
+		ID float64 `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &single); err != nil || single.ID != 3 {
+		t.Errorf("Response to single request = %s, want an object for request 3", lines[1])
+	}
+
+	var invalid struct {
+		Error *struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(lines[2]), &invalid); err != nil || invalid.Error == nil || invalid.Error.Code != mcp.INVALID_REQUEST {
+		t.Errorf("Response to empty batch = %s, want an invalid request error", lines[2])
+	}
+}
+
+func TestRelaySampling(t *testing.T) {
+	if err := logger.Init(config.LogLevelError, ""); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	s := NewAggregatorServer("test-aggregator", "1.0.0", aggregator.NewMCPAggregator())
+
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	serveDone := make(chan error, 1)
+	go func() { serveDone <- s.serve(context.Background(), inReader, outWriter) }()
+
+	// The relay needs a connected client
+	waitForServe := time.Now().Add(2 * time.Second)
+	for {
+		s.mu.RLock()
+		connected := s.downstream != nil
+		s.mu.RUnlock()
+		if connected {
+			break
+		}
+		if time.Now().After(waitForServe) {
+			t.Fatal("serve() didn't start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	type relayResult struct {
+		result json.RawMessage
+		err    error
+	}
+	relayDone := make(chan relayResult, 1)
+	go func() {
+		result, err := s.relaySampling(context.Background(), "upstream", json.RawMessage(`{"maxTokens":10}`))
+		relayDone <- relayResult{result, err}
+	}()
+
+	// The client receives the sampling request with an id of the aggregator's choosing
+	line, err := bufio.NewReader(outReader).ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("Failed to read relayed request: %v", err)
+	}
+	var request struct {
+		ID     int64           `json:"id"`
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(line, &request); err != nil {
+		t.Fatalf("Relayed request is not valid JSON: %v (%q)", err, line)
+	}
+	if request.Method != aggregator.MethodCreateMessage || string(request.Params) != `{"maxTokens":10}` {
+		t.Errorf("Relayed request = %s, want sampling request with the upstream params", line)
+	}
+
+	fmt.Fprintf(inWriter, `{"jsonrpc":"2.0","id":%d,"result":{"role":"assistant","content":{"type":"text","text":"Hi!"},"model":"test-model"}}`+"\n", request.ID)
+
+	select {
+	case relayed := <-relayDone:
+		if relayed.err != nil {
+			t.Fatalf("relaySampling() error = %v", relayed.err)
+		}
+		if !strings.Contains(string(relayed.result), `"text":"Hi!"`) {
+			t.Errorf("relaySampling() result = %s, want the client's result", relayed.result)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("relaySampling() didn't receive the client's response")
+	}
+
+	inWriter.Close()
+	if err := <-serveDone; err != nil {
+		t.Fatalf("serve() error = %v", err)
+	}
+}
+
+func TestServeStopsOnCancel(t *testing.T) {
+	if err := logger.Init(config.LogLevelError, ""); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	s := NewAggregatorServer("test-aggregator", "1.0.0", aggregator.NewMCPAggregator())
+
+	// The client keeps stdin open, so only cancelling the context stops serving
+	inReader, inWriter := io.Pipe()
+	defer inWriter.Close()
+	outReader, outWriter := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	serveDone := make(chan error, 1)
+	go func() { serveDone <- s.serve(ctx, inReader, outWriter) }()
+
+	// Requests are answered until then
+	fmt.Fprintln(inWriter, `{"jsonrpc":"2.0","id":1,"method":"ping"}`)
+	line, err := bufio.NewReader(outReader).ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("Failed to read ping response: %v", err)
+	}
+	if !strings.Contains(string(line), `"id":1`) {
+		t.Errorf("Ping response = %s, want the response to request 1", line)
+	}
+
+	cancel()
+	select {
+	case err := <-serveDone:
+		if err != nil {
+			t.Errorf("serve() error = %v, want nil after cancellation", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("serve() didn't return after the context was cancelled")
+	}
+}
+
+func TestToolCallSpans(t *testing.T) {
+	if err := logger.Init(config.LogLevelError, ""); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	s := NewAggregatorServer("test-aggregator", "1.0.0", aggregator.NewMCPAggregator())
+	s.mcpServer.AddTool(mcp.NewTool("shortcut_search_stories"), s.createToolHandler("shortcut_search_stories"))
+
+	// The client passes its trace context in the _meta of the request
+	line := []byte(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"shortcut_search_stories",` +
+		`"_meta":{"traceparent":"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}}`)
+	s.handleMessage(context.Background(), line, func([]byte) {})
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Recorded %d spans, want the handler and aggregator spans", len(spans))
+	}
+	// The aggregator span ends first, inside the handler span
+	handlerSpan, aggregatorSpan := spans[1], spans[0]
+	if handlerSpan.Name() != "shortcut_search_stories" || handlerSpan.SpanKind() != trace.SpanKindServer {
+		t.Errorf("Handler span = %s (%v), want shortcut_search_stories (server)", handlerSpan.Name(), handlerSpan.SpanKind())
+	}
+	if got := handlerSpan.Parent().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Handler span trace ID = %s, want the trace of the client", got)
+	}
+	if aggregatorSpan.Parent().SpanID() != handlerSpan.SpanContext().SpanID() {
+		t.Errorf("Aggregator span isn't a child of the handler span")
+	}
+	// The tool doesn't exist in the aggregator, so the call fails
+	if handlerSpan.Status().Code != codes.Error {
+		t.Errorf("Handler span status = %v, want error", handlerSpan.Status().Code)
+	}
+}
+
+func TestStatusTool(t *testing.T) {
+	if err := logger.Init(config.LogLevelError, ""); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	s := NewAggregatorServer("test-aggregator", "1.2.3", aggregator.NewMCPAggregator())
+
+	var statusTool *server.ServerTool
+	for _, tool := range s.serverTools() {
+		if tool.Tool.Name == StatusToolName {
+			statusTool = &tool
+		}
+	}
+	if statusTool == nil {
+		t.Fatalf("serverTools() doesn't include %s", StatusToolName)
+	}
+
+	result, err := statusTool.Handler(context.Background(), mcp.CallToolRequest{})
+	if err != nil {
+		t.Fatalf("Status tool error = %v", err)
+	}
+	text, ok := mcp.AsTextContent(result.Content[0])
+	if !ok {
+		t.Fatalf("Status tool content = %+v, want text", result.Content[0])
+	}
+	var got status
+	if err := json.Unmarshal([]byte(text.Text), &got); err != nil {
+		t.Fatalf("Status isn't valid JSON: %v", err)
+	}
+	if got.Name != "test-aggregator" || got.Version != "1.2.3" || len(got.Servers) != 0 || got.Stats.Connected != 0 || got.Stats.Tools != 0 {
+		t.Errorf("Status = %+v, want test-aggregator 1.2.3 without servers", got)
+	}
+}
+
+func TestRefreshTool(t *testing.T) {
+	if err := logger.Init(config.LogLevelError, ""); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	s := NewAggregatorServer("test-aggregator", "1.0.0", aggregator.NewMCPAggregator())
+	result, err := s.handleRefresh(context.Background(), mcp.CallToolRequest{})
+	if err != nil {
+		t.Fatalf("Refresh tool error = %v", err)
+	}
+	text, ok := mcp.AsTextContent(result.Content[0])
+	if !ok || text.Text != "Added tools: none\nRemoved tools: none" {
+		t.Errorf("Refresh tool content = %+v, want a summary without changes", result.Content[0])
+	}
+}
+
+func TestToolSummary(t *testing.T) {
+	summary := toolSummary([]aggregator.ServerTools{
+		{Name: "github", Tools: []string{"github_get_repo", "github_list_issues"}, Filtered: []string{"delete-repo"}},
+		{Name: "shortcut"},
+	})
+
+	want := "Tool summary:\n" +
+		"[github] 2 registered, 1 filtered out\n" +
+		"  registered   github_get_repo\n" +
+		"  registered   github_list_issues\n" +
+		"  filtered out delete-repo\n" +
+		"[shortcut] 0 registered, 0 filtered out"
+	if summary != want {
+		t.Errorf("toolSummary() = %q, want %q", summary, want)
+	}
+}
+
+func TestRelayProgress(t *testing.T) {
+	if err := logger.Init(config.LogLevelError, ""); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	s := NewAggregatorServer("test-aggregator", "1.0.0", aggregator.NewMCPAggregator())
+	session := newStdioSession()
+	session.Initialize()
+	if err := s.mcpServer.RegisterSession(session); err != nil {
+		t.Fatalf("RegisterSession() error = %v", err)
+	}
+	defer s.mcpServer.UnregisterSession(session.SessionID())
+	ctx := s.mcpServer.WithContext(context.Background(), session)
+
+	s.relayProgress(ctx, map[string]interface{}{"progressToken": "build-1", "progress": float64(1), "total": float64(4)})
+
+	select {
+	case notification := <-session.notifications:
+		if notification.Method != aggregator.MethodProgress {
+			t.Errorf("Notification method = %s, want %s", notification.Method, aggregator.MethodProgress)
+		}
+		params := notification.Params.AdditionalFields
+		if params["progressToken"] != "build-1" || params["progress"] != float64(1) || params["total"] != float64(4) {
+			t.Errorf("Notification params = %v, want the relayed progress", params)
+		}
+	default:
+		t.Fatal("Progress notification didn't reach the client session")
+	}
+}
+
+// correlationHelperEnvVar makes the test binary forward a tool call, logging to the file it names
+const correlationHelperEnvVar = "COMBINE_MCP_CORRELATION_LOG"
+
+// correlationClient is a server client that records the correlation ID of the calls it receives
+type correlationClient struct {
+	correlationID string
+}
+
+func (c *correlationClient) Initialize(ctx context.Context, request mcp.InitializeRequest) (*mcp.InitializeResult, error) {
+	return &mcp.InitializeResult{ServerInfo: mcp.Implementation{Name: "github", Version: "1.0.0"}}, nil
+}
+
+func (c *correlationClient) ListTools(ctx context.Context, request mcp.ListToolsRequest) (*mcp.ListToolsResult, error) {
+	return &mcp.ListToolsResult{Tools: []mcp.Tool{mcp.NewTool("get_repo")}}, nil
+}
+
+func (c *correlationClient) CallTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	c.correlationID = aggregator.CorrelationID(ctx)
+	return mcp.NewToolResultText("ok"), nil
+}
+
+func (c *correlationClient) Close() error {
+	return nil
+}
+
+// TestCorrelationIDHelper is not a real test, it is the process whose log TestCorrelationID inspects
+func TestCorrelationIDHelper(t *testing.T) {
+	logPath := os.Getenv(correlationHelperEnvVar)
+	if logPath == "" {
+		return
+	}
+
+	client := &correlationClient{}
+	agg := aggregator.NewMCPAggregator(aggregator.WithClientFactory(func(serverCfg config.ServerConfig) (aggregator.MCPClient, error) {
+		return client, nil
+	}))
+	cfg := &config.Config{
+		Servers:  []config.ServerConfig{{Name: "github", Command: "test-command"}},
+		LogLevel: config.LogLevelTrace,
+		LogFile:  logPath,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	defer logger.Close()
+	defer agg.Close()
+
+	s := NewAggregatorServer("test-aggregator", "1.0.0", agg)
+	if err := s.RegisterTools(); err != nil {
+		t.Fatalf("RegisterTools() error = %v", err)
+	}
+	line := []byte(`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"github_get_repo"}}`)
+	s.handleMessage(context.Background(), line, func([]byte) {})
+
+	// The parent process finds the ID the server saw in the log
+	if client.correlationID == "" {
+		t.Fatalf("The forwarded call carried no correlation ID")
+	}
+	logger.Debug("Server saw correlation=%s", client.correlationID)
+}
+
+func TestCorrelationID(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "combine-mcp.log")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestCorrelationIDHelper$")
+	cmd.Env = append(os.Environ(), correlationHelperEnvVar+"="+logPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Helper process failed: %v\n%s", err, output)
+	}
+
+	logged, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	match := regexp.MustCompile(`Server saw correlation=(\w+)`).FindSubmatch(logged)
+	if match == nil {
+		t.Fatalf("Log doesn't contain the correlation ID the server saw:\n%s", logged)
+	}
+	id := string(match[1])
+
+	// The incoming message, its forwarded call and the response all carry the same ID
+	for _, want := range []string{
+		"IN [correlation=" + id + "] RPC",
+		"Received request: method=tools/call, id=7, correlation=" + id,
+		"Calling tool get_repo on server github (mapped from github_get_repo), correlation=" + id,
+		"OUT [correlation=" + id + "] RPC",
+	} {
+		if !strings.Contains(string(logged), want) {
+			t.Errorf("Log doesn't contain %q:\n%s", want, logged)
+		}
+	}
+}
+
+// loggingClient is a server client that reports the logging capability
+type loggingClient struct {
+	correlationClient
+}
+
+func (c *loggingClient) Initialize(ctx context.Context, request mcp.InitializeRequest) (*mcp.InitializeResult, error) {
+	result := &mcp.InitializeResult{ServerInfo: mcp.Implementation{Name: "github", Version: "1.0.0"}}
+	result.Capabilities.Logging = &struct{}{}
+	return result, nil
+}
+
+func TestDownstreamLogging(t *testing.T) {
+	if err := logger.Init(config.LogLevelError, ""); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprintf("enabled=%v", enabled), func(t *testing.T) {
+			agg := aggregator.NewMCPAggregator(aggregator.WithClientFactory(func(serverCfg config.ServerConfig) (aggregator.MCPClient, error) {
+				return &loggingClient{}, nil
+			}))
+			cfg := &config.Config{
+				Servers:  []config.ServerConfig{{Name: "github", Command: "test-command"}},
+				LogLevel: config.LogLevelError,
+			}
+			if err := agg.Initialize(context.Background(), cfg); err != nil {
+				t.Fatalf("Initialize() error = %v", err)
+			}
+			defer agg.Close()
+
+			s := NewAggregatorServer("test-aggregator", "1.0.0", agg, WithDownstreamLogging(enabled))
+			line := []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05",` +
+				`"capabilities":{},"clientInfo":{"name":"test-client","version":"1.0.0"}}}`)
+			var response []byte
+			s.handleMessage(context.Background(), line, func(out []byte) { response = out })
+
+			var got struct {
+				Result struct {
+					Capabilities map[string]json.RawMessage `json:"capabilities"`
+				} `json:"result"`
+			}
+			if err := json.Unmarshal(response, &got); err != nil {
+				t.Fatalf("Initialize response isn't valid JSON: %v\n%s", err, response)
+			}
+			if _, advertised := got.Result.Capabilities["logging"]; advertised != enabled {
+				t.Errorf("Logging capability advertised = %v, want %v: %s", advertised, enabled, response)
+			}
+		})
+	}
+}
+
+// slowCallHelperEnvVar makes the test binary make a slow and a fast tool call, logging to the file it names
+const slowCallHelperEnvVar = "COMBINE_MCP_SLOW_CALL_LOG"
+
+// slowClient is a server client whose slow tool takes 100ms to answer
+type slowClient struct {
+	correlationClient
+}
+
+func (c *slowClient) ListTools(ctx context.Context, request mcp.ListToolsRequest) (*mcp.ListToolsResult, error) {
+	return &mcp.ListToolsResult{Tools: []mcp.Tool{mcp.NewTool("slow"), mcp.NewTool("fast")}}, nil
+}
+
+func (c *slowClient) CallTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	if request.Params.Name == "slow" {
+		time.Sleep(100 * time.Millisecond)
+	}
+	return mcp.NewToolResultText("ok"), nil
+}
+
+// TestSlowCallLogHelper is not a real test, it is the process whose log TestSlowCallLog inspects
+func TestSlowCallLogHelper(t *testing.T) {
+	logPath := os.Getenv(slowCallHelperEnvVar)
+	if logPath == "" {
+		return
+	}
+
+	agg := aggregator.NewMCPAggregator(aggregator.WithClientFactory(func(serverCfg config.ServerConfig) (aggregator.MCPClient, error) {
+		return &slowClient{}, nil
+	}))
+	cfg := &config.Config{
+		Servers:  []config.ServerConfig{{Name: "github", Command: "test-command"}},
+		LogLevel: config.LogLevelDebug,
+		LogFile:  logPath,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	defer logger.Close()
+	defer agg.Close()
+
+	s := NewAggregatorServer("test-aggregator", "1.0.0", agg)
+	s.SetSlowCallThreshold(50 * time.Millisecond)
+	for _, name := range []string{"github_slow", "github_fast"} {
+		request := mcp.CallToolRequest{}
+		request.Params.Name = name
+		if _, err := s.createToolHandler(name)(context.Background(), request); err != nil {
+			t.Fatalf("Tool call %s error = %v", name, err)
+		}
+	}
+}
+
+func TestSlowCallLog(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "combine-mcp.log")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSlowCallLogHelper$")
+	cmd.Env = append(os.Environ(), slowCallHelperEnvVar+"="+logPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Helper process failed: %v\n%s", err, output)
+	}
+
+	logged, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	wantLines := map[string]*regexp.Regexp{
+		"slow call warning": regexp.MustCompile(`(?m)^WARN: .* Slow tool call github_slow on server github took \d+(\.\d+)?ms$`),
+		"fast call debug":   regexp.MustCompile(`(?m)^DEBUG: .* Tool call github_fast on server github took [\d.]+[µnm]?s$`),
+	}
+	for name, pattern := range wantLines {
+		if !pattern.Match(logged) {
+			t.Errorf("Log doesn't contain the %s:\n%s", name, logged)
+		}
+	}
+	if strings.Contains(string(logged), "Slow tool call github_fast") {
+		t.Errorf("Fast call was logged as slow:\n%s", logged)
+	}
+}
+
+func TestInstructions(t *testing.T) {
+	if err := logger.Init(config.LogLevelError, ""); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		instructions string
+		want         string
+	}{
+		{name: "None", want: ""},
+		{name: "Configured", instructions: "Prefer the github tools for code questions.", want: "Prefer the github tools for code questions."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewAggregatorServer("test-aggregator", "1.0.0", aggregator.NewMCPAggregator())
+			s.SetInstructions(tt.instructions)
+
+			line := []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05",` +
+				`"capabilities":{},"clientInfo":{"name":"test-client","version":"1.0.0"}}}`)
+			var response []byte
+			s.handleMessage(context.Background(), line, func(out []byte) { response = out })
+
+			var got struct {
+				Result mcp.InitializeResult `json:"result"`
+			}
+			if err := json.Unmarshal(response, &got); err != nil {
+				t.Fatalf("Initialize response isn't valid JSON: %v\n%s", err, response)
+			}
+			if got.Result.Instructions != tt.want {
+				t.Errorf("Instructions = %q, want %q", got.Result.Instructions, tt.want)
+			}
+		})
+	}
+}
+
+// blockingClient is a server client whose tool calls run until released
+type blockingClient struct {
+	correlationClient
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingClient) CallTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	c.started <- struct{}{}
+	<-c.release
+	return mcp.NewToolResultText("finished"), nil
+}
+
+// lockedBuffer collects the output of serve, which may be written while the test reads it
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// serveBlockingCall starts serving a client that makes a tool call, which runs until the client's release channel is closed
+func serveBlockingCall(t *testing.T, opts ...Option) (client *blockingClient, out *lockedBuffer, cancel context.CancelFunc, serveDone <-chan error) {
+	t.Helper()
+	if err := logger.Init(config.LogLevelError, ""); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	client = &blockingClient{started: make(chan struct{}, 1), release: make(chan struct{})}
+	agg := aggregator.NewMCPAggregator(aggregator.WithClientFactory(func(serverCfg config.ServerConfig) (aggregator.MCPClient, error) {
+		return client, nil
+	}))
+	cfg := &config.Config{
+		Servers:  []config.ServerConfig{{Name: "github", Command: "test-command"}},
+		LogLevel: config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	t.Cleanup(func() { agg.Close() })
+	s := NewAggregatorServer("test-aggregator", "1.0.0", agg, opts...)
+	if err := s.RegisterTools(); err != nil {
+		t.Fatalf("RegisterTools() error = %v", err)
+	}
+
+	// The client keeps stdin open, so only cancelling the context stops serving
+	inReader, inWriter := io.Pipe()
+	t.Cleanup(func() { inWriter.Close() })
+	out = &lockedBuffer{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.serve(ctx, inReader, out) }()
+
+	fmt.Fprintln(inWriter, `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"github_get_repo"}}`)
+	select {
+	case <-client.started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Tool call didn't reach the server")
+	}
+	return client, out, cancel, done
+}
+
+func TestServeAnswersCallInFlightOnCancel(t *testing.T) {
+	client, out, cancel, serveDone := serveBlockingCall(t)
+
+	// Serving keeps going after cancellation until the call in flight is answered
+	cancel()
+	select {
+	case <-serveDone:
+		t.Fatal("serve() returned before the call in flight was answered")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(client.release)
+
+	select {
+	case err := <-serveDone:
+		if err != nil {
+			t.Errorf("serve() error = %v, want nil after cancellation", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("serve() didn't return after the call in flight was answered")
+	}
+	if written := out.String(); !strings.Contains(written, `"id":1,"result"`) || !strings.Contains(written, "finished") {
+		t.Errorf("serve() wrote %q, want the result of the call in flight", written)
+	}
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	client, out, cancel, serveDone := serveBlockingCall(t, WithShutdownTimeout(50*time.Millisecond))
+	defer close(client.release)
+
+	cancel()
+	select {
+	case err := <-serveDone:
+		if err != nil {
+			t.Errorf("serve() error = %v, want nil after cancellation", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("serve() didn't return after the shutdown timeout")
+	}
+	if written := out.String(); written != "" {
+		t.Errorf("serve() wrote %q, want nothing while the call is still running", written)
+	}
+}
+
+func TestRejectMessage(t *testing.T) {
+	if err := logger.Init(config.LogLevelError, ""); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		message string
+		want    string // Written line, none if empty
+	}{
+		{
+			name:    "Request",
+			message: `{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"github_get_repo"}}`,
+			want:    `{"jsonrpc":"2.0","id":7,"error":{"code":-32603,"message":"Server is shutting down"}}`,
+		},
+		{
+			name:    "Notification",
+			message: `{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		},
+		{
+			name:    "Batch",
+			message: `[{"jsonrpc":"2.0","id":"a","method":"ping"},{"jsonrpc":"2.0","method":"notifications/initialized"},{"jsonrpc":"2.0","id":"b","method":"tools/list"}]`,
+			want:    `[{"jsonrpc":"2.0","id":"a","error":{"code":-32603,"message":"Server is shutting down"}},{"jsonrpc":"2.0","id":"b","error":{"code":-32603,"message":"Server is shutting down"}}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var written []string
+			rejectMessage([]byte(tt.message), func(line []byte) {
+				written = append(written, string(line))
+			})
+			var want []string
+			if tt.want != "" {
+				want = []string{tt.want}
+			}
+			if !reflect.DeepEqual(written, want) {
+				t.Errorf("rejectMessage() wrote %v, want %v", written, want)
+			}
+		})
+	}
+}
+
+// shadowingClient is a server client with a tool named like the status tool, recording the tools it is called with
+type shadowingClient struct {
+	correlationClient
+	calls []string
+}
+
+func (c *shadowingClient) ListTools(ctx context.Context, request mcp.ListToolsRequest) (*mcp.ListToolsResult, error) {
+	return &mcp.ListToolsResult{Tools: []mcp.Tool{mcp.NewTool(StatusToolName), mcp.NewTool("get_repo")}}, nil
+}
+
+func (c *shadowingClient) CallTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	c.calls = append(c.calls, request.Params.Name)
+	return mcp.NewToolResultText("upstream"), nil
+}
+
+func TestPassthrough(t *testing.T) {
+	if err := logger.Init(config.LogLevelError, ""); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	client := &shadowingClient{}
+	agg := aggregator.NewMCPAggregator(aggregator.WithClientFactory(func(serverCfg config.ServerConfig) (aggregator.MCPClient, error) {
+		return client, nil
+	}))
+	cfg := &config.Config{
+		Servers:     []config.ServerConfig{{Name: "github", Command: "test-command"}},
+		Passthrough: true,
+		LogLevel:    config.LogLevelError,
+	}
+	if err := agg.Initialize(context.Background(), cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	defer agg.Close()
+
+	// A transparent proxy only exposes the server's tools, so the upstream tool isn't shadowed by the built-in one
+	s := NewAggregatorServer("test-aggregator", "1.0.0", agg, WithPassthrough(true))
+	var names []string
+	var statusTool *server.ServerTool
+	for _, tool := range s.serverTools() {
+		names = append(names, tool.Tool.Name)
+		if tool.Tool.Name == StatusToolName {
+			statusTool = &tool
+		}
+	}
+	sort.Strings(names)
+	if want := []string{StatusToolName, "get_repo"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("serverTools() names = %v, want %v", names, want)
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = StatusToolName
+	result, err := statusTool.Handler(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Tool call error = %v", err)
+	}
+	if text, ok := mcp.AsTextContent(result.Content[0]); !ok || text.Text != "upstream" {
+		t.Errorf("Tool call result = %+v, want the upstream result", result.Content[0])
+	}
+	if want := []string{StatusToolName}; !reflect.DeepEqual(client.calls, want) {
+		t.Errorf("Upstream calls = %v, want %v", client.calls, want)
+	}
+}
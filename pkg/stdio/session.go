@@ -0,0 +1,43 @@
+package stdio
+
+import (
+	"sync/atomic"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// stdioSessionID identifies the single client session served over stdio
+const stdioSessionID = "stdio"
+
+// stdioSession is the client session of the stdio transport, used to deliver notifications to the client
+type stdioSession struct {
+	notifications chan mcp.JSONRPCNotification
+	initialized   atomic.Bool
+}
+
+// newStdioSession creates a session with a buffered notification channel
+func newStdioSession() *stdioSession {
+	return &stdioSession{
+		notifications: make(chan mcp.JSONRPCNotification, 100),
+	}
+}
+
+// SessionID returns the identifier of the session
+func (s *stdioSession) SessionID() string {
+	return stdioSessionID
+}
+
+// NotificationChannel returns the channel notifications for the client are sent to
+func (s *stdioSession) NotificationChannel() chan<- mcp.JSONRPCNotification {
+	return s.notifications
+}
+
+// Initialize marks the session as ready to receive notifications
+func (s *stdioSession) Initialize() {
+	s.initialized.Store(true)
+}
+
+// Initialized reports whether the client has completed initialization
+func (s *stdioSession) Initialized() bool {
+	return s.initialized.Load()
+}
@@ -0,0 +1,49 @@
+package stdio
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/nazar256/combine-mcp/pkg/aggregator"
+)
+
+// StatusToolName is the name of the built-in tool that describes the aggregator and its servers
+const StatusToolName = "combine_mcp_status"
+
+// status is the result of the status tool
+type status struct {
+	Name    string                     `json:"name"`
+	Version string                     `json:"version"`
+	Servers []aggregator.ServerStatus  `json:"servers"`
+	Skipped []aggregator.SkippedServer `json:"skipped,omitempty"` // Servers that failed to initialize
+	Stats   aggregator.Stats           `json:"stats"`
+}
+
+// statusTool builds the built-in tool that reports the connected servers
+func (s *AggregatorServer) statusTool() server.ServerTool {
+	return server.ServerTool{
+		Tool: mcp.NewTool(StatusToolName,
+			mcp.WithDescription("Show the version of combine-mcp and the servers it is connected to, with the name and version each server reported and the number of tools it contributes, the servers that failed to initialize with the reason why, and the total counts of tools and servers"),
+		),
+		Handler: s.handleStatus,
+	}
+}
+
+// handleStatus reports the aggregator version and the connected servers as JSON
+func (s *AggregatorServer) handleStatus(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	result := status{
+		Name:    s.name,
+		Version: s.version,
+		Servers: s.aggregator.ServerStatuses(),
+		Skipped: s.aggregator.InitReport().Skipped,
+		Stats:   s.aggregator.Stats(),
+	}
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal status: %w", err)
+	}
+	return mcp.NewToolResultText(string(data)), nil
+}
@@ -0,0 +1,25 @@
+package stdio
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nazar256/combine-mcp/pkg/aggregator"
+)
+
+// toolSummary formats the tools of every server as a table, one section per server,
+// listing the registered tools by their exposed names and the filtered out ones by their original names
+func toolSummary(servers []aggregator.ServerTools) string {
+	var b strings.Builder
+	b.WriteString("Tool summary:")
+	for _, server := range servers {
+		fmt.Fprintf(&b, "\n[%s] %d registered, %d filtered out", server.Name, len(server.Tools), len(server.Filtered))
+		for _, tool := range server.Tools {
+			fmt.Fprintf(&b, "\n  %-12s %s", "registered", tool)
+		}
+		for _, tool := range server.Filtered {
+			fmt.Fprintf(&b, "\n  %-12s %s", "filtered out", tool)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,58 @@
+// Package tracing sets up OpenTelemetry tracing of tool calls
+package tracing
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// EndpointEnvVar is the standard OpenTelemetry variable that enables exporting spans when set
+const EndpointEnvVar = "OTEL_EXPORTER_OTLP_ENDPOINT"
+
+// propagator reads the W3C trace context clients pass in the _meta of their requests
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Setup exports spans over OTLP/HTTP if an endpoint is configured and returns a function that flushes
+// and stops the exporter. Without an endpoint the global no-op tracer is kept and nothing is exported.
+func Setup(ctx context.Context, serviceName, version string) (func(context.Context) error, error) {
+	if os.Getenv(EndpointEnvVar) == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// The exporter reads the endpoint, headers and protocol options from the standard environment variables
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName(serviceName),
+			semconv.ServiceVersion(version),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// ContextFromMeta returns the context with the trace context carried in the _meta of a request, if any
+func ContextFromMeta(ctx context.Context, meta map[string]interface{}) context.Context {
+	carrier := propagation.MapCarrier{}
+	for key, value := range meta {
+		if s, ok := value.(string); ok {
+			carrier[key] = s
+		}
+	}
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return propagator.Extract(ctx, carrier)
+}